@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -17,11 +18,12 @@ import (
 	"github.com/dhontecillas/hfw/pkg/config"
 	"github.com/dhontecillas/hfw/pkg/db"
 	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
 	ginfwconfig "github.com/dhontecillas/hfw/pkg/ginfw/config"
+	"github.com/dhontecillas/hfw/pkg/ginfw/ratelimit"
 	"github.com/dhontecillas/hfw/pkg/ginfw/web"
 	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
 	"github.com/dhontecillas/hfw/pkg/ginfw/web/wusers"
-	"github.com/dhontecillas/hfw/pkg/obs/metrics"
 )
 
 const (
@@ -66,7 +68,7 @@ func main() {
 	// to have a clean shutdown of the reporting (that is sending
 	// pending metrics and logs before closing the app)
 	insBuilder, insFlush := config.CreateInsightsBuilder(insConfig,
-		metrics.MetricDefinitionList{})
+		ratelimit.MetricDefinitions())
 	depsBuilder := config.BuildExternalServices(cldr, insBuilder, insFlush)
 	defer depsBuilder.Shutdown()
 
@@ -103,7 +105,14 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// the postgres session backend stores the sessions in our db
+	sessionConf.SQLDB = depsBuilder.ExtServices().SQL
+	// record the logins, so users can list and revoke their sessions
+	sessionConf.NewRegistry = wusers.NewSessionRegistry
 	session.Use(router, sessionConf)
+	// load the permissions of the roles of the users for
+	// the routes that use auth.RequirePermission
+	router.Use(auth.PermissionsMiddleware(wusers.LoadPermissions))
 
 	// read the specific configuration for aur app, in this case
 	// just the static assets folder.
@@ -112,13 +121,30 @@ func main() {
 
 	router.GET("/", home)
 
+	// limit the requests to the user routes by client ip, sharing
+	// the counters between instances in redis
+	usersLimiter, err := ratelimit.NewRedisLimiter(ins,
+		db.NewRedisPool(redisConf, 10), ratelimit.Conf{
+			Name:      "users",
+			Algorithm: ratelimit.SlidingWindow,
+			Limit:     60,
+			Period:    time.Minute,
+		}, "")
+	if err != nil {
+		panic(err)
+	}
+	usersGroup := router.Group("/users")
+	usersGroup.Use(ratelimit.Middleware(ins, usersLimiter, ratelimit.KeyByIP))
+
 	// configure the routes for user registration
 	actionPaths := wusers.ActionPaths{
 		BasePath:          "/users/",
 		ActivationPath:    "/users/activate/",
 		ResetPasswordPath: "/users/resetpassword",
+		Scheme:            "http",
+		Host:              "localhost:8080",
 	}
-	wusers.Routes(router.Group("/users"), actionPaths)
+	wusers.Routes(usersGroup, actionPaths)
 
 	// to use it in production:
 	// router.HTMLRender = web.NewMultiRenderEngineFromDirs(
@@ -24,5 +24,6 @@ export WEBEXAMPLE_WEB_STATICPATH="$WEBEXAMPLE/static"
 export WEBEXAMPLE_WEB_TMPLPATH="$WEBEXAMPLE/html_templates"
 export WEBEXAMPLE_WEB_EXTRATMPLPATH="$WEBEXAMPLE"
 
+export WEBEXAMPLE_GINFW_SESSION_BACKEND="redis"
 export WEBEXAMPLE_GINFW_SESSION_CSRFSECRET="ThisIsTheCSRFSecretToken"
 export WEBEXAMPLE_GINFW_SESSION_SECRETKEYPAIR="ThisIsSecretKeyPair"
@@ -109,7 +109,7 @@ func BuildExternalServices(cldr ConfLoader,
 	}
 	composer, err := CreateNotificationsComposer(ins, notificationsConf, mailer)
 	if err != nil {
-		panic("cannot create notifications")
+		panic("cannot create notifications: " + err.Error())
 	}
 	return extdeps.NewExternalServicesBuilder(
 		insBuilderFn, insFlush,
@@ -15,6 +15,10 @@ var _ ConfLoader = (*MapConf)(nil)
 
 var ErrEmptyMap = errors.New("empty map")
 
+// ErrPathNotFound is returned when a path (or a section)
+// does not exist in the config.
+var ErrPathNotFound = errors.New("path not found")
+
 func newMapConf(data map[string]any) *MapConf {
 	if data == nil {
 		data = make(map[string]any)
@@ -68,7 +72,7 @@ func (m *MapConf) Set(path []string, val any) error {
 
 func (m *MapConf) Get(path []string) (any, error) {
 	if m.mi == nil {
-		return nil, fmt.Errorf("no values in map")
+		return nil, fmt.Errorf("%w: no values in map", ErrPathNotFound)
 	}
 
 	var cm map[string]any
@@ -81,7 +85,8 @@ func (m *MapConf) Get(path []string) (any, error) {
 		}
 		i, ok = cm[el]
 		if !ok {
-			return nil, fmt.Errorf("cannot find %s at path idx %d", el, idx)
+			return nil, fmt.Errorf("%w: cannot find %s at path idx %d",
+				ErrPathNotFound, el, idx)
 		}
 	}
 	return i, nil
@@ -90,8 +95,8 @@ func (m *MapConf) Get(path []string) (any, error) {
 func (m *MapConf) section(path []string) (*MapConf, error) {
 	miAny, err := m.Get(path)
 	if err != nil {
-		return nil, fmt.Errorf("section %s not found: %s",
-			strings.Join(path, "->"), err.Error())
+		return nil, fmt.Errorf("section %s not found: %w",
+			strings.Join(path, "->"), err)
 	}
 	mi, ok := miAny.(map[string]interface{})
 	if !ok {
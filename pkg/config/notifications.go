@@ -1,40 +1,52 @@
 package config
 
 import (
-	"fmt"
+	"errors"
+	"os"
 
+	"github.com/dhontecillas/hfw/pkg/i18n"
 	"github.com/dhontecillas/hfw/pkg/mailer"
 	"github.com/dhontecillas/hfw/pkg/notifications"
 	"github.com/dhontecillas/hfw/pkg/obs"
 )
 
 const (
-	confKeyNotificationsTemplatesDir string = "notifications.templates.dir"
+	confKeyNotificationsTemplatesDir     string = "notifications.templates.dir"
+	confKeyNotificationsTemplatesReload  string = "notifications.templates.reload"
+	confKeyNotificationsTemplatesCatalog string = "notifications.templates.catalog"
 )
 
 // NotificationsConfig contains the configuration for sending
 // notifications.
 type NotificationsConfig struct {
+	// NotificationsTemplatesDir is the templates directory. When
+	// empty, the templates embedded in `pkg/notifications` are used.
 	NotificationsTemplatesDir string `json:"dir"`
+	// NotificationsTemplatesReload reloads the templates on each
+	// render, to use while developing.
+	NotificationsTemplatesReload bool `json:"reload"`
+	// NotificationsTemplatesCatalog is the directory with the
+	// `<lang>.json` message catalogs for the templates.
+	NotificationsTemplatesCatalog string `json:"catalog"`
 }
 
 // ReadNotificationsConfig creates a NotificationsConfig instance from
 // the existing environment or config file values.
 func ReadNotificationsConfig(ins *obs.Insighter, cldr ConfLoader) (*NotificationsConfig, error) {
+	var notCfg NotificationsConfig
 	cldr, err := cldr.Section([]string{"notifications", "templates"})
+	if errors.Is(err, ErrPathNotFound) {
+		// without config, the embedded templates are used
+		return &notCfg, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	var notCfg NotificationsConfig
 	err = cldr.Parse(&notCfg)
 	if err != nil {
 		return nil, err
 	}
 
-	if notCfg.NotificationsTemplatesDir == "" {
-		return nil, fmt.Errorf("cannot read notifications templates dir")
-	}
-
 	return &notCfg, nil
 }
 
@@ -44,5 +56,33 @@ func CreateNotificationsComposer(ins *obs.Insighter,
 	notificationsConf *NotificationsConfig,
 	mailer mailer.Mailer) (notifications.Composer, error) {
 
-	return notifications.NewFileSystemComposer(notificationsConf.NotificationsTemplatesDir), nil
+	templates := notifications.Templates()
+	source := "embedded"
+	if notificationsConf.NotificationsTemplatesDir != "" {
+		templates = os.DirFS(notificationsConf.NotificationsTemplatesDir)
+		source = notificationsConf.NotificationsTemplatesDir
+	}
+	ins.L.Info("notifications templates", map[string]interface{}{
+		"source":  source,
+		"reload":  notificationsConf.NotificationsTemplatesReload,
+		"catalog": notificationsConf.NotificationsTemplatesCatalog,
+	})
+	var catalog *i18n.Catalog
+	if notificationsConf.NotificationsTemplatesCatalog != "" {
+		var err error
+		catalog, err = i18n.LoadCatalog(
+			os.DirFS(notificationsConf.NotificationsTemplatesCatalog),
+			notifications.DefaultLang)
+		if err != nil {
+			return nil, err
+		}
+	}
+	composer, err := notifications.NewFSComposer(templates, notifications.FSComposerConf{
+		Reload:  notificationsConf.NotificationsTemplatesReload,
+		Catalog: catalog,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return composer, nil
 }
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+func TestReadNotificationsConfig(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+
+	cases := map[string]struct {
+		source  string
+		wantErr bool
+		wantDir string
+	}{
+		"missing":   {source: `{"db": {}}`},
+		"malformed": {source: `{"notifications": {"templates": "dir"}}`, wantErr: true},
+		"bad value": {source: `{"notifications": {"templates": {"reload": "yes"}}}`, wantErr: true},
+		"dir":       {source: `{"notifications": {"templates": {"dir": "./tmpl"}}}`, wantDir: "./tmpl"},
+	}
+	for name, tc := range cases {
+		mc, err := newMapConfFromJSON([]byte(tc.source))
+		if err != nil {
+			t.Errorf("%s: cannot load content: %s", name, err.Error())
+			return
+		}
+		conf, err := ReadNotificationsConfig(ins, mc)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: want an error", name)
+				return
+			}
+			continue
+		}
+		if err != nil || conf.NotificationsTemplatesDir != tc.wantDir {
+			t.Errorf("%s: want dir %q, got %#v (%v)", name, tc.wantDir, conf, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/oidc"
+)
+
+type OIDCConfig struct {
+	Providers []oidc.ProviderConf `json:"providers"`
+}
+
+// ReadOIDCConfig reads the configured OpenID Connect providers.
+func ReadOIDCConfig(cldr ConfLoader) (*OIDCConfig, error) {
+	var err error
+	cldr, err = cldr.Section([]string{"oidc"})
+	if err != nil {
+		return nil, err
+	}
+	var conf OIDCConfig
+	if err := cldr.Parse(&conf); err != nil {
+		return nil, err
+	}
+	for i := range conf.Providers {
+		if err := conf.Providers[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &conf, nil
+}
+
+// CreateOIDCProviders fetches the discovery document of each
+// configured provider.
+func CreateOIDCProviders(ctx context.Context, ins *obs.Insighter,
+	conf *OIDCConfig, client *http.Client) ([]*oidc.Provider, error) {
+	providers := make([]*oidc.Provider, 0, len(conf.Providers))
+	for _, pc := range conf.Providers {
+		p, err := oidc.NewProvider(ctx, pc, client)
+		if err != nil {
+			ins.L.Err(err, "cannot create oidc provider", map[string]interface{}{
+				"provider": pc.Name,
+			})
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
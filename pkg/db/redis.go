@@ -2,6 +2,9 @@ package db
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
 )
 
 // RedisConfig contains the configuration to access a
@@ -15,3 +18,22 @@ type RedisConfig struct {
 func (rc *RedisConfig) Address() string {
 	return fmt.Sprintf("%s:%d", rc.Host, rc.Port)
 }
+
+// NewRedisPool creates a pool of connections to a redis instance.
+func NewRedisPool(rc *RedisConfig, maxIdle int) *redis.Pool {
+	addr := rc.Address()
+	return &redis.Pool{
+		MaxIdle:     maxIdle,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
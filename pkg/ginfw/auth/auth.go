@@ -8,6 +8,12 @@ import (
 
 const (
 	userIDKey string = "HFW_UserID"
+	scopesKey string = "HFW_Scopes"
+	apiKeyKey string = "HFW_APIKey"
+
+	// APIKeyHeader is the header used to authenticate
+	// requests with an API key.
+	APIKeyHeader string = "X-Api-Key"
 )
 
 // GetUserID returns the current user id from a request context.
@@ -26,3 +32,42 @@ func GetUserID(c *gin.Context) *ids.ID {
 func SetUserID(c *gin.Context, userID ids.ID) {
 	c.Set(userIDKey, userID)
 }
+
+// GetScopes returns the scopes of the API key used to authenticate
+// the request. The second value is false when the request has not
+// been authenticated with an API key (so it is not restricted).
+func GetScopes(c *gin.Context) ([]string, bool) {
+	scopes, ok := c.Keys[scopesKey].([]string)
+	return scopes, ok
+}
+
+// SetScopes sets the scopes of the API key used to authenticate
+// the request.
+func SetScopes(c *gin.Context, scopes []string) {
+	c.Set(scopesKey, scopes)
+}
+
+// GetAPIKey returns the id of the API key used to authenticate
+// the request, or nil if it was not authenticated with an API key.
+func GetAPIKey(c *gin.Context) *ids.ID {
+	if key, ok := c.Keys[apiKeyKey].(ids.ID); ok {
+		return &key
+	}
+	return nil
+}
+
+// SetAPIKey sets the id of the API key used to authenticate
+// the request.
+func SetAPIKey(c *gin.Context, key ids.ID) {
+	c.Set(apiKeyKey, key)
+}
+
+// InScopes tells if a scope is granted in a list of scopes.
+func InScopes(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == AllPermissions {
+			return true
+		}
+	}
+	return false
+}
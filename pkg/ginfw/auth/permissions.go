@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/consterr"
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// ErrNoPermissionsLoader is returned when checking permissions
+// without having installed the PermissionsMiddleware.
+const ErrNoPermissionsLoader = consterr.ConstErr("ErrNoPermissionsLoader")
+
+const (
+	permissionsLoaderKey string = "HFW_PermissionsLoader"
+	permissionsKey       string = "HFW_Permissions"
+
+	// AllPermissions is a permission that grants any other one.
+	AllPermissions string = "*"
+)
+
+// PermissionsLoaderFn returns the permissions granted to a user.
+type PermissionsLoaderFn func(c *gin.Context, userID ids.ID) ([]string, error)
+
+// userPermissions are the loaded permissions for a user in
+// the current request.
+type userPermissions struct {
+	userID      ids.ID
+	permissions map[string]bool
+}
+
+// PermissionsMiddleware makes the permissions loader available
+// to RequirePermission.
+func PermissionsMiddleware(fn PermissionsLoaderFn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(permissionsLoaderKey, fn)
+		c.Next()
+	}
+}
+
+// HasPermission tells if the current user has been granted a
+// permission. Permissions are loaded only once per request. When
+// the request is authenticated with an API key, the permission
+// must also be in the key scopes.
+func HasPermission(c *gin.Context, permission string) (bool, error) {
+	userID := GetUserID(c)
+	if userID == nil {
+		return false, nil
+	}
+	if scopes, ok := GetScopes(c); ok && !InScopes(scopes, permission) {
+		return false, nil
+	}
+	up, ok := c.Keys[permissionsKey].(*userPermissions)
+	if !ok || up.userID != *userID {
+		fn, ok := c.Keys[permissionsLoaderKey].(PermissionsLoaderFn)
+		if !ok {
+			return false, ErrNoPermissionsLoader
+		}
+		list, err := fn(c, *userID)
+		if err != nil {
+			return false, err
+		}
+		up = &userPermissions{
+			userID:      *userID,
+			permissions: make(map[string]bool, len(list)),
+		}
+		for _, p := range list {
+			up.permissions[p] = true
+		}
+		c.Set(permissionsKey, up)
+	}
+	return up.permissions[permission] || up.permissions[AllPermissions], nil
+}
+
+// RequirePermission is a middleware to check that the current user
+// has been granted a permission. It must be placed after the
+// middleware that authenticates the user (session.AuthRequired or
+// wtokenapi.RequireAPIToken).
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetUserID(c) == nil {
+			c.JSON(http.StatusUnauthorized, nil)
+			c.Abort()
+			return
+		}
+		ok, err := HasPermission(c, permission)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, nil)
+			c.Abort()
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, nil)
+			c.Abort()
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+func Test_RequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := ids.NewIDGenerator().MustNew()
+	adminID := ids.NewIDGenerator().MustNew()
+	loads := 0
+	loader := func(c *gin.Context, id ids.ID) ([]string, error) {
+		loads++
+		switch id {
+		case userID:
+			return []string{"keys:read"}, nil
+		case adminID:
+			return []string{AllPermissions}, nil
+		}
+		return nil, nil
+	}
+
+	r := gin.New()
+	r.Use(PermissionsMiddleware(loader))
+	r.Use(func(c *gin.Context) {
+		var id ids.ID
+		if err := id.FromUUID(c.GetHeader("X-User")); err == nil {
+			SetUserID(c, id)
+		}
+		if scopes, ok := c.Request.Header["X-Scopes"]; ok {
+			SetScopes(c, scopes)
+		}
+	})
+	r.GET("/keys", RequirePermission("keys:read"), RequirePermission("keys:read"),
+		func(c *gin.Context) {})
+	r.POST("/keys", RequirePermission("keys:write"), func(c *gin.Context) {})
+
+	do := func(method string, user string, scopes ...string) int {
+		req := httptest.NewRequest(method, "/keys", nil)
+		req.Header.Set("X-User", user)
+		for _, scope := range scopes {
+			req.Header.Add("X-Scopes", scope)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do(http.MethodGet, ""); code != http.StatusUnauthorized {
+		t.Errorf("want 401 without user, got %d", code)
+		return
+	}
+	if code := do(http.MethodGet, userID.ToUUID()); code != http.StatusOK {
+		t.Errorf("want 200, got %d", code)
+		return
+	}
+	if loads != 1 {
+		t.Errorf("permissions should be loaded once per request, got %d", loads)
+		return
+	}
+	if code := do(http.MethodPost, userID.ToUUID()); code != http.StatusForbidden {
+		t.Errorf("want 403, got %d", code)
+		return
+	}
+	if code := do(http.MethodPost, adminID.ToUUID()); code != http.StatusOK {
+		t.Errorf("want 200 for admin, got %d", code)
+		return
+	}
+	// with an API key, the permission must also be in its scopes
+	if code := do(http.MethodPost, adminID.ToUUID(), "keys:read"); code != http.StatusForbidden {
+		t.Errorf("want 403 for admin key without scope, got %d", code)
+		return
+	}
+	if code := do(http.MethodPost, adminID.ToUUID(), "keys:write"); code != http.StatusOK {
+		t.Errorf("want 200 for admin key with scope, got %d", code)
+		return
+	}
+}
+
+func Test_RequirePermission_NoLoader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		SetUserID(c, ids.NewIDGenerator().MustNew())
+	})
+	r.GET("/keys", RequirePermission("keys:read"), func(c *gin.Context) {})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 without loader, got %d", w.Code)
+		return
+	}
+}
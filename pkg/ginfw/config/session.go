@@ -28,6 +28,7 @@ func (c *GinSessionRedisConfig) Validate() error {
 }
 
 type GinSessionConfig struct {
+	Backend          string                `json:"backend"`
 	Redis            GinSessionRedisConfig `json:"redis"`
 	CSRFSecret       string                `json:"csrfsecret"`
 	SecretKeyPair    string                `json:"secretkeypair"`
@@ -35,6 +36,14 @@ type GinSessionConfig struct {
 }
 
 func (c *GinSessionConfig) Validate() error {
+	switch c.Backend {
+	case "":
+		c.Backend = session.BackendRedis
+	case session.BackendRedis, session.BackendPostgres,
+		session.BackendCookie, session.BackendMemory:
+	default:
+		return fmt.Errorf("unknown session 'backend': %s", c.Backend)
+	}
 	err := c.Redis.Validate()
 	if err != nil {
 		return err
@@ -42,11 +51,15 @@ func (c *GinSessionConfig) Validate() error {
 	if c.SecretKeyPair == "" {
 		return fmt.Errorf("missing session 'secretkeypair'")
 	}
+	if c.CSRFSecret == "" {
+		return fmt.Errorf("missing session 'csrfsecret'")
+	}
 	return nil
 }
 
 // ReadSessionConf reads the required configuration to have
-// a Seesion insttance.
+// a Seesion insttance. When the backend is "postgres", the
+// returned conf SQLDB must be set before using it.
 func ReadSessionConf(ins *obs.Insighter, cldr config.ConfLoader,
 	redisConf *db.RedisConfig) (*session.Conf, error) {
 
@@ -74,13 +87,14 @@ func ReadSessionConf(ins *obs.Insighter, cldr config.ConfLoader,
 	}
 
 	return &session.Conf{
+		Backend: conf.Backend,
 		RedisConf: session.RedisConf{
 			MaxIdleConnections: conf.Redis.MaxIdle,
 			Host:               conf.Redis.Host,
 			Password:           conf.Redis.Password,
-			SecretKeyPair:      conf.SecretKeyPair,
 		},
-		CsrfSecret: conf.CSRFSecret,
-		IsDevelop:  conf.SessionIsDevelop,
+		SecretKeyPair: conf.SecretKeyPair,
+		CsrfSecret:    conf.CSRFSecret,
+		IsDevelop:     conf.SessionIsDevelop,
 	}, nil
 }
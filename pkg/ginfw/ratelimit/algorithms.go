@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// The algorithms work with integer microseconds, in the same way
+// as the redis scripts, so both limiters behave the same.
+
+// bucket is the state of a token bucket.
+type bucket struct {
+	tokens int64
+	// ts is the last time a token was added
+	ts int64
+}
+
+func (b *bucket) take(now int64, capacity int64, interval int64) Result {
+	if b.ts == 0 {
+		b.tokens, b.ts = capacity, now
+	}
+	if refill := (now - b.ts) / interval; refill > 0 {
+		b.tokens += refill
+		b.ts += refill * interval
+	}
+	if b.tokens >= capacity {
+		b.tokens, b.ts = capacity, now
+	}
+	res := Result{Limit: int(capacity)}
+	if b.tokens > 0 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = usToDuration(interval - (now - b.ts))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = usToDuration((capacity-b.tokens)*interval - (now - b.ts))
+	return res
+}
+
+// expires returns the time when the bucket is full again, so
+// its state can be discarded.
+func (b *bucket) expires(capacity int64, interval int64) int64 {
+	return b.ts + (capacity-b.tokens)*interval
+}
+
+// window is the state of a sliding window counter.
+type window struct {
+	// idx is the number of the current fixed window
+	idx  int64
+	cur  int64
+	prev int64
+}
+
+func (w *window) take(now int64, limit int64, period int64) Result {
+	idx := now / period
+	switch {
+	case idx == w.idx+1:
+		w.prev, w.cur = w.cur, 0
+	case idx != w.idx:
+		w.prev, w.cur = 0, 0
+	}
+	w.idx = idx
+	elapsed := now - idx*period
+	count := float64(w.prev)*float64(period-elapsed)/float64(period) + float64(w.cur)
+
+	res := Result{
+		Limit: int(limit),
+		Reset: usToDuration(period - elapsed),
+	}
+	if count+1 <= float64(limit) {
+		w.cur++
+		count++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = usToDuration(windowRetry(elapsed, limit, period, w.cur, w.prev))
+	}
+	if remaining := int(float64(limit) - count); remaining > 0 {
+		res.Remaining = remaining
+	}
+	return res
+}
+
+// windowRetry returns the time until the weighted count drops
+// enough to allow a request.
+func windowRetry(elapsed int64, limit int64, period int64, cur int64, prev int64) int64 {
+	if cur >= limit {
+		// wait until the current window is the previous one,
+		// and its weight is low enough
+		return period - elapsed + period - (limit-1)*period/cur
+	}
+	if prev == 0 {
+		return period - elapsed
+	}
+	return period - elapsed - (limit-1-cur)*period/prev
+}
+
+// expires returns the time when the window counts are no
+// longer used.
+func (w *window) expires(period int64) int64 {
+	return (w.idx + 2) * period
+}
+
+func usToDuration(us int64) time.Duration {
+	if us < 0 {
+		return 0
+	}
+	return time.Duration(us) * time.Microsecond
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// memEntry is the state of a client in the MemLimiter
+type memEntry struct {
+	bucket  bucket
+	window  window
+	expires int64
+}
+
+// MemLimiter keeps the state of the limits in memory, so each
+// instance of the application enforces its own limits. It can
+// be used on its own, or as the fallback of a RedisLimiter.
+type MemLimiter struct {
+	conf     Conf
+	capacity int64
+	interval int64
+	period   int64
+
+	mu        sync.Mutex
+	entries   map[string]*memEntry
+	nextSweep int64
+	now       func() time.Time
+}
+
+var _ Limiter = (*MemLimiter)(nil)
+
+// NewMemLimiter creates a MemLimiter.
+func NewMemLimiter(conf Conf) (*MemLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return &MemLimiter{
+		conf:     conf,
+		capacity: int64(conf.capacity()),
+		interval: conf.interval().Microseconds(),
+		period:   conf.Period.Microseconds(),
+		entries:  map[string]*memEntry{},
+		now:      time.Now,
+	}, nil
+}
+
+// Allow checks if a client can make a request.
+func (m *MemLimiter) Allow(key string) (Result, error) {
+	now := m.now().UnixMicro()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	e, ok := m.entries[key]
+	if !ok {
+		e = &memEntry{}
+		m.entries[key] = e
+	}
+	var res Result
+	if m.conf.Algorithm == TokenBucket {
+		res = e.bucket.take(now, m.capacity, m.interval)
+		e.expires = e.bucket.expires(m.capacity, m.interval)
+	} else {
+		res = e.window.take(now, m.capacity, m.period)
+		e.expires = e.window.expires(m.period)
+	}
+	return res, nil
+}
+
+// sweep removes the entries that are back to their initial
+// state, once per period.
+func (m *MemLimiter) sweep(now int64) {
+	if now < m.nextSweep {
+		return
+	}
+	for key, e := range m.entries {
+		if e.expires <= now {
+			delete(m.entries, key)
+		}
+	}
+	m.nextSweep = now + m.period
+}
+
+// Len returns the number of clients being tracked.
+func (m *MemLimiter) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
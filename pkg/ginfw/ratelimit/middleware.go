@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// Headers sent with the state of the limit
+const (
+	HeaderLimit      string = "RateLimit-Limit"
+	HeaderRemaining  string = "RateLimit-Remaining"
+	HeaderReset      string = "RateLimit-Reset"
+	HeaderRetryAfter string = "Retry-After"
+)
+
+// KeyFn returns the key that identifies the client of a request.
+type KeyFn func(c *gin.Context) string
+
+// KeyByIP limits the requests by client IP.
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByUser limits the requests by the authenticated user, or
+// by client IP for anonymous requests.
+func KeyByUser(c *gin.Context) string {
+	if userID := auth.GetUserID(c); userID != nil {
+		return "user:" + userID.ToUUID()
+	}
+	return KeyByIP(c)
+}
+
+// KeyByAPIKey limits the requests by the API key used to
+// authenticate them, so it must be installed after the API
+// key check. Requests without a key are limited by user.
+func KeyByAPIKey(c *gin.Context) string {
+	if key := auth.GetAPIKey(c); key != nil {
+		return "key:" + key.ToUUID()
+	}
+	return KeyByUser(c)
+}
+
+// Middleware rejects with a 429 status the requests that go over
+// the limit, and sends the RateLimit headers in all the responses.
+// If the limit cannot be checked, the request is let through.
+func Middleware(ins *obs.Insighter, limiter Limiter, keyFn KeyFn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := limiter.Allow(keyFn(c))
+		if err != nil {
+			ins.L.Err(err, "cannot check rate limit", nil)
+			return
+		}
+		h := c.Writer.Header()
+		h.Set(HeaderLimit, strconv.Itoa(res.Limit))
+		h.Set(HeaderRemaining, strconv.Itoa(res.Remaining))
+		h.Set(HeaderReset, seconds(res.Reset))
+		if !res.Allowed {
+			ins.M.Inc(MetRateLimitRejected)
+			h.Set(HeaderRetryAfter, seconds(res.RetryAfter))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+	}
+}
+
+// seconds rounds up a duration to whole seconds, so the
+// clients do not retry too early.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// Algorithm is the algorithm used to limit the requests.
+type Algorithm string
+
+// Available rate limiting algorithms
+const (
+	// TokenBucket allows bursts of up to Burst requests, and
+	// refills the bucket at Limit requests per Period.
+	TokenBucket Algorithm = "token_bucket"
+	// SlidingWindow allows Limit requests in any Period, weighting
+	// the count of the previous window by the time that overlaps
+	// with the sliding one.
+	SlidingWindow Algorithm = "sliding_window"
+)
+
+// Metrics reported by the rate limiters
+const (
+	MetRateLimitRejected string = "ratelimit.rejected"
+	MetRateLimitFallback string = "ratelimit.fallback"
+)
+
+// MetricDefinitions returns the definitions of the metrics
+// reported by the rate limiters, to be registered in the
+// insighter.
+func MetricDefinitions() metrics.MetricDefinitionList {
+	return metrics.MetricDefinitionList{
+		&metrics.MetricDefinition{
+			Name:       MetRateLimitRejected,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+		},
+		&metrics.MetricDefinition{
+			Name:       MetRateLimitFallback,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+		},
+	}
+}
+
+// Conf has the configuration of a rate limit.
+type Conf struct {
+	// Name identifies the limit, to keep apart the counters
+	// of different limits for the same client.
+	Name      string
+	Algorithm Algorithm
+	// Limit is the number of requests allowed in a Period
+	Limit  int
+	Period time.Duration
+	// Burst is the capacity of the token bucket. When zero,
+	// the Limit is used.
+	Burst int
+}
+
+// Validate checks that the configuration is usable.
+func (c *Conf) Validate() error {
+	if c.Algorithm != TokenBucket && c.Algorithm != SlidingWindow {
+		return fmt.Errorf("unknown rate limit algorithm %q", c.Algorithm)
+	}
+	if c.Limit <= 0 || c.Period <= 0 || c.Burst < 0 {
+		return fmt.Errorf("bad rate limit %d requests per %s (burst %d)",
+			c.Limit, c.Period, c.Burst)
+	}
+	if c.Period/time.Duration(c.Limit) < time.Microsecond {
+		return fmt.Errorf("rate limit %d requests per %s is too high",
+			c.Limit, c.Period)
+	}
+	return nil
+}
+
+// capacity is the max number of requests that can be made at once.
+func (c *Conf) capacity() int {
+	if c.Algorithm == TokenBucket && c.Burst > 0 {
+		return c.Burst
+	}
+	return c.Limit
+}
+
+// interval is the time to refill a token in the bucket.
+func (c *Conf) interval() time.Duration {
+	return c.Period / time.Duration(c.Limit)
+}
+
+// Result is the outcome of checking a request against a limit.
+type Result struct {
+	Allowed bool
+	// Limit is the max number of requests that can be made at once.
+	Limit int
+	// Remaining is the number of requests that can still be made.
+	Remaining int
+	// Reset is the time until the limit is fully restored (or the
+	// current window ends).
+	Reset time.Duration
+	// RetryAfter is the time to wait before the next request is
+	// allowed, when it has been rejected.
+	RetryAfter time.Duration
+}
+
+// Limiter checks if a client (identified by key) can make a request.
+type Limiter interface {
+	Allow(key string) (Result, error)
+}
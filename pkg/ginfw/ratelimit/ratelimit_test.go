@@ -0,0 +1,238 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/logs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+func newTestLimiter(t *testing.T, conf Conf) (*MemLimiter, *time.Time) {
+	m, err := NewMemLimiter(conf)
+	if err != nil {
+		t.Fatalf("cannot create limiter: %s", err)
+	}
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+func Test_Conf_Validate(t *testing.T) {
+	bad := []Conf{
+		{Algorithm: "leaky", Limit: 1, Period: time.Second},
+		{Algorithm: TokenBucket, Limit: 0, Period: time.Second},
+		{Algorithm: SlidingWindow, Limit: 10, Period: 0},
+		{Algorithm: TokenBucket, Limit: 10, Period: time.Second, Burst: -1},
+		{Algorithm: TokenBucket, Limit: 1000000000, Period: time.Second},
+	}
+	for _, conf := range bad {
+		if err := conf.Validate(); err == nil {
+			t.Errorf("want error for %#v", conf)
+			return
+		}
+	}
+}
+
+func Test_MemLimiter_TokenBucket(t *testing.T) {
+	m, now := newTestLimiter(t, Conf{
+		Algorithm: TokenBucket,
+		Limit:     1,
+		Period:    time.Second,
+		Burst:     3,
+	})
+	for i := 0; i < 3; i++ {
+		res, _ := m.Allow("a")
+		if !res.Allowed || res.Remaining != 2-i || res.Limit != 3 {
+			t.Errorf("request %d: unexpected result %#v", i, res)
+			return
+		}
+	}
+	res, _ := m.Allow("a")
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("want rejected, got %#v", res)
+		return
+	}
+	// other clients have their own bucket
+	if res, _ := m.Allow("b"); !res.Allowed {
+		t.Errorf("want allowed for other client, got %#v", res)
+		return
+	}
+
+	*now = now.Add(1500 * time.Millisecond)
+	if res, _ := m.Allow("a"); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("want a refilled token, got %#v", res)
+		return
+	}
+	res, _ = m.Allow("a")
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("want retry in 500ms, got %#v", res)
+		return
+	}
+
+	// full buckets are discarded
+	*now = now.Add(time.Hour)
+	_, _ = m.Allow("c")
+	if m.Len() != 1 {
+		t.Errorf("want only the new client, got %d", m.Len())
+		return
+	}
+}
+
+func Test_MemLimiter_SlidingWindow(t *testing.T) {
+	m, now := newTestLimiter(t, Conf{
+		Algorithm: SlidingWindow,
+		Limit:     4,
+		Period:    time.Minute,
+	})
+	for i := 0; i < 4; i++ {
+		if res, _ := m.Allow("a"); !res.Allowed || res.Remaining != 3-i {
+			t.Errorf("request %d: unexpected result %#v", i, res)
+			return
+		}
+	}
+	res, _ := m.Allow("a")
+	// the window starts at the minute, so it has to wait until
+	// the end of the next one for the weight to drop below 3/4
+	elapsed := time.Duration(now.UnixMicro()%time.Minute.Microseconds()) * time.Microsecond
+	if res.Allowed || res.RetryAfter != 2*time.Minute-elapsed-45*time.Second {
+		t.Errorf("want rejected, got %#v", res)
+		return
+	}
+
+	// in the middle of the next window, the previous one
+	// counts as 2 requests
+	*now = now.Add(time.Minute - elapsed + 30*time.Second)
+	for i := 0; i < 2; i++ {
+		if res, _ := m.Allow("a"); !res.Allowed {
+			t.Errorf("request %d: want allowed, got %#v", i, res)
+			return
+		}
+	}
+	res, _ = m.Allow("a")
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != 15*time.Second {
+		t.Errorf("want retry in 15s, got %#v", res)
+		return
+	}
+}
+
+func Test_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+	m, _ := newTestLimiter(t, Conf{
+		Algorithm: TokenBucket,
+		Limit:     1,
+		Period:    10 * time.Second,
+	})
+	keyID := ids.NewIDGenerator().MustNew()
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader(auth.APIKeyHeader) != "" {
+			auth.SetAPIKey(c, keyID)
+		}
+	})
+	r.Use(Middleware(ins, m, KeyByAPIKey))
+	r.GET("/", func(c *gin.Context) {})
+
+	do := func(withKey bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if withKey {
+			req.Header.Set(auth.APIKeyHeader, "key")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(true)
+	if w.Code != http.StatusOK || w.Header().Get(HeaderLimit) != "1" ||
+		w.Header().Get(HeaderRemaining) != "0" || w.Header().Get(HeaderReset) != "10" {
+		t.Errorf("unexpected response %d %#v", w.Code, w.Header())
+		return
+	}
+	w = do(true)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(HeaderRetryAfter) != "10" {
+		t.Errorf("want 429, got %d %#v", w.Code, w.Header())
+		return
+	}
+	if len(meter.Incs) != 1 || meter.Incs[0] != MetRateLimitRejected {
+		t.Errorf("want a rejected metric, got %#v", meter.Incs)
+		return
+	}
+	// without the key, the request is limited by ip
+	if w = do(false); w.Code != http.StatusOK {
+		t.Errorf("want 200 by ip, got %d", w.Code)
+		return
+	}
+}
+
+type recordingLogger struct {
+	*logs.NopLogger
+	msgs []string
+}
+
+func (l *recordingLogger) Warn(msg string, attrs map[string]interface{}) {
+	l.msgs = append(l.msgs, msg)
+}
+
+func (l *recordingLogger) Info(msg string, attrs map[string]interface{}) {
+	l.msgs = append(l.msgs, msg)
+}
+
+func Test_RedisLimiter_Fallback(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	logger := &recordingLogger{NopLogger: logs.NewNopLogger()}
+	ins.M = meter
+	ins.L = logger
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return nil, errors.New("redis down")
+		},
+	}
+	r, err := NewRedisLimiter(ins, pool, Conf{
+		Name:      "test",
+		Algorithm: TokenBucket,
+		Limit:     10,
+		Period:    10 * time.Second,
+	}, "")
+	if err != nil {
+		t.Errorf("cannot create limiter: %s", err.Error())
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		if res, err := r.Allow("client"); err != nil || !res.Allowed {
+			t.Errorf("want allowed in memory, got %#v %v", res, err)
+			return
+		}
+	}
+	if len(logger.msgs) != 1 {
+		t.Errorf("want a single log while redis is down, got %#v", logger.msgs)
+		return
+	}
+	if n := meter.Count(MetRateLimitFallback); n != 3 {
+		t.Errorf("want 3 fallback metrics, got %d", n)
+		return
+	}
+
+	// going back to redis is logged once
+	r.setFallingBack(nil)
+	r.setFallingBack(nil)
+	if len(logger.msgs) != 2 {
+		t.Errorf("want a log when back to redis, got %#v", logger.msgs)
+	}
+}
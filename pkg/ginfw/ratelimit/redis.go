@@ -0,0 +1,199 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// DefaultRedisPrefix is the prefix of the redis keys
+// used to store the limits.
+const DefaultRedisPrefix string = "hfw:ratelimit:"
+
+// The scripts use the redis server time, so all the instances
+// share the same clock, and return
+// {allowed, remaining, reset, retry after} (times in microseconds).
+
+// tokenBucketScript takes a token from the bucket in KEYS[1],
+// with ARGV[1] capacity and a token added each ARGV[2] microseconds.
+var tokenBucketScript = redis.NewScript(1, `
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+local refill = math.floor((now - ts) / interval)
+if refill > 0 then
+	tokens = tokens + refill
+	ts = ts + refill * interval
+end
+if tokens >= capacity then
+	tokens = capacity
+	ts = now
+end
+
+local allowed = 0
+local retry = 0
+if tokens > 0 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = interval - (now - ts)
+end
+local reset = (capacity - tokens) * interval - (now - ts)
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset / 1000) + 1000)
+return {allowed, tokens, reset, retry}
+`)
+
+// slidingWindowScript counts a request in the window in KEYS[1],
+// allowing ARGV[1] requests in ARGV[2] microseconds.
+var slidingWindowScript = redis.NewScript(1, `
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local idx = math.floor(now / period)
+local state = redis.call('HMGET', KEYS[1], 'idx', 'cur', 'prev')
+local cur = tonumber(state[2]) or 0
+local prev = tonumber(state[3]) or 0
+local stored = tonumber(state[1])
+if stored == idx - 1 then
+	prev = cur
+	cur = 0
+elseif stored ~= idx then
+	prev = 0
+	cur = 0
+end
+local elapsed = now - idx * period
+local count = prev * (period - elapsed) / period + cur
+
+local allowed = 0
+local retry = 0
+if count + 1 <= limit then
+	cur = cur + 1
+	count = count + 1
+	allowed = 1
+elseif cur >= limit then
+	retry = period - elapsed + period - math.floor((limit - 1) * period / cur)
+elseif prev == 0 then
+	retry = period - elapsed
+else
+	retry = period - elapsed - math.floor((limit - 1 - cur) * period / prev)
+end
+local remaining = math.floor(limit - count)
+if remaining < 0 then
+	remaining = 0
+end
+redis.call('HSET', KEYS[1], 'idx', idx, 'cur', cur, 'prev', prev)
+redis.call('PEXPIRE', KEYS[1], math.ceil(2 * period / 1000))
+return {allowed, remaining, period - elapsed, retry}
+`)
+
+// RedisLimiter keeps the state of the limits in redis, so they
+// are shared by all the instances of the application. When redis
+// cannot be reached, the limits are enforced in memory.
+type RedisLimiter struct {
+	ins      *obs.Insighter
+	pool     *redis.Pool
+	conf     Conf
+	prefix   string
+	fallback *MemLimiter
+
+	mu          sync.Mutex
+	fallingBack bool
+}
+
+var _ Limiter = (*RedisLimiter)(nil)
+
+// NewRedisLimiter creates a RedisLimiter. If prefix is empty,
+// the DefaultRedisPrefix is used.
+func NewRedisLimiter(ins *obs.Insighter, pool *redis.Pool, conf Conf,
+	prefix string) (*RedisLimiter, error) {
+	fallback, err := NewMemLimiter(conf)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	return &RedisLimiter{
+		ins:      ins,
+		pool:     pool,
+		conf:     conf,
+		prefix:   prefix + conf.Name + ":",
+		fallback: fallback,
+	}, nil
+}
+
+// Allow checks if a client can make a request. The requests
+// checked in memory are counted in the MetRateLimitFallback
+// metric, and only the changes between redis and the memory
+// are logged.
+func (r *RedisLimiter) Allow(key string) (Result, error) {
+	res, err := r.allow(key)
+	r.setFallingBack(err)
+	if err != nil {
+		r.ins.M.Inc(MetRateLimitFallback)
+		return r.fallback.Allow(key)
+	}
+	return res, nil
+}
+
+// setFallingBack logs when the limiter starts using the memory
+// because of err, and when it goes back to redis.
+func (r *RedisLimiter) setFallingBack(err error) {
+	r.mu.Lock()
+	changed := r.fallingBack != (err != nil)
+	r.fallingBack = err != nil
+	r.mu.Unlock()
+	if !changed {
+		return
+	}
+	if err != nil {
+		r.ins.L.Warn("rate limit falls back to memory", map[string]interface{}{
+			"error": err.Error(),
+			"limit": r.conf.Name,
+		})
+		return
+	}
+	r.ins.L.Info("rate limit back to redis", map[string]interface{}{
+		"limit": r.conf.Name,
+	})
+}
+
+func (r *RedisLimiter) allow(key string) (Result, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	script, step := slidingWindowScript, r.conf.Period
+	if r.conf.Algorithm == TokenBucket {
+		script, step = tokenBucketScript, r.conf.interval()
+	}
+	vals, err := redis.Int64s(script.Do(conn, r.prefix+key,
+		r.conf.capacity(), step.Microseconds()))
+	if err != nil {
+		return Result{}, err
+	}
+	if len(vals) != 4 {
+		return Result{}, fmt.Errorf("unexpected rate limit script result %v", vals)
+	}
+	return Result{
+		Allowed:    vals[0] == 1,
+		Limit:      r.conf.capacity(),
+		Remaining:  int(vals[1]),
+		Reset:      usToDuration(vals[2]),
+		RetryAfter: usToDuration(vals[3]),
+	}, nil
+}
@@ -9,6 +9,9 @@ import (
 
 	"github.com/gin-contrib/multitemplate"
 	"github.com/gin-gonic/gin/render"
+
+	"github.com/dhontecillas/hfw/pkg/i18n"
+	"github.com/dhontecillas/hfw/pkg/tmplfuncs"
 )
 
 const templatesDir = "html_templates"
@@ -45,10 +48,12 @@ func (h *HTMLWrapper) Instance(a string, b interface{}) render.Render {
 
 // Templates contains map of pages to be used as templates
 // with a list of files that are used as "fragments" to
-// be used by those templates.
+// be used by those templates, and an optional message
+// catalog to translate them.
 type Templates struct {
 	Pages    map[string]string
 	Includes []string
+	Catalog  *i18n.Catalog
 }
 
 // Collect searches a give `dirName` directory for files
@@ -85,20 +90,13 @@ func (t *Templates) Collect(dirName string) {
 
 // NewMultiRenderEngine creates a render engine from the
 // Templates struct.
-// Currently, it only provides a `trans` function to templates
-// to be able to translate content.
+// The templates have the `tmplfuncs.FuncMap` helper functions,
+// shared with the notification templates, that translate the
+// messages with the `Templates.Catalog`.
 func NewMultiRenderEngine(t *Templates) render.HTMLRender {
 	r := multitemplate.NewRenderer()
 
-	funcs := template.FuncMap{
-		"trans": func(i interface{}) string {
-			s, ok := i.(string)
-			if !ok {
-				return "NO STRING"
-			}
-			return s
-		},
-	}
+	funcs := tmplfuncs.FuncMap(t.Catalog)
 
 	for name, fpath := range t.Pages {
 		l := make([]string, 0, len(t.Includes)+1)
@@ -106,7 +104,7 @@ func NewMultiRenderEngine(t *Templates) render.HTMLRender {
 		l = append(l, t.Includes...)
 
 		// check if the includes templates can be parsed
-		_, er := template.ParseFiles(l...)
+		_, er := template.New(filepath.Base(fpath)).Funcs(funcs).ParseFiles(l...)
 		if er != nil {
 			panic(fmt.Sprintf("cannot parse files: %s \n", er))
 		}
@@ -0,0 +1,20 @@
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/i18n"
+	"github.com/dhontecillas/hfw/pkg/notifications"
+)
+
+// RequestLang selects the language for a request from its
+// `Accept-Language` header and the available languages of
+// a catalog, to be passed as the `lang` of the templates data.
+// Without a catalog, it returns the notifications.DefaultLang.
+func RequestLang(c *gin.Context, catalog *i18n.Catalog) string {
+	if catalog == nil {
+		return notifications.DefaultLang
+	}
+	accepted := i18n.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	return i18n.Negotiate(accepted, catalog.Langs(), catalog.DefaultLang())
+}
@@ -0,0 +1,183 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+)
+
+// Names where the CSRF token is looked for in the requests.
+const (
+	CSRFFormField string = "_csrf"
+	CSRFHeader    string = "X-Csrf-Token"
+
+	keyCSRFSecret  string = "csrfSecret"
+	keyCSRFKey     string = "HFW_CSRFKey"
+	csrfSaltSize          = 16
+	csrfSecretSize        = 32
+)
+
+// csrfSafeMethods do not change state, so they are not checked
+var csrfSafeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+func randomB64(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// csrfSignature binds a salt to the session secret using the
+// server side key.
+func csrfSignature(key []byte, salt string, sessionSecret string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(salt))
+	mac.Write([]byte{':'})
+	mac.Write([]byte(sessionSecret))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func csrfKey(c *gin.Context) []byte {
+	key, _ := c.Keys[keyCSRFKey].([]byte)
+	return key
+}
+
+// GetCSRFToken returns a token for the current session. Each call
+// returns a different token (using a new salt), but all of them are
+// valid while the session lasts.
+func GetCSRFToken(c *gin.Context) string {
+	key := csrfKey(c)
+	if key == nil {
+		return ""
+	}
+	s := sessions.Default(c)
+	sessionSecret, _ := s.Get(keyCSRFSecret).(string)
+	if sessionSecret == "" {
+		var err error
+		if sessionSecret, err = randomB64(csrfSecretSize); err != nil {
+			return ""
+		}
+		s.Set(keyCSRFSecret, sessionSecret)
+		// TODO: check if we should return an error
+		_ = s.Save()
+	}
+	salt, err := randomB64(csrfSaltSize)
+	if err != nil {
+		return ""
+	}
+	return salt + "." + csrfSignature(key, salt, sessionSecret)
+}
+
+// GetCSRFTokenInput returns a hidden input tag with the token
+func GetCSRFTokenInput(c *gin.Context) template.HTML {
+	token := GetCSRFToken(c)
+	inputTag := fmt.Sprintf("<input type=\"hidden\" name=\"%s\" value=\"%s\">",
+		CSRFFormField, template.HTMLEscapeString(token))
+	return template.HTML(inputTag)
+}
+
+func validCSRFToken(c *gin.Context, key []byte, token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return false
+	}
+	s := sessions.Default(c)
+	sessionSecret, _ := s.Get(keyCSRFSecret).(string)
+	if sessionSecret == "" {
+		return false
+	}
+	expected := csrfSignature(key, parts[0], sessionSecret)
+	return hmac.Equal([]byte(expected), []byte(parts[1]))
+}
+
+// apiSession replaces the cookie session of the requests that skip
+// the CSRF check because they carry an API key: it starts empty and
+// is never saved, so those requests can only be authenticated by
+// the API key (a forged request adding a junk header cannot use nor
+// change the session of the user).
+type apiSession struct {
+	values map[interface{}]interface{}
+}
+
+func newAPISession() *apiSession {
+	return &apiSession{values: make(map[interface{}]interface{})}
+}
+
+func (s *apiSession) ID() string { return "" }
+
+func (s *apiSession) Get(key interface{}) interface{} { return s.values[key] }
+
+func (s *apiSession) Set(key interface{}, val interface{}) { s.values[key] = val }
+
+func (s *apiSession) Delete(key interface{}) { delete(s.values, key) }
+
+func (s *apiSession) Clear() { s.values = make(map[interface{}]interface{}) }
+
+func (s *apiSession) AddFlash(value interface{}, vars ...string) {
+	key := flashKey(vars)
+	flashes, _ := s.values[key].([]interface{})
+	s.values[key] = append(flashes, value)
+}
+
+func (s *apiSession) Flashes(vars ...string) []interface{} {
+	key := flashKey(vars)
+	flashes, _ := s.values[key].([]interface{})
+	delete(s.values, key)
+	return flashes
+}
+
+func (s *apiSession) Options(sessions.Options) {}
+
+func (s *apiSession) Save() error { return nil }
+
+func flashKey(vars []string) string {
+	if len(vars) > 0 {
+		return vars[0]
+	}
+	return "_flash"
+}
+
+// CSRFMiddleware rejects the requests with unsafe methods that do
+// not carry a valid token in the CSRFFormField form field or in the
+// CSRFHeader header. Requests with an API key header (that cannot be
+// sent cross site without a CORS preflight) are let through, but
+// without access to the cookie session: they must be authenticated
+// by the API key itself (see wtokenapi.RequireAPIToken).
+func CSRFMiddleware(secret string) gin.HandlerFunc {
+	key := []byte(secret)
+	return func(c *gin.Context) {
+		c.Set(keyCSRFKey, key)
+		if csrfSafeMethods[c.Request.Method] {
+			return
+		}
+		token := c.GetHeader(CSRFHeader)
+		if token == "" {
+			token = c.PostForm(CSRFFormField)
+		}
+		if validCSRFToken(c, key, token) {
+			return
+		}
+		if c.GetHeader(auth.APIKeyHeader) != "" {
+			c.Set(sessions.DefaultKey, newAPISession())
+			return
+		}
+		c.String(http.StatusForbidden, "Bad CSRF token")
+		c.Abort()
+	}
+}
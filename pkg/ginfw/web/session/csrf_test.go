@@ -0,0 +1,135 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+)
+
+func newCSRFTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions(keySessionName, cookie.NewStore([]byte("cookie secret"))))
+	r.Use(CSRFMiddleware("csrf secret"))
+	r.GET("/token", func(c *gin.Context) {
+		sessions.Default(c).Set("name", "alice")
+		c.String(http.StatusOK, GetCSRFToken(c))
+	})
+	r.POST("/action", func(c *gin.Context) {
+		c.String(http.StatusOK, "done")
+	})
+	r.POST("/whoami", func(c *gin.Context) {
+		s := sessions.Default(c)
+		name, _ := s.Get("name").(string)
+		s.Set("name", "changed")
+		_ = s.Save()
+		c.String(http.StatusOK, name)
+	})
+	return r
+}
+
+// getToken returns a token and the session cookie it is bound to
+func getToken(t *testing.T, r *gin.Engine) (string, string) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/token", nil))
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Fatalf("cannot get token: %d", w.Code)
+	}
+	return w.Body.String(), w.Header().Get("Set-Cookie")
+}
+
+func postAction(r *gin.Engine, cookieHeader string, header string, form string) int {
+	req := httptest.NewRequest(http.MethodPost, "/action", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", strings.Split(cookieHeader, ";")[0])
+	}
+	if header != "" {
+		req.Header.Set(CSRFHeader, header)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func Test_CSRFMiddleware(t *testing.T) {
+	r := newCSRFTestRouter()
+	token, sessCookie := getToken(t, r)
+	otherToken, _ := getToken(t, r)
+
+	if code := postAction(r, sessCookie, "", ""); code != http.StatusForbidden {
+		t.Errorf("missing token: want 403, got %d", code)
+	}
+	if code := postAction(r, sessCookie, token, ""); code != http.StatusOK {
+		t.Errorf("header token: want 200, got %d", code)
+	}
+	form := url.Values{CSRFFormField: []string{token}}.Encode()
+	if code := postAction(r, sessCookie, "", form); code != http.StatusOK {
+		t.Errorf("form token: want 200, got %d", code)
+	}
+	if code := postAction(r, sessCookie, otherToken, ""); code != http.StatusForbidden {
+		t.Errorf("token from other session: want 403, got %d", code)
+	}
+	if code := postAction(r, "", token, ""); code != http.StatusForbidden {
+		t.Errorf("token without session: want 403, got %d", code)
+	}
+}
+
+func Test_CSRFMiddleware_APIKeyExempt(t *testing.T) {
+	r := newCSRFTestRouter()
+	req := httptest.NewRequest(http.MethodPost, "/action", nil)
+	req.Header.Set(auth.APIKeyHeader, "some key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("api key requests should be exempt, got %d", w.Code)
+	}
+}
+
+func Test_CSRFMiddleware_APIKeyNoSession(t *testing.T) {
+	r := newCSRFTestRouter()
+	token, sessCookie := getToken(t, r)
+
+	whoami := func(token string, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/whoami", nil)
+		req.Header.Set("Cookie", strings.Split(sessCookie, ";")[0])
+		if token != "" {
+			req.Header.Set(CSRFHeader, token)
+		}
+		if apiKey != "" {
+			req.Header.Set(auth.APIKeyHeader, apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := whoami("", "junk")
+	if w.Code != http.StatusOK {
+		t.Errorf("api key requests should not be rejected, got %d", w.Code)
+		return
+	}
+	if w.Body.String() != "" {
+		t.Errorf("api key request without csrf token got the session: %q",
+			w.Body.String())
+		return
+	}
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("api key request without csrf token saved the session")
+		return
+	}
+
+	// a request with a valid token keeps using the cookie session
+	w = whoami(token, "junk")
+	if w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("want 200 alice, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,12 @@
+package session
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Errors for the sessions
+const (
+	// ErrSessionRevoked is returned by a Registry when a session
+	// does not exist or has been revoked.
+	ErrSessionRevoked = consterr.ConstErr("ErrSessionRevoked")
+)
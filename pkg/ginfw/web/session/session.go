@@ -1,24 +1,32 @@
 package session
 
 import (
+	"errors"
 	"fmt"
-	"html/template"
 	"net/http"
+	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/redis"
 	"github.com/gin-gonic/gin"
-	// csrf "github.com/utrack/gin-csrf"
 
+	"github.com/dhontecillas/hfw/pkg/db"
 	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
 	"github.com/dhontecillas/hfw/pkg/ids"
 )
 
 const keySessionName string = "sess"
 const keyUserID string = "strUserID"
+const keyPendingUserID string = "strPendingUserID"
+const keyPendingUserIDExpires string = "pendingUserIDExpires"
+const keySessionID string = "strSessionID"
 
-// LogInSession is a redis store for user sessions
-var LogInSession redis.Store
+// context keys for the session registry
+const keyRegistryBuilder string = "HFW_SessionRegistryBuilder"
+const keyCheckedUserID string = "HFW_SessionCheckedUserID"
+
+// PendingUserIDTTL is the time a user has to complete the
+// second factor after providing a valid password.
+const PendingUserIDTTL = 5 * time.Minute
 
 // RedisConf contains the configuration to
 // maintain user session backed by a Redis server
@@ -27,82 +35,218 @@ type RedisConf struct {
 	Host               string
 	User               string
 	Password           string
-	SecretKeyPair      string
 }
 
-// Conf maintains the session configuration
-type Conf struct {
-	RedisConf  RedisConf
-	CsrfSecret string
-	IsDevelop  bool
+// Registry records the logins of the users, so their sessions
+// can be listed and revoked.
+type Registry interface {
+	// Start records a new session for a user, returning its ID.
+	Start(userID ids.ID, ip string, userAgent string) (ids.ID, error)
+	// Touch returns the user of a session, or ErrSessionRevoked
+	// if the session is no longer valid. Any other error means
+	// the session could not be checked.
+	Touch(sessionID ids.ID) (ids.ID, error)
+	// End revokes a session.
+	End(sessionID ids.ID) error
 }
 
-// Setup initializes the global `LogInSession` redis.Store instance
-func Setup(conf *Conf) error {
-	var err error
-	LogInSession, err = redis.NewStore(conf.RedisConf.MaxIdleConnections, "tcp",
-		conf.RedisConf.Host, conf.RedisConf.User, conf.RedisConf.Password,
-		[]byte(conf.RedisConf.SecretKeyPair))
-	return err
+// RegistryBuilderFn returns the registry to use in a request.
+type RegistryBuilderFn func(c *gin.Context) Registry
+
+// Conf maintains the session configuration. Backend selects
+// where the sessions are stored (see NewStore): RedisConf is used
+// by the redis backend, and SQLDB by the postgres one. NewRegistry
+// is optional: without it, sessions cannot be revoked.
+type Conf struct {
+	Backend       string
+	RedisConf     RedisConf
+	SQLDB         db.SQLDB
+	SecretKeyPair string
+	CsrfSecret    string
+	IsDevelop     bool
+	NewRegistry   RegistryBuilderFn
 }
 
-// Use adds the session and csrf token middleware
+// Use adds the session and csrf token middleware, with a new
+// store for the configured backend.
 func Use(r gin.IRoutes, conf *Conf) {
-	err := Setup(conf)
+	store, err := NewStore(conf)
 	if err != nil {
-		panic(fmt.Sprintf("cannot set up session\n%#v\n: %s", *conf, err.Error()))
+		panic(fmt.Sprintf("cannot set up %q session: %s", conf.Backend, err.Error()))
+	}
+	if conf.CsrfSecret == "" {
+		panic("cannot set up session: missing csrf secret")
+	}
+	r.Use(sessions.Sessions(keySessionName, store))
+	if conf.NewRegistry != nil {
+		r.Use(RegistryMiddleware(conf.NewRegistry))
+	}
+	r.Use(CSRFMiddleware(conf.CsrfSecret))
+}
+
+// RegistryMiddleware makes the session registry available to
+// record the logins and reject the revoked sessions.
+func RegistryMiddleware(fn RegistryBuilderFn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(keyRegistryBuilder, fn)
+		c.Next()
+	}
+}
+
+func registry(c *gin.Context) Registry {
+	fn, ok := c.Keys[keyRegistryBuilder].(RegistryBuilderFn)
+	if !ok {
+		return nil
 	}
-	r.Use(sessions.Sessions(keySessionName, LogInSession))
-	/*
-		r.Use(csrf.Middleware(csrf.Options{
-			Secret: conf.CsrfSecret,
-			ErrorFunc: func(c *gin.Context) {
-				c.String(400, "Bad CSRF token")
-				c.Abort()
-			},
-		}))
-	*/
+	return fn(c)
 }
 
-// GetUserID returns the user ID of an authenticated request
+// GetUserID returns the user ID of an authenticated request. When
+// there is a session registry, revoked sessions are logged out. If
+// the registry cannot be checked, it returns an empty string, but
+// the session is kept.
 func GetUserID(c *gin.Context) string {
+	userID, _ := CheckUserID(c)
+	return userID
+}
+
+// CheckUserID returns the user ID of an authenticated request, or an
+// empty string. When there is a session registry, revoked sessions
+// are logged out, and the error of the registry is returned when
+// it cannot check the session.
+func CheckUserID(c *gin.Context) (string, error) {
+	if checked, ok := c.Keys[keyCheckedUserID].(string); ok {
+		return checked, nil
+	}
 	s := sessions.Default(c)
 	v := s.Get(keyUserID)
 	userID, ok := v.(string)
 	if !ok {
-		return ""
+		return "", nil
 	}
-	return userID
+	if reg := registry(c); reg != nil {
+		err := checkSession(reg, s, userID)
+		if errors.Is(err, ErrSessionRevoked) {
+			ClearUserID(c)
+			userID = ""
+		} else if err != nil {
+			return "", err
+		}
+	}
+	// the registry is only checked once per request
+	c.Set(keyCheckedUserID, userID)
+	return userID, nil
+}
+
+// checkSession returns ErrSessionRevoked when the session has
+// no valid registry ID, or it does not belong to the user.
+func checkSession(reg Registry, s sessions.Session, userID string) error {
+	strSessionID, _ := s.Get(keySessionID).(string)
+	var sessionID ids.ID
+	if strSessionID == "" || sessionID.FromUUID(strSessionID) != nil {
+		return ErrSessionRevoked
+	}
+	sessionUserID, err := reg.Touch(sessionID)
+	if err != nil {
+		return err
+	}
+	if sessionUserID.ToUUID() != userID {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// GetSessionID returns the registry ID of the current session,
+// or an empty string if there is none.
+func GetSessionID(c *gin.Context) string {
+	s := sessions.Default(c)
+	sessionID, _ := s.Get(keySessionID).(string)
+	return sessionID
 }
 
 // ClearUserID remove a user session (effectively logging out the user)
 func ClearUserID(c *gin.Context) {
 	s := sessions.Default(c)
+	if reg := registry(c); reg != nil {
+		var sessionID ids.ID
+		strSessionID, _ := s.Get(keySessionID).(string)
+		if strSessionID != "" && sessionID.FromUUID(strSessionID) == nil {
+			// TODO: check if we should return an error
+			_ = reg.End(sessionID)
+		}
+	}
 	s.Clear()
+	c.Set(keyCheckedUserID, "")
 	// TODO: check if we should return an error
 	_ = s.Save()
 }
 
-// SetUserID sets a userId to a session (effectivly logging in the user)
-func SetUserID(c *gin.Context, userID string) {
+// SetUserID sets a userId to a session (effectivly logging in the
+// user). When there is a session registry, the login is recorded
+// first, and the user is not logged in if it fails.
+func SetUserID(c *gin.Context, userID string) error {
 	s := sessions.Default(c)
+	s.Delete(keySessionID)
+	if reg := registry(c); reg != nil {
+		var uID ids.ID
+		if err := uID.FromUUID(userID); err != nil {
+			return err
+		}
+		sessionID, err := reg.Start(uID, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			return err
+		}
+		s.Set(keySessionID, sessionID.ToUUID())
+	}
 	s.Set(keyUserID, userID)
+	c.Set(keyCheckedUserID, userID)
+	return s.Save()
+}
+
+// SetPendingUserID stores a user that has provided a valid password,
+// but still has to complete the second factor to be logged in.
+func SetPendingUserID(c *gin.Context, userID string) {
+	s := sessions.Default(c)
+	s.Set(keyPendingUserID, userID)
+	s.Set(keyPendingUserIDExpires, time.Now().Add(PendingUserIDTTL).Unix())
 	// TODO: check if we should return an error
 	_ = s.Save()
 }
 
-// GetCSRFTokenInput returns a hidden input tag with the token
-func GetCSRFTokenInput(c *gin.Context) template.HTML {
-	token := "tkn" // csrf.GetToken(c)
-	inputTag := fmt.Sprintf("<input type=\"hidden\" name=\"_csrf\" value=\"%s\">", token)
-	return template.HTML(inputTag)
+// GetPendingUserID returns the user ID waiting for a second factor,
+// or an empty string if there is none or it has expired.
+func GetPendingUserID(c *gin.Context) string {
+	s := sessions.Default(c)
+	expires, ok := s.Get(keyPendingUserIDExpires).(int64)
+	if !ok || time.Now().Unix() > expires {
+		return ""
+	}
+	userID, ok := s.Get(keyPendingUserID).(string)
+	if !ok {
+		return ""
+	}
+	return userID
+}
+
+// ClearPendingUserID removes the user waiting for a second factor.
+func ClearPendingUserID(c *gin.Context) {
+	s := sessions.Default(c)
+	s.Delete(keyPendingUserID)
+	s.Delete(keyPendingUserIDExpires)
+	// TODO: check if we should return an error
+	_ = s.Save()
 }
 
 // AuthRequired is a middleware to check there is a logged in user
 // in the current session
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		strUserID := GetUserID(c)
+		strUserID, err := CheckUserID(c)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, nil)
+			c.Abort()
+			return
+		}
 		if len(strUserID) == 0 {
 			c.JSON(http.StatusUnauthorized, nil)
 			c.Abort()
@@ -0,0 +1,92 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// fakeRegistry is a Registry whose Touch returns a fixed error
+type fakeRegistry struct {
+	userID   ids.ID
+	startErr error
+	touchErr error
+	ended    int
+}
+
+func (r *fakeRegistry) Start(userID ids.ID, ip string, userAgent string) (ids.ID, error) {
+	return ids.NewIDGenerator().MustNew(), r.startErr
+}
+
+func (r *fakeRegistry) Touch(sessionID ids.ID) (ids.ID, error) {
+	return r.userID, r.touchErr
+}
+
+func (r *fakeRegistry) End(sessionID ids.ID) error {
+	r.ended++
+	return nil
+}
+
+func newSessionTestRouter(t *testing.T, reg *fakeRegistry) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions(keySessionName, newTestMemStore(t)))
+	r.Use(RegistryMiddleware(func(c *gin.Context) Registry { return reg }))
+	r.GET("/login", func(c *gin.Context) {
+		if err := SetUserID(c, reg.userID.ToUUID()); err != nil {
+			c.Status(http.StatusServiceUnavailable)
+		}
+	})
+	r.GET("/private", AuthRequired(), func(c *gin.Context) {
+		c.String(http.StatusOK, GetUserID(c))
+	})
+	return r
+}
+
+func Test_Session_Registry(t *testing.T) {
+	reg := &fakeRegistry{userID: ids.NewIDGenerator().MustNew()}
+	r := newSessionTestRouter(t, reg)
+
+	reg.startErr = errors.New("db down")
+	if w := storeRequest(r, "/login", ""); w.Code != http.StatusServiceUnavailable ||
+		w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("want no login without a registered session, got %d", w.Code)
+		return
+	}
+	reg.startErr = nil
+	sessCookie := storeRequest(r, "/login", "").Header().Get("Set-Cookie")
+	if w := storeRequest(r, "/private", sessCookie); w.Code != http.StatusOK ||
+		w.Body.String() != reg.userID.ToUUID() {
+		t.Errorf("want logged in user, got %d %q", w.Code, w.Body.String())
+		return
+	}
+
+	// a registry error keeps the session
+	reg.touchErr = errors.New("db down")
+	if w := storeRequest(r, "/private", sessCookie); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 when the registry fails, got %d", w.Code)
+		return
+	}
+	reg.touchErr = nil
+	if w := storeRequest(r, "/private", sessCookie); w.Code != http.StatusOK || reg.ended != 0 {
+		t.Errorf("want the session kept after a registry error, got %d", w.Code)
+		return
+	}
+
+	reg.touchErr = ErrSessionRevoked
+	if w := storeRequest(r, "/private", sessCookie); w.Code != http.StatusUnauthorized ||
+		reg.ended != 1 {
+		t.Errorf("want a revoked session logged out, got %d", w.Code)
+		return
+	}
+	reg.touchErr = nil
+	if w := storeRequest(r, "/private", sessCookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("want the revoked session cleared, got %d", w.Code)
+		return
+	}
+}
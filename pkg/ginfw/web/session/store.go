@@ -0,0 +1,168 @@
+package session
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-contrib/sessions/redis"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// Available session backends
+const (
+	BackendRedis    string = "redis"
+	BackendPostgres string = "postgres"
+	BackendCookie   string = "cookie"
+	BackendMemory   string = "memory"
+)
+
+// defaultMaxAge is the session duration when the options
+// do not set one (the same as the gorilla stores).
+const defaultMaxAge = 86400 * 30
+
+// NewStore creates the session store for the configured backend.
+// When no backend is set, Redis is used.
+func NewStore(conf *Conf) (sessions.Store, error) {
+	keyPair := []byte(conf.SecretKeyPair)
+	switch conf.Backend {
+	case BackendRedis, "":
+		return redis.NewStore(conf.RedisConf.MaxIdleConnections, "tcp",
+			conf.RedisConf.Host, conf.RedisConf.User, conf.RedisConf.Password,
+			keyPair)
+	case BackendPostgres:
+		if conf.SQLDB == nil {
+			return nil, fmt.Errorf("missing sql db for the %s session backend",
+				BackendPostgres)
+		}
+		return NewSQLStore(conf.SQLDB, keyPair), nil
+	case BackendCookie:
+		return cookie.NewStore(keyPair), nil
+	case BackendMemory:
+		// the sweep of the store lasts as long as the process
+		store, _ := NewMemStore(keyPair)
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown session backend %q", conf.Backend)
+}
+
+// storeData is where a server side store keeps the encoded
+// session values.
+type storeData interface {
+	// load returns the values of a session, or an empty string
+	// if it does not exist or has expired.
+	load(id string) (string, error)
+	save(id string, data string, expires time.Time) error
+	delete(id string) error
+}
+
+// serverStore keeps the session values in the server, and only
+// the signed session id in the cookie.
+type serverStore struct {
+	data    storeData
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+var _ sessions.Store = (*serverStore)(nil)
+
+func newServerStore(data storeData, keyPairs ...[]byte) *serverStore {
+	s := &serverStore{
+		data:   data,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+	}
+	s.Options(sessions.Options{
+		Path:   "/",
+		MaxAge: defaultMaxAge,
+	})
+	return s
+}
+
+// Options sets the options for the sessions
+func (s *serverStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	if s.options.MaxAge > 0 {
+		for _, c := range s.codecs {
+			if sc, ok := c.(*securecookie.SecureCookie); ok {
+				sc.MaxAge(s.options.MaxAge)
+			}
+		}
+	}
+}
+
+// Get returns a session, cached for the request.
+func (s *serverStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session, loading its values if the request has
+// a valid session cookie.
+func (s *serverStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.codecs...); err != nil {
+		return session, err
+	}
+	data, err := s.data.load(session.ID)
+	if err != nil {
+		return session, err
+	}
+	if data == "" {
+		// the session has expired or has been removed
+		session.ID = ""
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, data, &session.Values, s.codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session values and sets the session cookie. A
+// negative MaxAge deletes the session.
+func (s *serverStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.data.delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(
+			securecookie.GenerateRandomKey(32)), "=")
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultMaxAge
+	}
+	expires := time.Now().Add(time.Duration(maxAge) * time.Second)
+	if err := s.data.save(session.ID, data, expires); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
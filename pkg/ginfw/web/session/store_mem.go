@@ -0,0 +1,108 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+)
+
+// memSweepInterval is how often the memory store removes the
+// expired sessions.
+const memSweepInterval = 10 * time.Minute
+
+type memEntry struct {
+	data    string
+	expires time.Time
+}
+
+// memData keeps the sessions in the process memory.
+type memData struct {
+	mu      sync.Mutex
+	entries map[string]memEntry
+
+	done     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewMemStore creates a session store that keeps the sessions in
+// memory: they are lost on restart and not shared between
+// instances, so it is meant for tests and development. The expired
+// sessions are removed every memSweepInterval until the returned
+// stop function is called.
+func NewMemStore(keyPairs ...[]byte) (sessions.Store, func()) {
+	m := newMemData(memSweepInterval)
+	return newServerStore(m, keyPairs...), m.stop
+}
+
+func newMemData(interval time.Duration) *memData {
+	m := &memData{
+		entries: map[string]memEntry{},
+		done:    make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.run(interval)
+	return m
+}
+
+func (m *memData) run(interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.sweep(now)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// sweep removes the sessions expired at now.
+func (m *memData) sweep(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, id)
+		}
+	}
+}
+
+// stop ends the periodic sweep, and waits for it to finish.
+func (m *memData) stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+	})
+	m.wg.Wait()
+}
+
+func (m *memData) load(id string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[id]
+	if !ok {
+		return "", nil
+	}
+	if time.Now().After(e.expires) {
+		delete(m.entries, id)
+		return "", nil
+	}
+	return e.data, nil
+}
+
+func (m *memData) save(id string, data string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[id] = memEntry{data: data, expires: expires}
+	return nil
+}
+
+func (m *memData) delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, id)
+	return nil
+}
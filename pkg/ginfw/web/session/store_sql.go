@@ -0,0 +1,121 @@
+package session
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+
+	"github.com/dhontecillas/hfw/pkg/db"
+)
+
+// sqlCleanupInterval is how often the SQL store removes the
+// expired sessions.
+const sqlCleanupInterval = time.Hour
+
+// sqlData keeps the sessions in the http_sessions table.
+type sqlData struct {
+	sqlDB db.SQLDB
+
+	mu          sync.Mutex
+	nextCleanup time.Time
+}
+
+// NewSQLStore creates a session store that keeps the sessions
+// in the http_sessions table of a PostgreSQL database.
+func NewSQLStore(sqlDB db.SQLDB, keyPairs ...[]byte) sessions.Store {
+	return newServerStore(&sqlData{sqlDB: sqlDB}, keyPairs...)
+}
+
+// DeleteExpiredSQLSessions removes the expired sessions from the
+// http_sessions table, returning how many have been deleted. The
+// SQL store already calls it every sqlCleanupInterval while saving
+// the sessions, so it is only needed to clean up the table of an
+// app that no longer saves sessions (like from a job).
+func DeleteExpiredSQLSessions(sqlDB db.SQLDB) (int64, error) {
+	master := sqlDB.Master()
+	deleteQ := `
+DELETE FROM http_sessions
+WHERE
+	expires < $1
+`
+	res, err := master.Exec(deleteQ, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (d *sqlData) load(id string) (string, error) {
+	master := d.sqlDB.Master()
+	loadQ := `
+SELECT
+	data
+FROM http_sessions
+WHERE
+	id = $1
+	AND expires > $2
+`
+	var data string
+	err := master.QueryRowx(loadQ, id, time.Now()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return data, err
+}
+
+func (d *sqlData) save(id string, data string, expires time.Time) error {
+	master := d.sqlDB.Master()
+	saveQ := `
+INSERT INTO http_sessions(
+	id
+	,data
+	,modified
+	,expires
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+)
+ON CONFLICT (id) DO UPDATE SET
+	data = EXCLUDED.data
+	,modified = EXCLUDED.modified
+	,expires = EXCLUDED.expires
+`
+	now := time.Now()
+	if _, err := master.Exec(saveQ, id, data, now, expires); err != nil {
+		return err
+	}
+	d.cleanup(now)
+	return nil
+}
+
+// cleanup removes the expired sessions in the background, at most
+// once per sqlCleanupInterval. A failed cleanup is not reported,
+// as it must not fail the save of a session: it is retried in the
+// next interval.
+func (d *sqlData) cleanup(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Before(d.nextCleanup) {
+		return
+	}
+	d.nextCleanup = now.Add(sqlCleanupInterval)
+	go func() {
+		_, _ = DeleteExpiredSQLSessions(d.sqlDB)
+	}()
+}
+
+func (d *sqlData) delete(id string) error {
+	master := d.sqlDB.Master()
+	deleteQ := `
+DELETE FROM http_sessions
+WHERE
+	id = $1
+`
+	_, err := master.Exec(deleteQ, id)
+	return err
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestMemStore(t *testing.T) sessions.Store {
+	store, stop := NewMemStore([]byte("secret"))
+	t.Cleanup(stop)
+	return store
+}
+
+func newStoreTestRouter(store sessions.Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions(keySessionName, store))
+	r.GET("/set", func(c *gin.Context) {
+		s := sessions.Default(c)
+		s.Set("value", c.Query("v"))
+		_ = s.Save()
+	})
+	r.GET("/get", func(c *gin.Context) {
+		v, _ := sessions.Default(c).Get("value").(string)
+		c.String(http.StatusOK, v)
+	})
+	r.GET("/clear", func(c *gin.Context) {
+		s := sessions.Default(c)
+		s.Options(sessions.Options{MaxAge: -1})
+		s.Clear()
+		_ = s.Save()
+	})
+	return r
+}
+
+func storeRequest(r *gin.Engine, path string, cookieHeader string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", strings.Split(cookieHeader, ";")[0])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func Test_MemStore(t *testing.T) {
+	r := newStoreTestRouter(newTestMemStore(t))
+
+	sessCookie := storeRequest(r, "/set?v=foo", "").Header().Get("Set-Cookie")
+	if sessCookie == "" {
+		t.Errorf("expected a session cookie")
+		return
+	}
+	if v := storeRequest(r, "/get", sessCookie).Body.String(); v != "foo" {
+		t.Errorf("want foo, got %q", v)
+		return
+	}
+
+	// the cookie only has the id, so other store cannot read it
+	other := newStoreTestRouter(newTestMemStore(t))
+	if v := storeRequest(other, "/get", sessCookie).Body.String(); v != "" {
+		t.Errorf("want empty value from other store, got %q", v)
+		return
+	}
+
+	// a tampered cookie is not accepted
+	tampered := strings.Replace(sessCookie, "=", "=x", 1)
+	if v := storeRequest(r, "/get", tampered).Body.String(); v != "" {
+		t.Errorf("want empty value with tampered cookie, got %q", v)
+		return
+	}
+
+	storeRequest(r, "/clear", sessCookie)
+	if v := storeRequest(r, "/get", sessCookie).Body.String(); v != "" {
+		t.Errorf("want empty value after clear, got %q", v)
+		return
+	}
+}
+
+func Test_MemStore_Sweep(t *testing.T) {
+	m := newMemData(time.Millisecond)
+	defer m.stop()
+
+	now := time.Now()
+	if err := m.save("expired", "old", now.Add(-time.Second)); err != nil {
+		t.Errorf("cannot save: %s", err.Error())
+		return
+	}
+	if err := m.save("valid", "new", now.Add(time.Hour)); err != nil {
+		t.Errorf("cannot save: %s", err.Error())
+		return
+	}
+
+	// the expired session is removed without being loaded
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.mu.Lock()
+		_, expired := m.entries["expired"]
+		_, valid := m.entries["valid"]
+		m.mu.Unlock()
+		if !valid {
+			t.Errorf("valid session should not be swept")
+			return
+		}
+		if !expired {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("expired session not swept")
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// after stop, nothing is swept
+	m.stop()
+	m.stop()
+	if err := m.save("expired", "old", now.Add(-time.Second)); err != nil {
+		t.Errorf("cannot save: %s", err.Error())
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	m.mu.Lock()
+	_, expired := m.entries["expired"]
+	m.mu.Unlock()
+	if !expired {
+		t.Errorf("sweep should not run after stop")
+	}
+}
+
+func Test_NewStore(t *testing.T) {
+	for _, backend := range []string{BackendCookie, BackendMemory} {
+		store, err := NewStore(&Conf{Backend: backend, SecretKeyPair: "secret"})
+		if err != nil || store == nil {
+			t.Errorf("cannot create %s store: %v", backend, err)
+			return
+		}
+	}
+	if _, err := NewStore(&Conf{Backend: BackendPostgres}); err == nil {
+		t.Errorf("expected error without sql db")
+		return
+	}
+	if _, err := NewStore(&Conf{Backend: "foo"}); err == nil {
+		t.Errorf("expected error with unknown backend")
+		return
+	}
+}
@@ -5,7 +5,8 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	// csrf "github.com/utrack/gin-csrf"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
 )
 
 // Middleware sets up a restrictive CORS Middleware and
@@ -19,7 +20,7 @@ func Middleware() gin.HandlerFunc {
 	corsConf.AllowMethods = []string{
 		"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", // "OPTIONS",
 	}
-	corsConf.AllowHeaders = append(corsConf.AllowHeaders, "X-Csrf-Token")
+	corsConf.AllowHeaders = append(corsConf.AllowHeaders, session.CSRFHeader)
 	corsConf.AllowHeaders = append(corsConf.AllowHeaders, "X-Client")
 	corsConf.AllowHeaders = append(corsConf.AllowHeaders, "Origin")
 	corsFn := cors.New(corsConf)
@@ -42,7 +43,7 @@ func DevMiddleware() gin.HandlerFunc {
 		"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", // "OPTIONS",
 	}
 	corsConf.AllowCredentials = true
-	corsConf.AllowHeaders = append(corsConf.AllowHeaders, "X-Csrf-Token")
+	corsConf.AllowHeaders = append(corsConf.AllowHeaders, session.CSRFHeader)
 	corsConf.AllowHeaders = append(corsConf.AllowHeaders, "X-Client")
 	corsConf.AllowHeaders = append(corsConf.AllowHeaders, "Origin")
 	return cors.New(corsConf)
@@ -58,6 +59,6 @@ func GetCSRFToken(c *gin.Context) {
 	var msg struct {
 		CsrfToken string `json:"csrf_token"`
 	}
-	msg.CsrfToken = "tkn" // csrf.GetToken(c)
+	msg.CsrfToken = session.GetCSRFToken(c)
 	c.JSON(http.StatusOK, msg)
 }
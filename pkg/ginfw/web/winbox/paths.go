@@ -0,0 +1,15 @@
+package winbox
+
+const (
+	// PathInbox is the route to list the inbox items.
+	PathInbox string = "inbox"
+	// PathInboxUnread is the route to get the unread count.
+	PathInboxUnread string = "inbox/unread"
+	// PathInboxReadAll is the route to mark all the items as read.
+	PathInboxReadAll string = "inbox/read"
+	// PathInboxRead is the route to mark an item as read.
+	PathInboxRead string = "inbox/:id/read"
+	// PathInboxStream is the Server-Sent Events route with the
+	// new items.
+	PathInboxStream string = "inbox/stream"
+)
@@ -0,0 +1,53 @@
+package winbox
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/notifications"
+)
+
+// ListQuery has the query params to list the inbox items. Before
+// is the item to start the page after, and Limit defaults to
+// notifications.DefaultInboxLimit.
+type ListQuery struct {
+	Before string `form:"before"`
+	Limit  int    `form:"limit"`
+}
+
+// ItemRes has the data of an inbox item.
+type ItemRes struct {
+	ID           string            `json:"id"`
+	Notification string            `json:"notification"`
+	Texts        map[string]string `json:"texts"`
+	HTMLs        map[string]string `json:"htmls"`
+	Created      time.Time         `json:"created"`
+	Read         *time.Time        `json:"read"`
+}
+
+// ListRes has a page of inbox items, with the unread count.
+type ListRes struct {
+	Items  []ItemRes `json:"items"`
+	Unread int       `json:"unread"`
+}
+
+// UnreadRes has the number of unread items.
+type UnreadRes struct {
+	Unread int `json:"unread"`
+}
+
+// ReadAllRes has the number of items marked as read.
+type ReadAllRes struct {
+	Success bool  `json:"success"`
+	Marked  int64 `json:"marked"`
+}
+
+func fromInboxItem(item *notifications.InboxItem) ItemRes {
+	return ItemRes{
+		ID:           item.ID.ToUUID(),
+		Notification: item.Notification,
+		Texts:        item.Content.Texts,
+		HTMLs:        item.Content.HTMLs,
+		Created:      item.Created,
+		Read:         item.Read,
+	}
+}
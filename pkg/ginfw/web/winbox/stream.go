@@ -0,0 +1,63 @@
+package winbox
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+	"github.com/dhontecillas/hfw/pkg/notifications"
+)
+
+// Events sent by the stream endpoint
+const (
+	EventUnread       string = "unread"
+	EventNotification string = "notification"
+)
+
+// WAPIStream is the Server-Sent Events handler with the new inbox
+// items of the logged in user. It starts with an "unread" event
+// with the UnreadRes, followed by a "notification" event with the
+// ItemRes of each new item.
+func WAPIStream(c *gin.Context, inbox *notifications.Inbox) {
+	userID := auth.GetUserID(c)
+	items, cancel, err := inbox.Subscribe(*userID)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, FailRes{Error: err.Error()})
+		return
+	}
+	defer cancel()
+	// the count is read after subscribing, so no item is missed
+	unread, err := inbox.Unread(*userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// disable the buffering of proxies like nginx
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(EventUnread, UnreadRes{Unread: unread})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				return false
+			}
+			c.SSEvent(EventNotification, fromInboxItem(&item))
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
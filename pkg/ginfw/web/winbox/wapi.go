@@ -0,0 +1,135 @@
+package winbox
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/notifications"
+)
+
+// HeartbeatInterval is the time between the comments sent to
+// keep the stream connections open.
+const HeartbeatInterval = 30 * time.Second
+
+// HandlerWithInbox is a gin handler that also receives the
+// inbox controller.
+type HandlerWithInbox func(*gin.Context, *notifications.Inbox)
+
+// inboxMiddleware converts a HandlerWithInbox into a gin handler
+func inboxMiddleware(fn HandlerWithInbox, inbox *notifications.Inbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fn(c, inbox)
+	}
+}
+
+// WAPIRoutes sets up the routes of the in-app inbox of the
+// logged in user.
+func WAPIRoutes(r gin.IRouter, inbox *notifications.Inbox) {
+	r.GET(PathInbox, session.AuthRequired(),
+		inboxMiddleware(WAPIList, inbox))
+	r.GET(PathInboxUnread, session.AuthRequired(),
+		inboxMiddleware(WAPIUnread, inbox))
+	r.POST(PathInboxReadAll, session.AuthRequired(),
+		inboxMiddleware(WAPIMarkAllRead, inbox))
+	r.POST(PathInboxRead, session.AuthRequired(),
+		inboxMiddleware(WAPIMarkRead, inbox))
+	r.GET(PathInboxStream, session.AuthRequired(),
+		inboxMiddleware(WAPIStream, inbox))
+}
+
+// OKRes is the response for a successful operation.
+type OKRes struct {
+	Success bool `json:"success"`
+}
+
+// FailRes is the response for a failed operation.
+type FailRes struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// WAPIList is the handler to list the inbox items of the logged
+// in user, the most recent first, paginated with the ListQuery
+// params.
+func WAPIList(c *gin.Context, inbox *notifications.Inbox) {
+	userID := auth.GetUserID(c)
+	q := ListQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	var before *ids.ID
+	if q.Before != "" {
+		var b ids.ID
+		if err := b.FromUUID(q.Before); err != nil {
+			c.JSON(http.StatusBadRequest, FailRes{Error: "bad before id"})
+			return
+		}
+		before = &b
+	}
+	items, err := inbox.List(*userID, before, q.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	unread, err := inbox.Unread(*userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	res := ListRes{Items: make([]ItemRes, 0, len(items)), Unread: unread}
+	for idx := range items {
+		res.Items = append(res.Items, fromInboxItem(&items[idx]))
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// WAPIUnread is the handler to get the number of unread items
+// of the logged in user.
+func WAPIUnread(c *gin.Context, inbox *notifications.Inbox) {
+	userID := auth.GetUserID(c)
+	unread, err := inbox.Unread(*userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, UnreadRes{Unread: unread})
+}
+
+// WAPIMarkRead is the handler to mark an item of the logged in
+// user as read.
+func WAPIMarkRead(c *gin.Context, inbox *notifications.Inbox) {
+	userID := auth.GetUserID(c)
+	var id ids.ID
+	if err := id.FromUUID(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: "bad item id"})
+		return
+	}
+	if err := inbox.MarkRead(*userID, id); err != nil {
+		if errors.Is(err, notifications.ErrNotFound) {
+			c.JSON(http.StatusNotFound, FailRes{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPIMarkAllRead is the handler to mark all the items of the
+// logged in user as read.
+func WAPIMarkAllRead(c *gin.Context, inbox *notifications.Inbox) {
+	userID := auth.GetUserID(c)
+	n, err := inbox.MarkAllRead(*userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ReadAllRes{Success: true, Marked: n})
+}
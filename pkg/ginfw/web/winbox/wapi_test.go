@@ -0,0 +1,170 @@
+package winbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/notifications"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+type inboxTest struct {
+	inbox  *notifications.Inbox
+	router *gin.Engine
+	userID ids.ID
+}
+
+func newInboxTest() *inboxTest {
+	gin.SetMode(gin.TestMode)
+	ins := obs.InsighterFromContext(context.Background())
+	it := &inboxTest{
+		inbox: notifications.NewInbox(ins, notifications.NewMemInboxRepo(),
+			notifications.NewMemInboxBroker()),
+		router: gin.New(),
+		userID: ids.NewIDGenerator().MustNew(),
+	}
+	// the routes without the session, that sets the user
+	logged := func(c *gin.Context) {
+		auth.SetUserID(c, it.userID)
+	}
+	it.router.GET(PathInbox, logged, inboxMiddleware(WAPIList, it.inbox))
+	it.router.GET(PathInboxUnread, logged, inboxMiddleware(WAPIUnread, it.inbox))
+	it.router.POST(PathInboxReadAll, logged, inboxMiddleware(WAPIMarkAllRead, it.inbox))
+	it.router.POST(PathInboxRead, logged, inboxMiddleware(WAPIMarkRead, it.inbox))
+	it.router.GET(PathInboxStream, logged, inboxMiddleware(WAPIStream, it.inbox))
+	return it
+}
+
+func (it *inboxTest) add(title string) {
+	// the items are sorted by id, that only has millisecond precision
+	time.Sleep(2 * time.Millisecond)
+	_ = notifications.NewInAppCarrier(it.inbox).Deliver(
+		&notifications.Recipient{UserID: it.userID}, "hello",
+		&notifications.ContentSet{
+			Texts: map[string]string{"title": title},
+			HTMLs: map[string]string{},
+		})
+}
+
+func (it *inboxTest) do(method string, path string, res interface{}) int {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "/"+path, nil)
+	it.router.ServeHTTP(w, req)
+	if res != nil {
+		_ = json.Unmarshal(w.Body.Bytes(), res)
+	}
+	return w.Code
+}
+
+func Test_WAPI_Inbox(t *testing.T) {
+	it := newInboxTest()
+	it.add("first")
+	it.add("second")
+	it.add("third")
+
+	var list ListRes
+	if code := it.do(http.MethodGet, PathInbox+"?limit=2", &list); code != http.StatusOK ||
+		len(list.Items) != 2 || list.Unread != 3 || list.Items[0].Texts["title"] != "third" {
+		t.Errorf("unexpected list %d %#v", code, list)
+		return
+	}
+	var rest ListRes
+	it.do(http.MethodGet, PathInbox+"?before="+list.Items[1].ID, &rest)
+	if len(rest.Items) != 1 || rest.Items[0].Texts["title"] != "first" {
+		t.Errorf("unexpected second page %#v", rest)
+		return
+	}
+	if code := it.do(http.MethodGet, PathInbox+"?before=bad", nil); code != http.StatusBadRequest {
+		t.Errorf("want bad request, got %d", code)
+		return
+	}
+
+	readPath := strings.Replace(PathInboxRead, ":id", list.Items[0].ID, 1)
+	if code := it.do(http.MethodPost, readPath, nil); code != http.StatusOK {
+		t.Errorf("cannot mark read: %d", code)
+		return
+	}
+	missing := ids.NewIDGenerator().MustNew()
+	missingPath := strings.Replace(PathInboxRead, ":id", missing.ToUUID(), 1)
+	if code := it.do(http.MethodPost, missingPath, nil); code != http.StatusNotFound {
+		t.Errorf("want not found, got %d", code)
+		return
+	}
+	var unread UnreadRes
+	if it.do(http.MethodGet, PathInboxUnread, &unread); unread.Unread != 2 {
+		t.Errorf("want 2 unread, got %d", unread.Unread)
+		return
+	}
+	var readAll ReadAllRes
+	if it.do(http.MethodPost, PathInboxReadAll, &readAll); readAll.Marked != 2 {
+		t.Errorf("want 2 marked, got %#v", readAll)
+		return
+	}
+}
+
+func Test_WAPI_InboxStream(t *testing.T) {
+	it := newInboxTest()
+	it.add("old")
+	srv := httptest.NewServer(it.router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/"+PathInboxStream, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("cannot connect: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("unexpected content type %q", ct)
+		return
+	}
+
+	// readEvent returns the event name and data of the next event
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, string) {
+		var event, data string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return event, data
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if event != "" {
+					return event, data
+				}
+			case strings.HasPrefix(line, "event:"):
+				event = line[len("event:"):]
+			case strings.HasPrefix(line, "data:"):
+				data = line[len("data:"):]
+			}
+		}
+	}
+
+	event, data := readEvent()
+	if event != EventUnread || !strings.Contains(data, `"unread":1`) {
+		t.Errorf("want the unread count first, got %q %q", event, data)
+		return
+	}
+	it.add("new")
+	event, data = readEvent()
+	if event != EventNotification || !strings.Contains(data, `"title":"new"`) {
+		t.Errorf("want the new item, got %q %q", event, data)
+		return
+	}
+}
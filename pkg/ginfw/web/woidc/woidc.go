@@ -0,0 +1,279 @@
+package woidc
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/wusers"
+	"github.com/dhontecillas/hfw/pkg/oidc"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
+)
+
+// Paths for the OpenID Connect login flow (the provider name
+// is part of the path).
+const (
+	PathLogin    string = ":provider/login"
+	PathCallback string = ":provider/callback"
+
+	keyFlowProvider string = "oidcProvider"
+	keyFlowState    string = "oidcState"
+	keyFlowNonce    string = "oidcNonce"
+	keyFlowVerifier string = "oidcVerifier"
+	keyFlowNextPage string = "oidcNextPage"
+	keyFlowExpires  string = "oidcExpires"
+
+	// FlowTTL is the time a user has to complete the login
+	// in the provider.
+	FlowTTL = 10 * time.Minute
+)
+
+// Conf contains the providers and the paths to where to
+// redirect the user once the flow is completed.
+//   - Providers: the configured providers by name
+//   - UsersBasePath: the path where the wusers routes are installed,
+//     used to complete the second factor and to show login errors
+//   - SuccessPath: the default page after a successful login
+type Conf struct {
+	Providers     map[string]*oidc.Provider
+	UsersBasePath string
+	SuccessPath   string
+
+	// repo replaces the users sql repo (for the tests)
+	repo usersRepo
+}
+
+// usersRepo is the part of the users repo used by the flow.
+type usersRepo interface {
+	users.RegistrationRepo
+	users.IdentitiesRepo
+}
+
+// NewConf creates a Conf for a list of providers.
+func NewConf(usersBasePath string, successPath string,
+	providers ...*oidc.Provider) Conf {
+	conf := Conf{
+		Providers:     make(map[string]*oidc.Provider, len(providers)),
+		UsersBasePath: usersBasePath,
+		SuccessPath:   successPath,
+	}
+	for _, p := range providers {
+		conf.Providers[p.Name()] = p
+	}
+	return conf
+}
+
+// Routes setup the routes to log in with the configured
+// OpenID Connect providers.
+func Routes(r gin.IRouter, conf Conf) {
+	if conf.SuccessPath == "" {
+		conf.SuccessPath = "/"
+	}
+	r.GET(PathLogin, func(c *gin.Context) { Login(c, &conf) })
+	r.GET(PathCallback, func(c *gin.Context) { Callback(c, &conf) })
+}
+
+func buildController(c *gin.Context, conf *Conf) (*users.ExternalLogin, usersRepo) {
+	ed := ginfw.ExtServices(c)
+	repo := conf.repo
+	if repo == nil {
+		repo = users.NewRepoSQLX(ed.Ins, ed.SQL, "")
+	}
+	return users.NewExternalLogin(ed.Ins, repo, repo), repo
+}
+
+// loginError shows the login form with an error message
+func loginError(c *gin.Context, conf *Conf, status int, msg string) {
+	emailUserAuth := wusers.NewEmailUserAuthRenderData(conf.UsersBasePath)
+	emailUserAuth.FormErrors = []string{msg}
+	c.HTML(status, wusers.TemplLoginForm,
+		gin.H{
+			"csrf_token":      session.GetCSRFTokenInput(c),
+			"email_user_auth": emailUserAuth,
+		})
+	c.Abort()
+}
+
+// Login starts the authorization code flow, storing the state,
+// nonce and PKCE verifier in the session, and redirecting the
+// user to the provider.
+func Login(c *gin.Context, conf *Conf) {
+	ed := ginfw.ExtServices(c)
+	p, ok := conf.Providers[c.Param("provider")]
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	ar, err := p.NewAuthRequest()
+	if err != nil {
+		ed.Ins.L.Err(err, "cannot create oidc auth request", nil)
+		loginError(c, conf, http.StatusInternalServerError,
+			"Cannot log in with "+p.Name())
+		return
+	}
+
+	s := sessions.Default(c)
+	s.Set(keyFlowProvider, p.Name())
+	s.Set(keyFlowState, ar.State)
+	s.Set(keyFlowNonce, ar.Nonce)
+	s.Set(keyFlowVerifier, ar.CodeVerifier)
+	s.Set(keyFlowNextPage, localPath(c.Query(wusers.ParamNextPage)))
+	s.Set(keyFlowExpires, time.Now().Add(FlowTTL).Unix())
+	if err := s.Save(); err != nil {
+		ed.Ins.L.Err(err, "cannot save oidc flow in session", nil)
+		loginError(c, conf, http.StatusInternalServerError,
+			"Cannot log in with "+p.Name())
+		return
+	}
+	c.Redirect(http.StatusFound, ar.URL)
+}
+
+// localPath discards next pages that are not in our own site,
+// to not be used as an open redirect.
+func localPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") ||
+		strings.HasPrefix(p, "/\\") {
+		return ""
+	}
+	return p
+}
+
+type flowValues struct {
+	provider string
+	state    string
+	nonce    string
+	verifier string
+	nextPage string
+}
+
+// popFlow returns the values stored when the flow was started,
+// and removes them from the session so they can only be used once.
+func popFlow(c *gin.Context) (*flowValues, bool) {
+	s := sessions.Default(c)
+	expires, _ := s.Get(keyFlowExpires).(int64)
+	fv := flowValues{}
+	fv.provider, _ = s.Get(keyFlowProvider).(string)
+	fv.state, _ = s.Get(keyFlowState).(string)
+	fv.nonce, _ = s.Get(keyFlowNonce).(string)
+	fv.verifier, _ = s.Get(keyFlowVerifier).(string)
+	fv.nextPage, _ = s.Get(keyFlowNextPage).(string)
+
+	for _, k := range []string{keyFlowProvider, keyFlowState, keyFlowNonce,
+		keyFlowVerifier, keyFlowNextPage, keyFlowExpires} {
+		s.Delete(k)
+	}
+	_ = s.Save()
+
+	if fv.state == "" || time.Now().Unix() > expires {
+		return nil, false
+	}
+	return &fv, true
+}
+
+// Callback completes the flow when the provider redirects the
+// user back: it checks the state, exchanges the code, verifies
+// the ID token and logs in the linked user.
+func Callback(c *gin.Context, conf *Conf) {
+	ed := ginfw.ExtServices(c)
+	p, ok := conf.Providers[c.Param("provider")]
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	fv, ok := popFlow(c)
+	state := c.Query("state")
+	if !ok || fv.provider != p.Name() ||
+		subtle.ConstantTimeCompare([]byte(fv.state), []byte(state)) != 1 {
+		ed.Ins.L.Warn("oidc callback with bad state", map[string]interface{}{
+			"provider": p.Name(),
+			"error":    oidc.ErrStateMismatch.Error(),
+		})
+		loginError(c, conf, http.StatusBadRequest,
+			"The login has expired, please try again")
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		ed.Ins.L.Warn("oidc provider returned an error", map[string]interface{}{
+			"provider":    p.Name(),
+			"error":       errCode,
+			"description": c.Query("error_description"),
+		})
+		loginError(c, conf, http.StatusUnauthorized,
+			"Cannot log in with "+p.Name())
+		return
+	}
+
+	ctx := c.Request.Context()
+	tokens, err := p.Exchange(ctx, c.Query("code"), fv.verifier)
+	if err != nil {
+		ed.Ins.L.Err(err, "cannot exchange oidc code", map[string]interface{}{
+			"provider": p.Name(),
+		})
+		loginError(c, conf, http.StatusUnauthorized,
+			"Cannot log in with "+p.Name())
+		return
+	}
+	claims, err := p.VerifyIDToken(ctx, tokens.IDToken, fv.nonce)
+	if err != nil {
+		ed.Ins.L.Err(err, "cannot verify oidc id token", map[string]interface{}{
+			"provider": p.Name(),
+		})
+		loginError(c, conf, http.StatusUnauthorized,
+			"Cannot log in with "+p.Name())
+		return
+	}
+
+	extUC, repo := buildController(c, conf)
+	u, err := extUC.Login(p.Name(), claims.Subject, claims.Email, claims.EmailVerified)
+	if err != nil {
+		ed.Ins.L.Err(err, "cannot log in external identity", map[string]interface{}{
+			"provider": p.Name(),
+		})
+		msg := "Cannot log in with " + p.Name()
+		if errors.Is(err, users.ErrUnverifiedEmail) {
+			msg = "The email of your " + p.Name() + " account is not verified"
+		}
+		loginError(c, conf, http.StatusForbidden, msg)
+		return
+	}
+
+	nextPage := fv.nextPage
+	if nextPage == "" {
+		nextPage = conf.SuccessPath
+	}
+
+	// the provider replaces the password, but not our second factor
+	totpConf, err := repo.GetTOTP(u.ID)
+	if err != nil {
+		ed.Ins.L.Err(err, "cannot check second factor", nil)
+		loginError(c, conf, http.StatusInternalServerError,
+			"Cannot log in with "+p.Name())
+		return
+	}
+	if totpConf != nil && totpConf.Enabled {
+		session.SetPendingUserID(c, u.ID.ToUUID())
+		totpURL := path.Join(conf.UsersBasePath, wusers.PathLoginTOTP) +
+			"?" + wusers.ParamNextPage + "=" + url.QueryEscape(nextPage)
+		c.Redirect(http.StatusFound, totpURL)
+		return
+	}
+
+	if err := session.SetUserID(c, u.ID.ToUUID()); err != nil {
+		ed.Ins.L.Err(err, "cannot start session", nil)
+		loginError(c, conf, http.StatusServiceUnavailable,
+			"Cannot log in with "+p.Name())
+		return
+	}
+	c.Redirect(http.StatusFound, nextPage)
+}
@@ -0,0 +1,226 @@
+package woidc
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/extdeps"
+	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/wusers"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/oidc"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
+	hfwtest "github.com/dhontecillas/hfw/testing"
+)
+
+// fakeUsersRepo has a single user, that can be linked to
+// the identities
+type fakeUsersRepo struct {
+	users.RegistrationRepo
+	users.IdentitiesRepo
+
+	user       users.User
+	identities map[string]ids.ID
+	totp       *users.TOTPConf
+}
+
+func (r *fakeUsersRepo) GetUserByEmail(email string) *users.User {
+	if email != r.user.Email {
+		return nil
+	}
+	u := r.user
+	return &u
+}
+
+func (r *fakeUsersRepo) GetUserByID(userID ids.ID) *users.User {
+	if userID != r.user.ID {
+		return nil
+	}
+	u := r.user
+	return &u
+}
+
+func (r *fakeUsersRepo) GetTOTP(userID ids.ID) (*users.TOTPConf, error) {
+	return r.totp, nil
+}
+
+func (r *fakeUsersRepo) GetIdentity(provider string, subject string) (*users.Identity, error) {
+	userID, ok := r.identities[provider+":"+subject]
+	if !ok {
+		return nil, nil
+	}
+	return &users.Identity{UserID: userID, Provider: provider, Subject: subject}, nil
+}
+
+func (r *fakeUsersRepo) LinkIdentity(userID ids.ID, provider string,
+	subject string, email string) error {
+	r.identities[provider+":"+subject] = userID
+	return nil
+}
+
+type oidcTest struct {
+	idp    *hfwtest.FakeIdP
+	repo   *fakeUsersRepo
+	router *gin.Engine
+	cookie string
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	gin.SetMode(gin.TestMode)
+	idp := hfwtest.NewFakeIdP("client", "secret")
+	t.Cleanup(idp.Close)
+	p, err := oidc.NewProvider(context.Background(), oidc.ProviderConf{
+		Name:         "fake",
+		Issuer:       idp.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oidc/fake/callback",
+	}, nil)
+	if err != nil {
+		t.Fatalf("cannot create provider: %s", err.Error())
+	}
+	store, stop := session.NewMemStore([]byte("secret"))
+	t.Cleanup(stop)
+
+	ot := &oidcTest{
+		idp: idp,
+		repo: &fakeUsersRepo{
+			user: users.User{
+				ID:    ids.NewIDGenerator().MustNew(),
+				Email: idp.Email,
+			},
+			identities: map[string]ids.ID{},
+		},
+		router: gin.New(),
+	}
+	ot.router.SetHTMLTemplate(template.Must(template.New(wusers.TemplLoginForm).Parse(
+		`{{range .email_user_auth.FormErrors}}{{.}}{{end}}`)))
+	ot.router.Use(ginfw.ExtServicesMiddleware(extdeps.GetNopExternalServices()))
+	ot.router.Use(sessions.Sessions("test", store))
+	ot.router.GET("/whoami", func(c *gin.Context) {
+		c.String(http.StatusOK, session.GetUserID(c)+"|"+session.GetPendingUserID(c))
+	})
+	conf := NewConf("/users", "/home", p)
+	conf.repo = ot.repo
+	Routes(ot.router.Group("/oidc"), conf)
+	return ot
+}
+
+// get sends a request with the session cookie, and keeps the
+// cookie of the response
+func (ot *oidcTest) get(target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if ot.cookie != "" {
+		req.Header.Set("Cookie", ot.cookie)
+	}
+	w := httptest.NewRecorder()
+	ot.router.ServeHTTP(w, req)
+	if c := w.Header().Get("Set-Cookie"); c != "" {
+		ot.cookie = strings.Split(c, ";")[0]
+	}
+	return w
+}
+
+// login starts the flow, and returns the callback the provider
+// redirects the user to.
+func (ot *oidcTest) login(t *testing.T, nextPage string) string {
+	w := ot.get("/oidc/fake/login?" + wusers.ParamNextPage + "=" + url.QueryEscape(nextPage))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login want 302, got %d", w.Code)
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("cannot authorize: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad callback: %s", err.Error())
+	}
+	return callback.RequestURI()
+}
+
+func (ot *oidcTest) whoami() string {
+	return ot.get("/whoami").Body.String()
+}
+
+func Test_LoginCallback(t *testing.T) {
+	ot := newOIDCTest(t)
+	if w := ot.get("/oidc/other/login"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown provider want 404, got %d", w.Code)
+		return
+	}
+
+	callback := ot.login(t, "/dashboard")
+	w := ot.get(callback)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard" {
+		t.Errorf("want redirect to next page, got %d %q", w.Code, w.Header().Get("Location"))
+		return
+	}
+	if got, want := ot.whoami(), ot.repo.user.ID.ToUUID()+"|"; got != want {
+		t.Errorf("want logged in %q, got %q", want, got)
+		return
+	}
+	if _, ok := ot.repo.identities["fake:"+ot.idp.Subject]; !ok {
+		t.Errorf("identity not linked")
+		return
+	}
+
+	// the flow can only be completed once
+	if w := ot.get(callback); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback want 400, got %d", w.Code)
+	}
+}
+
+func Test_LoginCallback_BadState(t *testing.T) {
+	ot := newOIDCTest(t)
+	callback := ot.login(t, "/dashboard")
+	u, _ := url.Parse(callback)
+	q := u.Query()
+	q.Set("state", "forged")
+	u.RawQuery = q.Encode()
+	if w := ot.get(u.RequestURI()); w.Code != http.StatusBadRequest {
+		t.Errorf("bad state want 400, got %d", w.Code)
+		return
+	}
+	if got := ot.whoami(); got != "|" {
+		t.Errorf("want not logged in, got %q", got)
+	}
+}
+
+func Test_LoginCallback_ExternalNextPage(t *testing.T) {
+	ot := newOIDCTest(t)
+	w := ot.get(ot.login(t, "//evil.example.com/"))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/home" {
+		t.Errorf("want redirect to success path, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func Test_LoginCallback_TOTP(t *testing.T) {
+	ot := newOIDCTest(t)
+	ot.repo.totp = &users.TOTPConf{UserID: ot.repo.user.ID, Enabled: true}
+
+	w := ot.get(ot.login(t, "/dashboard"))
+	want := "/users/" + wusers.PathLoginTOTP + "?" + wusers.ParamNextPage + "=%2Fdashboard"
+	if w.Code != http.StatusFound || w.Header().Get("Location") != want {
+		t.Errorf("want redirect to %q, got %d %q", want, w.Code, w.Header().Get("Location"))
+		return
+	}
+	if got, want := ot.whoami(), "|"+ot.repo.user.ID.ToUUID(); got != want {
+		t.Errorf("want pending second factor %q, got %q", want, got)
+	}
+}
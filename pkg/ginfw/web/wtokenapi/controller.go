@@ -7,8 +7,64 @@ import (
 	"github.com/dhontecillas/hfw/pkg/tokenapi"
 )
 
+const (
+	keyRepo    string = "HFW_TokenAPIRepo"
+	keyQuotas  string = "HFW_TokenAPIQuotas"
+	keyTracker string = "HFW_TokenAPITracker"
+)
+
+// RepoMiddleware sets the repo to be used by the handlers and
+// by RequireAPIToken, instead of a new RepoSQLX for each request.
+// It is required to share a tokenapi.CachedRepo, so the keys
+// deleted or rotated through the endpoints are evicted.
+func RepoMiddleware(repo tokenapi.Repo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(keyRepo, repo)
+		c.Next()
+	}
+}
+
+func contextRepo(c *gin.Context) (tokenapi.Repo, bool) {
+	repo, ok := c.Keys[keyRepo].(tokenapi.Repo)
+	return repo, ok
+}
+
+// QuotasMiddleware sets the quotas enforcer used by RequireAPIToken
+// to reject the requests of the keys that have exhausted their
+// daily or monthly quota. The quotas are reloaded with the usage
+// written by the tracker, so it is required (it panics without
+// one), and RequireAPIToken records the usage with it when it
+// has not been given a tracker of its own.
+func QuotasMiddleware(quotas *tokenapi.QuotaEnforcer,
+	tracker *tokenapi.UsageTracker) gin.HandlerFunc {
+	if tracker == nil {
+		panic("api key quotas require a usage tracker")
+	}
+	return func(c *gin.Context) {
+		c.Set(keyQuotas, quotas)
+		c.Set(keyTracker, tracker)
+		c.Next()
+	}
+}
+
+func contextQuotas(c *gin.Context) (*tokenapi.QuotaEnforcer, bool) {
+	quotas, ok := c.Keys[keyQuotas].(*tokenapi.QuotaEnforcer)
+	return quotas, ok
+}
+
+func contextTracker(c *gin.Context) *tokenapi.UsageTracker {
+	tracker, _ := c.Keys[keyTracker].(*tokenapi.UsageTracker)
+	return tracker
+}
+
 func buildController(c *gin.Context) tokenapi.TokenAPI {
 	ed := ginfw.ExtServices(c)
-	repo := tokenapi.NewRepoSQLX(ed.Ins, ed.SQL)
+	repo, ok := contextRepo(c)
+	if !ok {
+		repo = tokenapi.NewRepoSQLX(ed.Ins, ed.SQL)
+	}
+	if quotas, ok := contextQuotas(c); ok {
+		return tokenapi.NewTokenAPIWithQuotas(ed.Ins, repo, quotas)
+	}
 	return tokenapi.NewTokenAPI(ed.Ins, repo)
 }
@@ -1,56 +1,126 @@
 package wtokenapi
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/dhontecillas/hfw/pkg/extdeps"
-	"github.com/dhontecillas/hfw/pkg/ids"
 	"github.com/dhontecillas/hfw/pkg/tokenapi"
 
 	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
 )
 
+// NewUsageTracker creates a tracker to record the use of the
+// api keys in the database. It must be closed on shutdown.
+func NewUsageTracker(extDeps *extdeps.ExternalServicesBuilder,
+	interval time.Duration) *tokenapi.UsageTracker {
+	ins := extDeps.Insighter()
+	return tokenapi.NewUsageTracker(ins, tokenapi.NewRepoSQLX(ins, extDeps.SQL), interval)
+}
+
+// Headers sent with the state of the quota of a key
 const (
-	apiKeyHeaderName = "X-Api-Key"
+	HeaderQuotaLimit     string = "X-Quota-Limit"
+	HeaderQuotaRemaining string = "X-Quota-Remaining"
+	HeaderQuotaReset     string = "X-Quota-Reset"
+	HeaderQuotaPeriod    string = "X-Quota-Period"
+	HeaderRetryAfter     string = "Retry-After"
 )
 
-// RequireAPIToken checks a valid token, and stores the
-// userID for the token to the context
-func RequireAPIToken(extDeps *extdeps.ExternalServicesBuilder) gin.HandlerFunc {
+// NewQuotaEnforcer creates the enforcer of the api keys quotas, to
+// be installed with QuotasMiddleware. The quotas and the usage (as
+// written by the usage tracker) are reloaded from the sql database
+// after the ttl.
+func NewQuotaEnforcer(extDeps *extdeps.ExternalServicesBuilder,
+	ttl time.Duration) *tokenapi.QuotaEnforcer {
+	ins := extDeps.Insighter()
+	return tokenapi.NewQuotaEnforcer(ins, tokenapi.NewRepoSQLX(ins, extDeps.SQL), ttl)
+}
+
+// checkQuota sets the quota headers, and rejects the request if
+// the quota of the key (or of its user) is exhausted. If the quota
+// cannot be checked, the request is let through.
+func checkQuota(c *gin.Context, quotas *tokenapi.QuotaEnforcer,
+	tk *tokenapi.APIKey) bool {
+	status, err := quotas.Check(tk.Key, tk.UserID)
+	if err != nil || status.Period == "" {
+		return true
+	}
+	h := c.Writer.Header()
+	reset := strconv.FormatInt(int64(math.Ceil(time.Until(status.Reset).Seconds())), 10)
+	h.Set(HeaderQuotaLimit, strconv.FormatInt(status.Limit, 10))
+	h.Set(HeaderQuotaRemaining, strconv.FormatInt(status.Remaining, 10))
+	h.Set(HeaderQuotaReset, reset)
+	h.Set(HeaderQuotaPeriod, status.Period)
+	if !status.Allowed {
+		h.Set(HeaderRetryAfter, reset)
+		c.JSON(http.StatusTooManyRequests, nil)
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// RequireAPIToken checks a valid token, that has not expired and
+// that has been granted all the required scopes, and stores the
+// userID, the key and the scopes of the token to the context. If
+// a tracker is provided, the use of the key is recorded. The keys
+// are looked up in the repo set with RepoMiddleware, or in the sql
+// database. When a QuotasMiddleware is installed, the requests
+// over the quota of the key are rejected with a 429 status, and
+// without a tracker the use is recorded with the tracker of the
+// QuotasMiddleware (so the quotas are always enforced).
+func RequireAPIToken(extDeps *extdeps.ExternalServicesBuilder,
+	tracker *tokenapi.UsageTracker, requiredScopes ...string) gin.HandlerFunc {
+
 	ins := extDeps.Insighter()
 	// here we construct the repository to check the api keys
 	tokenAPIRepo := tokenapi.NewRepoSQLX(ins, extDeps.SQL)
 	tokenAPI := tokenapi.NewTokenAPI(ins, tokenAPIRepo)
 
 	return func(c *gin.Context) {
-		strAPIKey, ok := c.Request.Header[apiKeyHeaderName]
+		strAPIKey, ok := c.Request.Header[auth.APIKeyHeader]
 		if !ok || len(strAPIKey) != 1 {
 			c.JSON(http.StatusUnauthorized, nil)
 			c.Abort()
 			return
 		}
-		var apiKey ids.ID
-		if err := apiKey.FromShuffled(strAPIKey[0]); err != nil {
-			c.JSON(http.StatusUnauthorized, nil)
-			c.Abort()
-			return
-		}
 
-		tk, err := tokenAPI.GetKey(apiKey)
+		checker := tokenAPI
+		if repo, ok := contextRepo(c); ok {
+			checker = tokenapi.NewTokenAPI(ins, repo)
+		}
+		tk, err := checker.CheckKey(strAPIKey[0])
 		if err != nil || tk == nil {
 			c.JSON(http.StatusUnauthorized, nil)
 			c.Abort()
 			return
 		}
 
-		if tk.Deleted != nil {
-			c.JSON(http.StatusUnauthorized, nil)
-			c.Abort()
-			return
+		for _, scope := range requiredScopes {
+			if !tk.HasScope(scope) {
+				c.JSON(http.StatusForbidden, nil)
+				c.Abort()
+				return
+			}
 		}
 
+		if quotas, ok := contextQuotas(c); ok && !checkQuota(c, quotas, tk) {
+			return
+		}
+		t := tracker
+		if t == nil {
+			t = contextTracker(c)
+		}
+		if t != nil {
+			t.Track(tk.Key, tk.UserID, time.Now())
+		}
 		auth.SetUserID(c, tk.UserID)
+		auth.SetScopes(c, tk.Scopes)
+		auth.SetAPIKey(c, tk.Key)
 	}
 }
@@ -3,4 +3,6 @@ package wtokenapi
 const (
 	// PathAPIKeys contains a route to display apikeys.
 	PathAPIKeys string = "apikeys"
+	// PathRotateAPIKey contains a route to rotate an apikey.
+	PathRotateAPIKey string = "apikeys/rotate"
 )
@@ -7,8 +7,11 @@ import (
 )
 
 // CreatePayload is the required payload to create an API token.
+// Expires is optional.
 type CreatePayload struct {
-	Description string `json:"description" binding:"required"`
+	Description string     `json:"description" binding:"required"`
+	Scopes      []string   `json:"scopes"`
+	Expires     *time.Time `json:"expires"`
 }
 
 // DeletePayload is the required payload to delete an API token.
@@ -16,11 +19,44 @@ type DeletePayload struct {
 	Key string `json:"key" binding:"required"`
 }
 
-// TokenAPIKey has the data for an API token
+// RotatePayload is the required payload to rotate an API token.
+// GraceSeconds is the time the rotated token can still be used.
+type RotatePayload struct {
+	Key          string `json:"key" binding:"required"`
+	GraceSeconds int64  `json:"grace_seconds"`
+}
+
+// Limits for the number of keys returned in a list page.
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+// ListQuery has the query params to list API tokens. State can
+// be empty (all the keys), active, deleted or expired. From is
+// the key to start the page after (or before, when listing
+// backwards).
+type ListQuery struct {
+	State     string `form:"state"`
+	From      string `form:"from"`
+	Limit     int    `form:"limit"`
+	Backwards bool   `form:"backwards"`
+}
+
+// TokenAPIKey has the data for an API token. The full Token
+// is only returned when the key is created.
 type TokenAPIKey struct {
-	Key         string `json:"key"`
-	Created     string `json:"created"`
-	Description string `json:"description"`
+	Key         string   `json:"key"`
+	Token       string   `json:"token,omitempty"`
+	Created     string   `json:"created"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+	Expires     string   `json:"expires,omitempty"`
+	LastUsed    string   `json:"last_used,omitempty"`
+	Requests    int64    `json:"requests"`
+	Deleted     string   `json:"deleted,omitempty"`
+	RotatedFrom string   `json:"rotated_from,omitempty"`
+	RotatedTo   string   `json:"rotated_to,omitempty"`
 }
 
 // TokenAPIKeyList has a list of TokenAPIKey's
@@ -29,11 +65,30 @@ type TokenAPIKeyList struct {
 }
 
 func fromTokenAPI(t *tokenapi.APIKey) *TokenAPIKey {
-	return &TokenAPIKey{
+	res := &TokenAPIKey{
 		Key:         t.Key.ToShuffled(),
+		Token:       t.Token,
 		Created:     t.Created.Format(time.RFC3339),
 		Description: t.Description,
+		Scopes:      t.Scopes,
+		Requests:    t.Requests,
 	}
+	if t.Expires != nil {
+		res.Expires = t.Expires.Format(time.RFC3339)
+	}
+	if t.LastUsed != nil {
+		res.LastUsed = t.LastUsed.Format(time.RFC3339)
+	}
+	if t.Deleted != nil {
+		res.Deleted = t.Deleted.Format(time.RFC3339)
+	}
+	if t.RotatedFrom != nil {
+		res.RotatedFrom = t.RotatedFrom.ToShuffled()
+	}
+	if t.RotatedTo != nil {
+		res.RotatedTo = t.RotatedTo.ToShuffled()
+	}
+	return res
 }
 
 func fromTokenAPISlice(t []tokenapi.APIKey) TokenAPIKeyList {
@@ -1,14 +1,17 @@
 package wtokenapi
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
 	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
 	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/tokenapi"
 )
 
 // WAPIRoutes sets up the routes to handle token api keys.
@@ -22,6 +25,9 @@ func WAPIRoutes(r gin.IRouter) {
 	r.DELETE(PathAPIKeys,
 		session.AuthRequired(),
 		WAPIDelete)
+	r.POST(PathRotateAPIKey,
+		session.AuthRequired(),
+		WAPIRotate)
 }
 
 // OKRes is the response for a successful operation.
@@ -46,7 +52,7 @@ func WAPICreate(c *gin.Context) {
 		return
 	}
 	ctrl := buildController(c)
-	res, err := ctrl.CreateKey(*userID, p.Description)
+	res, err := ctrl.CreateKey(*userID, p.Description, p.Scopes, p.Expires)
 	if err != nil {
 		// we do not leak the reason why the registration failed
 		c.JSON(http.StatusOK, FailRes{Success: false, Error: err.Error()})
@@ -57,7 +63,9 @@ func WAPICreate(c *gin.Context) {
 	c.JSON(http.StatusOK, *jres)
 }
 
-// WAPIList is the handler for the list api tokens endpoint.
+// WAPIList is the handler for the list api tokens endpoint. The
+// keys can be filtered by state and paginated with the ListQuery
+// params.
 func WAPIList(c *gin.Context) {
 	userID := auth.GetUserID(c)
 	if userID == nil {
@@ -65,8 +73,32 @@ func WAPIList(c *gin.Context) {
 		return
 	}
 
+	q := ListQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{
+			Error: fmt.Sprintf("bad query %s", err.Error())})
+		return
+	}
+	state := tokenapi.KeyState(q.State)
+	if !state.Valid() {
+		c.JSON(http.StatusBadRequest, FailRes{Error: tokenapi.ErrInvalidState.Error()})
+		return
+	}
+	var from ids.ID
+	if q.From != "" {
+		if err := from.FromShuffled(q.From); err != nil {
+			c.JSON(http.StatusBadRequest, FailRes{Error: fmt.Sprintf("from %s", err.Error())})
+			return
+		}
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultListLimit
+	} else if q.Limit > MaxListLimit {
+		q.Limit = MaxListLimit
+	}
+
 	ctrl := buildController(c)
-	res, err := ctrl.ListKeys(*userID, false)
+	res, err := ctrl.ListKeysPage(*userID, state, from, q.Limit, q.Backwards)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
 		return
@@ -102,3 +134,45 @@ func WAPIDelete(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, OKRes{Success: true})
 }
+
+// WAPIRotate is the handler for the rotate api token endpoint. It
+// returns the successor token, and the rotated one can still be
+// used during the grace period.
+func WAPIRotate(c *gin.Context) {
+	userID := auth.GetUserID(c)
+
+	p := RotatePayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{
+			Error: fmt.Sprintf("bad payload %s", err.Error())})
+		return
+	}
+
+	var keyID ids.ID
+	if err := keyID.FromShuffled(p.Key); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: fmt.Sprintf("key %s", err.Error())})
+		return
+	}
+
+	// check the range before converting, as a big number of
+	// seconds overflows the duration
+	if p.GraceSeconds < 0 || p.GraceSeconds > int64(tokenapi.MaxRotationGrace/time.Second) {
+		c.JSON(http.StatusBadRequest, FailRes{Error: tokenapi.ErrInvalidGrace.Error()})
+		return
+	}
+
+	ctrl := buildController(c)
+	res, err := ctrl.RotateKey(*userID, keyID, time.Duration(p.GraceSeconds)*time.Second)
+	if err != nil {
+		switch {
+		case errors.Is(err, tokenapi.ErrInvalidGrace):
+			c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		case errors.Is(err, tokenapi.ErrNotFound):
+			c.JSON(http.StatusNotFound, FailRes{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, *fromTokenAPI(res))
+}
@@ -0,0 +1,131 @@
+package wusers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
+)
+
+// EmailChangeForm renders the form to change the email of
+// the logged in user
+func EmailChangeForm(c *gin.Context, actionPaths *ActionPaths) {
+	c.HTML(http.StatusOK, TemplEmailChangeForm,
+		gin.H{
+			"csrf_token":      session.GetCSRFTokenInput(c),
+			"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+		})
+}
+
+// RequestEmailChange sends the confirmation link to the new
+// email and the revert link to the current one
+func RequestEmailChange(c *gin.Context, actionPaths *ActionPaths) {
+	ed := ginfw.ExtServices(c)
+	userID := auth.GetUserID(c)
+	p := EmailChangePayload{}
+	err := c.ShouldBindWith(&p, binding.Form)
+	if err == nil {
+		regUC := buildController(c, actionPaths)
+		err = regUC.RequestEmailChange(*userID, p.Email)
+	}
+	if err != nil {
+		ed.Ins.L.Warn("cannot request email change", map[string]interface{}{
+			"error": err.Error(),
+		})
+		emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
+		switch {
+		case errors.Is(err, users.ErrUserExists):
+			emailUserAuth.FormErrors = []string{"The email is already in use"}
+		case errors.Is(err, users.ErrInvalidEmail):
+			emailUserAuth.FormErrors = []string{"Invalid email"}
+		default:
+			emailUserAuth.FormErrors = []string{"Cannot change the email"}
+		}
+		c.HTML(http.StatusBadRequest, TemplEmailChangeForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": emailUserAuth,
+			})
+		return
+	}
+	c.HTML(http.StatusOK, TemplEmailChangeSent, gin.H{"email": p.Email})
+}
+
+// emailChangeTokenForm renders a form to post the token, so the
+// change is not applied by email clients that prefetch the links
+func emailChangeTokenForm(c *gin.Context, actionPaths *ActionPaths, action string) {
+	token := c.Query("token")
+	if token == "" {
+		c.HTML(http.StatusBadRequest, TemplEmailChangeDone,
+			gin.H{
+				"error": "missing token",
+			})
+		return
+	}
+	c.HTML(http.StatusOK, TemplEmailChangeTokenForm,
+		gin.H{
+			"token":      token,
+			"action":     action,
+			"csrf_token": session.GetCSRFTokenInput(c),
+		})
+}
+
+// ConfirmEmailChangeForm renders the form to confirm an email change
+func ConfirmEmailChangeForm(c *gin.Context, actionPaths *ActionPaths) {
+	emailChangeTokenForm(c, actionPaths, "Confirm")
+}
+
+// RevertEmailChangeForm renders the form to revert an email change
+func RevertEmailChangeForm(c *gin.Context, actionPaths *ActionPaths) {
+	emailChangeTokenForm(c, actionPaths, "Revert")
+}
+
+// ConfirmEmailChange applies an email change with the token sent
+// to the new address
+func ConfirmEmailChange(c *gin.Context, actionPaths *ActionPaths) {
+	p := EmailChangeTokenPayload{}
+	err := c.ShouldBindWith(&p, binding.Form)
+	if err == nil {
+		regUC := buildController(c, actionPaths)
+		err = regUC.ConfirmEmailChange(p.Token)
+	}
+	if err != nil {
+		c.HTML(http.StatusBadRequest, TemplEmailChangeDone,
+			gin.H{
+				"error": err.Error(),
+			})
+		return
+	}
+	c.HTML(http.StatusOK, TemplEmailChangeDone,
+		gin.H{
+			"message": "Your email has been changed",
+		})
+}
+
+// RevertEmailChange cancels or undoes an email change with the
+// token sent to the old address
+func RevertEmailChange(c *gin.Context, actionPaths *ActionPaths) {
+	p := EmailChangeTokenPayload{}
+	err := c.ShouldBindWith(&p, binding.Form)
+	if err == nil {
+		regUC := buildController(c, actionPaths)
+		err = regUC.RevertEmailChange(p.Token)
+	}
+	if err != nil {
+		c.HTML(http.StatusBadRequest, TemplEmailChangeDone,
+			gin.H{
+				"error": err.Error(),
+			})
+		return
+	}
+	c.HTML(http.StatusOK, TemplEmailChangeDone,
+		gin.H{
+			"message": "The email change has been reverted",
+		})
+}
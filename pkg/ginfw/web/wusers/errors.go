@@ -0,0 +1,10 @@
+package wusers
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Configuration errors of the handlers
+const (
+	ErrNoHost = consterr.ConstErr("ErrNoHost")
+)
@@ -0,0 +1,144 @@
+package wusers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+)
+
+// RequestLoginLinkForm renders the form to request a link to
+// log in without password
+func RequestLoginLinkForm(c *gin.Context, actionPaths *ActionPaths) {
+	c.HTML(http.StatusOK, TemplRequestLoginLinkForm,
+		gin.H{
+			"csrf_token":      session.GetCSRFTokenInput(c),
+			"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+		})
+}
+
+// RequestLoginLink sends an email with a link to log in
+func RequestLoginLink(c *gin.Context, actionPaths *ActionPaths) {
+	ed := ginfw.ExtServices(c)
+	p := RequestLoginLinkPayload{}
+	if err := c.ShouldBindWith(&p, binding.Form); err != nil {
+		emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
+		emailUserAuth.FormErrors = []string{"missing email field"}
+		c.HTML(http.StatusBadRequest, TemplRequestLoginLinkForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": emailUserAuth,
+			})
+		return
+	}
+	if len(actionPaths.Host) == 0 {
+		// a client could set the host to receive the token
+		ed.Ins.L.Err(ErrNoHost, "login links require a configured host", nil)
+		emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
+		emailUserAuth.FormErrors = []string{"login links are not available"}
+		c.HTML(http.StatusServiceUnavailable, TemplRequestLoginLinkForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": emailUserAuth,
+			})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	if err := regUC.RequestLoginLink(p.Email); err != nil {
+		// we do not leak info about if the user exists or not
+		ed.Ins.L.Err(err, "cannot request login link", map[string]interface{}{
+			"email": p.Email,
+		})
+	}
+	c.HTML(http.StatusOK, TemplLoginLinkSent, gin.H{})
+}
+
+// LoginLinkForm renders a form to confirm the login with the link
+// token. The token is not consumed on GET, so the links are not
+// wasted by email clients that prefetch them.
+func LoginLinkForm(c *gin.Context, actionPaths *ActionPaths) {
+	token := c.Query("token")
+	if token == "" {
+		c.Redirect(http.StatusFound, actionPaths.BasePath+"/"+PathRequestLoginLink)
+		c.Abort()
+		return
+	}
+	c.HTML(http.StatusOK, TemplLoginLinkForm,
+		gin.H{
+			"token":           token,
+			"csrf_token":      session.GetCSRFTokenInput(c),
+			"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+			"next_page":       c.Query(ParamNextPage),
+		})
+}
+
+// LoginWithLink logs in the user with the login link token
+func LoginWithLink(c *gin.Context, actionPaths *ActionPaths) {
+	ed := ginfw.ExtServices(c)
+	p := LoginLinkPayload{}
+	if err := c.ShouldBindWith(&p, binding.Form); err != nil {
+		c.Redirect(http.StatusFound, actionPaths.BasePath+"/"+PathRequestLoginLink)
+		c.Abort()
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	userID, err := regUC.LoginWithLink(p.Token)
+	if err != nil {
+		ed.Ins.L.Warn("cannot login with link", map[string]interface{}{
+			"error": err.Error(),
+		})
+		emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
+		emailUserAuth.FormErrors = []string{
+			"The link has expired or has already been used",
+		}
+		c.HTML(http.StatusBadRequest, TemplRequestLoginLinkForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": emailUserAuth,
+			})
+		return
+	}
+
+	hasTOTP, err := regUC.HasTOTP(userID)
+	if err != nil {
+		ed.Ins.L.Err(err, "cannot check second factor", nil)
+		c.HTML(http.StatusInternalServerError, TemplLoginForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+			})
+		return
+	}
+	if hasTOTP {
+		session.SetPendingUserID(c, userID.ToUUID())
+		c.HTML(http.StatusOK, TemplLoginTOTPForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+				"next_page":       p.NextPage,
+			})
+		return
+	}
+
+	u, _ := regUC.GetUser(userID)
+	htmlFields := gin.H{
+		"email":           "Not found",
+		"created":         "?",
+		"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+	}
+	if u != nil {
+		if err := session.SetUserID(c, u.ID.ToUUID()); err != nil {
+			loginUnavailable(c, actionPaths, err)
+			return
+		}
+		htmlFields["email"] = u.Email
+		htmlFields["created"] = u.Created
+	}
+	if len(p.NextPage) > 0 {
+		htmlFields["redirect"] = p.NextPage
+	}
+	c.HTML(http.StatusOK, TemplLoginSuccess, htmlFields)
+}
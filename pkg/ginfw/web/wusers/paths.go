@@ -1,5 +1,9 @@
 package wusers
 
+// ParamNextPage is the query param (and form field) with the page
+// to go to once the user has logged in.
+const ParamNextPage string = "nextpage"
+
 // These are the definitions for paths and template names
 // for user registration flows.
 const (
@@ -9,6 +13,17 @@ const (
 	PathLogout               string = "logout"
 	PathRequestPasswordReset string = "requestresetpassword"
 	PathResetPassword        string = "resetpassword"
+	PathLoginTOTP            string = "login/totp"
+	PathTOTPEnroll           string = "totp/enroll"
+	PathTOTPConfirm          string = "totp/confirm"
+	PathTOTPDisable          string = "totp/disable"
+	PathRequestLoginLink     string = "requestloginlink"
+	PathLoginLink            string = "loginlink"
+	PathEmailChange          string = "emailchange"
+	PathConfirmEmailChange   string = "emailchange/confirm"
+	PathRevertEmailChange    string = "emailchange/revert"
+	PathSessions             string = "sessions"
+	PathSession              string = "sessions/:id"
 
 	TemplLogin string = "wusers_login.html"
 
@@ -25,4 +40,18 @@ const (
 	TemplResetPasswordTokenSent   string = "wusers_registration_reset_password_token_sent.html"
 	TemplResetPasswordForm        string = "wusers_registration_reset_password_form.html"
 	TemplResetPasswordSuccess     string = "wusers_registration_reset_password_success.html"
+
+	TemplLoginTOTPForm  string = "wusers_login_totp.html"
+	TemplTOTPEnrollForm string = "wusers_totp_enroll.html"
+	TemplTOTPEnabled    string = "wusers_totp_enabled.html"
+	TemplTOTPDisabled   string = "wusers_totp_disabled.html"
+
+	TemplRequestLoginLinkForm string = "wusers_request_login_link_form.html"
+	TemplLoginLinkSent        string = "wusers_login_link_sent.html"
+	TemplLoginLinkForm        string = "wusers_login_link_form.html"
+
+	TemplEmailChangeForm      string = "wusers_email_change_form.html"
+	TemplEmailChangeSent      string = "wusers_email_change_sent.html"
+	TemplEmailChangeTokenForm string = "wusers_email_change_token_form.html"
+	TemplEmailChangeDone      string = "wusers_email_change_done.html"
 )
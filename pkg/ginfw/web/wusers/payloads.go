@@ -26,3 +26,33 @@ type ResetPasswordWithTokenPayload struct {
 	Token    string `form:"token" binding:"required"`
 	Password string `form:"password" binding:"required"`
 }
+
+// TOTPCodePayload contains a second factor code (from the
+// authenticator app, or a recovery code).
+type TOTPCodePayload struct {
+	Code     string `form:"code" json:"code" binding:"required"`
+	NextPage string `form:"nextpage" json:"nextpage"`
+}
+
+// RequestLoginLinkPayload contains the data to request a
+// link to log in without password.
+type RequestLoginLinkPayload struct {
+	Email string `form:"email" json:"email" binding:"required"`
+}
+
+// LoginLinkPayload contains the token from a login link.
+type LoginLinkPayload struct {
+	Token    string `form:"token" json:"token" binding:"required"`
+	NextPage string `form:"nextpage" json:"nextpage"`
+}
+
+// EmailChangePayload contains the new email for a user.
+type EmailChangePayload struct {
+	Email string `form:"email" json:"email" binding:"required"`
+}
+
+// EmailChangeTokenPayload contains the token to confirm or
+// revert an email change.
+type EmailChangeTokenPayload struct {
+	Token string `form:"token" json:"token" binding:"required"`
+}
@@ -0,0 +1,17 @@
+package wusers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
+)
+
+// LoadPermissions reads the permissions of a user from the
+// SQL roles tables, to be used with auth.PermissionsMiddleware.
+func LoadPermissions(c *gin.Context, userID ids.ID) ([]string, error) {
+	ed := ginfw.ExtServices(c)
+	roles := users.NewRoles(ed.Ins, users.NewRepoSQLX(ed.Ins, ed.SQL, ""))
+	return roles.UserPermissions(userID)
+}
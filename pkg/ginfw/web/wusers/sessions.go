@@ -0,0 +1,114 @@
+package wusers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
+)
+
+// NewSessionRegistry creates a SQL backed session registry, to be
+// used as the session.Conf NewRegistry function.
+func NewSessionRegistry(c *gin.Context) session.Registry {
+	return sessionRegistry{buildSessionRegistry(c)}
+}
+
+// sessionRegistry adapts a users.SessionRegistry to the errors
+// of a session.Registry.
+type sessionRegistry struct {
+	*users.SessionRegistry
+}
+
+func (r sessionRegistry) Touch(sessionID ids.ID) (ids.ID, error) {
+	userID, err := r.SessionRegistry.Touch(sessionID)
+	if errors.Is(err, users.ErrSessionRevoked) {
+		return userID, session.ErrSessionRevoked
+	}
+	return userID, err
+}
+
+func buildSessionRegistry(c *gin.Context) *users.SessionRegistry {
+	ed := ginfw.ExtServices(c)
+	return users.NewSessionRegistry(users.NewRepoSQLX(ed.Ins, ed.SQL, ""))
+}
+
+// SessionRes has the data of a logged in session.
+type SessionRes struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Current   bool      `json:"current"`
+}
+
+// SessionsRes has the list of active sessions of a user.
+type SessionsRes struct {
+	Sessions []SessionRes `json:"sessions"`
+}
+
+// WAPIListSessions is the handler to list the active sessions
+// of the logged in user.
+func WAPIListSessions(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	list, err := buildSessionRegistry(c).List(*userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	current := session.GetSessionID(c)
+	res := SessionsRes{Sessions: make([]SessionRes, 0, len(list))}
+	for _, s := range list {
+		res.Sessions = append(res.Sessions, SessionRes{
+			ID:        s.ID.ToUUID(),
+			Created:   s.Created,
+			LastSeen:  s.LastSeen,
+			IP:        s.IP,
+			UserAgent: s.UserAgent,
+			Current:   s.ID.ToUUID() == current,
+		})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// WAPIRevokeSession is the handler to revoke one of the sessions
+// of the logged in user.
+func WAPIRevokeSession(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	var sessionID ids.ID
+	if err := sessionID.FromUUID(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: "bad session id"})
+		return
+	}
+	if err := buildSessionRegistry(c).Revoke(*userID, sessionID); err != nil {
+		if errors.Is(err, users.ErrNotFound) {
+			c.JSON(http.StatusNotFound, FailRes{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	if sessionID.ToUUID() == session.GetSessionID(c) {
+		session.ClearUserID(c)
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPIRevokeAllSessions is the handler to log out the user
+// everywhere (including the current session).
+func WAPIRevokeAllSessions(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if err := buildSessionRegistry(c).RevokeAll(*userID); err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	session.ClearUserID(c)
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
@@ -12,7 +12,9 @@ import (
 	"github.com/gin-gonic/gin/binding"
 
 	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
 	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+	"github.com/dhontecillas/hfw/pkg/ids"
 	"github.com/dhontecillas/hfw/pkg/usecases/users"
 )
 
@@ -39,6 +41,38 @@ func Routes(r gin.IRouter, actionPaths ActionPaths) {
 		emailRegistrationMiddleware(ResetPasswordForm, actionPaths))
 	r.POST(PathResetPassword,
 		emailRegistrationMiddleware(ResetPasswordWithToken, actionPaths))
+	r.GET(PathLoginTOTP,
+		emailRegistrationMiddleware(LoginTOTPForm, actionPaths))
+	r.POST(PathLoginTOTP,
+		emailRegistrationMiddleware(LoginTOTP, actionPaths))
+	r.GET(PathTOTPEnroll, session.AuthRequired(),
+		emailRegistrationMiddleware(TOTPEnrollForm, actionPaths))
+	r.POST(PathTOTPEnroll, session.AuthRequired(),
+		emailRegistrationMiddleware(TOTPEnroll, actionPaths))
+	r.POST(PathTOTPConfirm, session.AuthRequired(),
+		emailRegistrationMiddleware(TOTPConfirm, actionPaths))
+	r.POST(PathTOTPDisable, session.AuthRequired(),
+		emailRegistrationMiddleware(TOTPDisable, actionPaths))
+	r.GET(PathRequestLoginLink,
+		emailRegistrationMiddleware(RequestLoginLinkForm, actionPaths))
+	r.POST(PathRequestLoginLink,
+		emailRegistrationMiddleware(RequestLoginLink, actionPaths))
+	r.GET(PathLoginLink,
+		emailRegistrationMiddleware(LoginLinkForm, actionPaths))
+	r.POST(PathLoginLink,
+		emailRegistrationMiddleware(LoginWithLink, actionPaths))
+	r.GET(PathEmailChange, session.AuthRequired(),
+		emailRegistrationMiddleware(EmailChangeForm, actionPaths))
+	r.POST(PathEmailChange, session.AuthRequired(),
+		emailRegistrationMiddleware(RequestEmailChange, actionPaths))
+	r.GET(PathConfirmEmailChange,
+		emailRegistrationMiddleware(ConfirmEmailChangeForm, actionPaths))
+	r.POST(PathConfirmEmailChange,
+		emailRegistrationMiddleware(ConfirmEmailChange, actionPaths))
+	r.GET(PathRevertEmailChange,
+		emailRegistrationMiddleware(RevertEmailChangeForm, actionPaths))
+	r.POST(PathRevertEmailChange,
+		emailRegistrationMiddleware(RevertEmailChange, actionPaths))
 }
 
 // ActionPaths indicates the path to where to
@@ -48,10 +82,30 @@ func Routes(r gin.IRouter, actionPaths ActionPaths) {
 //     a user (the token param will be appended to it)
 //   - ResetPasswordPath: the path to were to redirect a user with'
 //     a reset password token (the token param will be appended to it)
+//   - LoginLinkPath: the path to were to redirect a user with a
+//     login link token (the token param will be appended to it)
+//   - EmailChangePath / EmailRevertPath: the paths to where to
+//     redirect a user to confirm or revert an email change (the
+//     token param will be appended to them)
+//   - Lockout: the configuration to lock logins after repeated
+//     failures (zero values use the defaults)
+//   - TOTPIssuer: the name shown in the authenticator apps (if
+//     not set, the Host is used)
+//   - Scheme / Host: the public scheme (https by default) and host
+//     (with the port) of the links in the emails. If the Host is not
+//     set, the request host is used (that can be set by the client),
+//     and the login links are not sent.
 type ActionPaths struct {
 	BasePath          string
 	ActivationPath    string
 	ResetPasswordPath string
+	LoginLinkPath     string
+	EmailChangePath   string
+	EmailRevertPath   string
+	Lockout           users.LockoutConf
+	TOTPIssuer        string
+	Scheme            string
+	Host              string
 }
 
 // EmailUserAuthRenderData contains the data required
@@ -92,6 +146,18 @@ func emailRegistrationMiddleware(
 	if len(actionPaths.ResetPasswordPath) == 0 {
 		actionPaths.ResetPasswordPath = actionPaths.BasePath + "/" + PathResetPassword
 	}
+	if len(actionPaths.LoginLinkPath) == 0 {
+		actionPaths.LoginLinkPath = actionPaths.BasePath + "/" + PathLoginLink
+	}
+	if len(actionPaths.EmailChangePath) == 0 {
+		actionPaths.EmailChangePath = actionPaths.BasePath + "/" + PathConfirmEmailChange
+	}
+	if len(actionPaths.EmailRevertPath) == 0 {
+		actionPaths.EmailRevertPath = actionPaths.BasePath + "/" + PathRevertEmailChange
+	}
+	if err := actionPaths.Lockout.Validate(); err != nil {
+		panic(fmt.Sprintf("bad lockout configuration: %s", err.Error()))
+	}
 	return func(c *gin.Context) {
 		fn(c, &actionPaths)
 	}
@@ -101,24 +167,33 @@ func buildController(c *gin.Context, actionPaths *ActionPaths) *users.EmailRegis
 	ed := ginfw.ExtServices(c)
 	tokenSalt := fmt.Sprintf("%s%d", c.Request.Host, time.Now().Unix())
 	repo := users.NewRepoSQLX(ed.Ins, ed.SQL, tokenSalt)
-	// the default is https, and we do not trust the Request Host field
-	// because we could be running behind a proxy (like nginx)
-	scheme := c.Request.Header.Get("X-Forwarded-Proto")
-	if scheme == "" {
-		if strings.Contains(c.Request.Host, "localhost") {
+	scheme, host := actionPaths.Scheme, actionPaths.Host
+	if host == "" {
+		// the default is https, and we do not trust the Request Host field
+		// because we could be running behind a proxy (like nginx)
+		scheme = c.Request.Header.Get("X-Forwarded-Proto")
+		host = c.Request.Host
+		if scheme == "" && strings.Contains(host, "localhost") {
 			scheme = "http" // for localhost, drop tls
-		} else {
-			scheme = "https"
 		}
 	}
+	if scheme == "" {
+		scheme = "https"
+	}
 
 	hostInfo := users.HostInfo{
 		Scheme:            scheme,
-		Host:              c.Request.Host,
+		Host:              host,
 		ActivationPath:    actionPaths.ActivationPath,
 		ResetPasswordPath: actionPaths.ResetPasswordPath,
+		LoginLinkPath:     actionPaths.LoginLinkPath,
+		EmailChangePath:   actionPaths.EmailChangePath,
+		EmailRevertPath:   actionPaths.EmailRevertPath,
 	}
-	return users.NewEmailRegistration(ed.Ins, ed.Composer, ed.MailSender, repo, hostInfo)
+	// the lockout conf has already been validated when setting up the routes
+	throttler, _ := users.NewLoginThrottler(repo, actionPaths.Lockout)
+	return users.NewEmailRegistration(ed.Ins, ed.Composer, ed.MailSender, repo,
+		hostInfo, throttler, users.NewSessionRegistry(repo))
 }
 
 // Register implements the user registration api request
@@ -274,7 +349,7 @@ func ResetPasswordWithToken(c *gin.Context, actionPaths *ActionPaths) {
 
 // LoginForm returns the template to render the login page
 func LoginForm(c *gin.Context, actionPaths *ActionPaths) {
-	nextPage := c.Query("next_page")
+	nextPage := c.Query(ParamNextPage)
 	c.HTML(http.StatusOK, TemplLogin,
 		gin.H{
 			"csrf_token":      session.GetCSRFTokenInput(c),
@@ -292,18 +367,48 @@ func Login(c *gin.Context, actionPaths *ActionPaths) {
 		ed.Ins.L.Err(err, "missing fields", nil)
 	}
 	regUC := buildController(c, actionPaths)
-	userID, err := regUC.Login(lp.Email, lp.Password)
+	userID, err := regUC.Login(lp.Email, lp.Password, c.ClientIP())
 	if err != nil {
 		ed.Ins.L.Err(err, "cannot login", nil)
 		emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
-		emailUserAuth.FormErrors = []string{
-			"Incorrect email or password",
+		status := http.StatusOK
+		if errors.Is(err, users.ErrLocked) {
+			status = http.StatusTooManyRequests
+			emailUserAuth.FormErrors = []string{
+				"Too many failed attempts, try again later",
+			}
+		} else {
+			emailUserAuth.FormErrors = []string{
+				"Incorrect email or password",
+			}
 		}
-		c.HTML(http.StatusOK, "wusers_login.html",
+		c.HTML(status, TemplLoginForm,
 			gin.H{
 				"csrf_token":      session.GetCSRFTokenInput(c),
 				"email_user_auth": emailUserAuth,
 			})
+		return
+	}
+
+	hasTOTP, err := regUC.HasTOTP(userID)
+	if err != nil {
+		ed.Ins.L.Err(err, "cannot check second factor", nil)
+		c.HTML(http.StatusInternalServerError, TemplLoginForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+			})
+		return
+	}
+	if hasTOTP {
+		session.SetPendingUserID(c, userID.ToUUID())
+		c.HTML(http.StatusOK, TemplLoginTOTPForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+				"next_page":       lp.NextPage,
+			})
+		return
 	}
 
 	u, _ := regUC.GetUser(userID)
@@ -313,9 +418,12 @@ func Login(c *gin.Context, actionPaths *ActionPaths) {
 		"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
 	}
 	if u != nil {
+		if err := session.SetUserID(c, u.ID.ToUUID()); err != nil {
+			loginUnavailable(c, actionPaths, err)
+			return
+		}
 		htmlFields["email"] = u.Email
 		htmlFields["created"] = u.Created
-		session.SetUserID(c, u.ID.ToUUID())
 	}
 	if len(lp.NextPage) > 0 {
 		htmlFields["redirect"] = lp.NextPage
@@ -333,3 +441,191 @@ func Logout(c *gin.Context, actionPaths *ActionPaths) {
 	session.ClearUserID(c)
 	c.HTML(http.StatusOK, TemplLogoutSuccess, htmlFields)
 }
+
+// LoginTOTPForm renders the form to enter the second factor code
+func LoginTOTPForm(c *gin.Context, actionPaths *ActionPaths) {
+	if session.GetPendingUserID(c) == "" {
+		c.Redirect(http.StatusFound, actionPaths.BasePath+"/"+PathLogin)
+		c.Abort()
+		return
+	}
+	c.HTML(http.StatusOK, TemplLoginTOTPForm,
+		gin.H{
+			"csrf_token":      session.GetCSRFTokenInput(c),
+			"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+			"next_page":       c.Query(ParamNextPage),
+		})
+}
+
+// loginUnavailable renders the login form when the session of a
+// valid login cannot be started.
+func loginUnavailable(c *gin.Context, actionPaths *ActionPaths, err error) {
+	ginfw.ExtServices(c).Ins.L.Err(err, "cannot start session", nil)
+	emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
+	emailUserAuth.FormErrors = []string{
+		"Cannot log in now, try again later",
+	}
+	c.HTML(http.StatusServiceUnavailable, TemplLoginForm,
+		gin.H{
+			"csrf_token":      session.GetCSRFTokenInput(c),
+			"email_user_auth": emailUserAuth,
+		})
+}
+
+// LoginTOTP completes the login of a user that has provided
+// a valid password with the second factor code
+func LoginTOTP(c *gin.Context, actionPaths *ActionPaths) {
+	ed := ginfw.ExtServices(c)
+	strUserID := session.GetPendingUserID(c)
+	var userID ids.ID
+	if strUserID == "" || userID.FromUUID(strUserID) != nil {
+		c.Redirect(http.StatusFound, actionPaths.BasePath+"/"+PathLogin)
+		c.Abort()
+		return
+	}
+
+	p := TOTPCodePayload{}
+	err := c.ShouldBindWith(&p, binding.Form)
+	if err == nil {
+		regUC := buildController(c, actionPaths)
+		err = regUC.VerifySecondFactor(userID, p.Code)
+	}
+	if errors.Is(err, users.ErrLocked) {
+		// the password has to be provided again after the lock
+		session.ClearPendingUserID(c)
+		emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
+		emailUserAuth.FormErrors = []string{
+			"Too many failed attempts, try again later",
+		}
+		c.HTML(http.StatusTooManyRequests, TemplLoginForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": emailUserAuth,
+			})
+		return
+	}
+	if err != nil {
+		ed.Ins.L.Warn("cannot verify second factor", map[string]interface{}{
+			"error": err.Error(),
+		})
+		emailUserAuth := NewEmailUserAuthRenderData(actionPaths.BasePath)
+		emailUserAuth.FormErrors = []string{
+			"Invalid code",
+		}
+		c.HTML(http.StatusOK, TemplLoginTOTPForm,
+			gin.H{
+				"csrf_token":      session.GetCSRFTokenInput(c),
+				"email_user_auth": emailUserAuth,
+				"next_page":       p.NextPage,
+			})
+		return
+	}
+
+	session.ClearPendingUserID(c)
+	if err := session.SetUserID(c, strUserID); err != nil {
+		loginUnavailable(c, actionPaths, err)
+		return
+	}
+
+	regUC := buildController(c, actionPaths)
+	u, _ := regUC.GetUser(userID)
+	htmlFields := gin.H{
+		"email":           "Not found",
+		"created":         "?",
+		"email_user_auth": NewEmailUserAuthRenderData(actionPaths.BasePath),
+	}
+	if u != nil {
+		htmlFields["email"] = u.Email
+		htmlFields["created"] = u.Created
+	}
+	if len(p.NextPage) > 0 {
+		htmlFields["redirect"] = p.NextPage
+	}
+	c.HTML(http.StatusOK, TemplLoginSuccess, htmlFields)
+}
+
+func totpIssuer(c *gin.Context, actionPaths *ActionPaths) string {
+	if len(actionPaths.TOTPIssuer) > 0 {
+		return actionPaths.TOTPIssuer
+	}
+	if len(actionPaths.Host) > 0 {
+		return actionPaths.Host
+	}
+	return c.Request.Host
+}
+
+// TOTPEnrollForm renders the form to start the set up of the
+// second factor for the logged in user
+func TOTPEnrollForm(c *gin.Context, actionPaths *ActionPaths) {
+	c.HTML(http.StatusOK, TemplTOTPEnrollForm,
+		gin.H{
+			"csrf_token": session.GetCSRFTokenInput(c),
+		})
+}
+
+// TOTPEnroll creates a new TOTP secret for the logged in user, and
+// renders the form to confirm it with a code from the authenticator app
+func TOTPEnroll(c *gin.Context, actionPaths *ActionPaths) {
+	userID := auth.GetUserID(c)
+	regUC := buildController(c, actionPaths)
+	enrollment, err := regUC.EnrollTOTP(*userID, totpIssuer(c, actionPaths))
+	if err != nil {
+		ed := ginfw.ExtServices(c)
+		ed.Ins.L.Err(err, "cannot enroll totp", nil)
+		c.HTML(http.StatusBadRequest, TemplTOTPEnrollForm,
+			gin.H{
+				"error": err.Error(),
+			})
+		return
+	}
+	c.HTML(http.StatusOK, TemplTOTPEnrollForm,
+		gin.H{
+			"csrf_token":       session.GetCSRFTokenInput(c),
+			"secret":           enrollment.Secret,
+			"provisioning_uri": enrollment.ProvisioningURI,
+		})
+}
+
+// TOTPConfirm enables the second factor for the logged in user, and
+// renders the recovery codes
+func TOTPConfirm(c *gin.Context, actionPaths *ActionPaths) {
+	userID := auth.GetUserID(c)
+	p := TOTPCodePayload{}
+	if err := c.ShouldBindWith(&p, binding.Form); err != nil {
+		c.Redirect(http.StatusFound, actionPaths.BasePath+"/"+PathTOTPEnroll)
+		c.Abort()
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	codes, err := regUC.ConfirmTOTP(*userID, p.Code)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, TemplTOTPEnrollForm,
+			gin.H{
+				"error": err.Error(),
+			})
+		return
+	}
+	c.HTML(http.StatusOK, TemplTOTPEnabled,
+		gin.H{
+			"recovery_codes": codes,
+		})
+}
+
+// TOTPDisable removes the second factor for the logged in user
+func TOTPDisable(c *gin.Context, actionPaths *ActionPaths) {
+	userID := auth.GetUserID(c)
+	p := TOTPCodePayload{}
+	err := c.ShouldBindWith(&p, binding.Form)
+	if err == nil {
+		regUC := buildController(c, actionPaths)
+		err = regUC.DisableTOTP(*userID, p.Code)
+	}
+	if err != nil {
+		c.HTML(http.StatusBadRequest, TemplTOTPDisabled,
+			gin.H{
+				"error": err.Error(),
+			})
+		return
+	}
+	c.HTML(http.StatusOK, TemplTOTPDisabled, gin.H{})
+}
@@ -0,0 +1,74 @@
+package wusers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+)
+
+func newLoginTOTPTestRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	store, stop := session.NewMemStore([]byte("secret"))
+	t.Cleanup(stop)
+
+	// the form renders its content block
+	form, err := os.ReadFile("html_templates/" + TemplLoginTOTPForm)
+	if err != nil {
+		t.Fatalf("cannot read template: %s", err.Error())
+	}
+	tmpl := template.Must(template.New(TemplLoginTOTPForm).Parse(`{{ template "content" . }}`))
+	template.Must(tmpl.New("form").Parse(string(form)))
+
+	actionPaths := &ActionPaths{BasePath: "/users"}
+	r := gin.New()
+	r.SetHTMLTemplate(tmpl)
+	r.Use(sessions.Sessions("test", store))
+	r.GET("/pending", func(c *gin.Context) {
+		session.SetPendingUserID(c, "pending user")
+	})
+	r.GET("/users/"+PathLoginTOTP, func(c *gin.Context) {
+		LoginTOTPForm(c, actionPaths)
+	})
+	return r
+}
+
+func Test_LoginTOTPForm_NextPage(t *testing.T) {
+	r := newLoginTOTPTestRouter(t)
+	formURL := "/users/" + PathLoginTOTP + "?" + ParamNextPage + "=" +
+		url.QueryEscape("/dashboard")
+
+	// without a pending second factor, the user has to log in
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, formURL, nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/users/"+PathLogin {
+		t.Errorf("want redirect to login, got %d %q", w.Code, w.Header().Get("Location"))
+		return
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pending", nil))
+	cookie := strings.Split(w.Header().Get("Set-Cookie"), ";")[0]
+
+	req := httptest.NewRequest(http.MethodGet, formURL, nil)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("want the form, got %d", w.Code)
+		return
+	}
+	// the next page is posted in the field read by LoginTOTP
+	want := `name="` + ParamNextPage + `" value="/dashboard"`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("want %s in the form, got %s", want, w.Body.String())
+	}
+}
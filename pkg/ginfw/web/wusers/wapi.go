@@ -1,12 +1,16 @@
 package wusers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/dhontecillas/hfw/pkg/ginfw"
+	"github.com/dhontecillas/hfw/pkg/ginfw/auth"
 	"github.com/dhontecillas/hfw/pkg/ginfw/web/session"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
 )
 
 const (
@@ -27,6 +31,27 @@ func WAPIRoutes(r gin.IRouter, actionPaths ActionPaths) {
 	r.GET(PathIsLoggedIn, WAPIIsLoggedIn)
 	r.POST(PathResetPassword,
 		emailRegistrationMiddleware(WAPIResetPasswordWithToken, actionPaths))
+	r.POST(PathLoginTOTP,
+		emailRegistrationMiddleware(WAPILoginTOTP, actionPaths))
+	r.POST(PathTOTPEnroll, session.AuthRequired(),
+		emailRegistrationMiddleware(WAPITOTPEnroll, actionPaths))
+	r.POST(PathTOTPConfirm, session.AuthRequired(),
+		emailRegistrationMiddleware(WAPITOTPConfirm, actionPaths))
+	r.POST(PathTOTPDisable, session.AuthRequired(),
+		emailRegistrationMiddleware(WAPITOTPDisable, actionPaths))
+	r.POST(PathRequestLoginLink,
+		emailRegistrationMiddleware(WAPIRequestLoginLink, actionPaths))
+	r.POST(PathLoginLink,
+		emailRegistrationMiddleware(WAPILoginWithLink, actionPaths))
+	r.POST(PathEmailChange, session.AuthRequired(),
+		emailRegistrationMiddleware(WAPIRequestEmailChange, actionPaths))
+	r.POST(PathConfirmEmailChange,
+		emailRegistrationMiddleware(WAPIConfirmEmailChange, actionPaths))
+	r.POST(PathRevertEmailChange,
+		emailRegistrationMiddleware(WAPIRevertEmailChange, actionPaths))
+	r.GET(PathSessions, session.AuthRequired(), WAPIListSessions)
+	r.DELETE(PathSessions, session.AuthRequired(), WAPIRevokeAllSessions)
+	r.DELETE(PathSession, session.AuthRequired(), WAPIRevokeSession)
 }
 
 // OKRes has the result for a successful operation.
@@ -40,6 +65,25 @@ type FailRes struct {
 	Error   string `json:"error"`
 }
 
+// TOTPRequiredRes is the result for a valid password login
+// that must be completed with a second factor code.
+type TOTPRequiredRes struct {
+	Success      bool `json:"success"`
+	TOTPRequired bool `json:"totp_required"`
+}
+
+// TOTPEnrollRes has the data to set up an authenticator app.
+type TOTPEnrollRes struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TOTPRecoveryCodesRes has the recovery codes for the second factor.
+type TOTPRecoveryCodesRes struct {
+	Success       bool     `json:"success"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
 // WAPIRegister is the handler for the register user endpoint.
 func WAPIRegister(c *gin.Context, actionPaths *ActionPaths) {
 	// for API calls we do not check repeated password, as we
@@ -71,12 +115,117 @@ func WAPILogin(c *gin.Context, actionPaths *ActionPaths) {
 		return
 	}
 	regUC := buildController(c, actionPaths)
-	userID, err := regUC.Login(p.Email, p.Password)
+	userID, err := regUC.Login(p.Email, p.Password, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, users.ErrLocked) {
+			c.JSON(http.StatusTooManyRequests, FailRes{Error: users.ErrLocked.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	hasTOTP, err := regUC.HasTOTP(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	if hasTOTP {
+		session.SetPendingUserID(c, userID.ToUUID())
+		c.JSON(http.StatusOK, TOTPRequiredRes{Success: false, TOTPRequired: true})
+		return
+	}
+	if err := session.SetUserID(c, userID.ToUUID()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPILoginTOTP is the handler to complete a login with
+// the second factor code.
+func WAPILoginTOTP(c *gin.Context, actionPaths *ActionPaths) {
+	strUserID := session.GetPendingUserID(c)
+	var userID ids.ID
+	if strUserID == "" || userID.FromUUID(strUserID) != nil {
+		c.JSON(http.StatusUnauthorized, FailRes{Error: "no pending login"})
+		return
+	}
+	p := TOTPCodePayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	if err := regUC.VerifySecondFactor(userID, p.Code); err != nil {
+		if errors.Is(err, users.ErrLocked) {
+			// the password has to be provided again after the lock
+			session.ClearPendingUserID(c)
+			c.JSON(http.StatusTooManyRequests, FailRes{Error: users.ErrLocked.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, FailRes{Error: users.ErrInvalidCode.Error()})
+		return
+	}
+	session.ClearPendingUserID(c)
+	if err := session.SetUserID(c, strUserID); err != nil {
+		c.JSON(http.StatusServiceUnavailable, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPITOTPEnroll is the handler to start the set up of a
+// second factor for the logged in user.
+func WAPITOTPEnroll(c *gin.Context, actionPaths *ActionPaths) {
+	userID := auth.GetUserID(c)
+	regUC := buildController(c, actionPaths)
+	enrollment, err := regUC.EnrollTOTP(*userID, totpIssuer(c, actionPaths))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, TOTPEnrollRes{
+		Secret:          enrollment.Secret,
+		ProvisioningURI: enrollment.ProvisioningURI,
+	})
+}
+
+// WAPITOTPConfirm is the handler to enable the second factor
+// with a code from the authenticator app.
+func WAPITOTPConfirm(c *gin.Context, actionPaths *ActionPaths) {
+	userID := auth.GetUserID(c)
+	p := TOTPCodePayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	codes, err := regUC.ConfirmTOTP(*userID, p.Code)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
 		return
 	}
-	session.SetUserID(c, userID.ToUUID())
+	c.JSON(http.StatusOK, TOTPRecoveryCodesRes{Success: true, RecoveryCodes: codes})
+}
+
+// WAPITOTPDisable is the handler to remove the second factor
+// for the logged in user.
+func WAPITOTPDisable(c *gin.Context, actionPaths *ActionPaths) {
+	userID := auth.GetUserID(c)
+	p := TOTPCodePayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	if err := regUC.DisableTOTP(*userID, p.Code); err != nil {
+		if errors.Is(err, users.ErrLocked) {
+			c.JSON(http.StatusTooManyRequests, FailRes{Error: users.ErrLocked.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, OKRes{Success: true})
 }
 
@@ -130,6 +279,115 @@ func WAPIResetPasswordWithToken(c *gin.Context, actionPaths *ActionPaths) {
 	c.JSON(http.StatusOK, OKRes{Success: true})
 }
 
+// WAPIRequestLoginLink is the handler to send a link to log
+// in without password.
+func WAPIRequestLoginLink(c *gin.Context, actionPaths *ActionPaths) {
+	p := RequestLoginLinkPayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	deps := ginfw.ExtServices(c)
+	if len(actionPaths.Host) == 0 {
+		// a client could set the host to receive the token
+		deps.Ins.L.Err(ErrNoHost, "login links require a configured host", nil)
+		c.JSON(http.StatusServiceUnavailable, FailRes{Error: ErrNoHost.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	if err := regUC.RequestLoginLink(p.Email); err != nil {
+		// we do not leak info about if the user exists or not
+		deps.Ins.L.Err(err, "cannot request login link", nil)
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPILoginWithLink is the handler to log in a user with the
+// token from a login link.
+func WAPILoginWithLink(c *gin.Context, actionPaths *ActionPaths) {
+	p := LoginLinkPayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	userID, err := regUC.LoginWithLink(p.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	hasTOTP, err := regUC.HasTOTP(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	if hasTOTP {
+		session.SetPendingUserID(c, userID.ToUUID())
+		c.JSON(http.StatusOK, TOTPRequiredRes{Success: false, TOTPRequired: true})
+		return
+	}
+	if err := session.SetUserID(c, userID.ToUUID()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPIRequestEmailChange is the handler to request a change
+// of the email of the logged in user.
+func WAPIRequestEmailChange(c *gin.Context, actionPaths *ActionPaths) {
+	userID := auth.GetUserID(c)
+	p := EmailChangePayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	if err := regUC.RequestEmailChange(*userID, p.Email); err != nil {
+		if errors.Is(err, users.ErrUserExists) || errors.Is(err, users.ErrInvalidEmail) {
+			c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+			return
+		}
+		deps := ginfw.ExtServices(c)
+		deps.Ins.L.Err(err, "cannot request email change", nil)
+		c.JSON(http.StatusInternalServerError, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPIConfirmEmailChange is the handler to apply an email change
+// with the token sent to the new address.
+func WAPIConfirmEmailChange(c *gin.Context, actionPaths *ActionPaths) {
+	p := EmailChangeTokenPayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	if err := regUC.ConfirmEmailChange(p.Token); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
+// WAPIRevertEmailChange is the handler to revert an email change
+// with the token sent to the old address.
+func WAPIRevertEmailChange(c *gin.Context, actionPaths *ActionPaths) {
+	p := EmailChangeTokenPayload{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	regUC := buildController(c, actionPaths)
+	if err := regUC.RevertEmailChange(p.Token); err != nil {
+		c.JSON(http.StatusBadRequest, FailRes{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKRes{Success: true})
+}
+
 // WAPILogout is the handler to log out a user.
 func WAPILogout(c *gin.Context) {
 	session.ClearUserID(c)
@@ -0,0 +1,16 @@
+package webhookauth
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Verification errors
+const (
+	ErrNoSecrets          = consterr.ConstErr("ErrNoSecrets")
+	ErrMissingSignature   = consterr.ConstErr("ErrMissingSignature")
+	ErrMalformedSignature = consterr.ConstErr("ErrMalformedSignature")
+	ErrTimestampExpired   = consterr.ConstErr("ErrTimestampExpired")
+	ErrInvalidSignature   = consterr.ConstErr("ErrInvalidSignature")
+	ErrBodyTooLarge       = consterr.ConstErr("ErrBodyTooLarge")
+	ErrReplayed           = consterr.ConstErr("ErrReplayed")
+)
@@ -0,0 +1,102 @@
+package webhookauth
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// Middleware rejects the requests without a valid signature of the
+// Verifier sender with a 401 status, and the replayed ones with a
+// 409. The body is read to check the signature, and restored so
+// the handlers can read it again.
+//
+// The verified timestamp and signature are the nonce of a message
+// (and not the header, that can be changed without breaking the
+// signature), and also the
+// Conf.NonceHeader value when present, so a message is replayed if
+// any of them was already seen. The nonces of a message are forgotten
+// when the handler does not answer with a 2xx status, so the sender
+// can retry it.
+func Middleware(ins *obs.Insighter, v *Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, v.conf.MaxBody+1))
+		if err != nil {
+			reject(ins, c, v, err, http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > v.conf.MaxBody {
+			reject(ins, c, v, ErrBodyTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ts, sig, err := v.Verify(c.GetHeader(v.conf.Header), body)
+		if err != nil {
+			reject(ins, c, v, err, http.StatusUnauthorized)
+			return
+		}
+		if v.nonces == nil {
+			return
+		}
+
+		// the signature is always a nonce, because it is the only
+		// signed value: a nonce header alone could be changed to
+		// replay a message.
+		nonces := []string{v.conf.Name + ":sig:" + strconv.FormatInt(ts, 10) + ":" + sig}
+		if v.conf.NonceHeader != "" && c.GetHeader(v.conf.NonceHeader) != "" {
+			nonces = append(nonces, v.conf.Name+":id:"+c.GetHeader(v.conf.NonceHeader))
+		}
+		added := make([]string, 0, len(nonces))
+		forget := func() {
+			for _, nonce := range added {
+				if err := v.nonces.Remove(nonce); err != nil {
+					ins.L.Err(err, "cannot remove webhook nonce", map[string]interface{}{
+						"webhook": v.conf.Name,
+					})
+				}
+			}
+		}
+		for _, nonce := range nonces {
+			// a message is valid for the tolerance before and
+			// after its timestamp
+			fresh, err := v.nonces.Add(nonce, 2*v.conf.Tolerance)
+			if err != nil {
+				forget()
+				ins.L.Err(err, "cannot check webhook nonce", map[string]interface{}{
+					"webhook": v.conf.Name,
+				})
+				c.AbortWithStatus(http.StatusServiceUnavailable)
+				return
+			}
+			if !fresh {
+				forget()
+				ins.M.Inc(MetWebhookReplayed)
+				reject(ins, c, v, ErrReplayed, http.StatusConflict)
+				return
+			}
+			added = append(added, nonce)
+		}
+
+		c.Next()
+
+		if status := c.Writer.Status(); status < 200 || status >= 300 {
+			forget()
+		}
+	}
+}
+
+func reject(ins *obs.Insighter, c *gin.Context, v *Verifier, err error, status int) {
+	ins.M.Inc(MetWebhookRejected)
+	ins.L.Warn("webhook verification failed", map[string]interface{}{
+		"webhook": v.conf.Name,
+		"error":   err.Error(),
+		"ip":      c.ClientIP(),
+	})
+	c.AbortWithStatus(status)
+}
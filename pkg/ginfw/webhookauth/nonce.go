@@ -0,0 +1,153 @@
+package webhookauth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// NonceStore records the ids of the received messages, to
+// reject the replayed ones.
+type NonceStore interface {
+	// Add records a nonce for the ttl, returning false if it
+	// was already recorded.
+	Add(nonce string, ttl time.Duration) (bool, error)
+	// Remove forgets a nonce, so the message can be received
+	// again.
+	Remove(nonce string) error
+}
+
+// memSweepInterval is the time between removals of the
+// expired nonces of a MemNonceStore.
+const memSweepInterval = time.Minute
+
+// MemNonceStore keeps the nonces in memory, so each instance of
+// the application only knows about the messages it has received.
+// It can be used on its own, or as the fallback of a
+// RedisNonceStore.
+type MemNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+var _ NonceStore = (*MemNonceStore)(nil)
+
+// NewMemNonceStore creates a MemNonceStore.
+func NewMemNonceStore() *MemNonceStore {
+	return &MemNonceStore{
+		nonces: map[string]time.Time{},
+		now:    time.Now,
+	}
+}
+
+// Add records a nonce for the ttl, returning false if it
+// was already recorded.
+func (m *MemNonceStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	if expires, ok := m.nonces[nonce]; ok && expires.After(now) {
+		return false, nil
+	}
+	m.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// Remove forgets a nonce.
+func (m *MemNonceStore) Remove(nonce string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nonces, nonce)
+	return nil
+}
+
+func (m *MemNonceStore) sweep(now time.Time) {
+	if now.Before(m.nextSweep) {
+		return
+	}
+	for nonce, expires := range m.nonces {
+		if !expires.After(now) {
+			delete(m.nonces, nonce)
+		}
+	}
+	m.nextSweep = now.Add(memSweepInterval)
+}
+
+// Len returns the number of nonces being tracked.
+func (m *MemNonceStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.nonces)
+}
+
+// DefaultRedisPrefix is the prefix of the redis keys
+// used to store the nonces.
+const DefaultRedisPrefix string = "hfw:webhookauth:"
+
+// RedisNonceStore keeps the nonces in redis, so the replays are
+// detected by all the instances of the application. When redis
+// cannot be reached, the nonces are kept in memory.
+type RedisNonceStore struct {
+	ins      *obs.Insighter
+	pool     *redis.Pool
+	prefix   string
+	fallback *MemNonceStore
+}
+
+var _ NonceStore = (*RedisNonceStore)(nil)
+
+// NewRedisNonceStore creates a RedisNonceStore. If prefix is
+// empty, the DefaultRedisPrefix is used.
+func NewRedisNonceStore(ins *obs.Insighter, pool *redis.Pool,
+	prefix string) *RedisNonceStore {
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	return &RedisNonceStore{
+		ins:      ins,
+		pool:     pool,
+		prefix:   prefix,
+		fallback: NewMemNonceStore(),
+	}
+}
+
+// Add records a nonce for the ttl, returning false if it
+// was already recorded.
+func (r *RedisNonceStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := redis.String(conn.Do("SET", r.prefix+nonce, 1,
+		"PX", ttl.Milliseconds(), "NX"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		r.ins.L.Warn("webhook nonces fall back to memory", map[string]interface{}{
+			"error": err.Error(),
+		})
+		r.ins.M.Inc(MetNonceStoreFallback)
+		return r.fallback.Add(nonce, ttl)
+	}
+	return true, nil
+}
+
+// Remove forgets a nonce.
+func (r *RedisNonceStore) Remove(nonce string) error {
+	_ = r.fallback.Remove(nonce)
+	conn := r.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("DEL", r.prefix+nonce); err != nil {
+		r.ins.L.Err(err, "cannot remove webhook nonce", nil)
+		return err
+	}
+	return nil
+}
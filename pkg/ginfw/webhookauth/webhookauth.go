@@ -0,0 +1,182 @@
+package webhookauth
+
+import (
+	"crypto/hmac"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+	"github.com/dhontecillas/hfw/pkg/webhooks"
+)
+
+// Metrics reported by the middleware
+const (
+	MetWebhookRejected    string = "webhookauth.rejected"
+	MetWebhookReplayed    string = "webhookauth.replayed"
+	MetNonceStoreFallback string = "webhookauth.nonce.fallback"
+)
+
+// MetricDefinitions returns the definitions of the metrics
+// reported by the middleware, to be registered in the insighter.
+func MetricDefinitions() metrics.MetricDefinitionList {
+	defs := metrics.MetricDefinitionList{}
+	for _, name := range []string{MetWebhookRejected, MetWebhookReplayed,
+		MetNonceStoreFallback} {
+		defs = append(defs, &metrics.MetricDefinition{
+			Name:       name,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+		})
+	}
+	return defs
+}
+
+// Default values for the Conf
+const (
+	DefaultTolerance       = 5 * time.Minute
+	DefaultMaxBody   int64 = 1 << 20
+)
+
+// Conf has the configuration to verify the webhooks of a sender.
+//
+// The signature header must have the form
+// `t=<unix timestamp>,v1=<signature>`, with one or more `v1`
+// signatures, each one the hex encoded HMAC-SHA256 of
+// `<timestamp>.<body>`. This is the format of the pkg/webhooks
+// deliveries, and of Stripe-like providers.
+type Conf struct {
+	// Name identifies the sender in the logs, and keeps apart
+	// its nonces.
+	Name string
+	// Header with the signature. When empty, the
+	// webhooks.HeaderSignature is used.
+	Header string
+	// NonceHeader has a unique id of each message (like the
+	// webhooks.HeaderDelivery), used to reject the repeated ones
+	// even when they are signed again. It is not signed, so the
+	// signature is always used to reject the replayed ones too.
+	NonceHeader string
+	// Secrets accepted at the same time, to rotate them
+	Secrets []string
+	// Tolerance is the max difference between the signature
+	// timestamp and the local clock.
+	Tolerance time.Duration
+	// MaxBody is the max size of the body, in bytes.
+	MaxBody int64
+}
+
+func (c *Conf) setDefaults() {
+	if c.Header == "" {
+		c.Header = webhooks.HeaderSignature
+	}
+	if c.Tolerance <= 0 {
+		c.Tolerance = DefaultTolerance
+	}
+	if c.MaxBody <= 0 {
+		c.MaxBody = DefaultMaxBody
+	}
+}
+
+// Verifier checks the signatures of the webhooks of a sender.
+type Verifier struct {
+	conf   Conf
+	nonces NonceStore
+	now    func() time.Time
+
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// NewVerifier creates a Verifier. The nonces are used to reject
+// the replayed messages: when nil, only the timestamp tolerance
+// protects against them.
+func NewVerifier(conf Conf, nonces NonceStore) (*Verifier, error) {
+	conf.setDefaults()
+	v := &Verifier{
+		conf:   conf,
+		nonces: nonces,
+		now:    time.Now,
+	}
+	if err := v.SetSecrets(conf.Secrets); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// SetSecrets replaces the accepted secrets. To rotate a secret,
+// add the new one, and remove the old one once the sender has
+// switched to it.
+func (v *Verifier) SetSecrets(secrets []string) error {
+	active := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		if s != "" {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 0 {
+		return ErrNoSecrets
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.secrets = active
+	return nil
+}
+
+// parseSignature returns the timestamp and the signatures of a
+// signature header.
+func parseSignature(header string) (int64, []string, error) {
+	if header == "" {
+		return 0, nil, ErrMissingSignature
+	}
+	var ts int64
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return 0, nil, ErrMalformedSignature
+		}
+		switch kv[0] {
+		case "t":
+			var err error
+			if ts, err = strconv.ParseInt(kv[1], 10, 64); err != nil {
+				return 0, nil, ErrMalformedSignature
+			}
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+		// other schemes are ignored
+	}
+	if ts == 0 || len(sigs) == 0 {
+		return 0, nil, ErrMalformedSignature
+	}
+	return ts, sigs, nil
+}
+
+// Verify checks that the signature header matches the body with
+// any of the secrets, and that its timestamp is within the
+// tolerance. It returns the timestamp and the signature that
+// matched, that identify the message whatever the header format.
+func (v *Verifier) Verify(header string, body []byte) (int64, string, error) {
+	ts, sigs, err := parseSignature(header)
+	if err != nil {
+		return 0, "", err
+	}
+	signed := time.Unix(ts, 0)
+	diff := v.now().Sub(signed)
+	if diff > v.conf.Tolerance || diff < -v.conf.Tolerance {
+		return 0, "", ErrTimestampExpired
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, secret := range v.secrets {
+		expected := webhooks.Sign(secret, signed, body)
+		for _, sig := range sigs {
+			if hmac.Equal([]byte(expected), []byte(sig)) {
+				return ts, expected, nil
+			}
+		}
+	}
+	return 0, "", ErrInvalidSignature
+}
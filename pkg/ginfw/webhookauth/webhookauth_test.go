@@ -0,0 +1,208 @@
+package webhookauth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+	"github.com/dhontecillas/hfw/pkg/webhooks"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+func newTestVerifier(t *testing.T, conf Conf, nonces NonceStore) *Verifier {
+	v, err := NewVerifier(conf, nonces)
+	if err != nil {
+		t.Fatalf("cannot create verifier: %s", err)
+	}
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func Test_Verifier_Verify(t *testing.T) {
+	if _, err := NewVerifier(Conf{}, nil); err != ErrNoSecrets {
+		t.Errorf("want ErrNoSecrets, got %v", err)
+		return
+	}
+	v := newTestVerifier(t, Conf{Secrets: []string{"old", "new"}}, nil)
+	body := []byte(`{"a":1}`)
+
+	cases := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{"old secret", webhooks.SignatureHeader("old", testNow, body), nil},
+		{"new secret", webhooks.SignatureHeader("new", testNow, body), nil},
+		{"several signatures", "t=1700000000,v1=bad,v1=" +
+			webhooks.Sign("new", testNow, body), nil},
+		{"within tolerance", webhooks.SignatureHeader("new",
+			testNow.Add(-DefaultTolerance), body), nil},
+		{"missing", "", ErrMissingSignature},
+		{"malformed", "v1", ErrMalformedSignature},
+		{"no signatures", "t=1700000000", ErrMalformedSignature},
+		{"bad timestamp", "t=foo,v1=abc", ErrMalformedSignature},
+		{"expired", webhooks.SignatureHeader("new",
+			testNow.Add(-DefaultTolerance-time.Second), body), ErrTimestampExpired},
+		{"future", webhooks.SignatureHeader("new",
+			testNow.Add(DefaultTolerance+time.Second), body), ErrTimestampExpired},
+		{"unknown secret", webhooks.SignatureHeader("other", testNow, body),
+			ErrInvalidSignature},
+		{"other body", webhooks.SignatureHeader("new", testNow, []byte(`{}`)),
+			ErrInvalidSignature},
+	}
+	for _, tc := range cases {
+		if _, _, err := v.Verify(tc.header, body); err != tc.want {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, err)
+			return
+		}
+	}
+
+	// once rotated, the old secret is rejected
+	if err := v.SetSecrets([]string{"new"}); err != nil {
+		t.Errorf("cannot set secrets: %s", err)
+		return
+	}
+	if _, _, err := v.Verify(webhooks.SignatureHeader("old", testNow, body), body); err != ErrInvalidSignature {
+		t.Errorf("want old secret rejected, got %v", err)
+		return
+	}
+	if err := v.SetSecrets([]string{""}); err != ErrNoSecrets {
+		t.Errorf("want ErrNoSecrets, got %v", err)
+		return
+	}
+}
+
+func Test_MemNonceStore(t *testing.T) {
+	m := NewMemNonceStore()
+	now := testNow
+	m.now = func() time.Time { return now }
+
+	if ok, _ := m.Add("a", time.Minute); !ok {
+		t.Errorf("want new nonce added")
+		return
+	}
+	if ok, _ := m.Add("a", time.Minute); ok {
+		t.Errorf("want repeated nonce rejected")
+		return
+	}
+	_ = m.Remove("a")
+	if ok, _ := m.Add("a", time.Minute); !ok {
+		t.Errorf("want removed nonce added again")
+		return
+	}
+	now = now.Add(2 * time.Minute)
+	if ok, _ := m.Add("b", time.Minute); !ok {
+		t.Errorf("want new nonce added")
+		return
+	}
+	if m.Len() != 1 {
+		t.Errorf("want expired nonces swept, got %d", m.Len())
+		return
+	}
+	if ok, _ := m.Add("a", time.Minute); !ok {
+		t.Errorf("want expired nonce added again")
+		return
+	}
+}
+
+func Test_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+	v := newTestVerifier(t, Conf{
+		Name:        "test",
+		NonceHeader: webhooks.HeaderDelivery,
+		Secrets:     []string{"secret"},
+		MaxBody:     64,
+	}, NewMemNonceStore())
+
+	status := http.StatusOK
+	r := gin.New()
+	r.Use(Middleware(ins, v))
+	r.POST("/", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(status, string(body))
+	})
+
+	do := func(body string, delivery string, sig string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(webhooks.HeaderDelivery, delivery)
+		req.Header.Set(webhooks.HeaderSignature, sig)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"a":1}`
+	sig := webhooks.SignatureHeader("secret", testNow, []byte(body))
+
+	w := do(body, "d1", sig)
+	if w.Code != http.StatusOK || w.Body.String() != body {
+		t.Errorf("want body restored, got %d %q", w.Code, w.Body.String())
+		return
+	}
+	if w = do(body, "d1", sig); w.Code != http.StatusConflict {
+		t.Errorf("want replay rejected, got %d", w.Code)
+		return
+	}
+	if w = do(body, "d1-changed", sig); w.Code != http.StatusConflict {
+		t.Errorf("want replay with another delivery id rejected, got %d", w.Code)
+		return
+	}
+	// the header can be changed without breaking the signature
+	for _, variant := range []string{sig + ",x=1", " " + sig + " ", sig + ",v1=bad",
+		strings.Replace(sig, ",", " , ", 1)} {
+		if w = do(body, "d1-"+variant, variant); w.Code != http.StatusConflict {
+			t.Errorf("want replay with header %q rejected, got %d", variant, w.Code)
+			return
+		}
+	}
+	resigned := webhooks.SignatureHeader("secret", testNow.Add(time.Second), []byte(body))
+	if w = do(body, "d1", resigned); w.Code != http.StatusConflict {
+		t.Errorf("want repeated delivery id rejected, got %d", w.Code)
+		return
+	}
+	if w = do(body, "d2", "t=1700000000,v1=bad"); w.Code != http.StatusUnauthorized {
+		t.Errorf("want bad signature rejected, got %d", w.Code)
+		return
+	}
+	large := strings.Repeat("a", 65)
+	w = do(large, "d3", webhooks.SignatureHeader("secret", testNow, []byte(large)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("want large body rejected, got %d", w.Code)
+		return
+	}
+
+	// a failed message can be retried
+	status = http.StatusInternalServerError
+	sig = webhooks.SignatureHeader("secret", testNow.Add(2*time.Second), []byte(body))
+	if w = do(body, "d4", sig); w.Code != http.StatusInternalServerError {
+		t.Errorf("want handler error, got %d", w.Code)
+		return
+	}
+	status = http.StatusOK
+	if w = do(body, "d4", sig); w.Code != http.StatusOK {
+		t.Errorf("want failed message retried, got %d", w.Code)
+		return
+	}
+
+	want := []string{MetWebhookReplayed, MetWebhookRejected,
+		MetWebhookReplayed, MetWebhookRejected,
+		MetWebhookReplayed, MetWebhookRejected, MetWebhookReplayed, MetWebhookRejected,
+		MetWebhookReplayed, MetWebhookRejected, MetWebhookReplayed, MetWebhookRejected,
+		MetWebhookReplayed, MetWebhookRejected,
+		MetWebhookRejected, MetWebhookRejected}
+	if strings.Join(meter.Incs, ",") != strings.Join(want, ",") {
+		t.Errorf("want %v metrics, got %v", want, meter.Incs)
+		return
+	}
+}
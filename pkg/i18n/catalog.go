@@ -0,0 +1,184 @@
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Message is a translated message, with a text for each CLDR plural
+// category. The messages without plural forms only have PluralOther.
+// The texts are `fmt` format strings.
+type Message map[PluralCategory]string
+
+// Catalog contains the translated messages by language.
+type Catalog struct {
+	defaultLang string
+	messages    map[string]map[string]Message
+}
+
+// NewCatalog creates an empty Catalog, that falls back to
+// the defaultLang messages.
+func NewCatalog(defaultLang string) *Catalog {
+	return &Catalog{
+		defaultLang: canonicalOrSelf(defaultLang),
+		messages:    make(map[string]map[string]Message, 8),
+	}
+}
+
+// LoadCatalog creates a Catalog from the `<lang>.json` files at the
+// root of fsys, that contain an object with the message keys, and
+// either a text or an object with a text for each plural category:
+//
+//	{
+//	  "welcome": "Welcome %s",
+//	  "unread": {"one": "%d unread notification", "other": "%d unread notifications"}
+//	}
+//
+// It fails if there are no messages for the default language.
+func LoadCatalog(fsys fs.FS, defaultLang string) (*Catalog, error) {
+	c := NewCatalog(defaultLang)
+	fnames, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, fname := range fnames {
+		content, err := fs.ReadFile(fsys, fname)
+		if err != nil {
+			return nil, err
+		}
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(content, &raw); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", fname, err)
+		}
+		msgs := make(map[string]Message, len(raw))
+		for key, val := range raw {
+			var text string
+			if err := json.Unmarshal(val, &text); err == nil {
+				msgs[key] = Message{PluralOther: text}
+				continue
+			}
+			var m Message
+			if err := json.Unmarshal(val, &m); err != nil {
+				return nil, fmt.Errorf("%w: %s in %s", ErrInvalidMessage, key, fname)
+			}
+			msgs[key] = m
+		}
+		lang := strings.TrimSuffix(path.Base(fname), ".json")
+		if err := c.Add(lang, msgs); err != nil {
+			return nil, fmt.Errorf("cannot load %s: %w", fname, err)
+		}
+	}
+	if _, ok := c.messages[c.defaultLang]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingLang, c.defaultLang)
+	}
+	return c, nil
+}
+
+// canonicalOrSelf returns the canonical form of a known language
+// or the same string
+func canonicalOrSelf(lang string) string {
+	if c := Canonical(lang); c != "" {
+		return c
+	}
+	return lang
+}
+
+// Add adds (or replaces) the messages of a language. It fails
+// if a message has an unknown plural category, or does not have
+// the PluralOther text. The messages should be added before the
+// catalog is used.
+func (c *Catalog) Add(lang string, messages map[string]Message) error {
+	for key, m := range messages {
+		if _, ok := m[PluralOther]; !ok {
+			return fmt.Errorf("%w: %s has no %q text", ErrInvalidMessage, key, PluralOther)
+		}
+		for cat := range m {
+			if !pluralCategories[cat] {
+				return fmt.Errorf("%w: %s has unknown category %q", ErrInvalidMessage,
+					key, cat)
+			}
+		}
+	}
+	lang = canonicalOrSelf(lang)
+	msgs, ok := c.messages[lang]
+	if !ok {
+		msgs = make(map[string]Message, len(messages))
+		c.messages[lang] = msgs
+	}
+	for key, m := range messages {
+		msgs[key] = m
+	}
+	return nil
+}
+
+// DefaultLang returns the default language of the catalog
+func (c *Catalog) DefaultLang() string {
+	return c.defaultLang
+}
+
+// Langs returns the sorted list of languages with messages
+func (c *Catalog) Langs() []string {
+	res := make([]string, 0, len(c.messages))
+	for lang := range c.messages {
+		res = append(res, lang)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// lookup searches a message through the fallbacks of lang and
+// the default language, returning the language where it was found.
+func (c *Catalog) lookup(lang string, key string) (Message, string, bool) {
+	chain := append(Fallbacks(lang), lang, c.defaultLang)
+	for _, l := range chain {
+		if m, ok := c.messages[l][key]; ok {
+			return m, l, true
+		}
+	}
+	return nil, "", false
+}
+
+func format(text string, args []interface{}) string {
+	if len(args) == 0 || !strings.Contains(text, "%") {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// Translate returns the message for the key in the language (or
+// its fallbacks), formatted with args. When the message is not
+// found, it returns the key.
+func (c *Catalog) Translate(lang string, key string, args ...interface{}) string {
+	m, _, ok := c.lookup(lang, key)
+	if !ok {
+		return key
+	}
+	return format(m[PluralOther], args)
+}
+
+// TranslatePlural returns the message text for the plural
+// category of n, formatted with args (or with n, when no args are
+// provided). When the message is not found, it returns the key.
+func (c *Catalog) TranslatePlural(lang string, key string, n interface{},
+	args ...interface{}) string {
+	m, foundLang, ok := c.lookup(lang, key)
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		args = []interface{}{n}
+	}
+	cat, err := Plural(foundLang, n)
+	if err != nil {
+		return format(m[PluralOther], args)
+	}
+	text, ok := m[cat]
+	if !ok {
+		text = m[PluralOther]
+	}
+	return format(text, args)
+}
@@ -0,0 +1,63 @@
+package i18n
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func Test_Catalog(t *testing.T) {
+	fsys := fstest.MapFS{
+		"en.json": {Data: []byte(`{
+			"welcome": "Welcome %s",
+			"bye": "Bye",
+			"unread": {"one": "%d unread notification", "other": "%d unread notifications"}
+		}`)},
+		"es.json": {Data: []byte(`{
+			"welcome": "Bienvenido %s",
+			"unread": {"one": "%d notificación sin leer", "other": "%d notificaciones sin leer"}
+		}`)},
+		"es-AR.json": {Data: []byte(`{"welcome": "Bienvenido, che, %s"}`)},
+		"ru.json": {Data: []byte(`{
+			"unread": {"one": "%d one", "few": "%d few", "many": "%d many", "other": "%d other"}
+		}`)},
+	}
+	c, err := LoadCatalog(fsys, "en")
+	if err != nil {
+		t.Errorf("cannot load catalog: %s", err)
+		return
+	}
+	cases := []struct {
+		got  string
+		want string
+	}{
+		{c.Translate("es-AR", "welcome", "Ana"), "Bienvenido, che, Ana"},
+		{c.Translate("es-MX", "welcome", "Ana"), "Bienvenido Ana"},
+		{c.Translate("es-AR", "bye"), "Bye"},
+		{c.Translate("fr", "welcome", "Ana"), "Welcome Ana"},
+		{c.Translate("en", "missing"), "missing"},
+		{c.TranslatePlural("es-AR", "unread", 1), "1 notificación sin leer"},
+		{c.TranslatePlural("es", "unread", 3), "3 notificaciones sin leer"},
+		{c.TranslatePlural("ru", "unread", 22), "22 few"},
+		{c.TranslatePlural("ru", "unread", 25), "25 many"},
+		{c.TranslatePlural("de", "unread", 1), "1 unread notification"},
+	}
+	for _, tc := range cases {
+		if tc.got != tc.want {
+			t.Errorf("want %q, got %q", tc.want, tc.got)
+			return
+		}
+	}
+
+	if _, err := LoadCatalog(fsys, "de"); !errors.Is(err, ErrMissingLang) {
+		t.Errorf("want ErrMissingLang, got %v", err)
+		return
+	}
+	bad := fstest.MapFS{
+		"en.json": {Data: []byte(`{"unread": {"one": "%d item", "lots": "%d items"}}`)},
+	}
+	if _, err := LoadCatalog(bad, "en"); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("want ErrInvalidMessage, got %v", err)
+		return
+	}
+}
@@ -0,0 +1,11 @@
+package i18n
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Errors for the message catalogs
+const (
+	ErrInvalidMessage = consterr.ConstErr("ErrInvalidMessage")
+	ErrMissingLang    = consterr.ConstErr("ErrMissingLang")
+)
@@ -0,0 +1,107 @@
+// Package i18n contains the locale negotiation and the message
+// catalogs (with CLDR plural rules) used to translate the
+// notifications and web templates.
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dhontecillas/hfw/pkg/i18n/langs"
+)
+
+// Canonical returns the canonical form of a language tag, like
+// `es_AR` for `es-ar`, using the `langs` language codes. It
+// returns an empty string if the tag is not a known language.
+func Canonical(lang string) string {
+	lc := langs.GetLangCodes(strings.TrimSpace(lang))
+	if lc == nil {
+		return ""
+	}
+	return lc.Lang()
+}
+
+// Fallbacks returns the chain of languages to try for a language
+// tag, from the most to the least specific: `es-AR` returns
+// `[es_AR es]`. An unknown variant still falls back to its
+// language, and an unknown language returns an empty list.
+func Fallbacks(lang string) []string {
+	lang = strings.TrimSpace(lang)
+	res := make([]string, 0, 2)
+	if c := Canonical(lang); c != "" && strings.Contains(c, "_") {
+		res = append(res, c)
+	}
+	base := strings.FieldsFunc(lang, func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	if len(base) == 0 {
+		return res
+	}
+	if c := Canonical(base[0]); c != "" {
+		res = append(res, c)
+	}
+	return res
+}
+
+// Negotiate selects the best of the available languages for the
+// accepted ones (sorted by preference), trying the fallbacks of
+// each accepted language. It returns the default language when
+// none is available.
+func Negotiate(accepted []string, available []string, def string) string {
+	avail := make(map[string]string, len(available))
+	for _, a := range available {
+		c := Canonical(a)
+		if c == "" {
+			c = a
+		}
+		avail[c] = a
+	}
+	for _, lang := range accepted {
+		for _, f := range Fallbacks(lang) {
+			if a, ok := avail[f]; ok {
+				return a
+			}
+		}
+	}
+	return def
+}
+
+// ParseAcceptLanguage returns the languages of an `Accept-Language`
+// header, sorted by their quality value.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	ws := make([]weighted, 0, 4)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = v
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ws = append(ws, weighted{lang: lang, q: q})
+	}
+	sort.SliceStable(ws, func(i, j int) bool {
+		return ws[i].q > ws[j].q
+	})
+	res := make([]string, 0, len(ws))
+	for _, w := range ws {
+		res = append(res, w.lang)
+	}
+	return res
+}
@@ -0,0 +1,53 @@
+package i18n
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Fallbacks(t *testing.T) {
+	cases := map[string][]string{
+		"es-AR":   {"es_AR", "es"},
+		"es_ar":   {"es_AR", "es"},
+		"es":      {"es"},
+		"es-ZZ":   {"es"},
+		"EN":      {"en"},
+		"klingon": {},
+		"":        {},
+	}
+	for lang, want := range cases {
+		if got := Fallbacks(lang); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: want %v, got %v", lang, want, got)
+			return
+		}
+	}
+}
+
+func Test_Negotiate(t *testing.T) {
+	available := []string{"en", "es", "pt-BR"}
+	cases := []struct {
+		accepted []string
+		want     string
+	}{
+		{[]string{"es-AR"}, "es"},
+		{[]string{"fr", "pt-BR"}, "pt-BR"},
+		{[]string{"pt"}, "en"},
+		{[]string{"de"}, "en"},
+		{nil, "en"},
+	}
+	for _, tc := range cases {
+		if got := Negotiate(tc.accepted, available, "en"); got != tc.want {
+			t.Errorf("%v: want %s, got %s", tc.accepted, tc.want, got)
+			return
+		}
+	}
+}
+
+func Test_ParseAcceptLanguage(t *testing.T) {
+	got := ParseAcceptLanguage("fr;q=0.5, es-AR, *;q=0.1, de;q=0, en;q=0.8")
+	want := []string{"es-AR", "en", "fr"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+		return
+	}
+}
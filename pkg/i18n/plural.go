@@ -0,0 +1,284 @@
+package i18n
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PluralCategory is a CLDR plural category
+type PluralCategory string
+
+// The CLDR plural categories
+const (
+	PluralZero  PluralCategory = "zero"
+	PluralOne   PluralCategory = "one"
+	PluralTwo   PluralCategory = "two"
+	PluralFew   PluralCategory = "few"
+	PluralMany  PluralCategory = "many"
+	PluralOther PluralCategory = "other"
+)
+
+// pluralCategories contains the valid categories
+var pluralCategories = map[PluralCategory]bool{
+	PluralZero:  true,
+	PluralOne:   true,
+	PluralTwo:   true,
+	PluralFew:   true,
+	PluralMany:  true,
+	PluralOther: true,
+}
+
+// operands are the CLDR plural operands of a number
+// (see https://unicode.org/reports/tr35/tr35-numbers.html#Operands)
+type operands struct {
+	n float64 // absolute value
+	i int64   // integer digits
+	v int     // number of visible fraction digits
+	f int64   // visible fraction digits
+}
+
+func newOperands(num interface{}) (operands, error) {
+	var s string
+	switch v := num.(type) {
+	case int:
+		s = strconv.FormatInt(int64(v), 10)
+	case int8:
+		s = strconv.FormatInt(int64(v), 10)
+	case int16:
+		s = strconv.FormatInt(int64(v), 10)
+	case int32:
+		s = strconv.FormatInt(int64(v), 10)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case uint:
+		s = strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		s = strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		s = strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		s = strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		s = strconv.FormatUint(v, 10)
+	case float32:
+		s = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return operands{}, fmt.Errorf("not a number: %T", num)
+	}
+	s = strings.TrimLeft(s, "+-")
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	var o operands
+	var err error
+	if o.n, err = strconv.ParseFloat(s, 64); err != nil {
+		return operands{}, err
+	}
+	o.n = math.Abs(o.n)
+	if o.i, err = strconv.ParseInt(intPart, 10, 64); err != nil {
+		return operands{}, err
+	}
+	if fracPart != "" {
+		o.v = len(fracPart)
+		if o.f, err = strconv.ParseInt(fracPart, 10, 64); err != nil {
+			return operands{}, err
+		}
+	}
+	return o, nil
+}
+
+// isMillion is the `many` rule of the languages that use it for
+// large round numbers (without the compact exponent operand).
+func (o operands) isMillion() bool {
+	return o.v == 0 && o.i != 0 && o.i%1000000 == 0
+}
+
+type pluralRule func(o operands) PluralCategory
+
+func pluralOnlyOther(o operands) PluralCategory {
+	return PluralOther
+}
+
+// one: i = 1 and v = 0
+func pluralOneInteger(o operands) PluralCategory {
+	if o.i == 1 && o.v == 0 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+// one: n = 1
+func pluralOneExact(o operands) PluralCategory {
+	if o.n == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+// one: i = 0 or n = 1
+func pluralOneZero(o operands) PluralCategory {
+	if o.i == 0 || o.n == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+// one: n = 1 or t != 0 and i = 0,1
+func pluralDanish(o operands) PluralCategory {
+	if o.n == 1 || (o.f != 0 && (o.i == 0 || o.i == 1)) {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+// one: i = 0,1; many: millions
+func pluralFrench(o operands) PluralCategory {
+	if o.i == 0 || o.i == 1 {
+		return PluralOne
+	}
+	if o.isMillion() {
+		return PluralMany
+	}
+	return PluralOther
+}
+
+// one: n = 1; many: millions
+func pluralSpanish(o operands) PluralCategory {
+	if o.n == 1 {
+		return PluralOne
+	}
+	if o.isMillion() {
+		return PluralMany
+	}
+	return PluralOther
+}
+
+// one: i = 1 and v = 0; many: millions
+func pluralItalian(o operands) PluralCategory {
+	if o.i == 1 && o.v == 0 {
+		return PluralOne
+	}
+	if o.isMillion() {
+		return PluralMany
+	}
+	return PluralOther
+}
+
+// one: v = 0 and i % 10 = 1 and i % 100 != 11
+// few: v = 0 and i % 10 = 2..4 and i % 100 != 12..14
+// many: v = 0 and (i % 10 = 0 or i % 10 = 5..9 or i % 100 = 11..14)
+func pluralRussian(o operands) PluralCategory {
+	if o.v != 0 {
+		return PluralOther
+	}
+	i10, i100 := o.i%10, o.i%100
+	switch {
+	case i10 == 1 && i100 != 11:
+		return PluralOne
+	case i10 >= 2 && i10 <= 4 && (i100 < 12 || i100 > 14):
+		return PluralFew
+	}
+	return PluralMany
+}
+
+// one: i = 1 and v = 0
+// few: v = 0 and i % 10 = 2..4 and i % 100 != 12..14
+// many: v = 0 and other integers
+func pluralPolish(o operands) PluralCategory {
+	if o.v != 0 {
+		return PluralOther
+	}
+	i10, i100 := o.i%10, o.i%100
+	switch {
+	case o.i == 1:
+		return PluralOne
+	case i10 >= 2 && i10 <= 4 && (i100 < 12 || i100 > 14):
+		return PluralFew
+	}
+	return PluralMany
+}
+
+// one: i = 1 and v = 0; few: i = 2..4 and v = 0; many: v != 0
+func pluralCzech(o operands) PluralCategory {
+	switch {
+	case o.v != 0:
+		return PluralMany
+	case o.i == 1:
+		return PluralOne
+	case o.i >= 2 && o.i <= 4:
+		return PluralFew
+	}
+	return PluralOther
+}
+
+// zero: n = 0; one: n = 1; two: n = 2;
+// few: n % 100 = 3..10; many: n % 100 = 11..99
+func pluralArabic(o operands) PluralCategory {
+	if o.f != 0 {
+		return PluralOther
+	}
+	n100 := o.i % 100
+	switch {
+	case o.i == 0:
+		return PluralZero
+	case o.i == 1:
+		return PluralOne
+	case o.i == 2:
+		return PluralTwo
+	case n100 >= 3 && n100 <= 10:
+		return PluralFew
+	case n100 >= 11:
+		return PluralMany
+	}
+	return PluralOther
+}
+
+// pluralRules are the CLDR cardinal plural rules by language
+var pluralRules = map[string]pluralRule{}
+
+func init() {
+	families := []struct {
+		rule  pluralRule
+		langs []string
+	}{
+		{pluralOnlyOther, []string{"bo", "dz", "id", "ig", "ja", "jv", "km", "ko",
+			"lo", "ms", "my", "sg", "th", "to", "vi", "wo", "yo", "zh"}},
+		{pluralOneInteger, []string{"de", "en", "et", "fi", "fy", "gl", "nl", "sv",
+			"sw", "ur", "yi"}},
+		{pluralOneExact, []string{"af", "az", "bg", "el", "eu", "hu", "ka", "kk",
+			"ml", "mn", "mr", "nb", "ne", "nn", "no", "sq", "ta", "te", "tr", "uz"}},
+		{pluralOneZero, []string{"am", "as", "bn", "fa", "gu", "hi", "kn", "zu"}},
+		{pluralDanish, []string{"da"}},
+		{pluralFrench, []string{"fr", "pt"}},
+		{pluralSpanish, []string{"es"}},
+		{pluralItalian, []string{"ca", "it", "pt_PT"}},
+		{pluralRussian, []string{"be", "ru", "uk"}},
+		{pluralPolish, []string{"pl"}},
+		{pluralCzech, []string{"cs", "sk"}},
+		{pluralArabic, []string{"ar"}},
+	}
+	for _, family := range families {
+		for _, lang := range family.langs {
+			pluralRules[lang] = family.rule
+		}
+	}
+}
+
+// Plural returns the CLDR plural category of a number for a
+// language. The unknown languages only use PluralOther.
+func Plural(lang string, n interface{}) (PluralCategory, error) {
+	o, err := newOperands(n)
+	if err != nil {
+		return PluralOther, err
+	}
+	for _, l := range Fallbacks(lang) {
+		if rule, ok := pluralRules[l]; ok {
+			return rule(o), nil
+		}
+	}
+	return PluralOther, nil
+}
@@ -0,0 +1,57 @@
+package i18n
+
+import (
+	"testing"
+)
+
+func Test_Plural(t *testing.T) {
+	cases := []struct {
+		lang string
+		n    interface{}
+		want PluralCategory
+	}{
+		{"en", 1, PluralOne},
+		{"en", 0, PluralOther},
+		{"en", "1.0", PluralOther},
+		{"en-GB", 1, PluralOne},
+		{"es-AR", 1, PluralOne},
+		{"es", 2, PluralOther},
+		{"es", 1000000, PluralMany},
+		{"fr", 0, PluralOne},
+		{"fr", 1.5, PluralOne},
+		{"fr", 2, PluralOther},
+		{"pt", 0, PluralOne},
+		{"pt-PT", 0, PluralOther},
+		{"ru", 1, PluralOne},
+		{"ru", 21, PluralOne},
+		{"ru", 11, PluralMany},
+		{"ru", 3, PluralFew},
+		{"ru", 13, PluralMany},
+		{"ru", 1.5, PluralOther},
+		{"pl", 1, PluralOne},
+		{"pl", 22, PluralFew},
+		{"pl", 21, PluralMany},
+		{"cs", 3, PluralFew},
+		{"cs", 0.5, PluralMany},
+		{"ar", 0, PluralZero},
+		{"ar", 2, PluralTwo},
+		{"ar", 105, PluralFew},
+		{"ar", 111, PluralMany},
+		{"ar", 100, PluralOther},
+		{"ja", 1, PluralOther},
+		{"klingon", 1, PluralOther},
+		{"da", 0.5, PluralOne},
+		{"en", int64(-1), PluralOne},
+	}
+	for _, tc := range cases {
+		got, err := Plural(tc.lang, tc.n)
+		if err != nil || got != tc.want {
+			t.Errorf("%s %v: want %s, got %s (%v)", tc.lang, tc.n, tc.want, got, err)
+			return
+		}
+	}
+	if _, err := Plural("en", "many"); err == nil {
+		t.Errorf("want an error for a non number")
+		return
+	}
+}
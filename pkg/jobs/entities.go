@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// Job status values
+const (
+	StatusPending string = "pending"
+	StatusRunning string = "running"
+	StatusDone    string = "done"
+	// StatusDead is the dead-letter state of the jobs that
+	// failed all their attempts. They are kept to be inspected,
+	// and can be run again with Jobs.Requeue.
+	StatusDead string = "dead"
+)
+
+// Job is a unit of work to be run in the background by a Worker.
+type Job struct {
+	ID   ids.ID
+	Type string
+	// Payload is the JSON encoded input of the handler
+	Payload []byte
+	// UniqueKey, when set, avoids enqueuing another job with the
+	// same key while this one is pending or running.
+	UniqueKey string
+	Status    string
+	// Attempts is the number of times the job has been claimed
+	Attempts    int
+	MaxAttempts int
+	// RunAt is when the job is due
+	RunAt   time.Time
+	Created time.Time
+	// LeaseUntil is when a running job can be claimed again, in
+	// case its worker died while running it.
+	LeaseUntil *time.Time
+	Finished   *time.Time
+	LastError  string
+}
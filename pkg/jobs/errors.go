@@ -0,0 +1,16 @@
+package jobs
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Domain errors for jobs
+const (
+	ErrNotFound       = consterr.ConstErr("ErrNotFound")
+	ErrDuplicated     = consterr.ConstErr("ErrDuplicated")
+	ErrInvalidType    = consterr.ConstErr("ErrInvalidType")
+	ErrNoHandler      = consterr.ConstErr("ErrNoHandler")
+	ErrLeaseExpired   = consterr.ConstErr("ErrLeaseExpired")
+	ErrLeaseLost      = consterr.ConstErr("ErrLeaseLost")
+	ErrWorkerShutdown = consterr.ConstErr("ErrWorkerShutdown")
+)
@@ -0,0 +1,182 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// DefaultMaxAttempts is the number of attempts of a job when
+// the Options do not set it.
+const DefaultMaxAttempts = 5
+
+// Repo defines the storage contract for the job queue.
+type Repo interface {
+	// Enqueue stores a new pending job. When the job has a UniqueKey
+	// that is used by another pending or running job, it returns
+	// ErrDuplicated.
+	Enqueue(j *Job) error
+	// Claim marks as running up to limit jobs of the given types
+	// that are due, or whose lease has expired, counting a new
+	// attempt for each of them. The claimed jobs are not returned
+	// again until the lease time has passed.
+	Claim(types []string, now time.Time, limit int, lease time.Duration) ([]Job, error)
+	// The updates of a running job are fenced with the attempt it
+	// was claimed with: when the job is no longer running with that
+	// attempt (its lease expired and it was claimed again), they
+	// return ErrLeaseLost without changing it.
+	//
+	// Complete marks a running job as done.
+	Complete(id ids.ID, attempt int, now time.Time) error
+	// Retry marks a running job as pending again, to be run at runAt.
+	Retry(id ids.ID, attempt int, runAt time.Time, lastErr string) error
+	// Release marks a running job as pending again, to be run at
+	// once, without counting its last attempt.
+	Release(id ids.ID, attempt int, lastErr string) error
+	// Bury moves a running job to the dead-letter state.
+	Bury(id ids.ID, attempt int, now time.Time, lastErr string) error
+	// Requeue moves a dead job back to pending, resetting its
+	// attempts, or returns ErrNotFound if there is no such dead job.
+	Requeue(id ids.ID, now time.Time) error
+	// GetJob returns a job, or ErrNotFound.
+	GetJob(id ids.ID) (*Job, error)
+	// ListJobs returns up to limit jobs with a status, the most
+	// recent first.
+	ListJobs(status string, limit int) ([]Job, error)
+	// PurgeDone deletes the done jobs finished before a time, and
+	// returns how many were deleted.
+	PurgeDone(before time.Time) (int64, error)
+}
+
+// Options for a new job.
+type Options struct {
+	// RunAt is when the job is due. When zero, it is due now.
+	RunAt time.Time
+	// UniqueKey avoids having several pending or running
+	// jobs for the same work.
+	UniqueKey string
+	// MaxAttempts before the job is moved to the dead-letter
+	// state. When zero, the DefaultMaxAttempts is used.
+	MaxAttempts int
+}
+
+// Jobs is the controller to enqueue and manage jobs.
+type Jobs struct {
+	ins  *obs.Insighter
+	repo Repo
+	now  func() time.Time
+}
+
+// NewJobs creates a new Jobs controller.
+func NewJobs(ins *obs.Insighter, repo Repo) *Jobs {
+	return &Jobs{
+		ins:  ins,
+		repo: repo,
+		now:  time.Now,
+	}
+}
+
+func validType(jobType string) bool {
+	return jobType != "" && !strings.ContainsAny(jobType, " \t\r\n")
+}
+
+// Enqueue stores a job of a type, to be run by the Worker with the
+// handler registered for it. The payload is encoded as JSON. When
+// there is another pending or running job with the same UniqueKey,
+// it returns ErrDuplicated.
+func (j *Jobs) Enqueue(jobType string, payload interface{}, opts Options) (*Job, error) {
+	if !validType(jobType) {
+		return nil, ErrInvalidType
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := j.now()
+	job := &Job{
+		ID:          ids.NewIDGenerator().MustNew(),
+		Type:        jobType,
+		Payload:     data,
+		UniqueKey:   opts.UniqueKey,
+		Status:      StatusPending,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       opts.RunAt,
+		Created:     now,
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = DefaultMaxAttempts
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	if err := j.repo.Enqueue(job); err != nil {
+		if err != ErrDuplicated {
+			j.ins.L.Err(err, "cannot enqueue job", map[string]interface{}{
+				"job.type": jobType,
+			})
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// Get returns a job, or ErrNotFound.
+func (j *Jobs) Get(id ids.ID) (*Job, error) {
+	return j.repo.GetJob(id)
+}
+
+// Dead returns up to limit jobs in the dead-letter state.
+func (j *Jobs) Dead(limit int) ([]Job, error) {
+	return j.repo.ListJobs(StatusDead, limit)
+}
+
+// Requeue runs again a dead job, with all its attempts.
+func (j *Jobs) Requeue(id ids.ID) error {
+	return j.repo.Requeue(id, j.now())
+}
+
+// Purge deletes the jobs that were done before the given age.
+func (j *Jobs) Purge(age time.Duration) (int64, error) {
+	return j.repo.PurgeDone(j.now().Add(-age))
+}
+
+// Handler runs a job. When it returns an error, the job is retried
+// until it reaches its MaxAttempts, unless the error is Permanent.
+type Handler func(ctx context.Context, job *Job) error
+
+// HandlerFor creates a Handler that decodes the payload of the job
+// into a T before calling fn. A payload that cannot be decoded is
+// a Permanent error.
+func HandlerFor[T any](fn func(ctx context.Context, job *Job, payload T) error) Handler {
+	return func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return Permanent(fmt.Errorf("cannot decode %s payload: %w", job.Type, err))
+		}
+		return fn(ctx, job, payload)
+	}
+}
+
+// permanentError is an error that should not be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error of a handler, so the job is moved to
+// the dead-letter state without being retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
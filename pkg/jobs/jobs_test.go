@@ -0,0 +1,239 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// memRepo is an in memory Repo for the tests
+type memRepo struct {
+	mu   sync.Mutex
+	jobs map[ids.ID]*Job
+}
+
+var _ Repo = (*memRepo)(nil)
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		jobs: map[ids.ID]*Job{},
+	}
+}
+
+func (m *memRepo) Enqueue(j *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j.UniqueKey != "" {
+		for _, other := range m.jobs {
+			if other.UniqueKey == j.UniqueKey &&
+				(other.Status == StatusPending || other.Status == StatusRunning) {
+				return ErrDuplicated
+			}
+		}
+	}
+	cj := *j
+	m.jobs[j.ID] = &cj
+	return nil
+}
+
+func (m *memRepo) Claim(types []string, now time.Time, limit int,
+	lease time.Duration) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	due := []*Job{}
+	for _, j := range m.jobs {
+		found := false
+		for _, t := range types {
+			found = found || t == j.Type
+		}
+		if !found {
+			continue
+		}
+		if (j.Status == StatusPending && !j.RunAt.After(now)) ||
+			(j.Status == StatusRunning && j.LeaseUntil.Before(now)) {
+			due = append(due, j)
+		}
+	}
+	sort.Slice(due, func(a, b int) bool {
+		return due[a].RunAt.Before(due[b].RunAt)
+	})
+	res := []Job{}
+	for _, j := range due {
+		if len(res) == limit {
+			break
+		}
+		leaseUntil := now.Add(lease)
+		j.Status = StatusRunning
+		j.Attempts++
+		j.LeaseUntil = &leaseUntil
+		res = append(res, *j)
+	}
+	return res, nil
+}
+
+func (m *memRepo) update(id ids.ID, attempt int, fn func(j *Job)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok || j.Status != StatusRunning || j.Attempts != attempt {
+		return ErrLeaseLost
+	}
+	fn(j)
+	return nil
+}
+
+func (m *memRepo) Complete(id ids.ID, attempt int, now time.Time) error {
+	return m.update(id, attempt, func(j *Job) {
+		j.Status = StatusDone
+		j.Finished = &now
+		j.LeaseUntil = nil
+	})
+}
+
+func (m *memRepo) Retry(id ids.ID, attempt int, runAt time.Time, lastErr string) error {
+	return m.update(id, attempt, func(j *Job) {
+		j.Status = StatusPending
+		j.RunAt = runAt
+		j.LastError = lastErr
+		j.LeaseUntil = nil
+	})
+}
+
+func (m *memRepo) Release(id ids.ID, attempt int, lastErr string) error {
+	return m.update(id, attempt, func(j *Job) {
+		j.Status = StatusPending
+		if j.Attempts > 0 {
+			j.Attempts--
+		}
+		j.LastError = lastErr
+		j.LeaseUntil = nil
+	})
+}
+
+func (m *memRepo) Bury(id ids.ID, attempt int, now time.Time, lastErr string) error {
+	return m.update(id, attempt, func(j *Job) {
+		j.Status = StatusDead
+		j.Finished = &now
+		j.LastError = lastErr
+		j.LeaseUntil = nil
+	})
+}
+
+func (m *memRepo) Requeue(id ids.ID, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok || j.Status != StatusDead {
+		return ErrNotFound
+	}
+	j.Status = StatusPending
+	j.Attempts = 0
+	j.RunAt = now
+	j.Finished = nil
+	return nil
+}
+
+func (m *memRepo) GetJob(id ids.ID) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cj := *j
+	return &cj, nil
+}
+
+func (m *memRepo) ListJobs(status string, limit int) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []Job{}
+	for _, j := range m.jobs {
+		if j.Status == status {
+			res = append(res, *j)
+		}
+	}
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].ID.ToUUID() > res[b].ID.ToUUID()
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (m *memRepo) PurgeDone(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, j := range m.jobs {
+		if j.Status == StatusDone && j.Finished.Before(before) {
+			delete(m.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func Test_Jobs_Enqueue(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	repo := newMemRepo()
+	jobs := NewJobs(ins, repo)
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	jobs.now = func() time.Time { return now }
+
+	if _, err := jobs.Enqueue("", nil, Options{}); err != ErrInvalidType {
+		t.Errorf("want ErrInvalidType, got %v", err)
+		return
+	}
+	j, err := jobs.Enqueue("send_email", map[string]string{"to": "a@example.com"},
+		Options{})
+	if err != nil {
+		t.Errorf("cannot enqueue: %s", err)
+		return
+	}
+	if j.MaxAttempts != DefaultMaxAttempts || !j.RunAt.Equal(now) ||
+		j.Status != StatusPending || string(j.Payload) != `{"to":"a@example.com"}` {
+		t.Errorf("unexpected job %#v", j)
+		return
+	}
+
+	later := now.Add(time.Hour)
+	opts := Options{RunAt: later, UniqueKey: "report:1", MaxAttempts: 2}
+	j, err = jobs.Enqueue("report", 1, opts)
+	if err != nil || !j.RunAt.Equal(later) || j.MaxAttempts != 2 {
+		t.Errorf("unexpected job %#v (%v)", j, err)
+		return
+	}
+	if _, err := jobs.Enqueue("report", 1, opts); err != ErrDuplicated {
+		t.Errorf("want ErrDuplicated, got %v", err)
+		return
+	}
+	// once done, the key can be used again
+	claimed, _ := repo.Claim([]string{"report"}, later, 1, time.Minute)
+	if len(claimed) != 1 {
+		t.Errorf("want the job claimed, got %#v", claimed)
+		return
+	}
+	_ = repo.Complete(j.ID, claimed[0].Attempts, now)
+	if _, err := jobs.Enqueue("report", 1, opts); err != nil {
+		t.Errorf("want unique key available once done, got %v", err)
+		return
+	}
+
+	n, err := jobs.Purge(time.Minute)
+	if err != nil || n != 0 {
+		t.Errorf("want no jobs purged, got %d (%v)", n, err)
+		return
+	}
+	now = now.Add(time.Hour)
+	if n, _ := jobs.Purge(time.Minute); n != 1 {
+		t.Errorf("want 1 job purged, got %d", n)
+		return
+	}
+}
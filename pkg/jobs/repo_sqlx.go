@@ -0,0 +1,353 @@
+package jobs
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/dhontecillas/hfw/pkg/db"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// RepoSQLX implements the jobs repository with sqlx
+type RepoSQLX struct {
+	sqlDB db.SQLDB
+	ins   *obs.Insighter
+}
+
+var _ Repo = (*RepoSQLX)(nil)
+
+// NewRepoSQLX creates a new RepoSQLX
+func NewRepoSQLX(ins *obs.Insighter, sqlDB db.SQLDB) *RepoSQLX {
+	return &RepoSQLX{
+		sqlDB: sqlDB,
+		ins:   ins,
+	}
+}
+
+type sqlxJob struct {
+	ID          string
+	Type        string
+	Payload     []byte
+	UniqueKey   sql.NullString
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time
+	Created     time.Time
+	LeaseUntil  *time.Time
+	Finished    *time.Time
+	LastError   string
+}
+
+func (sj *sqlxJob) fromSQLX(j *Job) error {
+	if err := j.ID.FromUUID(sj.ID); err != nil {
+		return err
+	}
+	j.Type = sj.Type
+	j.Payload = sj.Payload
+	j.UniqueKey = sj.UniqueKey.String
+	j.Status = sj.Status
+	j.Attempts = sj.Attempts
+	j.MaxAttempts = sj.MaxAttempts
+	j.RunAt = sj.RunAt
+	j.Created = sj.Created
+	j.LeaseUntil = sj.LeaseUntil
+	j.Finished = sj.Finished
+	j.LastError = sj.LastError
+	return nil
+}
+
+const jobColumns = `
+	id AS ID
+	,type AS Type
+	,payload AS Payload
+	,unique_key AS UniqueKey
+	,status AS Status
+	,attempts AS Attempts
+	,max_attempts AS MaxAttempts
+	,run_at AS RunAt
+	,created AS Created
+	,lease_until AS LeaseUntil
+	,finished AS Finished
+	,last_error AS LastError
+`
+
+func scanJobs(rows *sqlx.Rows) ([]Job, error) {
+	defer rows.Close()
+	res := []Job{}
+	for rows.Next() {
+		var sj sqlxJob
+		if err := rows.StructScan(&sj); err != nil {
+			return nil, err
+		}
+		var j Job
+		if err := sj.fromSQLX(&j); err != nil {
+			return nil, err
+		}
+		res = append(res, j)
+	}
+	return res, rows.Err()
+}
+
+// Enqueue stores a new pending job.
+func (r *RepoSQLX) Enqueue(j *Job) error {
+	sqlQ := `
+INSERT INTO jobs(
+	id
+	,type
+	,payload
+	,unique_key
+	,status
+	,attempts
+	,max_attempts
+	,run_at
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,0
+	,$6
+	,$7
+	,$8
+)
+ON CONFLICT (unique_key) WHERE status IN ('pending', 'running') DO NOTHING
+`
+	uniqueKey := sql.NullString{String: j.UniqueKey, Valid: j.UniqueKey != ""}
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, j.ID.ToUUID(), j.Type, string(j.Payload), uniqueKey,
+		StatusPending, j.MaxAttempts, j.RunAt, j.Created)
+	if err != nil {
+		r.ins.L.Err(err, "cannot enqueue job", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDuplicated
+	}
+	return nil
+}
+
+// Claim marks as running up to limit due jobs of the given types.
+func (r *RepoSQLX) Claim(types []string, now time.Time, limit int,
+	lease time.Duration) ([]Job, error) {
+	sqlQ := `
+UPDATE jobs
+SET
+	status = $4
+	,attempts = attempts + 1
+	,lease_until = $3
+WHERE id IN (
+	SELECT
+		id
+	FROM jobs
+	WHERE
+		type = ANY($5)
+		AND (
+			(status = $6 AND run_at <= $1)
+			OR (status = $4 AND lease_until < $1)
+		)
+	ORDER BY run_at
+	LIMIT $2
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + jobColumns
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, now, limit, now.Add(lease), StatusRunning,
+		pq.Array(types), StatusPending)
+	if err != nil {
+		r.ins.L.Err(err, "cannot claim jobs", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// exec runs an update of a job, returning notFound if it
+// does not change any row.
+func (r *RepoSQLX) exec(notFound error, sqlQ string, args ...interface{}) error {
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, args...)
+	if err != nil {
+		r.ins.L.Err(err, "cannot update job", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// Complete marks a running job as done.
+func (r *RepoSQLX) Complete(id ids.ID, attempt int, now time.Time) error {
+	sqlQ := `
+UPDATE jobs
+SET
+	status = $2
+	,finished = $3
+	,lease_until = NULL
+WHERE
+	id = $1
+	AND status = $4
+	AND attempts = $5
+`
+	return r.exec(ErrLeaseLost, sqlQ, id.ToUUID(), StatusDone, now,
+		StatusRunning, attempt)
+}
+
+// Retry marks a running job as pending again, to be run at runAt.
+func (r *RepoSQLX) Retry(id ids.ID, attempt int, runAt time.Time, lastErr string) error {
+	sqlQ := `
+UPDATE jobs
+SET
+	status = $2
+	,run_at = $3
+	,last_error = $4
+	,lease_until = NULL
+WHERE
+	id = $1
+	AND status = $5
+	AND attempts = $6
+`
+	return r.exec(ErrLeaseLost, sqlQ, id.ToUUID(), StatusPending, runAt, lastErr,
+		StatusRunning, attempt)
+}
+
+// Release marks a running job as pending again, without
+// counting its last attempt.
+func (r *RepoSQLX) Release(id ids.ID, attempt int, lastErr string) error {
+	sqlQ := `
+UPDATE jobs
+SET
+	status = $2
+	,attempts = GREATEST(attempts - 1, 0)
+	,last_error = $3
+	,lease_until = NULL
+WHERE
+	id = $1
+	AND status = $4
+	AND attempts = $5
+`
+	return r.exec(ErrLeaseLost, sqlQ, id.ToUUID(), StatusPending, lastErr,
+		StatusRunning, attempt)
+}
+
+// Bury moves a running job to the dead-letter state.
+func (r *RepoSQLX) Bury(id ids.ID, attempt int, now time.Time, lastErr string) error {
+	sqlQ := `
+UPDATE jobs
+SET
+	status = $2
+	,finished = $3
+	,last_error = $4
+	,lease_until = NULL
+WHERE
+	id = $1
+	AND status = $5
+	AND attempts = $6
+`
+	return r.exec(ErrLeaseLost, sqlQ, id.ToUUID(), StatusDead, now, lastErr,
+		StatusRunning, attempt)
+}
+
+// Requeue moves a dead job back to pending, resetting its attempts.
+func (r *RepoSQLX) Requeue(id ids.ID, now time.Time) error {
+	sqlQ := `
+UPDATE jobs
+SET
+	status = $2
+	,attempts = 0
+	,run_at = $3
+	,finished = NULL
+WHERE
+	id = $1
+	AND status = $4
+`
+	return r.exec(ErrNotFound, sqlQ, id.ToUUID(), StatusPending, now, StatusDead)
+}
+
+// GetJob returns a job, or ErrNotFound.
+func (r *RepoSQLX) GetJob(id ids.ID) (*Job, error) {
+	sqlQ := `
+SELECT` + jobColumns + `
+FROM jobs
+WHERE
+	id = $1
+`
+	var sj sqlxJob
+	master := r.sqlDB.Master()
+	if err := master.QueryRowx(sqlQ, id.ToUUID()).StructScan(&sj); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		r.ins.L.Err(err, "cannot get job", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	var j Job
+	if err := sj.fromSQLX(&j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ListJobs returns up to limit jobs with a status, the most
+// recent first.
+func (r *RepoSQLX) ListJobs(status string, limit int) ([]Job, error) {
+	sqlQ := `
+SELECT` + jobColumns + `
+FROM jobs
+WHERE
+	status = $1
+ORDER BY id DESC
+LIMIT $2
+`
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, status, limit)
+	if err != nil {
+		r.ins.L.Err(err, "cannot list jobs", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// PurgeDone deletes the done jobs finished before a time.
+func (r *RepoSQLX) PurgeDone(before time.Time) (int64, error) {
+	sqlQ := `
+DELETE FROM jobs
+WHERE
+	status = $1
+	AND finished < $2
+`
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, StatusDone, before)
+	if err != nil {
+		r.ins.L.Err(err, "cannot purge jobs", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return 0, err
+	}
+	return res.RowsAffected()
+}
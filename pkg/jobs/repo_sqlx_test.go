@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	hfwtest "github.com/dhontecillas/hfw/testing"
+)
+
+func Test_RepoSQLX_Jobs(t *testing.T) {
+	deps := hfwtest.BuildExternalServices()
+	r := NewRepoSQLX(deps.Insighter(), deps.SQL)
+	now := time.Now().Truncate(time.Second)
+	id := ids.NewIDGenerator().MustNew()
+	jobType := "test_" + id.ToUUID()
+
+	j := &Job{
+		ID:          id,
+		Type:        jobType,
+		Payload:     []byte(`{"a":1}`),
+		UniqueKey:   jobType,
+		MaxAttempts: 2,
+		RunAt:       now,
+		Created:     now,
+	}
+	if err := r.Enqueue(j); err != nil {
+		t.Errorf("cannot enqueue: %s", err)
+		return
+	}
+	dup := *j
+	dup.ID = ids.NewIDGenerator().MustNew()
+	if err := r.Enqueue(&dup); err != ErrDuplicated {
+		t.Errorf("want ErrDuplicated, got %v", err)
+		return
+	}
+
+	claimed, err := r.Claim([]string{jobType}, now, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].Attempts != 1 ||
+		claimed[0].Status != StatusRunning {
+		t.Errorf("want 1 claimed job, got %#v (%v)", claimed, err)
+		return
+	}
+	if again, _ := r.Claim([]string{jobType}, now, 10, time.Minute); len(again) != 0 {
+		t.Errorf("want no jobs claimed during the lease, got %d", len(again))
+		return
+	}
+	if err := r.Complete(j.ID, 2, now); err != ErrLeaseLost {
+		t.Errorf("want ErrLeaseLost for another attempt, got %v", err)
+		return
+	}
+	if err := r.Retry(j.ID, 1, now.Add(time.Minute), "boom"); err != nil {
+		t.Errorf("cannot retry: %s", err)
+		return
+	}
+	claimed, _ = r.Claim([]string{jobType}, now.Add(time.Minute), 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].Attempts != 2 || claimed[0].LastError != "boom" {
+		t.Errorf("want the retried job claimed, got %#v", claimed)
+		return
+	}
+	if err := r.Bury(j.ID, 2, now, "boom"); err != nil {
+		t.Errorf("cannot bury: %s", err)
+		return
+	}
+	dead, err := r.ListJobs(StatusDead, 100)
+	found := false
+	for _, d := range dead {
+		found = found || d.ID == j.ID
+	}
+	if err != nil || !found {
+		t.Errorf("want the job in the dead-letter list (%v)", err)
+		return
+	}
+	if err := r.Requeue(j.ID, now); err != nil {
+		t.Errorf("cannot requeue: %s", err)
+		return
+	}
+	claimed, _ = r.Claim([]string{jobType}, now, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].Attempts != 1 {
+		t.Errorf("want the requeued job claimed, got %#v", claimed)
+		return
+	}
+	if err := r.Complete(j.ID, 1, now); err != nil {
+		t.Errorf("cannot complete: %s", err)
+		return
+	}
+	if got, err := r.GetJob(j.ID); err != nil || got.Status != StatusDone ||
+		string(got.Payload) != `{"a": 1}` {
+		t.Errorf("want done job, got %#v (%v)", got, err)
+		return
+	}
+	if _, err := r.PurgeDone(now.Add(time.Second)); err != nil {
+		t.Errorf("cannot purge: %s", err)
+		return
+	}
+	if _, err := r.GetJob(j.ID); err != ErrNotFound {
+		t.Errorf("want purged job, got %v", err)
+		return
+	}
+}
@@ -0,0 +1,324 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+	obsattrs "github.com/dhontecillas/hfw/pkg/obs/attrs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+	"github.com/dhontecillas/hfw/pkg/poller"
+)
+
+// Metrics reported by the Worker, with the job.type attribute
+const (
+	MetJobDone     string = "jobs.done"
+	MetJobRetried  string = "jobs.retried"
+	MetJobDead     string = "jobs.dead"
+	MetJobDuration string = "jobs.duration"
+)
+
+// AttrJobType is the attribute of the metrics with the type of job
+const AttrJobType string = "job.type"
+
+// MetricDefinitions returns the definitions of the metrics
+// reported by the Worker, to be registered in the insighter.
+func MetricDefinitions() metrics.MetricDefinitionList {
+	jobAttrs := obsattrs.AttrDefinitionList{
+		obsattrs.AttrDefinition{
+			Name:        AttrJobType,
+			StrAttrType: obsattrs.AttrTypeStr,
+		},
+	}
+	defs := metrics.MetricDefinitionList{}
+	for _, name := range []string{MetJobDone, MetJobRetried, MetJobDead} {
+		defs = append(defs, &metrics.MetricDefinition{
+			Name:       name,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+			Attributes: jobAttrs,
+		})
+	}
+	return append(defs, &metrics.MetricDefinition{
+		Name:       MetJobDuration,
+		Units:      "s",
+		MetricType: metrics.MetricTypeHistogram,
+		Attributes: jobAttrs,
+	})
+}
+
+// Default values for the WorkerConf
+const (
+	DefaultPollInterval = time.Second
+	DefaultConcurrency  = 10
+	DefaultTimeout      = 5 * time.Minute
+	DefaultBaseBackoff  = 10 * time.Second
+	DefaultMaxBackoff   = time.Hour
+)
+
+// WorkerConf has the configuration of a Worker. Zero values
+// are replaced with the defaults.
+type WorkerConf struct {
+	// PollInterval is the time between checks of due jobs
+	PollInterval time.Duration
+	// Concurrency is the max number of jobs run at once
+	Concurrency int
+	// Timeout of each job, after which its context is cancelled.
+	// The jobs are leased for twice this time, so a job whose
+	// worker died is run again once the lease expires.
+	Timeout time.Duration
+	// BaseBackoff and MaxBackoff are the poller.Backoff of
+	// the retries.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func (c *WorkerConf) setDefaults() {
+	if c.PollInterval <= 0 {
+		c.PollInterval = DefaultPollInterval
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = DefaultBaseBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+}
+
+// Backoff returns the time to wait before retrying a job
+// that has failed the given number of attempts.
+func (c *WorkerConf) Backoff(attempts int) time.Duration {
+	return poller.Backoff{Base: c.BaseBackoff, Max: c.MaxBackoff}.Delay(attempts)
+}
+
+// Worker runs the jobs of the types that have a registered
+// handler. Several workers can run at the same time, because
+// the jobs are claimed with SKIP LOCKED.
+type Worker struct {
+	ins  *obs.Insighter
+	repo Repo
+	conf WorkerConf
+	now  func() time.Time
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	running  int
+
+	// ctx is the parent of the context of the handlers, that is
+	// cancelled when a shutdown times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobsWg sync.WaitGroup
+
+	loop *poller.Loop
+}
+
+// NewWorker creates a Worker.
+func NewWorker(ins *obs.Insighter, repo Repo, conf WorkerConf) *Worker {
+	conf.setDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Worker{
+		ins:      ins,
+		repo:     repo,
+		conf:     conf,
+		now:      time.Now,
+		handlers: map[string]Handler{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	// keep claiming while there are free slots and due jobs
+	w.loop = poller.NewLoop(conf.PollInterval, func() bool {
+		return w.dispatch() > 0
+	})
+	return w
+}
+
+// Register sets the handler of a type of job. It must be
+// called before Start.
+func (w *Worker) Register(jobType string, h Handler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers[jobType] = h
+}
+
+func (w *Worker) types() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	types := make([]string, 0, len(w.handlers))
+	for t := range w.handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Start runs the due jobs in the background, until Shutdown
+// or Close are called.
+func (w *Worker) Start() {
+	w.loop.Start()
+}
+
+// Shutdown stops claiming jobs, and waits for the running ones
+// to finish. If the context is done before, the context of the
+// running jobs is cancelled, and Shutdown waits for them to
+// return and be rescheduled.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.loop.Stop()
+
+	finished := make(chan struct{})
+	go func() {
+		w.jobsWg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// Close stops the worker, waiting for the running jobs.
+func (w *Worker) Close() {
+	_ = w.Shutdown(context.Background())
+}
+
+// RunOnce claims the due jobs that fit in the free slots and
+// runs them, waiting for them to finish. It returns the number
+// of jobs claimed.
+func (w *Worker) RunOnce() int {
+	n := w.dispatch()
+	w.jobsWg.Wait()
+	return n
+}
+
+// dispatch claims the due jobs that fit in the free slots and
+// starts running them, returning the number of jobs claimed.
+func (w *Worker) dispatch() int {
+	w.mu.Lock()
+	free := w.conf.Concurrency - w.running
+	w.mu.Unlock()
+	types := w.types()
+	if free <= 0 || len(types) == 0 {
+		return 0
+	}
+	claimed, err := w.repo.Claim(types, w.now(), free, 2*w.conf.Timeout)
+	if err != nil {
+		w.ins.L.Err(err, "cannot claim jobs", nil)
+		return 0
+	}
+	w.mu.Lock()
+	w.running += len(claimed)
+	w.mu.Unlock()
+	for idx := range claimed {
+		w.jobsWg.Add(1)
+		go func(j *Job) {
+			defer func() {
+				w.mu.Lock()
+				w.running--
+				w.mu.Unlock()
+				w.jobsWg.Done()
+			}()
+			w.run(j)
+		}(&claimed[idx])
+	}
+	return len(claimed)
+}
+
+// run calls the handler of a claimed job, and records its outcome.
+func (w *Worker) run(j *Job) {
+	attrs := map[string]interface{}{
+		"job.id":      j.ID.ToUUID(),
+		"job.type":    j.Type,
+		"job.attempt": int64(j.Attempts),
+	}
+	metAttrs := map[string]interface{}{AttrJobType: j.Type}
+	span := w.ins.T.Start(context.Background(), "job", attrs)
+	defer span.End()
+
+	var err error
+	if j.Attempts > j.MaxAttempts {
+		// its worker died in the last attempt
+		err = Permanent(ErrLeaseExpired)
+	} else {
+		start := time.Now()
+		err = w.call(j)
+		w.ins.M.RecWL(MetJobDuration, time.Since(start).Seconds(), metAttrs)
+	}
+
+	if err == nil {
+		w.ins.M.IncWL(MetJobDone, metAttrs)
+		if err := w.repo.Complete(j.ID, j.Attempts, w.now()); err != nil {
+			span.Err(err)
+			w.recordErr(err, "cannot complete job", attrs)
+		}
+		return
+	}
+
+	span.Err(err)
+	lastErr := poller.SanitizeError(err.Error())
+	var permanent *permanentError
+	var recErr error
+	switch {
+	case errors.Is(err, ErrWorkerShutdown):
+		// the job was interrupted, so it does not count as an attempt
+		w.ins.L.Warn("job interrupted by shutdown", attrs)
+		recErr = w.repo.Release(j.ID, j.Attempts, lastErr)
+	case !errors.As(err, &permanent) && j.Attempts < j.MaxAttempts:
+		w.ins.M.IncWL(MetJobRetried, metAttrs)
+		recErr = w.repo.Retry(j.ID, j.Attempts, w.now().Add(w.conf.Backoff(j.Attempts)), lastErr)
+	default:
+		w.ins.M.IncWL(MetJobDead, metAttrs)
+		w.ins.L.Err(err, "job failed", attrs)
+		recErr = w.repo.Bury(j.ID, j.Attempts, w.now(), lastErr)
+	}
+	if recErr != nil {
+		w.recordErr(recErr, "cannot record job failure", attrs)
+	}
+}
+
+// recordErr logs an error recording the outcome of a job. A lost
+// lease is only a warning: the job was claimed again, and its new
+// attempt records the outcome.
+func (w *Worker) recordErr(err error, msg string, attrs map[string]interface{}) {
+	if errors.Is(err, ErrLeaseLost) {
+		w.ins.L.Warn("job lease lost", attrs)
+		return
+	}
+	w.ins.L.Err(err, msg, attrs)
+}
+
+// call runs the handler of a job with a timeout, turning its
+// panics into errors.
+func (w *Worker) call(j *Job) (err error) {
+	w.mu.Lock()
+	h, ok := w.handlers[j.Type]
+	w.mu.Unlock()
+	if !ok {
+		return Permanent(ErrNoHandler)
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, w.conf.Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	err = h(ctx, j)
+	if err != nil && w.ctx.Err() != nil {
+		err = fmt.Errorf("%w: %s", ErrWorkerShutdown, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,264 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+type workerTest struct {
+	repo   *memRepo
+	jobs   *Jobs
+	worker *Worker
+	meter  *metrics.MockMeter
+	now    time.Time
+}
+
+func newWorkerTest(conf WorkerConf) *workerTest {
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+
+	wt := &workerTest{
+		repo:  newMemRepo(),
+		meter: meter,
+		now:   time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+	wt.jobs = NewJobs(ins, wt.repo)
+	wt.jobs.now = func() time.Time { return wt.now }
+	// a job at a time, so the meter is not used concurrently
+	conf.Concurrency = 1
+	wt.worker = NewWorker(ins, wt.repo, conf)
+	wt.worker.now = func() time.Time { return wt.now }
+	return wt
+}
+
+type emailPayload struct {
+	To string `json:"to"`
+}
+
+func Test_Worker_TypedHandler(t *testing.T) {
+	wt := newWorkerTest(WorkerConf{})
+	var sent []string
+	wt.worker.Register("send_email", HandlerFor(
+		func(ctx context.Context, j *Job, p emailPayload) error {
+			sent = append(sent, p.To)
+			return nil
+		}))
+
+	j, _ := wt.jobs.Enqueue("send_email", emailPayload{To: "a@example.com"}, Options{})
+	// jobs without a handler are not claimed
+	other, _ := wt.jobs.Enqueue("other", nil, Options{})
+	// nor the scheduled for later
+	later, _ := wt.jobs.Enqueue("send_email", emailPayload{To: "b@example.com"},
+		Options{RunAt: wt.now.Add(time.Hour)})
+
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want 1 job run, got %d", n)
+		return
+	}
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no more jobs, got %d", n)
+		return
+	}
+	if len(sent) != 1 || sent[0] != "a@example.com" {
+		t.Errorf("want payload decoded, got %v", sent)
+		return
+	}
+	if j, _ = wt.jobs.Get(j.ID); j.Status != StatusDone || j.Attempts != 1 {
+		t.Errorf("want done job, got %#v", j)
+		return
+	}
+	if other, _ = wt.jobs.Get(other.ID); other.Status != StatusPending {
+		t.Errorf("want job without handler pending, got %#v", other)
+		return
+	}
+
+	wt.now = wt.now.Add(time.Hour)
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want scheduled job run, got %d", n)
+		return
+	}
+	if later, _ = wt.jobs.Get(later.ID); later.Status != StatusDone {
+		t.Errorf("want scheduled job done, got %#v", later)
+		return
+	}
+	if wt.meter.Count(MetJobDone) != 2 || len(wt.meter.Recs) != 2 {
+		t.Errorf("unexpected metrics %v %v", wt.meter.Incs, wt.meter.Recs)
+		return
+	}
+}
+
+func Test_Worker_RetriesAndDeadLetter(t *testing.T) {
+	wt := newWorkerTest(WorkerConf{BaseBackoff: time.Minute})
+	calls := 0
+	wt.worker.Register("flaky", func(ctx context.Context, j *Job) error {
+		calls++
+		return errors.New("boom")
+	})
+	j, _ := wt.jobs.Enqueue("flaky", nil, Options{MaxAttempts: 3})
+
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want 1 job run, got %d", n)
+		return
+	}
+	// not retried before the backoff
+	wt.now = wt.now.Add(time.Minute - time.Second)
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no retry before the backoff, got %d", n)
+		return
+	}
+	wt.now = wt.now.Add(time.Second)
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want a retry after the backoff, got %d", n)
+		return
+	}
+	wt.now = wt.now.Add(2 * time.Minute)
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want a retry after the second backoff, got %d", n)
+		return
+	}
+	wt.now = wt.now.Add(time.Hour)
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no more attempts, got %d", n)
+		return
+	}
+	if j, _ = wt.jobs.Get(j.ID); j.Status != StatusDead || j.LastError != "boom" ||
+		calls != 3 {
+		t.Errorf("want dead job after 3 calls, got %d %#v", calls, j)
+		return
+	}
+	if wt.meter.Count(MetJobRetried) != 2 || wt.meter.Count(MetJobDead) != 1 {
+		t.Errorf("unexpected metrics %v", wt.meter.Incs)
+		return
+	}
+
+	dead, _ := wt.jobs.Dead(10)
+	if len(dead) != 1 || dead[0].ID != j.ID {
+		t.Errorf("want the job in the dead-letter list, got %#v", dead)
+		return
+	}
+	if err := wt.jobs.Requeue(j.ID); err != nil {
+		t.Errorf("cannot requeue: %s", err)
+		return
+	}
+	if err := wt.jobs.Requeue(j.ID); err != ErrNotFound {
+		t.Errorf("want ErrNotFound requeuing a pending job, got %v", err)
+		return
+	}
+	if n := wt.worker.RunOnce(); n != 1 || calls != 4 {
+		t.Errorf("want requeued job run, got %d", n)
+		return
+	}
+}
+
+func Test_Worker_PermanentErrors(t *testing.T) {
+	wt := newWorkerTest(WorkerConf{})
+	wt.worker.Register("permanent", func(ctx context.Context, j *Job) error {
+		return Permanent(errors.New("bad input"))
+	})
+	wt.worker.Register("panics", func(ctx context.Context, j *Job) error {
+		panic("oops")
+	})
+	wt.worker.Register("typed", HandlerFor(
+		func(ctx context.Context, j *Job, p emailPayload) error {
+			return nil
+		}))
+
+	permanent, _ := wt.jobs.Enqueue("permanent", nil, Options{})
+	undecodable, _ := wt.jobs.Enqueue("typed", "not an object", Options{})
+	panics, _ := wt.jobs.Enqueue("panics", nil, Options{MaxAttempts: 1})
+	for wt.worker.RunOnce() > 0 {
+	}
+
+	for _, j := range []*Job{permanent, undecodable, panics} {
+		if j, _ = wt.jobs.Get(j.ID); j.Status != StatusDead || j.Attempts != 1 {
+			t.Errorf("want dead after 1 attempt, got %#v", j)
+			return
+		}
+	}
+	if j, _ := wt.jobs.Get(panics.ID); !strings.Contains(j.LastError, "oops") {
+		t.Errorf("want panic in the error, got %q", j.LastError)
+		return
+	}
+}
+
+func Test_Worker_LeaseExpired(t *testing.T) {
+	wt := newWorkerTest(WorkerConf{Timeout: time.Minute})
+	calls := 0
+	wt.worker.Register("task", func(ctx context.Context, j *Job) error {
+		calls++
+		return nil
+	})
+	j, _ := wt.jobs.Enqueue("task", nil, Options{MaxAttempts: 1})
+
+	// a worker that died after claiming the job
+	if _, err := wt.repo.Claim([]string{"task"}, wt.now, 1, 2*time.Minute); err != nil {
+		t.Errorf("cannot claim: %s", err)
+		return
+	}
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no jobs claimed during the lease, got %d", n)
+		return
+	}
+	wt.now = wt.now.Add(3 * time.Minute)
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want the job claimed after the lease, got %d", n)
+		return
+	}
+	if j, _ = wt.jobs.Get(j.ID); j.Status != StatusDead || calls != 0 {
+		t.Errorf("want dead job without attempts left, got %#v", j)
+		return
+	}
+}
+
+func Test_Worker_LeaseLost(t *testing.T) {
+	wt := newWorkerTest(WorkerConf{Timeout: time.Minute})
+	wt.worker.Register("task", func(ctx context.Context, j *Job) error {
+		// the job is too slow, and another worker claims it
+		_, err := wt.repo.Claim([]string{"task"}, wt.now.Add(3*time.Minute), 1,
+			2*time.Minute)
+		return err
+	})
+	j, _ := wt.jobs.Enqueue("task", nil, Options{})
+
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want 1 job claimed, got %d", n)
+		return
+	}
+	if j, _ = wt.jobs.Get(j.ID); j.Status != StatusRunning || j.Attempts != 2 {
+		t.Errorf("want the job kept for the new attempt, got %#v", j)
+		return
+	}
+}
+
+func Test_Worker_Shutdown(t *testing.T) {
+	wt := newWorkerTest(WorkerConf{PollInterval: 10 * time.Millisecond})
+	started := make(chan struct{})
+	wt.worker.Register("slow", func(ctx context.Context, j *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	j, _ := wt.jobs.Enqueue("slow", nil, Options{MaxAttempts: 1})
+
+	wt.worker.Start()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := wt.worker.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("want shutdown timed out, got %v", err)
+		return
+	}
+	// the interrupted job does not count as an attempt
+	if j, _ = wt.jobs.Get(j.ID); j.Status != StatusPending || j.Attempts != 0 {
+		t.Errorf("want interrupted job pending, got %#v", j)
+		return
+	}
+	wt.worker.Close()
+}
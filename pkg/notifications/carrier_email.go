@@ -0,0 +1,57 @@
+package notifications
+
+import (
+	"fmt"
+
+	"github.com/dhontecillas/hfw/pkg/mailer"
+)
+
+// EmailCarrier sends the notifications by email, with the
+// "subject" text and the "content" text and html templates.
+type EmailCarrier struct {
+	mailSender mailer.Mailer
+}
+
+var _ Carrier = (*EmailCarrier)(nil)
+
+// NewEmailCarrier creates an EmailCarrier.
+func NewEmailCarrier(mailSender mailer.Mailer) *EmailCarrier {
+	return &EmailCarrier{
+		mailSender: mailSender,
+	}
+}
+
+// Deliver sends the content by email to the recipient Email.
+func (c *EmailCarrier) Deliver(to *Recipient, notification string,
+	content *ContentSet) error {
+	if to.Email == "" {
+		return ErrNoAddress
+	}
+	subject, ok := content.Texts["subject"]
+	if !ok {
+		return fmt.Errorf("%w: missing 'subject' content", ErrMissingContent)
+	}
+	textBody, ok := content.Texts["content"]
+	if !ok {
+		return fmt.Errorf("%w: missing 'text body' content", ErrMissingContent)
+	}
+	htmlBody, ok := content.HTMLs["content"]
+	if !ok {
+		return fmt.Errorf("%w: missing 'html body' content", ErrMissingContent)
+	}
+
+	fromAddress, fromName := c.mailSender.Sender()
+	return c.mailSender.Send(mailer.Email{
+		To: mailer.User{
+			Name:    to.Email,
+			Address: to.Email,
+		},
+		From: mailer.User{
+			Name:    fromName,
+			Address: fromAddress,
+		},
+		Subject: subject,
+		HTML:    htmlBody,
+		Text:    textBody,
+	})
+}
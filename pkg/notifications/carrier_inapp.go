@@ -0,0 +1,37 @@
+package notifications
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// InAppCarrier stores the notifications in the in-app inbox
+// of the users.
+type InAppCarrier struct {
+	inbox InboxWriter
+	now   func() time.Time
+}
+
+var _ Carrier = (*InAppCarrier)(nil)
+
+// NewInAppCarrier creates an InAppCarrier. With an Inbox, the
+// new items are also published to the subscribed users.
+func NewInAppCarrier(inbox InboxWriter) *InAppCarrier {
+	return &InAppCarrier{
+		inbox: inbox,
+		now:   time.Now,
+	}
+}
+
+// Deliver adds the content to the inbox of the recipient.
+func (c *InAppCarrier) Deliver(to *Recipient, notification string,
+	content *ContentSet) error {
+	return c.inbox.AddInboxItem(&InboxItem{
+		ID:           ids.NewIDGenerator().MustNew(),
+		UserID:       to.UserID,
+		Notification: notification,
+		Content:      *content,
+		Created:      c.now(),
+	})
+}
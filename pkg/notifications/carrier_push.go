@@ -0,0 +1,51 @@
+package notifications
+
+import (
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// PushEventPrefix is prepended to the notification name to
+// get the event published by the PushCarrier.
+const PushEventPrefix string = "notification."
+
+// Publisher publishes an event for the endpoints of a user,
+// like webhooks.Webhooks does.
+type Publisher interface {
+	Publish(userID ids.ID, event string, data interface{}) (int, error)
+}
+
+// PushPayload is the data of the events published by the PushCarrier.
+type PushPayload struct {
+	Notification string            `json:"notification"`
+	Texts        map[string]string `json:"texts"`
+	HTMLs        map[string]string `json:"htmls"`
+}
+
+// PushCarrier pushes the notifications to the webhook endpoints
+// of the users, as "notification.<name>" events.
+type PushCarrier struct {
+	publisher Publisher
+}
+
+var _ Carrier = (*PushCarrier)(nil)
+
+// NewPushCarrier creates a PushCarrier.
+func NewPushCarrier(publisher Publisher) *PushCarrier {
+	return &PushCarrier{
+		publisher: publisher,
+	}
+}
+
+// Deliver publishes the content for the recipient endpoints
+// subscribed to the notification event. A user without
+// endpoints is not an error.
+func (c *PushCarrier) Deliver(to *Recipient, notification string,
+	content *ContentSet) error {
+	_, err := c.publisher.Publish(to.UserID, PushEventPrefix+notification,
+		PushPayload{
+			Notification: notification,
+			Texts:        content.Texts,
+			HTMLs:        content.HTMLs,
+		})
+	return err
+}
@@ -0,0 +1,94 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultSMSTimeout is the timeout of the requests to the SMS gateway
+const DefaultSMSTimeout = 10 * time.Second
+
+// SMSGatewayConf has the configuration of a generic HTTP SMS gateway,
+// that receives a JSON POST with the "from", "to" and "text" fields.
+type SMSGatewayConf struct {
+	URL string
+	// Token, when not empty, is sent as a Bearer Authorization header
+	Token string
+	// From is the sender number or name
+	From    string
+	Timeout time.Duration
+}
+
+// SMSCarrier sends the notifications by SMS, with the "content"
+// text template, through an HTTP gateway.
+type SMSCarrier struct {
+	conf   SMSGatewayConf
+	client *http.Client
+}
+
+var _ Carrier = (*SMSCarrier)(nil)
+
+// smsRequest is the body sent to the gateway
+type smsRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Text string `json:"text"`
+}
+
+// NewSMSCarrier creates an SMSCarrier. If client is nil, a client
+// with the configured timeout is used.
+func NewSMSCarrier(conf SMSGatewayConf, client *http.Client) *SMSCarrier {
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultSMSTimeout
+	}
+	if client == nil {
+		client = &http.Client{Timeout: conf.Timeout}
+	}
+	return &SMSCarrier{
+		conf:   conf,
+		client: client,
+	}
+}
+
+// Deliver sends the content by SMS to the recipient Phone.
+func (c *SMSCarrier) Deliver(to *Recipient, notification string,
+	content *ContentSet) error {
+	if to.Phone == "" {
+		return ErrNoAddress
+	}
+	text, ok := content.Texts["content"]
+	if !ok {
+		return fmt.Errorf("%w: missing 'text body' content", ErrMissingContent)
+	}
+	body, err := json.Marshal(smsRequest{
+		From: c.conf.From,
+		To:   to.Phone,
+		Text: text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.conf.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/mailer"
+)
+
+var testContent = &ContentSet{
+	Texts: map[string]string{
+		"subject": "Hello",
+		"content": "Hello there",
+	},
+	HTMLs: map[string]string{
+		"content": "<b>Hello</b> there",
+	},
+}
+
+func Test_EmailCarrier(t *testing.T) {
+	m := mailer.NewMockMailer()
+	c := NewEmailCarrier(m)
+	if err := c.Deliver(&Recipient{}, "hello", testContent); err != ErrNoAddress {
+		t.Errorf("want ErrNoAddress, got %v", err)
+		return
+	}
+	if err := c.Deliver(&Recipient{Email: "a@example.com"}, "hello",
+		&ContentSet{Texts: map[string]string{}}); err == nil {
+		t.Errorf("want missing content error")
+		return
+	}
+	if err := c.Deliver(&Recipient{Email: "a@example.com"}, "hello", testContent); err != nil {
+		t.Errorf("cannot deliver: %s", err)
+		return
+	}
+	if len(m.SentMails) != 1 || m.SentMails[0].To.Address != "a@example.com" ||
+		m.SentMails[0].From.Address != "noreply@example.com" ||
+		m.SentMails[0].Subject != "Hello" {
+		t.Errorf("unexpected emails %#v", m.SentMails)
+		return
+	}
+}
+
+func Test_SMSCarrier(t *testing.T) {
+	var got smsRequest
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	c := NewSMSCarrier(SMSGatewayConf{URL: srv.URL, Token: "secret", From: "hfw"}, nil)
+	if err := c.Deliver(&Recipient{Email: "a@example.com"}, "hello",
+		testContent); err != ErrNoAddress {
+		t.Errorf("want ErrNoAddress, got %v", err)
+		return
+	}
+	to := &Recipient{Phone: "+34600000000"}
+	if err := c.Deliver(to, "hello", testContent); err != nil {
+		t.Errorf("cannot deliver: %s", err)
+		return
+	}
+	if got.From != "hfw" || got.To != "+34600000000" || got.Text != "Hello there" {
+		t.Errorf("unexpected request %#v", got)
+		return
+	}
+	status = http.StatusBadGateway
+	if err := c.Deliver(to, "hello", testContent); err == nil {
+		t.Errorf("want an error for a failed request")
+		return
+	}
+}
+
+type fakePublisher struct {
+	userID ids.ID
+	event  string
+	data   interface{}
+}
+
+func (p *fakePublisher) Publish(userID ids.ID, event string, data interface{}) (int, error) {
+	p.userID = userID
+	p.event = event
+	p.data = data
+	return 1, nil
+}
+
+func Test_PushCarrier(t *testing.T) {
+	p := &fakePublisher{}
+	c := NewPushCarrier(p)
+	userID := ids.NewIDGenerator().MustNew()
+	if err := c.Deliver(&Recipient{UserID: userID}, "hello", testContent); err != nil {
+		t.Errorf("cannot deliver: %s", err)
+		return
+	}
+	payload, ok := p.data.(PushPayload)
+	if p.userID != userID || p.event != "notification.hello" || !ok ||
+		payload.Texts["content"] != "Hello there" {
+		t.Errorf("unexpected event %#v", p)
+		return
+	}
+}
+
+func Test_InAppCarrier(t *testing.T) {
+	inbox := NewMemInboxRepo()
+	c := NewInAppCarrier(inbox)
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	userID := ids.NewIDGenerator().MustNew()
+	if err := c.Deliver(&Recipient{UserID: userID}, "hello", testContent); err != nil {
+		t.Errorf("cannot deliver: %s", err)
+		return
+	}
+	items, _ := inbox.ListInboxItems(userID, nil, 10)
+	if len(items) != 1 || items[0].Notification != "hello" ||
+		items[0].Read != nil || !items[0].Created.Equal(now) ||
+		items[0].Content.HTMLs["content"] != "<b>Hello</b> there" {
+		t.Errorf("unexpected inbox %#v", items)
+		return
+	}
+}
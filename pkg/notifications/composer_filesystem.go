@@ -2,11 +2,15 @@ package notifications
 
 import (
 	"bytes"
+	"errors"
 	html_template "html/template"
+	"io/fs"
 	"os"
 	"path"
 	"strings"
 	"text/template"
+
+	"github.com/dhontecillas/hfw/pkg/i18n"
 )
 
 // FileSystemComposer is notification template renderer
@@ -21,10 +25,12 @@ func NewFileSystemComposer(templatesDir string) *FileSystemComposer {
 	}
 }
 
-// Render renders a notifications using the provided data
+// Render renders a notifications using the provided data. The
+// language directory falls back from a variant to its language,
+// and then to the default one (`es-AR`, `es`, `en`).
 func (r *FileSystemComposer) Render(notification string, data map[string]interface{}, carrier string) (*ContentSet, error) {
-	lang := "en"
-	if l, ok := data["lang"].(string); ok {
+	lang := DefaultLang
+	if l, ok := data["lang"].(string); ok && l != "" {
 		lang = l
 	}
 
@@ -33,9 +39,22 @@ func (r *FileSystemComposer) Render(notification string, data map[string]interfa
 		Texts: make(map[string]string, 2),
 	}
 
-	notifDir := path.Join(r.templatesDir, notification, carrier, lang)
-
-	finfos, err := os.ReadDir(notifDir)
+	var notifDir string
+	var finfos []os.DirEntry
+	var err error
+	for _, l := range append(i18n.Fallbacks(lang), lang, DefaultLang) {
+		// the variant dirs can be named `es_AR` or `es-AR`
+		for _, dir := range []string{l, strings.ReplaceAll(l, "_", "-")} {
+			notifDir = path.Join(r.templatesDir, notification, carrier, dir)
+			finfos, err = os.ReadDir(notifDir)
+			if !errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
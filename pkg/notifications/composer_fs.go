@@ -0,0 +1,238 @@
+package notifications
+
+import (
+	"bytes"
+	"fmt"
+	html_template "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/dhontecillas/hfw/pkg/i18n"
+	"github.com/dhontecillas/hfw/pkg/tmplfuncs"
+)
+
+// DefaultLang is the language used when the data to render
+// a notification does not have a "lang" value.
+const DefaultLang string = "en"
+
+// FSComposerConf contains the configuration for an FSComposer
+type FSComposerConf struct {
+	// DefaultLang is the language to use when the data does not
+	// have a "lang" value. Every notification and carrier must
+	// have templates for it.
+	DefaultLang string
+	// Required lists the carriers that must have templates for
+	// each notification name.
+	Required map[string][]string
+	// Reload parses the templates again on every Render, so the
+	// changes are picked up while developing: it should not be
+	// used in production.
+	Reload bool
+	// Funcs are additional functions for the templates, that are
+	// added to the `tmplfuncs.FuncMap` ones.
+	Funcs template.FuncMap
+	// Catalog contains the messages for the translation functions
+	// of the templates.
+	Catalog *i18n.Catalog
+}
+
+type fsTemplateKey struct {
+	notification string
+	carrier      string
+	lang         string
+}
+
+// fsTemplate is a parsed template file, with either
+// a text or an html template.
+type fsTemplate struct {
+	name string
+	text *template.Template
+	html *html_template.Template
+}
+
+// FSComposer renders notifications from the templates in a
+// fs.FS (like an embed.FS, or an os.DirFS), that follow the
+// `<notification>/<carrier>/<lang>/<name>.(txt|html).tmpl` layout.
+// The templates are parsed once, when the composer is created.
+// The language of the templates falls back from a variant to its
+// language, and then to the default one (`es-AR`, `es`, `en`).
+type FSComposer struct {
+	fsys  fs.FS
+	conf  FSComposerConf
+	funcs template.FuncMap
+
+	mu        sync.RWMutex
+	templates map[fsTemplateKey][]fsTemplate
+}
+
+var _ Composer = (*FSComposer)(nil)
+
+// NewFSComposer creates a new FSComposer, returning an error if
+// any template cannot be parsed, or if there are missing templates.
+func NewFSComposer(fsys fs.FS, conf FSComposerConf) (*FSComposer, error) {
+	if conf.DefaultLang == "" {
+		conf.DefaultLang = DefaultLang
+	}
+	conf.DefaultLang = langKey(conf.DefaultLang)
+	funcs := tmplfuncs.FuncMap(conf.Catalog)
+	for name, fn := range conf.Funcs {
+		funcs[name] = fn
+	}
+	c := &FSComposer{
+		fsys:  fsys,
+		conf:  conf,
+		funcs: funcs,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// langKey returns the canonical form of a language, so the
+// `es-AR` and `es_AR` directories are the same language.
+func langKey(lang string) string {
+	if c := i18n.Canonical(lang); c != "" {
+		return c
+	}
+	return lang
+}
+
+// load parses all the templates and replaces the current ones
+func (c *FSComposer) load() error {
+	templates := make(map[fsTemplateKey][]fsTemplate, 32)
+	err := fs.WalkDir(c.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".tmpl") {
+			return nil
+		}
+		parts := strings.Split(p, "/")
+		if len(parts) != 4 {
+			return nil
+		}
+		content, err := fs.ReadFile(c.fsys, p)
+		if err != nil {
+			return err
+		}
+		t, err := c.parse(parts[3], string(content))
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", p, err)
+		}
+		key := fsTemplateKey{notification: parts[0], carrier: parts[1],
+			lang: langKey(parts[2])}
+		templates[key] = append(templates[key], t)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.check(templates); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.templates = templates
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *FSComposer) parse(fname string, content string) (fsTemplate, error) {
+	t := fsTemplate{
+		name: strings.Split(fname, ".")[0],
+	}
+	var err error
+	if strings.Contains(fname, ".html.") {
+		t.html, err = html_template.New(fname).Funcs(c.funcs).Parse(content)
+	} else {
+		t.text, err = template.New(fname).Funcs(c.funcs).Parse(content)
+	}
+	return t, err
+}
+
+// check verifies that every notification and carrier has templates
+// for the default language, and that the required ones exist.
+func (c *FSComposer) check(templates map[fsTemplateKey][]fsTemplate) error {
+	if len(templates) == 0 {
+		return fmt.Errorf("%w: no templates found", ErrMissingTemplates)
+	}
+	for key := range templates {
+		def := fsTemplateKey{notification: key.notification, carrier: key.carrier,
+			lang: c.conf.DefaultLang}
+		if _, ok := templates[def]; !ok {
+			return fmt.Errorf("%w: %s", ErrMissingTemplates,
+				path.Join(def.notification, def.carrier, def.lang))
+		}
+	}
+	for notification, carriers := range c.conf.Required {
+		for _, carrier := range carriers {
+			req := fsTemplateKey{notification: notification, carrier: carrier,
+				lang: c.conf.DefaultLang}
+			if _, ok := templates[req]; !ok {
+				return fmt.Errorf("%w: %s", ErrMissingTemplates,
+					path.Join(req.notification, req.carrier, req.lang))
+			}
+		}
+	}
+	return nil
+}
+
+// Render renders a notification using the provided data, with the
+// templates of the best language for the data "lang" value. When
+// there are no templates for the notification and carrier, it
+// returns an error wrapping fs.ErrNotExist.
+func (c *FSComposer) Render(notification string, data map[string]interface{},
+	carrier string) (*ContentSet, error) {
+	if c.conf.Reload {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+	lang := c.conf.DefaultLang
+	if l, ok := data["lang"].(string); ok && l != "" {
+		lang = l
+	}
+
+	var tmpls []fsTemplate
+	ok := false
+	chain := append(i18n.Fallbacks(lang), lang, c.conf.DefaultLang)
+	c.mu.RLock()
+	for _, l := range chain {
+		tmpls, ok = c.templates[fsTemplateKey{notification: notification,
+			carrier: carrier, lang: l}]
+		if ok {
+			break
+		}
+	}
+	c.mu.RUnlock()
+	if !ok {
+		return nil, &fs.PathError{
+			Op:   "render",
+			Path: path.Join(notification, carrier),
+			Err:  fs.ErrNotExist,
+		}
+	}
+
+	cs := ContentSet{
+		HTMLs: make(map[string]string, 2),
+		Texts: make(map[string]string, 2),
+	}
+	for _, t := range tmpls {
+		var b bytes.Buffer
+		if t.html != nil {
+			if err := t.html.Execute(&b, data); err != nil {
+				return nil, err
+			}
+			cs.HTMLs[t.name] = b.String()
+		} else {
+			if err := t.text.Execute(&b, data); err != nil {
+				return nil, err
+			}
+			cs.Texts[t.name] = b.String()
+		}
+	}
+	return &cs, nil
+}
@@ -0,0 +1,151 @@
+package notifications
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dhontecillas/hfw/pkg/i18n"
+)
+
+func Test_FSComposer_Embedded(t *testing.T) {
+	c, err := NewFSComposer(Templates(), FSComposerConf{
+		Required: map[string][]string{
+			"users_emailchangenotice": {CarrierEmail, CarrierInApp},
+		},
+	})
+	if err != nil {
+		t.Errorf("cannot load embedded templates: %s", err)
+		return
+	}
+	cs, err := c.Render("users_requestregistration",
+		map[string]interface{}{
+			"scheme":           "https",
+			"host":             "example.com",
+			"path":             "/activate",
+			"activation_token": "foobar",
+		}, CarrierEmail)
+	if err != nil {
+		t.Errorf("cannot render: %s", err)
+		return
+	}
+	if len(cs.Texts) != 2 || len(cs.HTMLs) != 1 ||
+		cs.Texts["subject"] != "Activate your account\n" {
+		t.Errorf("unexpected content %#v", cs)
+		return
+	}
+	if _, err := c.Render("users_requestregistration", nil, CarrierSMS); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want fs.ErrNotExist for a missing carrier, got %v", err)
+		return
+	}
+	if cs, err := c.Render("users_requestregistration",
+		map[string]interface{}{"lang": "fr"}, CarrierEmail); err != nil ||
+		cs.Texts["subject"] != "Activate your account\n" {
+		t.Errorf("want the default lang for a missing lang, got %#v (%v)", cs, err)
+		return
+	}
+}
+
+func Test_FSComposer_Startup(t *testing.T) {
+	valid := fstest.MapFS{
+		"hello/email/en/subject.txt.tmpl":  {Data: []byte(`Hello {{.name}}`)},
+		"hello/email/en/content.html.tmpl": {Data: []byte(`<b>{{.name}}</b>`)},
+		"hello/email/es/subject.txt.tmpl":  {Data: []byte(`Hola {{.name}}`)},
+		"README.md":                        {Data: []byte(`not a template`)},
+	}
+	if _, err := NewFSComposer(valid, FSComposerConf{}); err != nil {
+		t.Errorf("cannot load templates: %s", err)
+		return
+	}
+
+	cases := map[string]struct {
+		fsys fs.FS
+		conf FSComposerConf
+	}{
+		"empty": {fsys: fstest.MapFS{}},
+		"required": {fsys: valid, conf: FSComposerConf{
+			Required: map[string][]string{"hello": {CarrierEmail, CarrierSMS}},
+		}},
+		"default lang": {fsys: valid, conf: FSComposerConf{DefaultLang: "fr"}},
+	}
+	for name, tc := range cases {
+		if _, err := NewFSComposer(tc.fsys, tc.conf); !errors.Is(err, ErrMissingTemplates) {
+			t.Errorf("%s: want ErrMissingTemplates, got %v", name, err)
+			return
+		}
+	}
+
+	invalid := fstest.MapFS{
+		"hello/email/en/subject.txt.tmpl": {Data: []byte(`Hello {{.name`)},
+	}
+	if _, err := NewFSComposer(invalid, FSComposerConf{}); err == nil {
+		t.Errorf("want a parse error")
+		return
+	}
+}
+
+func Test_FSComposer_Reload(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello/sms/en/content.txt.tmpl": {Data: []byte(`Hello {{.name}}`)},
+	}
+	data := map[string]interface{}{"name": "Bob"}
+	cached, err := NewFSComposer(fsys, FSComposerConf{})
+	if err != nil {
+		t.Errorf("cannot load templates: %s", err)
+		return
+	}
+	reloaded, _ := NewFSComposer(fsys, FSComposerConf{Reload: true})
+
+	fsys["hello/sms/en/content.txt.tmpl"] = &fstest.MapFile{Data: []byte(`Bye {{.name}}`)}
+	if cs, err := cached.Render("hello", data, CarrierSMS); err != nil ||
+		cs.Texts["content"] != "Hello Bob" {
+		t.Errorf("want the cached template, got %#v (%v)", cs, err)
+		return
+	}
+	if cs, err := reloaded.Render("hello", data, CarrierSMS); err != nil ||
+		cs.Texts["content"] != "Bye Bob" {
+		t.Errorf("want the reloaded template, got %#v (%v)", cs, err)
+		return
+	}
+}
+
+func Test_FSComposer_Langs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello/sms/en/content.txt.tmpl":    {Data: []byte(`Hello {{.name}}`)},
+		"hello/sms/es/content.txt.tmpl":    {Data: []byte(`Hola {{.name}}`)},
+		"hello/sms/es-AR/content.txt.tmpl": {Data: []byte(`Che {{.name}}`)},
+		"digest/sms/en/content.txt.tmpl":   {Data: []byte(`{{ plural .lang "unread" .count }}`)},
+	}
+	catalog := i18n.NewCatalog("en")
+	_ = catalog.Add("en", map[string]i18n.Message{
+		"unread": {i18n.PluralOne: "%d unread", i18n.PluralOther: "%d unread items"},
+	})
+	_ = catalog.Add("es", map[string]i18n.Message{
+		"unread": {i18n.PluralOne: "%d sin leer", i18n.PluralOther: "%d sin leer (varias)"},
+	})
+	c, err := NewFSComposer(fsys, FSComposerConf{Catalog: catalog})
+	if err != nil {
+		t.Errorf("cannot load templates: %s", err)
+		return
+	}
+	cases := []struct {
+		notification string
+		data         map[string]interface{}
+		want         string
+	}{
+		{"hello", map[string]interface{}{"lang": "es_AR", "name": "Ana"}, "Che Ana"},
+		{"hello", map[string]interface{}{"lang": "es-MX", "name": "Ana"}, "Hola Ana"},
+		{"hello", map[string]interface{}{"lang": "de", "name": "Ana"}, "Hello Ana"},
+		{"hello", map[string]interface{}{"name": "Ana"}, "Hello Ana"},
+		{"digest", map[string]interface{}{"lang": "es-AR", "count": 1}, "1 sin leer"},
+		{"digest", map[string]interface{}{"count": 3}, "3 unread items"},
+	}
+	for _, tc := range cases {
+		cs, err := c.Render(tc.notification, tc.data, CarrierSMS)
+		if err != nil || cs.Texts["content"] != tc.want {
+			t.Errorf("%v: want %q, got %#v (%v)", tc.data, tc.want, cs, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	obsattrs "github.com/dhontecillas/hfw/pkg/obs/attrs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// Names of the carriers, that are also the name of the templates
+// directory for each one.
+const (
+	CarrierEmail string = "email"
+	CarrierSMS   string = "sms"
+	CarrierPush  string = "push"
+	CarrierInApp string = "inapp"
+)
+
+// AllNotifications is the notification name of a Preference that
+// applies to all the notifications without their own preference.
+const AllNotifications string = "*"
+
+// Metrics reported by the Dispatcher, with the notification.carrier attribute
+const (
+	MetNotificationDelivered string = "notifications.delivered"
+	MetNotificationFailed    string = "notifications.failed"
+)
+
+// AttrCarrier is the attribute of the metrics with the carrier
+const AttrCarrier string = "notification.carrier"
+
+// MetricDefinitions returns the definitions of the metrics
+// reported by the Dispatcher, to be registered in the insighter.
+func MetricDefinitions() metrics.MetricDefinitionList {
+	carrierAttrs := obsattrs.AttrDefinitionList{
+		obsattrs.AttrDefinition{
+			Name:        AttrCarrier,
+			StrAttrType: obsattrs.AttrTypeStr,
+		},
+	}
+	defs := metrics.MetricDefinitionList{}
+	for _, name := range []string{MetNotificationDelivered, MetNotificationFailed} {
+		defs = append(defs, &metrics.MetricDefinition{
+			Name:       name,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+			Attributes: carrierAttrs,
+		})
+	}
+	return defs
+}
+
+// Recipient has the addresses of a user for the carriers.
+// A carrier returns ErrNoAddress when the address it needs
+// is empty, and the recipient is skipped for that carrier.
+type Recipient struct {
+	UserID ids.ID
+	// Lang is used to render the templates when the
+	// notification data does not have a "lang" value.
+	Lang  string
+	Email string
+	Phone string
+}
+
+// RecipientResolver returns the Recipient of a user.
+type RecipientResolver interface {
+	Recipient(userID ids.ID) (*Recipient, error)
+}
+
+// RecipientResolverFunc is a function that implements
+// the RecipientResolver interface.
+type RecipientResolverFunc func(userID ids.ID) (*Recipient, error)
+
+// Recipient calls the function.
+func (f RecipientResolverFunc) Recipient(userID ids.ID) (*Recipient, error) {
+	return f(userID)
+}
+
+// Carrier delivers the rendered content of a notification.
+type Carrier interface {
+	Deliver(to *Recipient, notification string, content *ContentSet) error
+}
+
+// Preference enables or disables a carrier for a notification
+// (or for AllNotifications) of a user.
+type Preference struct {
+	Notification string
+	Carrier      string
+	Enabled      bool
+}
+
+// PreferencesRepo stores the channel preferences of the users.
+type PreferencesRepo interface {
+	// GetPreferences returns the preferences of a user.
+	GetPreferences(userID ids.ID) ([]Preference, error)
+	// SetPreference creates or updates a preference of a user.
+	SetPreference(userID ids.ID, p Preference) error
+	// DeletePreference removes a preference of a user, returning
+	// ErrNotFound if it does not exist.
+	DeletePreference(userID ids.ID, notification string, carrier string) error
+}
+
+type registeredCarrier struct {
+	carrier          Carrier
+	enabledByDefault bool
+}
+
+// Dispatcher sends a notification to a user through all the
+// carriers enabled by the user preferences.
+type Dispatcher struct {
+	ins        *obs.Insighter
+	composer   Composer
+	recipients RecipientResolver
+	prefs      PreferencesRepo
+
+	mu       sync.Mutex
+	carriers map[string]registeredCarrier
+}
+
+// NewDispatcher creates a Dispatcher. The prefs repo can be nil,
+// to always use the carriers enabled by default.
+func NewDispatcher(ins *obs.Insighter, composer Composer,
+	recipients RecipientResolver, prefs PreferencesRepo) *Dispatcher {
+	return &Dispatcher{
+		ins:        ins,
+		composer:   composer,
+		recipients: recipients,
+		prefs:      prefs,
+		carriers:   map[string]registeredCarrier{},
+	}
+}
+
+// Register adds a carrier, with the name of its templates
+// directory. A carrier enabled by default is used for all the
+// users, unless they disable it in their preferences, while
+// the others are only used for the users that enable them.
+func (d *Dispatcher) Register(name string, c Carrier, enabledByDefault bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.carriers[name] = registeredCarrier{
+		carrier:          c,
+		enabledByDefault: enabledByDefault,
+	}
+}
+
+// Preferences returns the channel preferences of a user.
+func (d *Dispatcher) Preferences(userID ids.ID) ([]Preference, error) {
+	if d.prefs == nil {
+		return []Preference{}, nil
+	}
+	return d.prefs.GetPreferences(userID)
+}
+
+// SetPreference enables or disables a registered carrier for
+// a notification of a user, or for AllNotifications.
+func (d *Dispatcher) SetPreference(userID ids.ID, p Preference) error {
+	d.mu.Lock()
+	_, ok := d.carriers[p.Carrier]
+	d.mu.Unlock()
+	if !ok || d.prefs == nil {
+		return ErrUnknownCarrier
+	}
+	if p.Notification == "" {
+		p.Notification = AllNotifications
+	}
+	return d.prefs.SetPreference(userID, p)
+}
+
+// ResetPreference removes a preference of a user, so the
+// carrier default is used again.
+func (d *Dispatcher) ResetPreference(userID ids.ID, notification string,
+	carrier string) error {
+	if d.prefs == nil {
+		return ErrNotFound
+	}
+	return d.prefs.DeletePreference(userID, notification, carrier)
+}
+
+// enabledCarriers returns the names of the carriers enabled
+// for a notification of a user, sorted by name. A preference
+// for the notification takes precedence over the one for
+// AllNotifications, and this one over the carrier default.
+func (d *Dispatcher) enabledCarriers(userID ids.ID, notification string) ([]string, error) {
+	enabled := map[string]bool{}
+	d.mu.Lock()
+	for name, rc := range d.carriers {
+		enabled[name] = rc.enabledByDefault
+	}
+	d.mu.Unlock()
+
+	if d.prefs != nil {
+		prefs, err := d.prefs.GetPreferences(userID)
+		if err != nil {
+			return nil, err
+		}
+		// the AllNotifications preferences are applied first, to
+		// be overridden by the ones of the notification
+		sort.SliceStable(prefs, func(a, b int) bool {
+			return prefs[a].Notification == AllNotifications &&
+				prefs[b].Notification != AllNotifications
+		})
+		for _, p := range prefs {
+			if _, ok := enabled[p.Carrier]; !ok {
+				continue
+			}
+			if p.Notification == AllNotifications || p.Notification == notification {
+				enabled[p.Carrier] = p.Enabled
+			}
+		}
+	}
+
+	names := []string{}
+	for name, on := range enabled {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Notify renders a notification for each carrier enabled for a
+// user, and delivers it. The carriers without templates for the
+// notification, or without an address for the user, are skipped.
+// A failed carrier does not stop the others, and the returned
+// error wraps ErrDeliveryFailed with the failures.
+func (d *Dispatcher) Notify(userID ids.ID, notification string,
+	data map[string]interface{}) error {
+
+	attrs := map[string]interface{}{
+		"user_id":      userID.ToUUID(),
+		"notification": notification,
+	}
+	span := d.ins.T.Start(context.Background(), "notify", attrs)
+	defer span.End()
+
+	to, err := d.recipients.Recipient(userID)
+	if err != nil {
+		span.Err(err)
+		d.ins.L.Err(err, "cannot get notification recipient", attrs)
+		return err
+	}
+	names, err := d.enabledCarriers(userID, notification)
+	if err != nil {
+		span.Err(err)
+		d.ins.L.Err(err, "cannot get notification preferences", attrs)
+		return err
+	}
+
+	tmplData := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		tmplData[k] = v
+	}
+	if _, ok := tmplData["lang"]; !ok && to.Lang != "" {
+		tmplData["lang"] = to.Lang
+	}
+
+	var errs []error
+	for _, name := range names {
+		if err := d.deliver(name, to, notification, tmplData); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		err := fmt.Errorf("%w: %w", ErrDeliveryFailed, errors.Join(errs...))
+		span.Err(err)
+		return err
+	}
+	return nil
+}
+
+// deliver renders and delivers a notification with a carrier.
+func (d *Dispatcher) deliver(name string, to *Recipient, notification string,
+	data map[string]interface{}) error {
+
+	d.mu.Lock()
+	rc, ok := d.carriers[name]
+	d.mu.Unlock()
+	if !ok {
+		return ErrUnknownCarrier
+	}
+	attrs := map[string]interface{}{
+		"user_id":      to.UserID.ToUUID(),
+		"notification": notification,
+		"carrier":      name,
+	}
+	metAttrs := map[string]interface{}{AttrCarrier: name}
+
+	content, err := d.composer.Render(notification, data, name)
+	if err == nil {
+		err = rc.carrier.Deliver(to, notification, content)
+	}
+	switch {
+	case err == nil:
+		d.ins.M.IncWL(MetNotificationDelivered, metAttrs)
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		// the notification is not sent with this carrier
+		return nil
+	case errors.Is(err, ErrNoAddress):
+		d.ins.L.Info("no address for notification carrier", attrs)
+		return nil
+	}
+	d.ins.M.IncWL(MetNotificationFailed, metAttrs)
+	d.ins.L.Err(err, "cannot deliver notification", attrs)
+	return err
+}
@@ -0,0 +1,198 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// memPrefs is an in memory PreferencesRepo for the tests
+type memPrefs struct {
+	prefs map[ids.ID][]Preference
+}
+
+var _ PreferencesRepo = (*memPrefs)(nil)
+
+func (m *memPrefs) GetPreferences(userID ids.ID) ([]Preference, error) {
+	return append([]Preference{}, m.prefs[userID]...), nil
+}
+
+func (m *memPrefs) SetPreference(userID ids.ID, p Preference) error {
+	prefs := m.prefs[userID]
+	for idx := range prefs {
+		if prefs[idx].Notification == p.Notification && prefs[idx].Carrier == p.Carrier {
+			prefs[idx].Enabled = p.Enabled
+			return nil
+		}
+	}
+	m.prefs[userID] = append(prefs, p)
+	return nil
+}
+
+func (m *memPrefs) DeletePreference(userID ids.ID, notification string,
+	carrier string) error {
+	prefs := m.prefs[userID]
+	for idx := range prefs {
+		if prefs[idx].Notification == notification && prefs[idx].Carrier == carrier {
+			m.prefs[userID] = append(prefs[:idx], prefs[idx+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// recordingCarrier keeps the delivered contents
+type recordingCarrier struct {
+	delivered []*ContentSet
+	err       error
+}
+
+func (c *recordingCarrier) Deliver(to *Recipient, notification string,
+	content *ContentSet) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.delivered = append(c.delivered, content)
+	return nil
+}
+
+// writeTemplates creates the "order_shipped" templates for the
+// given carriers, returning the templates dir.
+func writeTemplates(t *testing.T, carriers ...string) string {
+	dir := t.TempDir()
+	for _, carrier := range carriers {
+		langDir := filepath.Join(dir, "order_shipped", carrier, "en")
+		if err := os.MkdirAll(langDir, 0o755); err != nil {
+			t.Fatalf("cannot create templates: %s", err)
+		}
+		files := map[string]string{
+			"subject.txt.tmpl":  "Order {{.order}} shipped",
+			"content.txt.tmpl":  carrier + ": order {{.order}} shipped",
+			"content.html.tmpl": "<b>Order {{.order}}</b> shipped",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(langDir, name), []byte(content),
+				0o644); err != nil {
+				t.Fatalf("cannot write template: %s", err)
+			}
+		}
+	}
+	return dir
+}
+
+func Test_Dispatcher_Notify(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+	userID := ids.NewIDGenerator().MustNew()
+	recipients := RecipientResolverFunc(func(id ids.ID) (*Recipient, error) {
+		return &Recipient{UserID: id, Lang: "en", Email: "a@example.com"}, nil
+	})
+	prefs := &memPrefs{prefs: map[ids.ID][]Preference{}}
+	composer := NewFileSystemComposer(writeTemplates(t, CarrierEmail, CarrierSMS,
+		CarrierPush, CarrierInApp))
+	d := NewDispatcher(ins, composer, recipients, prefs)
+
+	carriers := map[string]*recordingCarrier{}
+	for _, name := range []string{CarrierEmail, CarrierSMS, CarrierPush, CarrierInApp,
+		"fax"} {
+		carriers[name] = &recordingCarrier{}
+		d.Register(name, carriers[name], name != CarrierSMS)
+	}
+	delivered := func() map[string]int {
+		res := map[string]int{}
+		for name, c := range carriers {
+			res[name] = len(c.delivered)
+		}
+		return res
+	}
+	data := map[string]interface{}{"order": "A1"}
+
+	// sms is disabled by default, and there are no fax templates
+	if err := d.Notify(userID, "order_shipped", data); err != nil {
+		t.Errorf("cannot notify: %s", err)
+		return
+	}
+	want := map[string]int{CarrierEmail: 1, CarrierSMS: 0, CarrierPush: 1,
+		CarrierInApp: 1, "fax": 0}
+	for name, n := range want {
+		if delivered()[name] != n {
+			t.Errorf("want %d %s deliveries, got %v", n, name, delivered())
+			return
+		}
+	}
+	if got := carriers[CarrierPush].delivered[0].Texts["content"]; got != "push: order A1 shipped" {
+		t.Errorf("unexpected push content %q", got)
+		return
+	}
+
+	// a preference for the notification overrides the one for all
+	for _, p := range []Preference{
+		{Carrier: CarrierSMS, Enabled: true},
+		{Notification: "order_shipped", Carrier: CarrierPush, Enabled: false},
+		{Notification: "order_shipped", Carrier: CarrierEmail, Enabled: true},
+		{Notification: AllNotifications, Carrier: CarrierEmail, Enabled: false},
+	} {
+		if err := d.SetPreference(userID, p); err != nil {
+			t.Errorf("cannot set preference: %s", err)
+			return
+		}
+	}
+	if err := d.SetPreference(userID, Preference{Carrier: "pigeon"}); err != ErrUnknownCarrier {
+		t.Errorf("want ErrUnknownCarrier, got %v", err)
+		return
+	}
+	if err := d.Notify(userID, "order_shipped", data); err != nil {
+		t.Errorf("cannot notify: %s", err)
+		return
+	}
+	want = map[string]int{CarrierEmail: 2, CarrierSMS: 1, CarrierPush: 1,
+		CarrierInApp: 2}
+	for name, n := range want {
+		if delivered()[name] != n {
+			t.Errorf("want %d %s deliveries, got %v", n, name, delivered())
+			return
+		}
+	}
+
+	// a failed carrier does not stop the others
+	carriers[CarrierEmail].err = errors.New("mailer down")
+	err := d.Notify(userID, "order_shipped", data)
+	if !errors.Is(err, ErrDeliveryFailed) {
+		t.Errorf("want ErrDeliveryFailed, got %v", err)
+		return
+	}
+	if delivered()[CarrierSMS] != 2 || delivered()[CarrierInApp] != 3 {
+		t.Errorf("want other carriers delivered, got %v", delivered())
+		return
+	}
+
+	if err := d.ResetPreference(userID, AllNotifications, CarrierSMS); err != nil {
+		t.Errorf("cannot reset preference: %s", err)
+		return
+	}
+	if p, _ := d.Preferences(userID); len(p) != 3 {
+		t.Errorf("want 3 preferences, got %v", p)
+		return
+	}
+
+	failed, sent := 0, 0
+	for _, inc := range meter.Incs {
+		switch inc {
+		case MetNotificationFailed:
+			failed++
+		case MetNotificationDelivered:
+			sent++
+		}
+	}
+	if failed != 1 || sent != 8 {
+		t.Errorf("unexpected metrics %v", meter.Incs)
+		return
+	}
+}
@@ -0,0 +1,18 @@
+package notifications
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Templates returns the notification templates shipped with
+// the package (the ones used by `usecases/users`), to be used
+// with an FSComposer.
+func Templates() fs.FS {
+	// fs.Sub only fails with an invalid dir name
+	sub, _ := fs.Sub(embeddedTemplates, "templates")
+	return sub
+}
@@ -0,0 +1,16 @@
+package notifications
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Domain errors for the notifications
+const (
+	ErrNotFound         = consterr.ConstErr("ErrNotFound")
+	ErrUnknownCarrier   = consterr.ConstErr("ErrUnknownCarrier")
+	ErrNoAddress        = consterr.ConstErr("ErrNoAddress")
+	ErrMissingContent   = consterr.ConstErr("ErrMissingContent")
+	ErrDeliveryFailed   = consterr.ConstErr("ErrDeliveryFailed")
+	ErrNoBroker         = consterr.ConstErr("ErrNoBroker")
+	ErrMissingTemplates = consterr.ConstErr("ErrMissingTemplates")
+)
@@ -0,0 +1,135 @@
+package notifications
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// Limits of the items listed from an inbox
+const (
+	DefaultInboxLimit = 20
+	MaxInboxLimit     = 100
+)
+
+// InboxItem is a notification in the in-app inbox of a user
+type InboxItem struct {
+	ID           ids.ID
+	UserID       ids.ID
+	Notification string
+	Content      ContentSet
+	Created      time.Time
+	// Read is when the user read the item, or nil if unread
+	Read *time.Time
+}
+
+// InboxWriter adds items to the in-app inbox.
+type InboxWriter interface {
+	// AddInboxItem stores a new unread item.
+	AddInboxItem(item *InboxItem) error
+}
+
+// InboxRepo stores the items of the in-app inbox.
+type InboxRepo interface {
+	InboxWriter
+	// ListInboxItems returns up to limit items of a user, the
+	// most recent first, that are older than the before item
+	// when it is not nil.
+	ListInboxItems(userID ids.ID, before *ids.ID, limit int) ([]InboxItem, error)
+	// CountUnread returns the number of unread items of a user.
+	CountUnread(userID ids.ID) (int, error)
+	// MarkRead marks an item of a user as read, returning
+	// ErrNotFound if the user does not have it.
+	MarkRead(userID ids.ID, id ids.ID, now time.Time) error
+	// MarkAllRead marks all the unread items of a user as read,
+	// and returns how many were marked.
+	MarkAllRead(userID ids.ID, now time.Time) (int64, error)
+}
+
+// InboxBroker sends the new inbox items to the subscribed users,
+// that might be connected to other instances.
+type InboxBroker interface {
+	// Publish sends an item to the subscriptions of its user.
+	Publish(item *InboxItem) error
+	// Subscribe returns a channel with the new items of a user,
+	// and a function to cancel the subscription, that closes it.
+	Subscribe(userID ids.ID) (<-chan InboxItem, func(), error)
+}
+
+// Inbox is the controller of the in-app inbox of the users.
+type Inbox struct {
+	ins    *obs.Insighter
+	repo   InboxRepo
+	broker InboxBroker
+	now    func() time.Time
+}
+
+var _ InboxWriter = (*Inbox)(nil)
+
+// NewInbox creates an Inbox. The broker can be nil, when the
+// items are not sent in real time.
+func NewInbox(ins *obs.Insighter, repo InboxRepo, broker InboxBroker) *Inbox {
+	return &Inbox{
+		ins:    ins,
+		repo:   repo,
+		broker: broker,
+		now:    time.Now,
+	}
+}
+
+// AddInboxItem stores a new unread item, and publishes it to
+// the subscriptions of its user. A failed publication is only
+// logged, because the item is already in the inbox.
+func (i *Inbox) AddInboxItem(item *InboxItem) error {
+	if err := i.repo.AddInboxItem(item); err != nil {
+		return err
+	}
+	if i.broker == nil {
+		return nil
+	}
+	if err := i.broker.Publish(item); err != nil {
+		i.ins.L.Err(err, "cannot publish inbox item", map[string]interface{}{
+			"user_id": item.UserID.ToUUID(),
+			"item_id": item.ID.ToUUID(),
+		})
+	}
+	return nil
+}
+
+// List returns up to limit items of a user, the most recent
+// first, that are older than the before item when it is not nil.
+func (i *Inbox) List(userID ids.ID, before *ids.ID, limit int) ([]InboxItem, error) {
+	if limit <= 0 {
+		limit = DefaultInboxLimit
+	}
+	if limit > MaxInboxLimit {
+		limit = MaxInboxLimit
+	}
+	return i.repo.ListInboxItems(userID, before, limit)
+}
+
+// Unread returns the number of unread items of a user.
+func (i *Inbox) Unread(userID ids.ID) (int, error) {
+	return i.repo.CountUnread(userID)
+}
+
+// MarkRead marks an item of a user as read.
+func (i *Inbox) MarkRead(userID ids.ID, id ids.ID) error {
+	return i.repo.MarkRead(userID, id, i.now())
+}
+
+// MarkAllRead marks all the unread items of a user as read.
+func (i *Inbox) MarkAllRead(userID ids.ID) (int64, error) {
+	return i.repo.MarkAllRead(userID, i.now())
+}
+
+// Subscribe returns a channel with the new items of a user, and
+// a function to cancel the subscription. It returns ErrNoBroker
+// when the inbox does not have a broker.
+func (i *Inbox) Subscribe(userID ids.ID) (<-chan InboxItem, func(), error) {
+	if i.broker == nil {
+		return nil, nil, ErrNoBroker
+	}
+	return i.broker.Subscribe(userID)
+}
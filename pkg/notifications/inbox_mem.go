@@ -0,0 +1,171 @@
+package notifications
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// MemInboxRepo is an in memory InboxRepo, useful for tests.
+type MemInboxRepo struct {
+	mu    sync.Mutex
+	items map[ids.ID]*InboxItem
+}
+
+var _ InboxRepo = (*MemInboxRepo)(nil)
+
+// NewMemInboxRepo creates a MemInboxRepo.
+func NewMemInboxRepo() *MemInboxRepo {
+	return &MemInboxRepo{
+		items: map[ids.ID]*InboxItem{},
+	}
+}
+
+// AddInboxItem stores a new unread item.
+func (r *MemInboxRepo) AddInboxItem(item *InboxItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ci := *item
+	r.items[item.ID] = &ci
+	return nil
+}
+
+// ListInboxItems returns up to limit items of a user, the most
+// recent first, that are older than the before item if not nil.
+func (r *MemInboxRepo) ListInboxItems(userID ids.ID, before *ids.ID,
+	limit int) ([]InboxItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []InboxItem{}
+	for _, item := range r.items {
+		if item.UserID != userID {
+			continue
+		}
+		if before != nil && item.ID.ToUUID() >= before.ToUUID() {
+			continue
+		}
+		res = append(res, *item)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].ID.ToUUID() > res[b].ID.ToUUID()
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// CountUnread returns the number of unread items of a user.
+func (r *MemInboxRepo) CountUnread(userID ids.ID) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, item := range r.items {
+		if item.UserID == userID && item.Read == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MarkRead marks an item of a user as read.
+func (r *MemInboxRepo) MarkRead(userID ids.ID, id ids.ID, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok || item.UserID != userID {
+		return ErrNotFound
+	}
+	if item.Read == nil {
+		item.Read = &now
+	}
+	return nil
+}
+
+// MarkAllRead marks all the unread items of a user as read.
+func (r *MemInboxRepo) MarkAllRead(userID ids.ID, now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, item := range r.items {
+		if item.UserID == userID && item.Read == nil {
+			item.Read = &now
+			n++
+		}
+	}
+	return n, nil
+}
+
+// subscriptionBuffer is the number of items a subscription can
+// have pending before the new ones are dropped.
+const subscriptionBuffer = 16
+
+// MemInboxBroker is an in memory InboxBroker, useful for tests
+// or single instance deployments.
+type MemInboxBroker struct {
+	mu   sync.Mutex
+	subs map[ids.ID]map[chan InboxItem]struct{}
+}
+
+var _ InboxBroker = (*MemInboxBroker)(nil)
+
+// NewMemInboxBroker creates a MemInboxBroker.
+func NewMemInboxBroker() *MemInboxBroker {
+	return &MemInboxBroker{
+		subs: map[ids.ID]map[chan InboxItem]struct{}{},
+	}
+}
+
+// Publish sends an item to the subscriptions of its user. The
+// subscriptions that are not reading are skipped.
+func (b *MemInboxBroker) Publish(item *InboxItem) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[item.UserID] {
+		select {
+		case ch <- *item:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel with the new items of a user.
+func (b *MemInboxBroker) Subscribe(userID ids.ID) (<-chan InboxItem, func(), error) {
+	ch := make(chan InboxItem, subscriptionBuffer)
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = map[chan InboxItem]struct{}{}
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// it could have been closed by closeAll
+		if _, ok := b.subs[userID][ch]; !ok {
+			return
+		}
+		delete(b.subs[userID], ch)
+		if len(b.subs[userID]) == 0 {
+			delete(b.subs, userID)
+		}
+		close(ch)
+	}
+	return ch, cancel, nil
+}
+
+// closeAll closes all the subscriptions
+func (b *MemInboxBroker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for userID, chans := range b.subs {
+		for ch := range chans {
+			close(ch)
+		}
+		delete(b.subs, userID)
+	}
+}
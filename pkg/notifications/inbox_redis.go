@@ -0,0 +1,170 @@
+package notifications
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// DefaultInboxChannelPrefix is the prefix of the redis pub/sub
+// channels of the users inboxes.
+const DefaultInboxChannelPrefix string = "hfw:inbox:"
+
+// RedisInboxBroker is an InboxBroker that uses redis pub/sub, so
+// the items added in an instance are sent to the users connected
+// to any other. A single connection subscribes to the channels of
+// all the users, and the items are sent in memory to the
+// subscriptions of the instance.
+type RedisInboxBroker struct {
+	ins    *obs.Insighter
+	pool   *redis.Pool
+	prefix string
+
+	// local has the subscriptions of the running subscriber
+	// connection, and is nil when it is not running.
+	mu    sync.Mutex
+	local *MemInboxBroker
+	psc   *redis.PubSubConn
+}
+
+var _ InboxBroker = (*RedisInboxBroker)(nil)
+
+// redisInboxItem is the message published for an inbox item
+type redisInboxItem struct {
+	ID           string            `json:"id"`
+	UserID       string            `json:"user_id"`
+	Notification string            `json:"notification"`
+	Texts        map[string]string `json:"texts"`
+	HTMLs        map[string]string `json:"htmls"`
+	Created      time.Time         `json:"created"`
+}
+
+// NewRedisInboxBroker creates a RedisInboxBroker. If the prefix is
+// empty, the DefaultInboxChannelPrefix is used.
+func NewRedisInboxBroker(ins *obs.Insighter, pool *redis.Pool,
+	prefix string) *RedisInboxBroker {
+	if prefix == "" {
+		prefix = DefaultInboxChannelPrefix
+	}
+	return &RedisInboxBroker{
+		ins:    ins,
+		pool:   pool,
+		prefix: prefix,
+	}
+}
+
+func (b *RedisInboxBroker) channel(userID ids.ID) string {
+	return b.prefix + userID.ToUUID()
+}
+
+// Publish sends an item to the channel of its user.
+func (b *RedisInboxBroker) Publish(item *InboxItem) error {
+	data, err := json.Marshal(redisInboxItem{
+		ID:           item.ID.ToUUID(),
+		UserID:       item.UserID.ToUUID(),
+		Notification: item.Notification,
+		Texts:        item.Content.Texts,
+		HTMLs:        item.Content.HTMLs,
+		Created:      item.Created,
+	})
+	if err != nil {
+		return err
+	}
+	conn := b.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("PUBLISH", b.channel(item.UserID), data)
+	return err
+}
+
+// Subscribe returns a channel with the items published for a
+// user, until the subscription is cancelled or the connection
+// to redis is lost (then, the channels of all the subscriptions
+// are closed). The subscriber connection is started with the
+// first subscription.
+func (b *RedisInboxBroker) Subscribe(userID ids.ID) (<-chan InboxItem, func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.local == nil {
+		psc := &redis.PubSubConn{Conn: b.pool.Get()}
+		if err := psc.PSubscribe(b.prefix + "*"); err != nil {
+			psc.Close()
+			return nil, nil, err
+		}
+		b.local = NewMemInboxBroker()
+		b.psc = psc
+		go b.receive(psc, b.local)
+	}
+	return b.local.Subscribe(userID)
+}
+
+// receive sends the published items to the local subscriptions,
+// until the subscriber connection is closed or lost.
+func (b *RedisInboxBroker) receive(psc *redis.PubSubConn, local *MemInboxBroker) {
+	defer func() {
+		// the connection is closed with the lock, so Close does
+		// not use it at the same time
+		b.mu.Lock()
+		if b.local == local {
+			b.local = nil
+			b.psc = nil
+		}
+		psc.Close()
+		b.mu.Unlock()
+		local.closeAll()
+	}()
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			item, err := b.decode(v.Data)
+			if err != nil {
+				b.ins.L.Err(err, "cannot decode inbox item", nil)
+				continue
+			}
+			_ = local.Publish(item)
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			b.ins.L.Err(v, "inbox subscriber connection lost", nil)
+			return
+		}
+	}
+}
+
+// Close stops the subscriber connection, closing the channels
+// of all the subscriptions.
+func (b *RedisInboxBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.psc != nil {
+		_ = b.psc.PUnsubscribe()
+	}
+}
+
+func (b *RedisInboxBroker) decode(data []byte) (*InboxItem, error) {
+	var ri redisInboxItem
+	if err := json.Unmarshal(data, &ri); err != nil {
+		return nil, err
+	}
+	item := &InboxItem{
+		Notification: ri.Notification,
+		Content: ContentSet{
+			Texts: ri.Texts,
+			HTMLs: ri.HTMLs,
+		},
+		Created: ri.Created,
+	}
+	if err := item.ID.FromUUID(ri.ID); err != nil {
+		return nil, err
+	}
+	if err := item.UserID.FromUUID(ri.UserID); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
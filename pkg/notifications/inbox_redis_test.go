@@ -0,0 +1,172 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// fakePubSub is a minimal redis server with PSUBSCRIBE and PUBLISH
+type fakePubSub struct {
+	mu          sync.Mutex
+	subscribers []*fakePubSubConn
+	psubscribes int
+}
+
+func (s *fakePubSub) dial() (redis.Conn, error) {
+	return &fakePubSubConn{
+		server:  s,
+		replies: make(chan interface{}, 64),
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+// drop closes the subscriber connections
+func (s *fakePubSub) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.subscribers {
+		_ = c.Close()
+	}
+	s.subscribers = nil
+}
+
+type fakePubSubConn struct {
+	server  *fakePubSub
+	pattern string
+	replies chan interface{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (c *fakePubSubConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakePubSubConn) Err() error {
+	return nil
+}
+
+func (c *fakePubSubConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "PUBLISH" {
+		return nil, nil
+	}
+	channel := args[0].(string)
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	for _, sub := range c.server.subscribers {
+		if strings.HasPrefix(channel, strings.TrimSuffix(sub.pattern, "*")) {
+			sub.replies <- []interface{}{[]byte("pmessage"), []byte(sub.pattern),
+				[]byte(channel), args[1]}
+		}
+	}
+	return int64(1), nil
+}
+
+func (c *fakePubSubConn) Send(cmd string, args ...interface{}) error {
+	switch cmd {
+	case "PSUBSCRIBE":
+		c.pattern = args[0].(string)
+		c.server.mu.Lock()
+		c.server.subscribers = append(c.server.subscribers, c)
+		c.server.psubscribes++
+		c.server.mu.Unlock()
+		c.replies <- []interface{}{[]byte("psubscribe"), []byte(c.pattern), int64(1)}
+	case "PUNSUBSCRIBE":
+		c.replies <- []interface{}{[]byte("punsubscribe"), []byte(c.pattern), int64(0)}
+	case "ECHO":
+		c.replies <- args[0]
+	}
+	return nil
+}
+
+func (c *fakePubSubConn) Flush() error {
+	return nil
+}
+
+func (c *fakePubSubConn) Receive() (interface{}, error) {
+	select {
+	case r := <-c.replies:
+		return r, nil
+	case <-c.closed:
+		return nil, errors.New("connection closed")
+	}
+}
+
+func receiveItem(ch <-chan InboxItem) (InboxItem, bool) {
+	select {
+	case item, ok := <-ch:
+		return item, ok
+	case <-time.After(time.Second):
+		return InboxItem{}, false
+	}
+}
+
+func Test_RedisInboxBroker(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	server := &fakePubSub{}
+	pool := &redis.Pool{Dial: server.dial}
+	b := NewRedisInboxBroker(ins, pool, "")
+	userID := ids.NewIDGenerator().MustNew()
+	otherID := ids.NewIDGenerator().MustNew()
+
+	first, cancelFirst, err := b.Subscribe(userID)
+	if err != nil {
+		t.Errorf("cannot subscribe: %s", err)
+		return
+	}
+	second, _, _ := b.Subscribe(userID)
+	other, _, _ := b.Subscribe(otherID)
+	if server.psubscribes != 1 {
+		t.Errorf("want a single subscriber connection, got %d", server.psubscribes)
+		return
+	}
+
+	item := &InboxItem{ID: ids.NewIDGenerator().MustNew(), UserID: userID,
+		Notification: "hello", Content: *testContent}
+	if err := b.Publish(item); err != nil {
+		t.Errorf("cannot publish: %s", err)
+		return
+	}
+	for _, ch := range []<-chan InboxItem{first, second} {
+		if got, ok := receiveItem(ch); !ok || got.ID != item.ID {
+			t.Errorf("want the published item, got %#v", got)
+			return
+		}
+	}
+	select {
+	case got := <-other:
+		t.Errorf("unexpected item for other user %#v", got)
+		return
+	default:
+	}
+	cancelFirst()
+	cancelFirst()
+
+	// a lost connection closes the subscriptions, and the next
+	// one starts a new subscriber
+	server.drop()
+	if _, ok := receiveItem(second); ok {
+		t.Errorf("want the subscription closed")
+		return
+	}
+	third, _, err := b.Subscribe(userID)
+	if err != nil || server.psubscribes != 2 {
+		t.Errorf("want a new subscriber connection, got %d (%v)", server.psubscribes, err)
+		return
+	}
+	b.Close()
+	if _, ok := receiveItem(third); ok {
+		t.Errorf("want the subscription closed by Close")
+		return
+	}
+}
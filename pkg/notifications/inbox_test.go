@@ -0,0 +1,91 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+func Test_Inbox(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	broker := NewMemInboxBroker()
+	inbox := NewInbox(ins, NewMemInboxRepo(), broker)
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	inbox.now = func() time.Time { return now }
+	userID := ids.NewIDGenerator().MustNew()
+	otherID := ids.NewIDGenerator().MustNew()
+
+	items, cancel, err := inbox.Subscribe(userID)
+	if err != nil {
+		t.Errorf("cannot subscribe: %s", err)
+		return
+	}
+	c := NewInAppCarrier(inbox)
+	for _, to := range []ids.ID{userID, userID, userID, otherID} {
+		if err := c.Deliver(&Recipient{UserID: to}, "hello", testContent); err != nil {
+			t.Errorf("cannot deliver: %s", err)
+			return
+		}
+	}
+	for idx := 0; idx < 3; idx++ {
+		select {
+		case item := <-items:
+			if item.UserID != userID {
+				t.Errorf("unexpected published item %#v", item)
+				return
+			}
+		default:
+			t.Errorf("want 3 published items, got %d", idx)
+			return
+		}
+	}
+	cancel()
+	if _, ok := <-items; ok {
+		t.Errorf("want subscription closed")
+		return
+	}
+
+	if n, _ := inbox.Unread(userID); n != 3 {
+		t.Errorf("want 3 unread items, got %d", n)
+		return
+	}
+	page, err := inbox.List(userID, nil, 2)
+	if err != nil || len(page) != 2 {
+		t.Errorf("want a page of 2 items, got %d (%v)", len(page), err)
+		return
+	}
+	rest, _ := inbox.List(userID, &page[1].ID, 0)
+	if len(rest) != 1 || rest[0].ID.ToUUID() >= page[1].ID.ToUUID() {
+		t.Errorf("want the older item, got %#v", rest)
+		return
+	}
+
+	if err := inbox.MarkRead(otherID, page[0].ID); err != ErrNotFound {
+		t.Errorf("want ErrNotFound marking the item of another user, got %v", err)
+		return
+	}
+	if err := inbox.MarkRead(userID, page[0].ID); err != nil {
+		t.Errorf("cannot mark read: %s", err)
+		return
+	}
+	if n, _ := inbox.Unread(userID); n != 2 {
+		t.Errorf("want 2 unread items, got %d", n)
+		return
+	}
+	if n, _ := inbox.MarkAllRead(userID); n != 2 {
+		t.Errorf("want 2 items marked, got %d", n)
+		return
+	}
+	if n, _ := inbox.Unread(otherID); n != 1 {
+		t.Errorf("want the items of other users unread, got %d", n)
+		return
+	}
+
+	if _, _, err := NewInbox(ins, NewMemInboxRepo(), nil).Subscribe(userID); err != ErrNoBroker {
+		t.Errorf("want ErrNoBroker, got %v", err)
+		return
+	}
+}
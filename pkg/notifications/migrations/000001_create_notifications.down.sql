@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE notification_inbox;
+DROP TABLE notification_preferences;
+COMMIT;
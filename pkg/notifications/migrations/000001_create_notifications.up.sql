@@ -0,0 +1,25 @@
+BEGIN;
+
+CREATE TABLE notification_preferences(
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,notification   TEXT NOT NULL
+    ,carrier        TEXT NOT NULL
+    ,enabled        BOOLEAN NOT NULL
+    ,PRIMARY KEY (user_id, notification, carrier)
+);
+
+CREATE TABLE notification_inbox(
+    id              UUID PRIMARY KEY
+    ,user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,notification   TEXT NOT NULL
+    ,texts          JSONB NOT NULL
+    ,htmls          JSONB NOT NULL
+    ,created        TIMESTAMP NOT NULL
+    ,read_at        TIMESTAMP
+);
+
+CREATE INDEX idx_notification_inbox_user_id ON notification_inbox(user_id, id);
+CREATE INDEX idx_notification_inbox_unread ON notification_inbox(user_id)
+    WHERE read_at IS NULL;
+
+COMMIT;
@@ -0,0 +1,300 @@
+package notifications
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/db"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// RepoSQLX implements the preferences and inbox repositories
+// with sqlx
+type RepoSQLX struct {
+	sqlDB db.SQLDB
+	ins   *obs.Insighter
+}
+
+var _ PreferencesRepo = (*RepoSQLX)(nil)
+var _ InboxRepo = (*RepoSQLX)(nil)
+
+// NewRepoSQLX creates a new RepoSQLX
+func NewRepoSQLX(ins *obs.Insighter, sqlDB db.SQLDB) *RepoSQLX {
+	return &RepoSQLX{
+		sqlDB: sqlDB,
+		ins:   ins,
+	}
+}
+
+type sqlxPreference struct {
+	Notification string
+	Carrier      string
+	Enabled      bool
+}
+
+// GetPreferences returns the preferences of a user.
+func (r *RepoSQLX) GetPreferences(userID ids.ID) ([]Preference, error) {
+	sqlQ := `
+SELECT
+	notification AS Notification
+	,carrier AS Carrier
+	,enabled AS Enabled
+FROM notification_preferences
+WHERE
+	user_id = $1
+ORDER BY notification, carrier
+`
+	var sps []sqlxPreference
+	master := r.sqlDB.Master()
+	if err := master.Select(&sps, sqlQ, userID.ToUUID()); err != nil {
+		r.ins.L.Err(err, "cannot get notification preferences", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	res := make([]Preference, 0, len(sps))
+	for _, sp := range sps {
+		res = append(res, Preference(sp))
+	}
+	return res, nil
+}
+
+// SetPreference creates or updates a preference of a user.
+func (r *RepoSQLX) SetPreference(userID ids.ID, p Preference) error {
+	sqlQ := `
+INSERT INTO notification_preferences(
+	user_id
+	,notification
+	,carrier
+	,enabled
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+)
+ON CONFLICT (user_id, notification, carrier) DO UPDATE
+SET
+	enabled = EXCLUDED.enabled
+`
+	master := r.sqlDB.Master()
+	if _, err := master.Exec(sqlQ, userID.ToUUID(), p.Notification, p.Carrier,
+		p.Enabled); err != nil {
+		r.ins.L.Err(err, "cannot set notification preference", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// DeletePreference removes a preference of a user.
+func (r *RepoSQLX) DeletePreference(userID ids.ID, notification string,
+	carrier string) error {
+	sqlQ := `
+DELETE FROM notification_preferences
+WHERE
+	user_id = $1
+	AND notification = $2
+	AND carrier = $3
+`
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, userID.ToUUID(), notification, carrier)
+	if err != nil {
+		r.ins.L.Err(err, "cannot delete notification preference", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddInboxItem stores a new unread item.
+func (r *RepoSQLX) AddInboxItem(item *InboxItem) error {
+	sqlQ := `
+INSERT INTO notification_inbox(
+	id
+	,user_id
+	,notification
+	,texts
+	,htmls
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,$6
+)
+`
+	texts, err := json.Marshal(item.Content.Texts)
+	if err != nil {
+		return err
+	}
+	htmls, err := json.Marshal(item.Content.HTMLs)
+	if err != nil {
+		return err
+	}
+	master := r.sqlDB.Master()
+	if _, err := master.Exec(sqlQ, item.ID.ToUUID(), item.UserID.ToUUID(),
+		item.Notification, string(texts), string(htmls), item.Created); err != nil {
+		r.ins.L.Err(err, "cannot add inbox item", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	return nil
+}
+
+type sqlxInboxItem struct {
+	ID           string
+	UserID       string
+	Notification string
+	Texts        []byte
+	HTMLs        []byte
+	Created      time.Time
+	ReadAt       *time.Time
+}
+
+func (si *sqlxInboxItem) fromSQLX(item *InboxItem) error {
+	if err := item.ID.FromUUID(si.ID); err != nil {
+		return err
+	}
+	if err := item.UserID.FromUUID(si.UserID); err != nil {
+		return err
+	}
+	item.Notification = si.Notification
+	if err := json.Unmarshal(si.Texts, &item.Content.Texts); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(si.HTMLs, &item.Content.HTMLs); err != nil {
+		return err
+	}
+	item.Created = si.Created
+	item.Read = si.ReadAt
+	return nil
+}
+
+// ListInboxItems returns up to limit items of a user, the most
+// recent first, that are older than the before item if not nil.
+func (r *RepoSQLX) ListInboxItems(userID ids.ID, before *ids.ID,
+	limit int) ([]InboxItem, error) {
+	sqlQ := `
+SELECT
+	id AS ID
+	,user_id AS UserID
+	,notification AS Notification
+	,texts AS Texts
+	,htmls AS HTMLs
+	,created AS Created
+	,read_at AS ReadAt
+FROM notification_inbox
+WHERE
+	user_id = $1
+	AND ($2::UUID IS NULL OR id < $2::UUID)
+ORDER BY id DESC
+LIMIT $3
+`
+	var beforeID *string
+	if before != nil {
+		b := before.ToUUID()
+		beforeID = &b
+	}
+	var sis []sqlxInboxItem
+	master := r.sqlDB.Master()
+	if err := master.Select(&sis, sqlQ, userID.ToUUID(), beforeID, limit); err != nil {
+		r.ins.L.Err(err, "cannot list inbox items", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	res := make([]InboxItem, len(sis))
+	for idx := range sis {
+		if err := sis[idx].fromSQLX(&res[idx]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// CountUnread returns the number of unread items of a user.
+func (r *RepoSQLX) CountUnread(userID ids.ID) (int, error) {
+	sqlQ := `
+SELECT
+	COUNT(*)
+FROM notification_inbox
+WHERE
+	user_id = $1
+	AND read_at IS NULL
+`
+	var n int
+	master := r.sqlDB.Master()
+	if err := master.Get(&n, sqlQ, userID.ToUUID()); err != nil {
+		r.ins.L.Err(err, "cannot count unread inbox items", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return 0, err
+	}
+	return n, nil
+}
+
+// MarkRead marks an item of a user as read. An already read
+// item keeps its read time.
+func (r *RepoSQLX) MarkRead(userID ids.ID, id ids.ID, now time.Time) error {
+	sqlQ := `
+UPDATE notification_inbox
+SET
+	read_at = COALESCE(read_at, $3)
+WHERE
+	id = $1
+	AND user_id = $2
+`
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, id.ToUUID(), userID.ToUUID(), now)
+	if err != nil {
+		r.ins.L.Err(err, "cannot mark inbox item as read", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks all the unread items of a user as read.
+func (r *RepoSQLX) MarkAllRead(userID ids.ID, now time.Time) (int64, error) {
+	sqlQ := `
+UPDATE notification_inbox
+SET
+	read_at = $2
+WHERE
+	user_id = $1
+	AND read_at IS NULL
+`
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, userID.ToUUID(), now)
+	if err != nil {
+		r.ins.L.Err(err, "cannot mark inbox items as read", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return 0, err
+	}
+	return res.RowsAffected()
+}
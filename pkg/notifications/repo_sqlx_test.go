@@ -0,0 +1,94 @@
+package notifications_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/notifications"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
+	hfwtest "github.com/dhontecillas/hfw/testing"
+)
+
+func Test_RepoSQLX_Notifications(t *testing.T) {
+	deps := hfwtest.BuildExternalServices()
+	userRepo := users.NewRepoSQLX(deps.Insighter(), deps.SQL, "tokenSalt")
+	id := ids.NewIDGenerator().MustNew()
+	email := "notif_" + id.ToUUID() + "@example.com"
+	if _, err := userRepo.CreateInactiveUser(email, "bar"); err != nil {
+		t.Errorf("cannot create user: %s", err)
+		return
+	}
+	u := userRepo.GetUserByEmail(email)
+	defer func() { _ = userRepo.DeleteUser(email) }()
+
+	r := notifications.NewRepoSQLX(deps.Insighter(), deps.SQL)
+	p := notifications.Preference{
+		Notification: notifications.AllNotifications,
+		Carrier:      notifications.CarrierSMS,
+		Enabled:      true,
+	}
+	if err := r.SetPreference(u.ID, p); err != nil {
+		t.Errorf("cannot set preference: %s", err)
+		return
+	}
+	p.Enabled = false
+	if err := r.SetPreference(u.ID, p); err != nil {
+		t.Errorf("cannot update preference: %s", err)
+		return
+	}
+	prefs, err := r.GetPreferences(u.ID)
+	if err != nil || len(prefs) != 1 || prefs[0] != p {
+		t.Errorf("want the updated preference, got %v (%v)", prefs, err)
+		return
+	}
+	if err := r.DeletePreference(u.ID, p.Notification, p.Carrier); err != nil {
+		t.Errorf("cannot delete preference: %s", err)
+		return
+	}
+	if err := r.DeletePreference(u.ID, p.Notification, p.Carrier); err != notifications.ErrNotFound {
+		t.Errorf("want ErrNotFound, got %v", err)
+		return
+	}
+
+	item := &notifications.InboxItem{
+		ID:           ids.NewIDGenerator().MustNew(),
+		UserID:       u.ID,
+		Notification: "hello",
+		Content: notifications.ContentSet{
+			Texts: map[string]string{"content": "Hello"},
+			HTMLs: map[string]string{},
+		},
+		Created: time.Now().Truncate(time.Second),
+	}
+	if err := r.AddInboxItem(item); err != nil {
+		t.Errorf("cannot add inbox item: %s", err)
+		return
+	}
+	items, err := r.ListInboxItems(u.ID, nil, 10)
+	if err != nil || len(items) != 1 || items[0].Content.Texts["content"] != "Hello" ||
+		items[0].Read != nil {
+		t.Errorf("want the unread item, got %#v (%v)", items, err)
+		return
+	}
+	if items, _ := r.ListInboxItems(u.ID, &item.ID, 10); len(items) != 0 {
+		t.Errorf("want no items before the first one, got %d", len(items))
+		return
+	}
+	if n, err := r.CountUnread(u.ID); err != nil || n != 1 {
+		t.Errorf("want 1 unread item, got %d (%v)", n, err)
+		return
+	}
+	if err := r.MarkRead(u.ID, item.ID, item.Created); err != nil {
+		t.Errorf("cannot mark read: %s", err)
+		return
+	}
+	if n, _ := r.MarkAllRead(u.ID, item.Created); n != 0 {
+		t.Errorf("want no unread items to mark, got %d", n)
+		return
+	}
+	if err := r.MarkRead(id, item.ID, item.Created); err != notifications.ErrNotFound {
+		t.Errorf("want ErrNotFound for another user, got %v", err)
+		return
+	}
+}
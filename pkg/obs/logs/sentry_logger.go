@@ -27,7 +27,7 @@ type SentryConf struct {
 	SampleRate       float64 `json:"sample_rate"`
 	Release          string  `json:"release"`
 	Environment      string  `json:"environment"`
-	FlushTimeoutSecs int     `json:"flush_timeout_secs`
+	FlushTimeoutSecs int     `json:"flush_timeout_secs"`
 	LevelThreshold   string  `json:"level_threshold"` // the mininimum level required to be sent
 	AllowedTags      []string
 }
@@ -64,6 +64,17 @@ func (m *MockMeter) IncWL(key string, labels map[string]interface{}) {
 	m.Incs = append(m.Incs, key)
 }
 
+// Count returns the number of times a key has been increased
+func (m *MockMeter) Count(key string) int {
+	n := 0
+	for _, inc := range m.Incs {
+		if inc == key {
+			n++
+		}
+	}
+	return n
+}
+
 // Dec decreases an integer value
 func (m *MockMeter) Dec(key string) {
 	m.Decs = append(m.Decs, key)
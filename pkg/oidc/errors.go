@@ -0,0 +1,12 @@
+package oidc
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Errors for the OpenID Connect flow
+const (
+	ErrExchangeFailed = consterr.ConstErr("ErrExchangeFailed")
+	ErrInvalidIDToken = consterr.ConstErr("ErrInvalidIDToken")
+	ErrStateMismatch  = consterr.ConstErr("ErrStateMismatch")
+)
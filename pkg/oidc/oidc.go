@@ -0,0 +1,233 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	maxBodySize   = 1 << 20
+)
+
+// ProviderConf contains the configuration to use an
+// OpenID Connect provider.
+type ProviderConf struct {
+	// Name is the identifier of the provider in our app
+	// (used in the routes and stored with the identities).
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientid"`
+	ClientSecret string   `json:"clientsecret"`
+	RedirectURL  string   `json:"redirecturl"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Validate checks the required fields and sets the default scopes.
+func (c *ProviderConf) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("missing oidc provider name")
+	}
+	if c.Issuer == "" {
+		return fmt.Errorf("missing oidc issuer for %s", c.Name)
+	}
+	if c.ClientID == "" {
+		return fmt.Errorf("missing oidc client id for %s", c.Name)
+	}
+	if c.RedirectURL == "" {
+		return fmt.Errorf("missing oidc redirect url for %s", c.Name)
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+	return nil
+}
+
+// Endpoints contains the provider metadata obtained from
+// the discovery document.
+type Endpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is a client for an OpenID Connect provider that
+// implements the authorization code flow with PKCE.
+type Provider struct {
+	conf      ProviderConf
+	endpoints Endpoints
+	client    *http.Client
+
+	keysMtx     sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	now         func() time.Time
+}
+
+// NewProvider fetches the discovery document of the issuer and
+// creates a new Provider. If client is nil, http.DefaultClient is used.
+func NewProvider(ctx context.Context, conf ProviderConf, client *http.Client) (*Provider, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	p := &Provider{
+		conf:   conf,
+		client: client,
+		keys:   map[string]*rsa.PublicKey{},
+		now:    time.Now,
+	}
+	discoveryURL := strings.TrimSuffix(conf.Issuer, "/") + discoveryPath
+	if err := p.getJSON(ctx, discoveryURL, &p.endpoints); err != nil {
+		return nil, fmt.Errorf("cannot fetch oidc discovery for %s: %w", conf.Name, err)
+	}
+	if p.endpoints.Issuer != conf.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: want %s, got %s",
+			conf.Issuer, p.endpoints.Issuer)
+	}
+	if p.endpoints.AuthorizationEndpoint == "" || p.endpoints.TokenEndpoint == "" ||
+		p.endpoints.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete oidc discovery for %s", conf.Name)
+	}
+	return p, nil
+}
+
+// Name returns the name of the provider.
+func (p *Provider) Name() string {
+	return p.conf.Name
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, u)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(target)
+}
+
+// AuthRequest contains the values that must be kept (usually in
+// the session) to complete the flow once the user is redirected
+// back from the provider.
+type AuthRequest struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	URL          string
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewAuthRequest creates the state, nonce and PKCE verifier for
+// a new login, and the URL to redirect the user to.
+func (p *Provider) NewAuthRequest() (*AuthRequest, error) {
+	state, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.conf.ClientID)
+	params.Set("redirect_uri", p.conf.RedirectURL)
+	params.Set("scope", strings.Join(p.conf.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return &AuthRequest{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		URL:          p.endpoints.AuthorizationEndpoint + sep + params.Encode(),
+	}, nil
+}
+
+// Tokens contains the response from the token endpoint.
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Exchange trades an authorization code for the tokens.
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string) (*Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.conf.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.conf.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientID),
+			url.QueryEscape(p.conf.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint status %d", ErrExchangeFailed,
+			resp.StatusCode)
+	}
+	var t Tokens
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&t); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err.Error())
+	}
+	if t.IDToken == "" {
+		return nil, fmt.Errorf("%w: missing id_token", ErrExchangeFailed)
+	}
+	return &t, nil
+}
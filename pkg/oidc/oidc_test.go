@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hfwtest "github.com/dhontecillas/hfw/testing"
+)
+
+// authorize follows the flow until the provider redirects back,
+// returning the code and the state.
+func authorize(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("cannot authorize: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad redirect location: %s", err.Error())
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func Test_Provider_AuthorizationCodeFlow(t *testing.T) {
+	idp := hfwtest.NewFakeIdP("client", "secret")
+	defer idp.Close()
+
+	ctx := context.Background()
+	p, err := NewProvider(ctx, ProviderConf{
+		Name:         "fake",
+		Issuer:       idp.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oidc/fake/callback",
+	}, nil)
+	if err != nil {
+		t.Errorf("cannot create provider: %s", err.Error())
+		return
+	}
+
+	ar, err := p.NewAuthRequest()
+	if err != nil {
+		t.Errorf("cannot create auth request: %s", err.Error())
+		return
+	}
+	code, state := authorize(t, ar.URL)
+	if state != ar.State {
+		t.Errorf("state want %s, got %s", ar.State, state)
+		return
+	}
+
+	if _, err := p.Exchange(ctx, code, "bad verifier"); !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("exchange with a bad verifier should fail, got %v", err)
+		return
+	}
+
+	code, _ = authorize(t, ar.URL)
+	tokens, err := p.Exchange(ctx, code, ar.CodeVerifier)
+	if err != nil {
+		t.Errorf("cannot exchange code: %s", err.Error())
+		return
+	}
+
+	if _, err := p.VerifyIDToken(ctx, tokens.IDToken, "other nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("id token with other nonce should fail, got %v", err)
+		return
+	}
+
+	claims, err := p.VerifyIDToken(ctx, tokens.IDToken, ar.Nonce)
+	if err != nil {
+		t.Errorf("cannot verify id token: %s", err.Error())
+		return
+	}
+	if claims.Subject != idp.Subject || claims.Email != idp.Email || !claims.EmailVerified {
+		t.Errorf("unexpected claims %#v", claims)
+	}
+}
+
+func Test_Provider_RejectsExpiredAndForeignTokens(t *testing.T) {
+	idp := hfwtest.NewFakeIdP("client", "secret")
+	defer idp.Close()
+
+	p, err := NewProvider(context.Background(), ProviderConf{
+		Name:        "fake",
+		Issuer:      idp.Issuer(),
+		ClientID:    "client",
+		RedirectURL: "http://localhost/oidc/fake/callback",
+	}, nil)
+	if err != nil {
+		t.Errorf("cannot create provider: %s", err.Error())
+		return
+	}
+
+	now := time.Now()
+	expired := idp.SignIDToken(map[string]interface{}{
+		"iss":   idp.Issuer(),
+		"sub":   "sub",
+		"aud":   "client",
+		"exp":   now.Add(-time.Hour).Unix(),
+		"nonce": "n",
+	})
+	if _, err := p.VerifyIDToken(context.Background(), expired, "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expired token should fail, got %v", err)
+		return
+	}
+
+	foreign := idp.SignIDToken(map[string]interface{}{
+		"iss":   idp.Issuer(),
+		"sub":   "sub",
+		"aud":   []string{"other client"},
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": "n",
+	})
+	if _, err := p.VerifyIDToken(context.Background(), foreign, "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("token for other audience should fail, got %v", err)
+	}
+}
+
+// countingTransport counts the requests to each path
+type countingTransport struct {
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ct.mu.Lock()
+	ct.paths[req.URL.Path]++
+	ct.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (ct *countingTransport) count(path string) int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.paths[path]
+}
+
+func Test_Provider_KeysRefetchInterval(t *testing.T) {
+	idp := hfwtest.NewFakeIdP("client", "secret")
+	defer idp.Close()
+
+	ct := &countingTransport{paths: map[string]int{}}
+	p, err := NewProvider(context.Background(), ProviderConf{
+		Name:        "fake",
+		Issuer:      idp.Issuer(),
+		ClientID:    "client",
+		RedirectURL: "http://localhost/oidc/fake/callback",
+	}, &http.Client{Transport: ct})
+	if err != nil {
+		t.Errorf("cannot create provider: %s", err.Error())
+		return
+	}
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	valid := idp.SignIDToken(map[string]interface{}{
+		"iss":   idp.Issuer(),
+		"sub":   "sub",
+		"aud":   "client",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": "n",
+	})
+	// same token, but signed with a key the provider does not have
+	parts := strings.Split(valid, ".")
+	unknownKid := base64.RawURLEncoding.EncodeToString(
+		[]byte(`{"alg":"RS256","kid":"unknown"}`)) + "." + parts[1] + "." + parts[2]
+
+	for i := 0; i < 5; i++ {
+		if _, err := p.VerifyIDToken(context.Background(), unknownKid, "n"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("token with unknown kid should fail, got %v", err)
+			return
+		}
+	}
+	if n := ct.count("/jwks"); n != 1 {
+		t.Errorf("want a single jwks fetch, got %d", n)
+		return
+	}
+	// the known keys are still used
+	if _, err := p.VerifyIDToken(context.Background(), valid, "n"); err != nil {
+		t.Errorf("cannot verify valid token: %s", err.Error())
+		return
+	}
+
+	now = now.Add(keysRefetchInterval)
+	if _, err := p.VerifyIDToken(context.Background(), unknownKid, "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("token with unknown kid should fail, got %v", err)
+		return
+	}
+	if n := ct.count("/jwks"); n != 2 {
+		t.Errorf("want a new jwks fetch after the interval, got %d", n)
+	}
+}
@@ -0,0 +1,251 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+	obsattrs "github.com/dhontecillas/hfw/pkg/obs/attrs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+	"github.com/dhontecillas/hfw/pkg/poller"
+)
+
+// Metrics reported by the Dispatcher, with the outbox.carrier attribute
+const (
+	MetOutboxSent    string = "outbox.sent"
+	MetOutboxRetried string = "outbox.retried"
+	MetOutboxFailed  string = "outbox.failed"
+)
+
+// AttrCarrier is the attribute of the metrics with the carrier
+const AttrCarrier string = "outbox.carrier"
+
+// MetricDefinitions returns the definitions of the metrics
+// reported by the Dispatcher, to be registered in the insighter.
+func MetricDefinitions() metrics.MetricDefinitionList {
+	carrierAttrs := obsattrs.AttrDefinitionList{
+		obsattrs.AttrDefinition{
+			Name:        AttrCarrier,
+			StrAttrType: obsattrs.AttrTypeStr,
+		},
+	}
+	defs := metrics.MetricDefinitionList{}
+	for _, name := range []string{MetOutboxSent, MetOutboxRetried, MetOutboxFailed} {
+		defs = append(defs, &metrics.MetricDefinition{
+			Name:       name,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+			Attributes: carrierAttrs,
+		})
+	}
+	return defs
+}
+
+// Default values for the DispatcherConf
+const (
+	DefaultPollInterval = 2 * time.Second
+	DefaultBatchSize    = 50
+	DefaultLease        = time.Minute
+	DefaultMaxAttempts  = 8
+	DefaultBaseBackoff  = 30 * time.Second
+	DefaultMaxBackoff   = time.Hour
+)
+
+// DispatcherConf has the configuration of a Dispatcher. Zero
+// values are replaced with the defaults.
+type DispatcherConf struct {
+	// PollInterval is the time between checks of pending messages
+	PollInterval time.Duration
+	// BatchSize is the max number of messages sent at once
+	BatchSize int
+	// Lease is the time a claimed message is not sent by other
+	// dispatchers, so it must be longer than sending a batch.
+	Lease time.Duration
+	// MaxAttempts is the number of attempts before a message fails
+	MaxAttempts int
+	// BaseBackoff and MaxBackoff are the poller.Backoff of
+	// the retries.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func (c *DispatcherConf) setDefaults() {
+	if c.PollInterval <= 0 {
+		c.PollInterval = DefaultPollInterval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.Lease <= 0 {
+		c.Lease = DefaultLease
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = DefaultBaseBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+}
+
+// Backoff returns the time to wait before retrying a message
+// that has failed the given number of attempts.
+func (c *DispatcherConf) Backoff(attempts int) time.Duration {
+	return poller.Backoff{Base: c.BaseBackoff, Max: c.MaxBackoff}.Delay(attempts)
+}
+
+// Dispatcher sends the committed messages of the outbox with
+// the Sender registered for their carrier. Several dispatchers
+// can run at the same time, because the messages are claimed
+// before sending them. A message is sent at least once: if a
+// dispatcher dies after sending it, it is sent again once the
+// lease expires, so the senders pass the IdempotencyKey to the
+// services that support it.
+type Dispatcher struct {
+	ins  *obs.Insighter
+	repo Repo
+	conf DispatcherConf
+	now  func() time.Time
+
+	mu      sync.Mutex
+	senders map[string]Sender
+
+	loop *poller.Loop
+}
+
+// NewDispatcher creates a Dispatcher.
+func NewDispatcher(ins *obs.Insighter, repo Repo, conf DispatcherConf) *Dispatcher {
+	conf.setDefaults()
+	d := &Dispatcher{
+		ins:     ins,
+		repo:    repo,
+		conf:    conf,
+		now:     time.Now,
+		senders: map[string]Sender{},
+	}
+	// keep sending while there are full batches
+	d.loop = poller.NewLoop(conf.PollInterval, func() bool {
+		return d.RunOnce() == d.conf.BatchSize
+	})
+	return d
+}
+
+// Register sets the sender of a carrier. It must be called
+// before Start.
+func (d *Dispatcher) Register(carrier string, s Sender) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.senders[carrier] = s
+}
+
+func (d *Dispatcher) carriers() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	carriers := make([]string, 0, len(d.senders))
+	for c := range d.senders {
+		carriers = append(carriers, c)
+	}
+	sort.Strings(carriers)
+	return carriers
+}
+
+// Start sends the pending messages in the background, until
+// Close is called.
+func (d *Dispatcher) Start() {
+	d.loop.Start()
+}
+
+// Close stops the dispatcher, waiting for the messages in progress.
+func (d *Dispatcher) Close() {
+	d.loop.Stop()
+}
+
+// RunOnce claims a batch of pending messages and sends them,
+// returning the number of messages claimed.
+func (d *Dispatcher) RunOnce() int {
+	carriers := d.carriers()
+	if len(carriers) == 0 {
+		return 0
+	}
+	msgs, err := d.repo.Claim(carriers, d.now(), d.conf.BatchSize, d.conf.Lease)
+	if err != nil {
+		d.ins.L.Err(err, "cannot claim outbox messages", nil)
+		return 0
+	}
+	for idx := range msgs {
+		d.dispatch(&msgs[idx])
+	}
+	return len(msgs)
+}
+
+// dispatch sends a claimed message, and records its outcome.
+func (d *Dispatcher) dispatch(m *Message) {
+	attrs := map[string]interface{}{
+		"outbox.id":           m.ID.ToUUID(),
+		"outbox.carrier":      m.Carrier,
+		"outbox.notification": m.Notification,
+		"outbox.attempt":      int64(m.Attempts),
+	}
+	metAttrs := map[string]interface{}{AttrCarrier: m.Carrier}
+	span := d.ins.T.Start(context.Background(), "outbox_message", attrs)
+	defer span.End()
+
+	err := d.send(m)
+	if err == nil {
+		d.ins.M.IncWL(MetOutboxSent, metAttrs)
+		if err := d.repo.MarkSent(m.ID, m.Attempts, d.now()); err != nil {
+			span.Err(err)
+			d.recordErr(err, "cannot mark outbox message as sent", attrs)
+		}
+		return
+	}
+
+	span.Err(err)
+	lastErr := poller.SanitizeError(err.Error())
+	var recErr error
+	if m.Attempts < d.conf.MaxAttempts {
+		d.ins.M.IncWL(MetOutboxRetried, metAttrs)
+		recErr = d.repo.Retry(m.ID, m.Attempts,
+			d.now().Add(d.conf.Backoff(m.Attempts)), lastErr)
+	} else {
+		d.ins.M.IncWL(MetOutboxFailed, metAttrs)
+		d.ins.L.Err(err, "outbox message failed", attrs)
+		recErr = d.repo.Fail(m.ID, m.Attempts, lastErr)
+	}
+	if recErr != nil {
+		d.recordErr(recErr, "cannot record outbox message failure", attrs)
+	}
+}
+
+// recordErr logs an error recording the outcome of a message. A
+// lost lease is only a warning: the message was claimed again, and
+// its new attempt records the outcome.
+func (d *Dispatcher) recordErr(err error, msg string, attrs map[string]interface{}) {
+	if errors.Is(err, ErrLeaseLost) {
+		d.ins.L.Warn("outbox message lease lost", attrs)
+		return
+	}
+	d.ins.L.Err(err, msg, attrs)
+}
+
+// send calls the sender of the message carrier, turning its
+// panics into errors.
+func (d *Dispatcher) send(m *Message) (err error) {
+	d.mu.Lock()
+	s, ok := d.senders[m.Carrier]
+	d.mu.Unlock()
+	if !ok {
+		return ErrNoSender
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("outbox sender panicked: %v", r)
+		}
+	}()
+	return s.Send(m)
+}
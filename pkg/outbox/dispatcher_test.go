@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/mailer"
+	"github.com/dhontecillas/hfw/pkg/notifications"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+type dispatcherTest struct {
+	repo       *memRepo
+	outbox     *Outbox
+	dispatcher *Dispatcher
+	meter      *metrics.MockMeter
+	now        time.Time
+}
+
+func newDispatcherTest(conf DispatcherConf) *dispatcherTest {
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+
+	dt := &dispatcherTest{
+		repo:  newMemRepo(),
+		meter: meter,
+		now:   time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+	dt.outbox = NewOutbox(ins, dt.repo)
+	dt.outbox.now = func() time.Time { return dt.now }
+	dt.dispatcher = NewDispatcher(ins, dt.repo, conf)
+	dt.dispatcher.now = func() time.Time { return dt.now }
+	return dt
+}
+
+func (dt *dispatcherTest) add(m *Message) *Message {
+	_ = dt.outbox.Add(nil, m)
+	return m
+}
+
+// senderFunc is a Sender that calls a function
+type senderFunc func(m *Message) error
+
+func (f senderFunc) Send(m *Message) error {
+	return f(m)
+}
+
+func Test_Dispatcher_EmailSender(t *testing.T) {
+	dt := newDispatcherTest(DispatcherConf{})
+	curDir, _ := os.Getwd()
+	composer := notifications.NewFileSystemComposer(
+		filepath.Join(curDir, "../notifications/templates"))
+	mockMailer := mailer.NewMockMailer()
+	dt.dispatcher.Register(CarrierEmail, NewEmailSender(composer, mockMailer))
+
+	m := dt.add(&Message{
+		Carrier:      CarrierEmail,
+		To:           "a@example.com",
+		Notification: "users_requestloginlink",
+		Data: map[string]interface{}{
+			"to_address":  "a@example.com",
+			"token":       "abc",
+			"ttl_minutes": 15,
+		},
+	})
+	// messages without a registered sender are not claimed
+	other := dt.add(&Message{Carrier: "sms", To: "+34600000000"})
+
+	if n := dt.dispatcher.RunOnce(); n != 1 {
+		t.Errorf("want 1 message sent, got %d", n)
+		return
+	}
+	if len(mockMailer.SentMails) != 1 {
+		t.Errorf("want 1 email, got %d", len(mockMailer.SentMails))
+		return
+	}
+	e := mockMailer.SentMails[0]
+	if e.To.Address != "a@example.com" || e.From.Address != "noreply@example.com" ||
+		e.Subject == "" || e.Text == "" || e.HTML == "" {
+		t.Errorf("unexpected email %#v", e)
+		return
+	}
+	if m, _ = dt.outbox.Get(m.ID); m.Status != StatusSent || m.Sent == nil {
+		t.Errorf("want sent message, got %#v", m)
+		return
+	}
+	if other, _ = dt.outbox.Get(other.ID); other.Status != StatusPending ||
+		other.Attempts != 0 {
+		t.Errorf("want message without sender pending, got %#v", other)
+		return
+	}
+	if n := dt.dispatcher.RunOnce(); n != 0 {
+		t.Errorf("want no more messages, got %d", n)
+		return
+	}
+	if dt.meter.Count(MetOutboxSent) != 1 {
+		t.Errorf("unexpected metrics %v", dt.meter.Incs)
+		return
+	}
+}
+
+func Test_Dispatcher_Retries(t *testing.T) {
+	dt := newDispatcherTest(DispatcherConf{BaseBackoff: time.Minute, MaxAttempts: 3})
+	calls := 0
+	dt.dispatcher.Register(CarrierEmail, senderFunc(func(m *Message) error {
+		calls++
+		if calls == 2 {
+			panic("oops")
+		}
+		// the NUL byte cannot be stored in the last error
+		return errors.New("mailer down\x00")
+	}))
+	m := dt.add(&Message{Carrier: CarrierEmail, To: "a@example.com"})
+
+	if n := dt.dispatcher.RunOnce(); n != 1 {
+		t.Errorf("want 1 message claimed, got %d", n)
+		return
+	}
+	// not retried before the backoff
+	dt.now = dt.now.Add(time.Minute - time.Second)
+	if n := dt.dispatcher.RunOnce(); n != 0 {
+		t.Errorf("want no retry before the backoff, got %d", n)
+		return
+	}
+	dt.now = dt.now.Add(time.Second)
+	if n := dt.dispatcher.RunOnce(); n != 1 {
+		t.Errorf("want a retry after the backoff, got %d", n)
+		return
+	}
+	dt.now = dt.now.Add(2 * time.Minute)
+	if n := dt.dispatcher.RunOnce(); n != 1 {
+		t.Errorf("want a retry after the second backoff, got %d", n)
+		return
+	}
+	dt.now = dt.now.Add(time.Hour)
+	if n := dt.dispatcher.RunOnce(); n != 0 {
+		t.Errorf("want no more attempts, got %d", n)
+		return
+	}
+	if m, _ = dt.outbox.Get(m.ID); m.Status != StatusFailed || m.Attempts != 3 ||
+		m.LastError != "mailer down" || calls != 3 {
+		t.Errorf("want failed message after 3 calls, got %d %#v", calls, m)
+		return
+	}
+	if dt.meter.Count(MetOutboxRetried) != 2 || dt.meter.Count(MetOutboxFailed) != 1 {
+		t.Errorf("unexpected metrics %v", dt.meter.Incs)
+		return
+	}
+}
+
+func Test_Dispatcher_StartClose(t *testing.T) {
+	dt := newDispatcherTest(DispatcherConf{PollInterval: 10 * time.Millisecond})
+	sent := make(chan string, 1)
+	dt.dispatcher.Register(CarrierEmail, senderFunc(func(m *Message) error {
+		sent <- m.To
+		return nil
+	}))
+	dt.add(&Message{Carrier: CarrierEmail, To: "a@example.com"})
+
+	dt.dispatcher.Start()
+	select {
+	case to := <-sent:
+		if to != "a@example.com" {
+			t.Errorf("unexpected recipient %s", to)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("message not sent")
+	}
+	dt.dispatcher.Close()
+}
+
+func Test_Dispatcher_LeaseLost(t *testing.T) {
+	dt := newDispatcherTest(DispatcherConf{Lease: time.Minute})
+	dt.dispatcher.Register(CarrierEmail, senderFunc(func(m *Message) error {
+		// the sender is too slow, and another dispatcher claims it
+		_, _ = dt.repo.Claim([]string{CarrierEmail}, dt.now.Add(2*time.Minute), 1,
+			time.Minute)
+		return errors.New("mailer down")
+	}))
+	m := dt.add(&Message{Carrier: CarrierEmail, To: "a@example.com"})
+
+	if n := dt.dispatcher.RunOnce(); n != 1 {
+		t.Errorf("want 1 message claimed, got %d", n)
+		return
+	}
+	got, _ := dt.outbox.Get(m.ID)
+	if got.Status != StatusPending || got.Attempts != 2 || got.LastError != "" ||
+		!got.NextAttempt.Equal(dt.now.Add(3*time.Minute)) {
+		t.Errorf("want the message kept for the new attempt, got %#v", got)
+		return
+	}
+}
@@ -0,0 +1,38 @@
+package outbox
+
+import (
+	"github.com/dhontecillas/hfw/pkg/mailer"
+	"github.com/dhontecillas/hfw/pkg/notifications"
+)
+
+// CarrierEmail is the carrier of the messages sent by an EmailSender
+const CarrierEmail string = notifications.CarrierEmail
+
+// EmailSender sends the messages of the email carrier, rendering
+// their notification with the templates of the email carrier.
+type EmailSender struct {
+	composer notifications.Composer
+	carrier  *notifications.EmailCarrier
+}
+
+var _ Sender = (*EmailSender)(nil)
+
+// NewEmailSender creates an EmailSender.
+func NewEmailSender(composer notifications.Composer,
+	mailSender mailer.Mailer) *EmailSender {
+	return &EmailSender{
+		composer: composer,
+		carrier:  notifications.NewEmailCarrier(mailSender),
+	}
+}
+
+// Send renders the message notification and sends it by email
+// to the message recipient.
+func (s *EmailSender) Send(m *Message) error {
+	content, err := s.composer.Render(m.Notification, m.Data, CarrierEmail)
+	if err != nil {
+		return err
+	}
+	return s.carrier.Deliver(&notifications.Recipient{Email: m.To},
+		m.Notification, content)
+}
@@ -0,0 +1,37 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// Message status values
+const (
+	StatusPending string = "pending"
+	StatusSent    string = "sent"
+	StatusFailed  string = "failed"
+)
+
+// Message is a notification to be sent once the transaction that
+// wrote it is committed.
+type Message struct {
+	ID ids.ID
+	// IdempotencyKey identifies the message: a message with the key
+	// of an existing one is not added. When empty, the ID is used.
+	// The senders that support it pass it to their services.
+	IdempotencyKey string
+	// Carrier is the kind of Sender that delivers the message
+	Carrier string
+	// To is the recipient address for the carrier
+	To           string
+	Notification string
+	Data         map[string]interface{}
+	Status       string
+	Attempts     int
+	// NextAttempt is when the message is sent (again)
+	NextAttempt time.Time
+	Created     time.Time
+	Sent        *time.Time
+	LastError   string
+}
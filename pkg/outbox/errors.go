@@ -0,0 +1,13 @@
+package outbox
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Domain errors for the outbox
+const (
+	ErrNotFound       = consterr.ConstErr("ErrNotFound")
+	ErrInvalidMessage = consterr.ConstErr("ErrInvalidMessage")
+	ErrNoSender       = consterr.ConstErr("ErrNoSender")
+	ErrLeaseLost      = consterr.ConstErr("ErrLeaseLost")
+)
@@ -0,0 +1,25 @@
+BEGIN;
+
+CREATE TABLE outbox_messages(
+    id                  UUID PRIMARY KEY
+    ,idempotency_key    TEXT NOT NULL
+    ,carrier            TEXT NOT NULL
+    ,recipient          TEXT NOT NULL
+    ,notification       TEXT NOT NULL
+    ,data               JSONB NOT NULL
+    ,status             TEXT NOT NULL
+    ,attempts           INTEGER NOT NULL DEFAULT 0
+    ,next_attempt       TIMESTAMP NOT NULL
+    ,created            TIMESTAMP NOT NULL
+    ,sent               TIMESTAMP
+    ,last_error         TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX idx_outbox_messages_idempotency_key
+    ON outbox_messages(idempotency_key);
+CREATE INDEX idx_outbox_messages_pending ON outbox_messages(next_attempt)
+    WHERE status = 'pending';
+CREATE INDEX idx_outbox_messages_sent ON outbox_messages(sent)
+    WHERE status = 'sent';
+
+COMMIT;
@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// Repo defines the storage contract for the outbox. The messages
+// are added in a transaction shared with the domain changes.
+type Repo interface {
+	// Transact runs fn in a transaction, that is committed when fn
+	// returns nil, and rolled back otherwise.
+	Transact(fn func(tx *sqlx.Tx) error) error
+	// Add stores a pending message in a transaction, returning false
+	// if there is already a message with its IdempotencyKey.
+	Add(tx *sqlx.Tx, m *Message) (bool, error)
+	// Claim returns up to limit pending messages of the given
+	// carriers that are due, increasing their attempts, and
+	// postpones them for the lease time, so other dispatchers
+	// do not send them meanwhile.
+	Claim(carriers []string, now time.Time, limit int, lease time.Duration) ([]Message, error)
+	// MarkSent marks a message as sent. The updates of a claimed
+	// message return ErrLeaseLost when its attempt is no longer the
+	// last one (because it was claimed again) or it is not pending.
+	MarkSent(id ids.ID, attempt int, now time.Time) error
+	// Retry records a failed attempt, to send it again at next.
+	Retry(id ids.ID, attempt int, next time.Time, lastErr string) error
+	// Fail records the last failed attempt of a message.
+	Fail(id ids.ID, attempt int, lastErr string) error
+	// GetMessage returns a message, or ErrNotFound.
+	GetMessage(id ids.ID) (*Message, error)
+	// PurgeSent deletes the messages sent before a time, and
+	// returns how many were deleted.
+	PurgeSent(before time.Time) (int64, error)
+}
+
+// Sender delivers the messages of a carrier.
+type Sender interface {
+	Send(m *Message) error
+}
+
+// Outbox is the controller to add messages in the transaction
+// of a domain change.
+type Outbox struct {
+	ins  *obs.Insighter
+	repo Repo
+	now  func() time.Time
+}
+
+// NewOutbox creates a new Outbox controller.
+func NewOutbox(ins *obs.Insighter, repo Repo) *Outbox {
+	return &Outbox{
+		ins:  ins,
+		repo: repo,
+		now:  time.Now,
+	}
+}
+
+// Transact runs fn in a transaction, that is committed when fn
+// returns nil, and rolled back otherwise. The repos that take part
+// in it, and the messages added with Add, are stored atomically.
+func (o *Outbox) Transact(fn func(tx *sqlx.Tx) error) error {
+	return o.repo.Transact(fn)
+}
+
+// Add stores a message in a transaction, to be sent by the
+// Dispatcher once it is committed. A message with the
+// IdempotencyKey of an existing one is ignored.
+func (o *Outbox) Add(tx *sqlx.Tx, m *Message) error {
+	if m.Carrier == "" || m.To == "" {
+		return ErrInvalidMessage
+	}
+	now := o.now()
+	m.ID = ids.NewIDGenerator().MustNew()
+	if m.IdempotencyKey == "" {
+		m.IdempotencyKey = m.ID.ToUUID()
+	}
+	if m.Data == nil {
+		m.Data = map[string]interface{}{}
+	}
+	m.Status = StatusPending
+	m.Attempts = 0
+	m.NextAttempt = now
+	m.Created = now
+
+	added, err := o.repo.Add(tx, m)
+	if err != nil {
+		o.ins.L.Err(err, "cannot add outbox message", map[string]interface{}{
+			"notification": m.Notification,
+			"carrier":      m.Carrier,
+		})
+		return err
+	}
+	if !added {
+		o.ins.L.Info("duplicated outbox message", map[string]interface{}{
+			"idempotency_key": m.IdempotencyKey,
+		})
+	}
+	return nil
+}
+
+// Get returns a message, or ErrNotFound.
+func (o *Outbox) Get(id ids.ID) (*Message, error) {
+	return o.repo.GetMessage(id)
+}
+
+// Purge deletes the messages that were sent before the given age.
+func (o *Outbox) Purge(age time.Duration) (int64, error) {
+	return o.repo.PurgeSent(o.now().Add(-age))
+}
@@ -0,0 +1,240 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// memRepo is an in memory Repo for the tests. The messages
+// added in a transaction are only visible once it succeeds.
+type memRepo struct {
+	mu       sync.Mutex
+	msgs     map[ids.ID]*Message
+	inTx     bool
+	txAdded  []*Message
+	failNext error
+}
+
+var _ Repo = (*memRepo)(nil)
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		msgs: map[ids.ID]*Message{},
+	}
+}
+
+func (m *memRepo) Transact(fn func(tx *sqlx.Tx) error) error {
+	m.mu.Lock()
+	m.inTx = true
+	m.txAdded = nil
+	m.mu.Unlock()
+
+	err := fn(nil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inTx = false
+	if err != nil {
+		return err
+	}
+	for _, msg := range m.txAdded {
+		m.msgs[msg.ID] = msg
+	}
+	return nil
+}
+
+func (m *memRepo) Add(tx *sqlx.Tx, msg *Message) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failNext != nil {
+		err := m.failNext
+		m.failNext = nil
+		return false, err
+	}
+	for _, other := range m.msgs {
+		if other.IdempotencyKey == msg.IdempotencyKey {
+			return false, nil
+		}
+	}
+	for _, other := range m.txAdded {
+		if other.IdempotencyKey == msg.IdempotencyKey {
+			return false, nil
+		}
+	}
+	cm := *msg
+	if m.inTx {
+		m.txAdded = append(m.txAdded, &cm)
+	} else {
+		m.msgs[msg.ID] = &cm
+	}
+	return true, nil
+}
+
+func (m *memRepo) Claim(carriers []string, now time.Time, limit int,
+	lease time.Duration) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	due := []*Message{}
+	for _, msg := range m.msgs {
+		found := false
+		for _, c := range carriers {
+			found = found || c == msg.Carrier
+		}
+		if found && msg.Status == StatusPending && !msg.NextAttempt.After(now) {
+			due = append(due, msg)
+		}
+	}
+	sort.Slice(due, func(a, b int) bool {
+		return due[a].NextAttempt.Before(due[b].NextAttempt)
+	})
+	res := []Message{}
+	for _, msg := range due {
+		if len(res) == limit {
+			break
+		}
+		msg.Attempts++
+		msg.NextAttempt = now.Add(lease)
+		res = append(res, *msg)
+	}
+	return res, nil
+}
+
+// update changes a claimed message, fenced by its attempt
+func (m *memRepo) update(id ids.ID, attempt int, fn func(msg *Message)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.msgs[id]
+	if !ok || msg.Status != StatusPending || msg.Attempts != attempt {
+		return ErrLeaseLost
+	}
+	fn(msg)
+	return nil
+}
+
+func (m *memRepo) MarkSent(id ids.ID, attempt int, now time.Time) error {
+	return m.update(id, attempt, func(msg *Message) {
+		msg.Status = StatusSent
+		msg.Sent = &now
+	})
+}
+
+func (m *memRepo) Retry(id ids.ID, attempt int, next time.Time, lastErr string) error {
+	return m.update(id, attempt, func(msg *Message) {
+		msg.NextAttempt = next
+		msg.LastError = lastErr
+	})
+}
+
+func (m *memRepo) Fail(id ids.ID, attempt int, lastErr string) error {
+	return m.update(id, attempt, func(msg *Message) {
+		msg.Status = StatusFailed
+		msg.LastError = lastErr
+	})
+}
+
+func (m *memRepo) GetMessage(id ids.ID) (*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.msgs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cm := *msg
+	return &cm, nil
+}
+
+func (m *memRepo) PurgeSent(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, msg := range m.msgs {
+		if msg.Status == StatusSent && msg.Sent.Before(before) {
+			delete(m.msgs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func Test_Outbox_Add(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	repo := newMemRepo()
+	ob := NewOutbox(ins, repo)
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	ob.now = func() time.Time { return now }
+
+	if err := ob.Add(nil, &Message{Carrier: CarrierEmail}); err != ErrInvalidMessage {
+		t.Errorf("want ErrInvalidMessage, got %v", err)
+		return
+	}
+
+	msg := &Message{Carrier: CarrierEmail, To: "a@example.com", Notification: "welcome"}
+	err := ob.Transact(func(tx *sqlx.Tx) error {
+		return ob.Add(tx, msg)
+	})
+	if err != nil {
+		t.Errorf("cannot add: %s", err)
+		return
+	}
+	got, err := ob.Get(msg.ID)
+	if err != nil || got.IdempotencyKey != msg.ID.ToUUID() ||
+		got.Status != StatusPending || !got.NextAttempt.Equal(now) || got.Data == nil {
+		t.Errorf("unexpected message %#v (%v)", got, err)
+		return
+	}
+
+	// the messages of a failed transaction are not stored
+	failed := &Message{Carrier: CarrierEmail, To: "b@example.com"}
+	err = ob.Transact(func(tx *sqlx.Tx) error {
+		if err := ob.Add(tx, failed); err != nil {
+			return err
+		}
+		return errors.New("domain change failed")
+	})
+	if err == nil {
+		t.Errorf("want the transaction error")
+		return
+	}
+	if _, err := ob.Get(failed.ID); err != ErrNotFound {
+		t.Errorf("want message of a rolled back transaction not stored, got %v", err)
+		return
+	}
+
+	// a message with an existing idempotency key is ignored
+	dup := &Message{Carrier: CarrierEmail, To: "a@example.com",
+		IdempotencyKey: msg.IdempotencyKey}
+	if err := ob.Add(nil, dup); err != nil {
+		t.Errorf("want duplicated message ignored, got %v", err)
+		return
+	}
+	if _, err := ob.Get(dup.ID); err != ErrNotFound {
+		t.Errorf("want duplicated message not stored, got %v", err)
+		return
+	}
+
+	repo.failNext = errors.New("db down")
+	if err := ob.Add(nil, &Message{Carrier: CarrierEmail, To: "c@example.com"}); err == nil {
+		t.Errorf("want the repo error")
+		return
+	}
+
+	_ = repo.MarkSent(msg.ID, 0, now)
+	if n, _ := ob.Purge(time.Minute); n != 0 {
+		t.Errorf("want no messages purged, got %d", n)
+		return
+	}
+	now = now.Add(time.Hour)
+	if n, _ := ob.Purge(time.Minute); n != 1 {
+		t.Errorf("want 1 message purged, got %d", n)
+		return
+	}
+}
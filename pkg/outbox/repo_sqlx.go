@@ -0,0 +1,310 @@
+package outbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/dhontecillas/hfw/pkg/db"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// RepoSQLX implements the outbox repository with sqlx
+type RepoSQLX struct {
+	sqlDB db.SQLDB
+	ins   *obs.Insighter
+}
+
+var _ Repo = (*RepoSQLX)(nil)
+
+// NewRepoSQLX creates a new RepoSQLX
+func NewRepoSQLX(ins *obs.Insighter, sqlDB db.SQLDB) *RepoSQLX {
+	return &RepoSQLX{
+		sqlDB: sqlDB,
+		ins:   ins,
+	}
+}
+
+type sqlxMessage struct {
+	ID             string
+	IdempotencyKey string
+	Carrier        string
+	Recipient      string
+	Notification   string
+	Data           []byte
+	Status         string
+	Attempts       int
+	NextAttempt    time.Time
+	Created        time.Time
+	Sent           *time.Time
+	LastError      string
+}
+
+func (sm *sqlxMessage) fromSQLX(m *Message) error {
+	if err := m.ID.FromUUID(sm.ID); err != nil {
+		return err
+	}
+	m.Data = map[string]interface{}{}
+	if err := json.Unmarshal(sm.Data, &m.Data); err != nil {
+		return err
+	}
+	m.IdempotencyKey = sm.IdempotencyKey
+	m.Carrier = sm.Carrier
+	m.To = sm.Recipient
+	m.Notification = sm.Notification
+	m.Status = sm.Status
+	m.Attempts = sm.Attempts
+	m.NextAttempt = sm.NextAttempt
+	m.Created = sm.Created
+	m.Sent = sm.Sent
+	m.LastError = sm.LastError
+	return nil
+}
+
+const messageColumns = `
+	id AS ID
+	,idempotency_key AS IdempotencyKey
+	,carrier AS Carrier
+	,recipient AS Recipient
+	,notification AS Notification
+	,data AS Data
+	,status AS Status
+	,attempts AS Attempts
+	,next_attempt AS NextAttempt
+	,created AS Created
+	,sent AS Sent
+	,last_error AS LastError
+`
+
+// Transact runs fn in a transaction, that is committed when fn
+// returns nil, and rolled back otherwise.
+func (r *RepoSQLX) Transact(fn func(tx *sqlx.Tx) error) error {
+	master := r.sqlDB.Master()
+	tx, err := master.Beginx()
+	if err != nil {
+		r.ins.L.Err(err, "cannot begin outbox transaction", nil)
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		r.ins.L.Err(err, "cannot commit outbox transaction", nil)
+		return err
+	}
+	return nil
+}
+
+// Add stores a pending message in a transaction, returning false
+// if there is already a message with its IdempotencyKey.
+func (r *RepoSQLX) Add(tx *sqlx.Tx, m *Message) (bool, error) {
+	sqlQ := `
+INSERT INTO outbox_messages(
+	id
+	,idempotency_key
+	,carrier
+	,recipient
+	,notification
+	,data
+	,status
+	,attempts
+	,next_attempt
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,$6
+	,$7
+	,0
+	,$8
+	,$9
+)
+ON CONFLICT (idempotency_key) DO NOTHING
+`
+	data, err := json.Marshal(m.Data)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(sqlQ, m.ID.ToUUID(), m.IdempotencyKey, m.Carrier, m.To,
+		m.Notification, string(data), StatusPending, m.NextAttempt, m.Created)
+	if err != nil {
+		r.ins.L.Err(err, "cannot add outbox message", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Claim returns up to limit due pending messages of the given
+// carriers, postponing them for the lease time.
+func (r *RepoSQLX) Claim(carriers []string, now time.Time, limit int,
+	lease time.Duration) ([]Message, error) {
+	sqlQ := `
+UPDATE outbox_messages
+SET
+	attempts = attempts + 1
+	,next_attempt = $3
+WHERE id IN (
+	SELECT
+		id
+	FROM outbox_messages
+	WHERE
+		status = $4
+		AND carrier = ANY($5)
+		AND next_attempt <= $1
+	ORDER BY next_attempt
+	LIMIT $2
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + messageColumns
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, now, limit, now.Add(lease), StatusPending,
+		pq.Array(carriers))
+	if err != nil {
+		r.ins.L.Err(err, "cannot claim outbox messages", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Message{}
+	for rows.Next() {
+		var sm sqlxMessage
+		if err := rows.StructScan(&sm); err != nil {
+			return nil, err
+		}
+		var m Message
+		if err := sm.fromSQLX(&m); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+// exec runs an update of a claimed message, returning
+// ErrLeaseLost if it does not change any row.
+func (r *RepoSQLX) exec(sqlQ string, args ...interface{}) error {
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, args...)
+	if err != nil {
+		r.ins.L.Err(err, "cannot update outbox message", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// MarkSent marks a message as sent.
+func (r *RepoSQLX) MarkSent(id ids.ID, attempt int, now time.Time) error {
+	sqlQ := `
+UPDATE outbox_messages
+SET
+	status = $2
+	,sent = $3
+WHERE
+	id = $1
+	AND status = $4
+	AND attempts = $5
+`
+	return r.exec(sqlQ, id.ToUUID(), StatusSent, now, StatusPending, attempt)
+}
+
+// Retry records a failed attempt, to send the message again at next.
+func (r *RepoSQLX) Retry(id ids.ID, attempt int, next time.Time, lastErr string) error {
+	sqlQ := `
+UPDATE outbox_messages
+SET
+	next_attempt = $2
+	,last_error = $3
+WHERE
+	id = $1
+	AND status = $4
+	AND attempts = $5
+`
+	return r.exec(sqlQ, id.ToUUID(), next, lastErr, StatusPending, attempt)
+}
+
+// Fail records the last failed attempt of a message.
+func (r *RepoSQLX) Fail(id ids.ID, attempt int, lastErr string) error {
+	sqlQ := `
+UPDATE outbox_messages
+SET
+	status = $2
+	,last_error = $3
+WHERE
+	id = $1
+	AND status = $4
+	AND attempts = $5
+`
+	return r.exec(sqlQ, id.ToUUID(), StatusFailed, lastErr, StatusPending, attempt)
+}
+
+// GetMessage returns a message, or ErrNotFound.
+func (r *RepoSQLX) GetMessage(id ids.ID) (*Message, error) {
+	sqlQ := `
+SELECT` + messageColumns + `
+FROM outbox_messages
+WHERE
+	id = $1
+`
+	var sm sqlxMessage
+	master := r.sqlDB.Master()
+	if err := master.QueryRowx(sqlQ, id.ToUUID()).StructScan(&sm); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		r.ins.L.Err(err, "cannot get outbox message", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	var m Message
+	if err := sm.fromSQLX(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// PurgeSent deletes the messages sent before a time.
+func (r *RepoSQLX) PurgeSent(before time.Time) (int64, error) {
+	sqlQ := `
+DELETE FROM outbox_messages
+WHERE
+	status = $1
+	AND sent < $2
+`
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, StatusSent, before)
+	if err != nil {
+		r.ins.L.Err(err, "cannot purge outbox messages", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,111 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	hfwtest "github.com/dhontecillas/hfw/testing"
+)
+
+func Test_RepoSQLX_Outbox(t *testing.T) {
+	deps := hfwtest.BuildExternalServices()
+	r := NewRepoSQLX(deps.Insighter(), deps.SQL)
+	now := time.Now().Truncate(time.Second)
+	id := ids.NewIDGenerator().MustNew()
+	carrier := "test_" + id.ToUUID()
+
+	m := &Message{
+		ID:             id,
+		IdempotencyKey: id.ToUUID(),
+		Carrier:        carrier,
+		To:             "a@example.com",
+		Notification:   "welcome",
+		Data:           map[string]interface{}{"a": "b"},
+		NextAttempt:    now,
+		Created:        now,
+	}
+	err := r.Transact(func(tx *sqlx.Tx) error {
+		added, err := r.Add(tx, m)
+		if err == nil && !added {
+			err = errors.New("message not added")
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("cannot add: %s", err)
+		return
+	}
+
+	// a rolled back message is not stored, nor a duplicated one
+	rolledBack := *m
+	rolledBack.ID = ids.NewIDGenerator().MustNew()
+	rolledBack.IdempotencyKey = rolledBack.ID.ToUUID()
+	_ = r.Transact(func(tx *sqlx.Tx) error {
+		if _, err := r.Add(tx, &rolledBack); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	if _, err := r.GetMessage(rolledBack.ID); err != ErrNotFound {
+		t.Errorf("want rolled back message not found, got %v", err)
+		return
+	}
+	dup := *m
+	dup.ID = ids.NewIDGenerator().MustNew()
+	_ = r.Transact(func(tx *sqlx.Tx) error {
+		added, err := r.Add(tx, &dup)
+		if err == nil && added {
+			t.Errorf("want duplicated message not added")
+		}
+		return err
+	})
+
+	claimed, err := r.Claim([]string{carrier}, now, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].Attempts != 1 ||
+		claimed[0].Data["a"] != "b" {
+		t.Errorf("want 1 claimed message, got %#v (%v)", claimed, err)
+		return
+	}
+	if again, _ := r.Claim([]string{carrier}, now, 10, time.Minute); len(again) != 0 {
+		t.Errorf("want no messages claimed during the lease, got %d", len(again))
+		return
+	}
+	if err := r.Retry(m.ID, 1, now, "boom"); err != nil {
+		t.Errorf("cannot retry: %s", err)
+		return
+	}
+	claimed, _ = r.Claim([]string{carrier}, now, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].Attempts != 2 || claimed[0].LastError != "boom" {
+		t.Errorf("want the retried message claimed, got %#v", claimed)
+		return
+	}
+	// the first attempt cannot change the message claimed again
+	if err := r.MarkSent(m.ID, 1, now); err != ErrLeaseLost {
+		t.Errorf("want ErrLeaseLost, got %v", err)
+		return
+	}
+	if err := r.MarkSent(m.ID, 2, now); err != nil {
+		t.Errorf("cannot mark sent: %s", err)
+		return
+	}
+	if got, err := r.GetMessage(m.ID); err != nil || got.Status != StatusSent {
+		t.Errorf("want sent message, got %#v (%v)", got, err)
+		return
+	}
+	if _, err := r.PurgeSent(now.Add(time.Second)); err != nil {
+		t.Errorf("cannot purge: %s", err)
+		return
+	}
+	if _, err := r.GetMessage(m.ID); err != ErrNotFound {
+		t.Errorf("want purged message, got %v", err)
+		return
+	}
+	if err := r.Fail(m.ID, 2, "boom"); err != ErrLeaseLost {
+		t.Errorf("want ErrLeaseLost, got %v", err)
+		return
+	}
+}
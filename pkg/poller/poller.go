@@ -0,0 +1,113 @@
+// Package poller contains the polling loop and the retry backoff
+// shared by the background workers that claim their work from
+// the database (like the jobs, the outbox and the webhooks).
+package poller
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Backoff is an exponential backoff: the time before the first
+// retry is Base, and it is doubled for each following one, up
+// to Max.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Delay returns the time to wait before retrying after the
+// given number of failed attempts.
+func (b Backoff) Delay(attempts int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= b.Max {
+			return b.Max
+		}
+	}
+	return d
+}
+
+// MaxErrorLen is the max length, in bytes, of the errors
+// returned by SanitizeError.
+const MaxErrorLen = 2048
+
+// SanitizeError makes an error message valid for a TEXT column,
+// that does not accept NUL bytes or invalid UTF-8, and truncates
+// it to MaxErrorLen: otherwise the failure could not be recorded,
+// and the work would be claimed again forever.
+func SanitizeError(msg string) string {
+	msg = strings.ToValidUTF8(strings.ReplaceAll(msg, "\x00", ""), "\uFFFD")
+	if len(msg) <= MaxErrorLen {
+		return msg
+	}
+	end := MaxErrorLen
+	for end > 0 && !utf8.RuneStart(msg[end]) {
+		end--
+	}
+	return msg[:end]
+}
+
+// Loop calls a function every interval in the background, and
+// again at once while the function reports that there is more
+// work to do.
+type Loop struct {
+	interval time.Duration
+	run      func() bool
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewLoop creates a Loop that calls run every interval. The run
+// function returns true when it should be called again without
+// waiting (like when it has claimed a full batch).
+func NewLoop(interval time.Duration, run func() bool) *Loop {
+	return &Loop{
+		interval: interval,
+		run:      run,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the loop in the background, until Stop is called.
+func (l *Loop) Start() {
+	l.startOnce.Do(func() {
+		l.wg.Add(1)
+		go l.loop()
+	})
+}
+
+func (l *Loop) loop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		for l.run() {
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Stop stops the loop, and waits for the current call of
+// the run function to return. It can be called several times.
+func (l *Loop) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.done)
+	})
+	l.wg.Wait()
+}
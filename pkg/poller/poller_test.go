@@ -0,0 +1,61 @@
+package poller
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func Test_Backoff(t *testing.T) {
+	b := Backoff{Base: time.Second, Max: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second,
+		5 * time.Second, 5 * time.Second}
+	for idx, w := range want {
+		if got := b.Delay(idx + 1); got != w {
+			t.Errorf("attempt %d: want %s, got %s", idx+1, w, got)
+			return
+		}
+	}
+}
+
+func Test_SanitizeError(t *testing.T) {
+	if got := SanitizeError("a\x00b\xffc"); got != "ab\uFFFDc" || !utf8.ValidString(got) {
+		t.Errorf("unexpected sanitized error %q", got)
+		return
+	}
+	long := "a" + strings.Repeat("ñ", MaxErrorLen)
+	if got := SanitizeError(long); len(got) != MaxErrorLen-1 ||
+		!utf8.ValidString(got) || !strings.HasPrefix(long, got) {
+		t.Errorf("want the error truncated, got %d bytes", len(got))
+		return
+	}
+}
+
+func Test_Loop(t *testing.T) {
+	var calls int32
+	called := make(chan struct{}, 10)
+	l := NewLoop(10*time.Millisecond, func() bool {
+		called <- struct{}{}
+		// the first calls have more work to do
+		return atomic.AddInt32(&calls, 1) < 3
+	})
+	l.Start()
+	for i := 0; i < 4; i++ {
+		select {
+		case <-called:
+		case <-time.After(time.Second):
+			t.Errorf("want the loop called %d times", i+1)
+			l.Stop()
+			return
+		}
+	}
+	l.Stop()
+	l.Stop()
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(30 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != n {
+		t.Errorf("want no calls after Stop, got %d more", got-n)
+	}
+}
@@ -0,0 +1,126 @@
+// Package tmplfuncs contains the helper functions available to
+// both the web html templates and the notification templates.
+package tmplfuncs
+
+import (
+	"fmt"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/i18n"
+)
+
+// FuncMap returns a new map with the helper functions, that can
+// be used with `text/template` and `html/template`:
+//
+//   - `trans`: translates a message key to a language (or its
+//     fallbacks), or to the default language of the catalog when
+//     there is no language: `{{ trans .lang "welcome" }}`.
+//   - `translate`: translates a message key to a language (or its
+//     fallbacks), with `fmt` args: `{{ translate .lang "hello" .name }}`.
+//   - `plural`: translates a message key with the plural form of a
+//     number: `{{ plural .lang "unread" .count }}`.
+//   - `date`: formats a `time.Time` (or `*time.Time`) with a Go
+//     time layout: `{{ date "2006-01-02" .created }}`.
+//   - `url`: builds a url from its scheme, host, path and a list
+//     of query key value pairs, escaping them:
+//     `{{ url .scheme .host .path "token" .token }}`.
+//
+// Without a catalog, the translation functions return the key.
+func FuncMap(catalog *i18n.Catalog) template.FuncMap {
+	tr := translator{catalog: catalog}
+	return template.FuncMap{
+		"trans":     tr.trans,
+		"translate": tr.translate,
+		"plural":    tr.plural,
+		"date":      Date,
+		"url":       URL,
+	}
+}
+
+// translator translates with an optional catalog
+type translator struct {
+	catalog *i18n.Catalog
+}
+
+// trans translates a message key, with an optional language
+// before it.
+func (tr translator) trans(args ...interface{}) string {
+	var lang interface{}
+	switch len(args) {
+	case 1:
+	case 2:
+		lang = args[0]
+	default:
+		return "NO STRING"
+	}
+	s, ok := args[len(args)-1].(string)
+	if !ok {
+		return "NO STRING"
+	}
+	if tr.catalog == nil {
+		return s
+	}
+	return tr.catalog.Translate(tr.lang(lang), s)
+}
+
+// lang returns the language from a template value, that
+// can be missing in the template data.
+func (tr translator) lang(l interface{}) string {
+	if s, ok := l.(string); ok && s != "" {
+		return s
+	}
+	return tr.catalog.DefaultLang()
+}
+
+func (tr translator) translate(lang interface{}, key string, args ...interface{}) string {
+	if tr.catalog == nil {
+		return key
+	}
+	return tr.catalog.Translate(tr.lang(lang), key, args...)
+}
+
+func (tr translator) plural(lang interface{}, key string, n interface{},
+	args ...interface{}) string {
+	if tr.catalog == nil {
+		return key
+	}
+	return tr.catalog.TranslatePlural(tr.lang(lang), key, n, args...)
+}
+
+// Date formats a time with the given layout. A nil time
+// is formatted as an empty string.
+func Date(layout string, t interface{}) (string, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		return v.Format(layout), nil
+	}
+	return "", fmt.Errorf("date: cannot format %T", t)
+}
+
+// URL builds an url, with the query parameters provided
+// as key value pairs.
+func URL(scheme string, host string, path string, query ...interface{}) (string, error) {
+	if len(query)%2 != 0 {
+		return "", fmt.Errorf("url: odd number of query key value params")
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   host,
+		Path:   path,
+	}
+	if len(query) > 0 {
+		q := url.Values{}
+		for idx := 0; idx < len(query); idx += 2 {
+			q.Add(fmt.Sprint(query[idx]), fmt.Sprint(query[idx+1]))
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
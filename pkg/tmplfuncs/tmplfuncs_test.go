@@ -0,0 +1,85 @@
+package tmplfuncs
+
+import (
+	"bytes"
+	html_template "html/template"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/i18n"
+)
+
+func Test_FuncMap(t *testing.T) {
+	created := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	data := map[string]interface{}{
+		"created": created,
+		"scheme":  "https",
+		"host":    "example.com",
+		"path":    "/users/activate",
+		"token":   "a b&c",
+	}
+	src := `{{ trans "Hi" }} {{ date "2006-01-02" .created }} {{ url .scheme .host .path "token" .token }}`
+	want := "Hi 2024-03-01 https://example.com/users/activate?token=a+b%26c"
+
+	tt, err := template.New("t").Funcs(FuncMap(nil)).Parse(src)
+	if err != nil {
+		t.Errorf("cannot parse text template: %s", err)
+		return
+	}
+	var b bytes.Buffer
+	if err := tt.Execute(&b, data); err != nil || b.String() != want {
+		t.Errorf("want %q, got %q (%v)", want, b.String(), err)
+		return
+	}
+
+	ht, err := html_template.New("t").Funcs(FuncMap(nil)).Parse(
+		`<a href="{{ url .scheme .host .path "token" .token }}">{{ date "Jan 2" .created }}</a>`)
+	if err != nil {
+		t.Errorf("cannot parse html template: %s", err)
+		return
+	}
+	b.Reset()
+	want = `<a href="https://example.com/users/activate?token=a&#43;b%26c">Mar 1</a>`
+	if err := ht.Execute(&b, data); err != nil || b.String() != want {
+		t.Errorf("want %q, got %q (%v)", want, b.String(), err)
+		return
+	}
+
+	if _, err := URL("https", "example.com", "/", "odd"); err == nil {
+		t.Errorf("want an error for an odd number of query params")
+		return
+	}
+	if s, err := Date("2006", (*time.Time)(nil)); err != nil || s != "" {
+		t.Errorf("want an empty nil date, got %q (%v)", s, err)
+		return
+	}
+}
+
+func Test_FuncMap_Catalog(t *testing.T) {
+	catalog := i18n.NewCatalog("en")
+	_ = catalog.Add("en", map[string]i18n.Message{
+		"hello":  {i18n.PluralOther: "Hello %s"},
+		"unread": {i18n.PluralOne: "%d unread", i18n.PluralOther: "%d unread items"},
+	})
+	_ = catalog.Add("es", map[string]i18n.Message{
+		"hello": {i18n.PluralOther: "Hola %s"},
+	})
+	src := `{{ trans "hello" }}|{{ trans .lang "hello" }}|{{ translate .lang "hello" .name }}|{{ plural .lang "unread" .count }}`
+	tt, err := template.New("t").Funcs(FuncMap(catalog)).Parse(src)
+	if err != nil {
+		t.Errorf("cannot parse: %s", err)
+		return
+	}
+	cases := map[string]map[string]interface{}{
+		"Hello %s|Hola %s|Hola Ana|1 unread":         {"lang": "es-AR", "name": "Ana", "count": 1},
+		"Hello %s|Hello %s|Hello Bob|2 unread items": {"name": "Bob", "count": 2},
+	}
+	for want, data := range cases {
+		var b bytes.Buffer
+		if err := tt.Execute(&b, data); err != nil || b.String() != want {
+			t.Errorf("want %q, got %q (%v)", want, b.String(), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package tokenapi
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// Metrics for the keys cache
+const (
+	MetKeyCacheHit  string = "tokenapi.keycache.hit"
+	MetKeyCacheMiss string = "tokenapi.keycache.miss"
+)
+
+// Default values for the CacheConf
+const (
+	DefaultCacheSize        = 10000
+	DefaultCacheTTL         = time.Minute
+	DefaultCacheNegativeTTL = 5 * time.Second
+)
+
+// CacheMetricDefinitions returns the definitions of the metrics
+// reported by the CachedRepo, to be registered in the insighter.
+func CacheMetricDefinitions() metrics.MetricDefinitionList {
+	return metrics.MetricDefinitionList{
+		&metrics.MetricDefinition{
+			Name:       MetKeyCacheHit,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+		},
+		&metrics.MetricDefinition{
+			Name:       MetKeyCacheMiss,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+		},
+	}
+}
+
+// CacheConf has the configuration for a CachedRepo. Zero
+// values are replaced with the defaults.
+type CacheConf struct {
+	// Size is the max number of cached keys
+	Size int
+	// TTL is the time a found key is cached
+	TTL time.Duration
+	// NegativeTTL is the time a not found key is cached
+	NegativeTTL time.Duration
+}
+
+// Invalidator notifies the evicted keys between instances.
+type Invalidator interface {
+	// Publish notifies the other instances that a key
+	// must be evicted.
+	Publish(key ids.ID) error
+	// Listen calls evict for each key evicted by other instances
+	// and reset when some notifications might have been lost,
+	// until it is closed.
+	Listen(evict func(key ids.ID), reset func())
+	// Close stops listening.
+	Close() error
+}
+
+type cacheEntry struct {
+	key     ids.ID
+	apiKey  *APIKey // nil for a not found key
+	expires time.Time
+}
+
+// CachedRepo is a Repo decorator that keeps the looked up keys
+// in an LRU cache with a TTL. Deleted and rotated keys are evicted,
+// also in other instances when an Invalidator is provided.
+type CachedRepo struct {
+	Repo
+	ins  *obs.Insighter
+	conf CacheConf
+	inv  Invalidator
+	now  func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[ids.ID]*list.Element
+	loads   map[ids.ID]*cacheLoad
+}
+
+// cacheLoad tracks the lookups of a key in flight, with the
+// generation of the key, that is increased when it is evicted
+// during a lookup, so the loaded key is not cached.
+type cacheLoad struct {
+	gen      uint64
+	inflight int
+}
+
+var _ Repo = (*CachedRepo)(nil)
+
+// NewCachedRepo creates a new CachedRepo. The invalidator is
+// optional, and it is closed when closing the CachedRepo.
+func NewCachedRepo(ins *obs.Insighter, repo Repo, conf CacheConf,
+	inv Invalidator) *CachedRepo {
+
+	if conf.Size <= 0 {
+		conf.Size = DefaultCacheSize
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = DefaultCacheTTL
+	}
+	if conf.NegativeTTL <= 0 {
+		conf.NegativeTTL = DefaultCacheNegativeTTL
+	}
+	c := &CachedRepo{
+		Repo:    repo,
+		ins:     ins,
+		conf:    conf,
+		inv:     inv,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[ids.ID]*list.Element),
+		loads:   make(map[ids.ID]*cacheLoad),
+	}
+	if inv != nil {
+		inv.Listen(c.evict, c.Purge)
+	}
+	return c
+}
+
+// GetKey retrieves an api key from the cache, or from the
+// underlying repo if it is not cached.
+func (c *CachedRepo) GetKey(key ids.ID) (*APIKey, error) {
+	if e, ok := c.get(key); ok {
+		c.ins.M.Inc(MetKeyCacheHit)
+		if e.apiKey == nil {
+			return nil, ErrNotFound
+		}
+		k := *e.apiKey
+		return &k, nil
+	}
+	c.ins.M.Inc(MetKeyCacheMiss)
+	gen := c.startLoad(key)
+	k, err := c.Repo.GetKey(key)
+	if err == ErrNotFound {
+		c.endLoad(key, gen, nil, c.conf.NegativeTTL)
+		return nil, err
+	}
+	if err != nil {
+		c.endLoad(key, gen, nil, 0)
+		return nil, err
+	}
+	cached := *k
+	c.endLoad(key, gen, &cached, c.conf.TTL)
+	return k, nil
+}
+
+// CreateKey stores a new api key.
+func (c *CachedRepo) CreateKey(k *APIKey) error {
+	// in case it was cached as not found
+	c.evict(k.Key)
+	return c.Repo.CreateKey(k)
+}
+
+// DeleteKey deletes an api key, and evicts it.
+func (c *CachedRepo) DeleteKey(key ids.ID) error {
+	err := c.Repo.DeleteKey(key)
+	c.evictAll(key)
+	return err
+}
+
+// DeleteUserKey deletes an api key of a user, and evicts it.
+func (c *CachedRepo) DeleteUserKey(userID ids.ID, key ids.ID) error {
+	err := c.Repo.DeleteUserKey(userID, key)
+	c.evictAll(key)
+	return err
+}
+
+// RotateKey stores the successor of a key, and evicts the
+// rotated one (because its deleted time changes).
+func (c *CachedRepo) RotateKey(userID ids.ID, key ids.ID, successor *APIKey,
+	deleted time.Time) error {
+	c.evict(successor.Key)
+	err := c.Repo.RotateKey(userID, key, successor, deleted)
+	c.evictAll(key)
+	return err
+}
+
+// Purge removes all the cached keys.
+func (c *CachedRepo) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[ids.ID]*list.Element)
+	for _, l := range c.loads {
+		l.gen++
+	}
+}
+
+// Close stops listening for evictions from other instances.
+func (c *CachedRepo) Close() error {
+	if c.inv == nil {
+		return nil
+	}
+	return c.inv.Close()
+}
+
+// Len returns the number of cached keys.
+func (c *CachedRepo) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *CachedRepo) get(key ids.ID) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// startLoad records a lookup of a key in the repo, returning
+// the generation of the key.
+func (c *CachedRepo) startLoad(key ids.ID) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.loads[key]
+	if !ok {
+		l = &cacheLoad{}
+		c.loads[key] = l
+	}
+	l.inflight++
+	return l.gen
+}
+
+// endLoad caches the result of a lookup (unless ttl is zero), only
+// when the key has not been evicted since the lookup started.
+func (c *CachedRepo) endLoad(key ids.ID, gen uint64, apiKey *APIKey,
+	ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.loads[key]
+	if l.inflight--; l.inflight == 0 {
+		delete(c.loads, key)
+	}
+	if ttl > 0 && l.gen == gen {
+		c.set(key, apiKey, ttl)
+	}
+}
+
+// set caches a key. It must be called with the lock held.
+func (c *CachedRepo) set(key ids.ID, apiKey *APIKey, ttl time.Duration) {
+	e := &cacheEntry{key: key, apiKey: apiKey, expires: c.now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.conf.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *CachedRepo) evict(key ids.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l, ok := c.loads[key]; ok {
+		l.gen++
+	}
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// evictAll evicts a key in this and the other instances.
+func (c *CachedRepo) evictAll(key ids.ID) {
+	c.evict(key)
+	if c.inv == nil {
+		return
+	}
+	if err := c.inv.Publish(key); err != nil {
+		c.ins.L.Err(err, "cannot publish key eviction", map[string]interface{}{
+			"key": key.ToUUID(),
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package tokenapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// DefaultInvalidationChannel is the redis channel used to
+// notify the evicted keys.
+const DefaultInvalidationChannel string = "hfw:tokenapi:evict"
+
+// redisReconnectDelay is the time to wait before subscribing
+// again when the connection is lost.
+const redisReconnectDelay = time.Second
+
+// RedisInvalidator notifies the evicted keys between instances
+// using redis pub/sub.
+type RedisInvalidator struct {
+	ins     *obs.Insighter
+	pool    *redis.Pool
+	channel string
+
+	mu     sync.Mutex
+	psc    *redis.PubSubConn
+	closed bool
+	wg     sync.WaitGroup
+}
+
+var _ Invalidator = (*RedisInvalidator)(nil)
+
+// NewRedisInvalidator creates a RedisInvalidator. If channel
+// is empty, the DefaultInvalidationChannel is used.
+func NewRedisInvalidator(ins *obs.Insighter, pool *redis.Pool,
+	channel string) *RedisInvalidator {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+	return &RedisInvalidator{
+		ins:     ins,
+		pool:    pool,
+		channel: channel,
+	}
+}
+
+// Publish notifies the other instances that a key must be evicted.
+func (r *RedisInvalidator) Publish(key ids.ID) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PUBLISH", r.channel, key.ToUUID())
+	return err
+}
+
+// Listen subscribes to the evictions channel in the background.
+// Each time it (re)subscribes, reset is called, because the
+// notifications sent while disconnected are lost.
+func (r *RedisInvalidator) Listen(evict func(key ids.ID), reset func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			if !r.subscribe() {
+				return
+			}
+			reset()
+			r.receive(evict)
+			if r.isClosed() {
+				return
+			}
+			time.Sleep(redisReconnectDelay)
+		}
+	}()
+}
+
+func (r *RedisInvalidator) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// subscribe returns false if the invalidator has been closed.
+func (r *RedisInvalidator) subscribe() bool {
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return false
+		}
+		psc := &redis.PubSubConn{Conn: r.pool.Get()}
+		err := psc.Subscribe(r.channel)
+		if err == nil {
+			r.psc = psc
+			r.mu.Unlock()
+			return true
+		}
+		r.mu.Unlock()
+		_ = psc.Close()
+		r.ins.L.Warn("cannot subscribe to key evictions", map[string]interface{}{
+			"channel": r.channel,
+			"error":   err.Error(),
+		})
+		time.Sleep(redisReconnectDelay)
+	}
+}
+
+func (r *RedisInvalidator) receive(evict func(key ids.ID)) {
+	r.mu.Lock()
+	psc := r.psc
+	r.mu.Unlock()
+	defer psc.Close()
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			var key ids.ID
+			if err := key.FromUUID(string(v.Data)); err != nil {
+				r.ins.L.Warn("bad key eviction message", map[string]interface{}{
+					"data": string(v.Data),
+				})
+				continue
+			}
+			evict(key)
+		case error:
+			if !r.isClosed() {
+				r.ins.L.Err(v, "key evictions subscription lost", nil)
+			}
+			return
+		}
+	}
+}
+
+// Close stops listening for evictions.
+func (r *RedisInvalidator) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	if r.psc != nil {
+		_ = r.psc.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return nil
+}
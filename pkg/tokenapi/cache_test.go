@@ -0,0 +1,183 @@
+package tokenapi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// countingRepo counts the lookups to the underlying repo, and
+// calls onGet (when set) after each lookup.
+type countingRepo struct {
+	*memRepo
+	gets  int
+	onGet func()
+}
+
+func (r *countingRepo) GetKey(key ids.ID) (*APIKey, error) {
+	r.gets++
+	k, err := r.memRepo.GetKey(key)
+	if r.onGet != nil {
+		r.onGet()
+	}
+	return k, err
+}
+
+// memBus connects the invalidators of several instances
+type memBus struct {
+	mu        sync.Mutex
+	listeners []func(ids.ID)
+}
+
+type memInvalidator struct {
+	bus *memBus
+}
+
+func (m *memInvalidator) Publish(key ids.ID) error {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+	for _, fn := range m.bus.listeners {
+		fn(key)
+	}
+	return nil
+}
+
+func (m *memInvalidator) Listen(evict func(key ids.ID), reset func()) {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+	m.bus.listeners = append(m.bus.listeners, evict)
+}
+
+func (m *memInvalidator) Close() error {
+	return nil
+}
+
+func newCacheTestInsighter() (*obs.Insighter, *metrics.MockMeter) {
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+	return ins, meter
+}
+
+func Test_CachedRepo(t *testing.T) {
+	ins, meter := newCacheTestInsighter()
+	repo := &countingRepo{memRepo: newMemRepo()}
+	cache := NewCachedRepo(ins, repo, CacheConf{Size: 2, TTL: time.Minute}, nil)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	userID := ids.NewIDGenerator().MustNew()
+	k := &APIKey{Key: ids.NewIDGenerator().MustNew(), UserID: userID}
+	_ = cache.CreateKey(k)
+
+	for i := 0; i < 3; i++ {
+		if got, err := cache.GetKey(k.Key); err != nil || got.Key != k.Key {
+			t.Errorf("cannot get key: %v", err)
+			return
+		}
+	}
+	if repo.gets != 1 || meter.Count(MetKeyCacheHit) != 2 ||
+		meter.Count(MetKeyCacheMiss) != 1 {
+		t.Errorf("want 1 lookup, 2 hits and 1 miss, got %d %v", repo.gets, meter.Incs)
+		return
+	}
+
+	// not found keys are cached for a shorter time
+	missing := ids.NewIDGenerator().MustNew()
+	_, _ = cache.GetKey(missing)
+	if _, err := cache.GetKey(missing); err != ErrNotFound || repo.gets != 2 {
+		t.Errorf("want cached ErrNotFound, got %v with %d lookups", err, repo.gets)
+		return
+	}
+	now = now.Add(DefaultCacheNegativeTTL)
+	_, _ = cache.GetKey(missing)
+	if repo.gets != 3 {
+		t.Errorf("negative entry should have expired, got %d lookups", repo.gets)
+		return
+	}
+
+	// the least recently used key is evicted
+	other := &APIKey{Key: ids.NewIDGenerator().MustNew(), UserID: userID}
+	_ = cache.CreateKey(other)
+	_, _ = cache.GetKey(other.Key)
+	if cache.Len() != 2 {
+		t.Errorf("want 2 cached keys, got %d", cache.Len())
+		return
+	}
+	gets := repo.gets
+	_, _ = cache.GetKey(k.Key)
+	if repo.gets != gets+1 {
+		t.Errorf("the oldest key should have been evicted")
+		return
+	}
+
+	// found keys expire after the TTL
+	now = now.Add(time.Minute)
+	gets = repo.gets
+	_, _ = cache.GetKey(k.Key)
+	if repo.gets != gets+1 {
+		t.Errorf("the key should have expired")
+		return
+	}
+}
+
+func Test_CachedRepo_EvictsDeletedKeys(t *testing.T) {
+	ins, _ := newCacheTestInsighter()
+	bus := &memBus{}
+	repo := newMemRepo()
+	// two instances sharing the same database
+	first := NewCachedRepo(ins, repo, CacheConf{}, &memInvalidator{bus: bus})
+	second := NewCachedRepo(ins, repo, CacheConf{}, &memInvalidator{bus: bus})
+
+	userID := ids.NewIDGenerator().MustNew()
+	k := &APIKey{Key: ids.NewIDGenerator().MustNew(), UserID: userID}
+	_ = first.CreateKey(k)
+	_, _ = first.GetKey(k.Key)
+	_, _ = second.GetKey(k.Key)
+
+	if err := first.DeleteUserKey(userID, k.Key); err != nil {
+		t.Errorf("cannot delete key: %s", err.Error())
+		return
+	}
+	if _, err := first.GetKey(k.Key); err != ErrNotFound {
+		t.Errorf("want ErrNotFound in first instance, got %v", err)
+		return
+	}
+	if _, err := second.GetKey(k.Key); err != ErrNotFound {
+		t.Errorf("want ErrNotFound in second instance, got %v", err)
+		return
+	}
+}
+
+func Test_CachedRepo_DeleteDuringLookup(t *testing.T) {
+	ins, _ := newCacheTestInsighter()
+	repo := &countingRepo{memRepo: newMemRepo()}
+	cache := NewCachedRepo(ins, repo, CacheConf{}, nil)
+
+	userID := ids.NewIDGenerator().MustNew()
+	k := &APIKey{Key: ids.NewIDGenerator().MustNew(), UserID: userID}
+	_ = cache.CreateKey(k)
+
+	// the key is deleted after the lookup has read it
+	repo.onGet = func() {
+		repo.onGet = nil
+		_ = cache.DeleteUserKey(userID, k.Key)
+	}
+	if _, err := cache.GetKey(k.Key); err != nil {
+		t.Errorf("want the key read before the delete, got %v", err)
+		return
+	}
+	if cache.Len() != 0 {
+		t.Errorf("want the deleted key not cached, got %d keys", cache.Len())
+		return
+	}
+	if _, err := cache.GetKey(k.Key); err != ErrNotFound {
+		t.Errorf("want ErrNotFound, got %v", err)
+		return
+	}
+}
@@ -7,11 +7,68 @@ import (
 )
 
 // APIKey contains the data regarding an API token key.
+//
+// The Key is the public part of the token, that can be used
+// to identify it, and only the SecretHash of the secret part is
+// stored. The full Token is only available when the key is created.
+// LastUsed and Requests are updated by the UsageTracker, so they
+// can be a bit behind. A rotated key has its successor in RotatedTo,
+// and a Deleted time in the future (until then it can still be used).
 type APIKey struct {
 	Key         ids.ID
 	UserID      ids.ID
 	Created     time.Time
 	Deleted     *time.Time
 	LastUsed    *time.Time
+	Requests    int64
 	Description string
+	SecretHash  string
+	Scopes      []string
+	Expires     *time.Time
+	RotatedFrom *ids.ID
+	RotatedTo   *ids.ID
+
+	Token string
+}
+
+// IsDeleted tells if the key has been deleted at a given time.
+func (k *APIKey) IsDeleted(now time.Time) bool {
+	return k.Deleted != nil && !now.Before(*k.Deleted)
+}
+
+// IsExpired tells if the key cannot be used anymore at a given time.
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.Expires != nil && !now.Before(*k.Expires)
+}
+
+// HasScope tells if the key has been granted a scope. The
+// AllScopes scope grants any other one.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == AllScopes {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyState is the state of the keys to list.
+type KeyState string
+
+// Key states to filter the listed keys. The keys in their rotation
+// grace period are active.
+const (
+	KeyStateAll     KeyState = ""
+	KeyStateActive  KeyState = "active"
+	KeyStateDeleted KeyState = "deleted"
+	KeyStateExpired KeyState = "expired"
+)
+
+// Valid tells if the state is a known one.
+func (s KeyState) Valid() bool {
+	switch s {
+	case KeyStateAll, KeyStateActive, KeyStateDeleted, KeyStateExpired:
+		return true
+	}
+	return false
 }
@@ -6,5 +6,11 @@ import (
 
 // Domain errors for token API
 const (
-	ErrNotFound = consterr.ConstErr("ErrNotFound")
+	ErrNotFound     = consterr.ConstErr("ErrNotFound")
+	ErrInvalidKey   = consterr.ConstErr("ErrInvalidKey")
+	ErrExpired      = consterr.ConstErr("ErrExpired")
+	ErrInvalidScope = consterr.ConstErr("ErrInvalidScope")
+	ErrInvalidGrace = consterr.ConstErr("ErrInvalidGrace")
+	ErrInvalidState = consterr.ConstErr("ErrInvalidState")
+	ErrInvalidQuota = consterr.ConstErr("ErrInvalidQuota")
 )
@@ -0,0 +1,6 @@
+BEGIN;
+ALTER TABLE tokenapi_keys
+    DROP COLUMN secret_hash
+    ,DROP COLUMN scopes
+    ,DROP COLUMN expires;
+COMMIT;
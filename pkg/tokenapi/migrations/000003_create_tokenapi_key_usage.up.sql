@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE tokenapi_key_usage(
+    key_id          UUID PRIMARY KEY REFERENCES tokenapi_keys(id) ON DELETE CASCADE
+    ,last_used      TIMESTAMP NOT NULL
+    ,requests       BIGINT NOT NULL
+);
+
+ALTER TABLE tokenapi_keys DROP COLUMN last_used;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE tokenapi_keys
+    DROP COLUMN rotated_from
+    ,DROP COLUMN rotated_to;
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE tokenapi_keys
+    ADD COLUMN rotated_from UUID REFERENCES tokenapi_keys(id) ON DELETE SET NULL
+    ,ADD COLUMN rotated_to  UUID REFERENCES tokenapi_keys(id) ON DELETE SET NULL;
+
+COMMIT;
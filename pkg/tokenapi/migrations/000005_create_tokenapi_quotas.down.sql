@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE tokenapi_quotas;
+DROP TABLE tokenapi_daily_usage;
+COMMIT;
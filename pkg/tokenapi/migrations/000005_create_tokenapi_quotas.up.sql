@@ -0,0 +1,21 @@
+BEGIN;
+
+CREATE TABLE tokenapi_daily_usage(
+    key_id          UUID NOT NULL
+    ,user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,day            DATE NOT NULL
+    ,requests       BIGINT NOT NULL
+    ,PRIMARY KEY (key_id, day)
+);
+
+CREATE INDEX idx_tokenapi_daily_usage_user_id ON tokenapi_daily_usage(user_id, day);
+
+CREATE TABLE tokenapi_quotas(
+    key_id          UUID UNIQUE REFERENCES tokenapi_keys(id) ON DELETE CASCADE
+    ,user_id        UUID UNIQUE REFERENCES users(id) ON DELETE CASCADE
+    ,daily          BIGINT NOT NULL
+    ,monthly        BIGINT NOT NULL
+    ,CHECK ((key_id IS NULL) <> (user_id IS NULL))
+);
+
+COMMIT;
@@ -0,0 +1,291 @@
+package tokenapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// Quota periods
+const (
+	QuotaDaily   string = "day"
+	QuotaMonthly string = "month"
+)
+
+// MetQuotaExceeded counts the requests rejected because a
+// quota is exhausted.
+const MetQuotaExceeded string = "tokenapi.quota.exceeded"
+
+// QuotaMetricDefinitions returns the definitions of the metrics
+// reported by the QuotaEnforcer, to be registered in the insighter.
+func QuotaMetricDefinitions() metrics.MetricDefinitionList {
+	return metrics.MetricDefinitionList{
+		&metrics.MetricDefinition{
+			Name:       MetQuotaExceeded,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+		},
+	}
+}
+
+// Quota limits the requests that can be made in a UTC day and
+// in a UTC month. Zero values mean no limit.
+type Quota struct {
+	Daily   int64
+	Monthly int64
+}
+
+// IsZero tells if the quota has no limits.
+func (q Quota) IsZero() bool {
+	return q.Daily <= 0 && q.Monthly <= 0
+}
+
+// Usage has the requests made in a UTC day and in its month
+// (up to that day).
+type Usage struct {
+	Day     time.Time
+	Daily   int64
+	Monthly int64
+}
+
+// QuotaRepo defines the storage contract for the quotas of the
+// keys and of the users, and the usage they are checked against.
+type QuotaRepo interface {
+	// SetKeyQuota sets the quota of a key. A zero quota removes it.
+	SetKeyQuota(key ids.ID, q Quota) error
+	// SetUserQuota sets the quota for all the keys of a user. A zero
+	// quota removes it.
+	SetUserQuota(userID ids.ID, q Quota) error
+	// GetQuotas returns the quota of a key and the quota of its
+	// user (zero when they are not set).
+	GetQuotas(userID ids.ID, key ids.ID) (Quota, Quota, error)
+	// GetKeyUsage returns the usage of a key in a day.
+	GetKeyUsage(key ids.ID, day time.Time) (Usage, error)
+	// GetUserUsage returns the usage of all the keys of a user in a day.
+	GetUserUsage(userID ids.ID, day time.Time) (Usage, error)
+}
+
+// QuotaStatus is the outcome of checking a request against the
+// quotas. When there are several quotas, it has the one with less
+// remaining requests.
+type QuotaStatus struct {
+	Allowed bool
+	// Period is QuotaDaily or QuotaMonthly, or empty if there
+	// is no quota.
+	Period    string
+	Limit     int64
+	Remaining int64
+	// Reset is when the period of the quota ends
+	Reset time.Time
+}
+
+// quotaCounter has the quota of a key or a user, and its usage
+// as loaded from the repo plus the requests counted since then.
+type quotaCounter struct {
+	quota  Quota
+	usage  Usage
+	loaded time.Time
+}
+
+// QuotaEnforcer checks the quotas of the keys in memory. The quotas
+// and the usage are reloaded from the repo after a TTL (so the
+// usage recorded by other instances is taken into account), and
+// in between, the requests are counted locally. So the hot path
+// never writes to the database, and the quotas are enforced with
+// an error of about the requests made in a flush interval of the
+// UsageTracker.
+type QuotaEnforcer struct {
+	ins  *obs.Insighter
+	repo QuotaRepo
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	keys      map[ids.ID]*quotaCounter
+	users     map[ids.ID]*quotaCounter
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+// NewQuotaEnforcer creates a QuotaEnforcer. When ttl is zero, the
+// DefaultUsageFlushInterval is used.
+func NewQuotaEnforcer(ins *obs.Insighter, repo QuotaRepo,
+	ttl time.Duration) *QuotaEnforcer {
+	if ttl <= 0 {
+		ttl = DefaultUsageFlushInterval
+	}
+	return &QuotaEnforcer{
+		ins:   ins,
+		repo:  repo,
+		ttl:   ttl,
+		keys:  make(map[ids.ID]*quotaCounter),
+		users: make(map[ids.ID]*quotaCounter),
+		now:   time.Now,
+	}
+}
+
+// Check counts a request of a key, unless it goes over the quota
+// of the key or the quota of its user.
+func (q *QuotaEnforcer) Check(key ids.ID, userID ids.ID) (QuotaStatus, error) {
+	now := q.now()
+	day := usageDay(now)
+
+	q.mu.Lock()
+	q.sweep(now)
+	keyC, userC := q.keys[key], q.users[userID]
+	keyStale, userStale := q.isStale(keyC, now, day), q.isStale(userC, now, day)
+	q.mu.Unlock()
+
+	if keyStale || userStale {
+		keyQ, userQ, err := q.repo.GetQuotas(userID, key)
+		if err != nil {
+			q.ins.L.Err(err, "cannot load api key quotas", nil)
+			return QuotaStatus{}, err
+		}
+		// the counters are loaded independently, so the requests
+		// counted for a user are not lost when a new key is used
+		if keyStale {
+			if keyC, err = q.load(keyQ, day, func() (Usage, error) {
+				return q.repo.GetKeyUsage(key, day)
+			}); err != nil {
+				q.ins.L.Err(err, "cannot load api key usage", nil)
+				return QuotaStatus{}, err
+			}
+		}
+		if userStale {
+			if userC, err = q.load(userQ, day, func() (Usage, error) {
+				return q.repo.GetUserUsage(userID, day)
+			}); err != nil {
+				q.ins.L.Err(err, "cannot load api user usage", nil)
+				return QuotaStatus{}, err
+			}
+		}
+		q.mu.Lock()
+		q.keys[key], q.users[userID] = keyC, userC
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := QuotaStatus{Allowed: true}
+	for _, c := range []*quotaCounter{keyC, userC} {
+		status = minStatus(status, c.status(QuotaDaily, day))
+		status = minStatus(status, c.status(QuotaMonthly, day))
+	}
+	if !status.Allowed {
+		q.ins.M.Inc(MetQuotaExceeded)
+		return status, nil
+	}
+	for _, c := range []*quotaCounter{keyC, userC} {
+		c.usage.Daily++
+		c.usage.Monthly++
+	}
+	if status.Period != "" {
+		status.Remaining--
+	}
+	return status, nil
+}
+
+// sweep removes the counters that have not been reloaded for a
+// TTL (so they would be reloaded before being used again), to not
+// keep the keys and users that are no longer used. It must be
+// called with the lock held.
+func (q *QuotaEnforcer) sweep(now time.Time) {
+	if now.Before(q.nextSweep) {
+		return
+	}
+	for _, counters := range []map[ids.ID]*quotaCounter{q.keys, q.users} {
+		for id, c := range counters {
+			if now.Sub(c.loaded) >= q.ttl {
+				delete(counters, id)
+			}
+		}
+	}
+	q.nextSweep = now.Add(q.ttl)
+}
+
+func (q *QuotaEnforcer) isStale(c *quotaCounter, now time.Time, day time.Time) bool {
+	return c == nil || now.Sub(c.loaded) >= q.ttl || !c.usage.Day.Equal(day)
+}
+
+// load creates a counter, reading the usage only if there is
+// a quota.
+func (q *QuotaEnforcer) load(quota Quota, day time.Time,
+	usage func() (Usage, error)) (*quotaCounter, error) {
+	c := &quotaCounter{quota: quota, usage: Usage{Day: day}, loaded: q.now()}
+	if !quota.IsZero() {
+		var err error
+		if c.usage, err = usage(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Forget discards the cached quotas of a key and of its user,
+// so the changes of the quotas are applied at once.
+func (q *QuotaEnforcer) Forget(key ids.ID, userID ids.ID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.keys, key)
+	delete(q.users, userID)
+}
+
+// ForgetUser discards the cached quota of a user, so the change
+// of the quota is applied at once.
+func (q *QuotaEnforcer) ForgetUser(userID ids.ID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.users, userID)
+}
+
+// status returns the state of the quota for a period, or an empty
+// status if there is no limit.
+func (c *quotaCounter) status(period string, day time.Time) QuotaStatus {
+	limit, used := c.quota.Daily, c.usage.Daily
+	reset := day.AddDate(0, 0, 1)
+	if period == QuotaMonthly {
+		limit, used = c.quota.Monthly, c.usage.Monthly
+		reset = time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if limit <= 0 {
+		return QuotaStatus{Allowed: true}
+	}
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return QuotaStatus{
+		Allowed:   remaining > 0,
+		Period:    period,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     reset,
+	}
+}
+
+// minStatus returns the most restrictive of two statuses: the
+// rejected one (the one that resets later if both are), or the one
+// with less remaining requests.
+func minStatus(a QuotaStatus, b QuotaStatus) QuotaStatus {
+	switch {
+	case b.Period == "":
+		return a
+	case a.Period == "":
+		return b
+	case a.Allowed != b.Allowed:
+		if !a.Allowed {
+			return a
+		}
+		return b
+	case !a.Allowed:
+		if b.Reset.After(a.Reset) {
+			return b
+		}
+		return a
+	case b.Remaining < a.Remaining:
+		return b
+	}
+	return a
+}
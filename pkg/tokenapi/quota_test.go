@@ -0,0 +1,226 @@
+package tokenapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// memQuotaRepo is an in memory QuotaRepo for the tests
+type memQuotaRepo struct {
+	keyQuotas  map[ids.ID]Quota
+	userQuotas map[ids.ID]Quota
+	usage      []KeyUsage
+	loads      int
+}
+
+func newMemQuotaRepo() *memQuotaRepo {
+	return &memQuotaRepo{
+		keyQuotas:  map[ids.ID]Quota{},
+		userQuotas: map[ids.ID]Quota{},
+	}
+}
+
+func (r *memQuotaRepo) SetKeyQuota(key ids.ID, q Quota) error {
+	r.keyQuotas[key] = q
+	return nil
+}
+
+func (r *memQuotaRepo) SetUserQuota(userID ids.ID, q Quota) error {
+	r.userQuotas[userID] = q
+	return nil
+}
+
+func (r *memQuotaRepo) GetQuotas(userID ids.ID, key ids.ID) (Quota, Quota, error) {
+	r.loads++
+	return r.keyQuotas[key], r.userQuotas[userID], nil
+}
+
+func (r *memQuotaRepo) getUsage(match func(u KeyUsage) bool, day time.Time) Usage {
+	res := Usage{Day: day}
+	for _, u := range r.usage {
+		if !match(u) || u.Day.After(day) || u.Day.Month() != day.Month() ||
+			u.Day.Year() != day.Year() {
+			continue
+		}
+		res.Monthly += u.Requests
+		if u.Day.Equal(day) {
+			res.Daily += u.Requests
+		}
+	}
+	return res
+}
+
+func (r *memQuotaRepo) GetKeyUsage(key ids.ID, day time.Time) (Usage, error) {
+	return r.getUsage(func(u KeyUsage) bool { return u.Key == key }, day), nil
+}
+
+func (r *memQuotaRepo) GetUserUsage(userID ids.ID, day time.Time) (Usage, error) {
+	return r.getUsage(func(u KeyUsage) bool { return u.UserID == userID }, day), nil
+}
+
+func Test_QuotaEnforcer(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+	repo := newMemQuotaRepo()
+	quotas := NewQuotaEnforcer(ins, repo, time.Minute)
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	quotas.now = func() time.Time { return now }
+
+	idGen := ids.NewIDGenerator()
+	userID, first, second := idGen.MustNew(), idGen.MustNew(), idGen.MustNew()
+	_ = repo.SetKeyQuota(first, Quota{Daily: 2})
+	_ = repo.SetUserQuota(userID, Quota{Monthly: 4})
+	// a request already recorded by another instance
+	repo.usage = append(repo.usage, KeyUsage{Key: second, UserID: userID,
+		Day: usageDay(now), Requests: 1})
+
+	st, err := quotas.Check(first, userID)
+	if err != nil || !st.Allowed || st.Period != QuotaDaily || st.Remaining != 1 ||
+		!st.Reset.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected status %#v (%v)", st, err)
+		return
+	}
+	if st, _ = quotas.Check(first, userID); !st.Allowed || st.Remaining != 0 {
+		t.Errorf("want last daily request, got %#v", st)
+		return
+	}
+	if st, _ = quotas.Check(first, userID); st.Allowed || st.Period != QuotaDaily {
+		t.Errorf("want daily quota exhausted, got %#v", st)
+		return
+	}
+	// the other key is only limited by the user quota
+	if st, _ = quotas.Check(second, userID); !st.Allowed || st.Period != QuotaMonthly ||
+		st.Remaining != 0 {
+		t.Errorf("want last monthly request, got %#v", st)
+		return
+	}
+	if st, _ = quotas.Check(second, userID); st.Allowed || st.Period != QuotaMonthly {
+		t.Errorf("want monthly quota exhausted, got %#v", st)
+		return
+	}
+	if len(meter.Incs) != 2 || meter.Incs[0] != MetQuotaExceeded {
+		t.Errorf("want exceeded metrics, got %#v", meter.Incs)
+		return
+	}
+
+	// a new month resets the counters (loading them again)
+	loads := repo.loads
+	now = now.Add(24 * time.Hour)
+	if st, _ = quotas.Check(second, userID); !st.Allowed || st.Remaining != 3 {
+		t.Errorf("want a new month, got %#v", st)
+		return
+	}
+	if repo.loads != loads+1 {
+		t.Errorf("want quotas reloaded, got %d loads", repo.loads-loads)
+		return
+	}
+	if st, _ = quotas.Check(second, userID); !st.Allowed || repo.loads != loads+1 {
+		t.Errorf("want cached quotas, got %#v with %d loads", st, repo.loads-loads)
+		return
+	}
+}
+
+func Test_QuotaEnforcer_Evict(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	repo := newMemQuotaRepo()
+	quotas := NewQuotaEnforcer(ins, repo, time.Minute)
+	now := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	quotas.now = func() time.Time { return now }
+
+	idGen := ids.NewIDGenerator()
+	userID, first, second := idGen.MustNew(), idGen.MustNew(), idGen.MustNew()
+	_, _ = quotas.Check(first, userID)
+	now = now.Add(30 * time.Second)
+	_, _ = quotas.Check(second, userID)
+	if len(quotas.keys) != 2 || len(quotas.users) != 1 {
+		t.Errorf("want cached counters, got %d keys %d users",
+			len(quotas.keys), len(quotas.users))
+		return
+	}
+	// the first key has not been used for a TTL
+	now = now.Add(45 * time.Second)
+	_, _ = quotas.Check(second, userID)
+	if _, ok := quotas.keys[first]; ok || len(quotas.keys) != 1 || len(quotas.users) != 1 {
+		t.Errorf("want the first key evicted, got %d keys %d users",
+			len(quotas.keys), len(quotas.users))
+		return
+	}
+}
+
+func Test_TokenAPI_ForgetQuotas(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	repo := newMemRepo()
+	quotas := NewQuotaEnforcer(ins, repo, time.Hour)
+	tapi := NewTokenAPIWithQuotas(ins, repo, quotas)
+	userID := ids.NewIDGenerator().MustNew()
+	k, _ := tapi.CreateKey(userID, "key", nil, nil)
+
+	_ = tapi.SetKeyQuota(userID, k.Key, Quota{Daily: 1})
+	if st, _ := quotas.Check(k.Key, userID); !st.Allowed {
+		t.Errorf("want allowed, got %#v", st)
+		return
+	}
+	if st, _ := quotas.Check(k.Key, userID); st.Allowed {
+		t.Errorf("want daily quota exhausted, got %#v", st)
+		return
+	}
+	_ = tapi.SetKeyQuota(userID, k.Key, Quota{Daily: 2})
+	if st, _ := quotas.Check(k.Key, userID); !st.Allowed || st.Remaining != 1 {
+		t.Errorf("want the new key quota applied, got %#v", st)
+		return
+	}
+	_ = tapi.SetUserQuota(userID, Quota{Daily: 5})
+	if _, ok := quotas.users[userID]; ok {
+		t.Errorf("want the user quota forgotten")
+		return
+	}
+}
+
+func Test_TokenAPI_Quotas(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	repo := newMemRepo()
+	tapi := NewTokenAPI(ins, repo)
+	userID := ids.NewIDGenerator().MustNew()
+	other := ids.NewIDGenerator().MustNew()
+	k, _ := tapi.CreateKey(userID, "key", nil, nil)
+
+	if err := tapi.SetKeyQuota(userID, k.Key, Quota{Daily: -1}); err != ErrInvalidQuota {
+		t.Errorf("want ErrInvalidQuota, got %v", err)
+		return
+	}
+	if err := tapi.SetKeyQuota(other, k.Key, Quota{Daily: 10}); err != ErrNotFound {
+		t.Errorf("want ErrNotFound for other user key, got %v", err)
+		return
+	}
+	if err := tapi.SetKeyQuota(userID, k.Key, Quota{Daily: 10, Monthly: 100}); err != nil {
+		t.Errorf("cannot set quota: %s", err)
+		return
+	}
+	_ = tapi.SetUserQuota(userID, Quota{Monthly: 1000})
+	keyQ, userQ, err := tapi.GetQuotas(userID, k.Key)
+	if err != nil || keyQ.Daily != 10 || keyQ.Monthly != 100 || userQ.Monthly != 1000 {
+		t.Errorf("unexpected quotas %#v %#v (%v)", keyQ, userQ, err)
+		return
+	}
+
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	repo.usage = []KeyUsage{
+		{Key: k.Key, UserID: userID, Day: day.AddDate(0, 0, -1), Requests: 3},
+		{Key: k.Key, UserID: userID, Day: day, Requests: 2},
+	}
+	u, err := tapi.GetKeyUsage(userID, k.Key, day.Add(5*time.Hour))
+	if err != nil || u.Daily != 2 || u.Monthly != 5 || !u.Day.Equal(day) {
+		t.Errorf("unexpected usage %#v (%v)", u, err)
+		return
+	}
+	if _, err := tapi.GetKeyUsage(other, k.Key, day); err != ErrNotFound {
+		t.Errorf("want ErrNotFound for other user usage, got %v", err)
+		return
+	}
+}
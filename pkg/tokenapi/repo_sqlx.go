@@ -1,8 +1,11 @@
 package tokenapi
 
 import (
+	"database/sql"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/dhontecillas/hfw/pkg/db"
 	"github.com/dhontecillas/hfw/pkg/ids"
 	"github.com/dhontecillas/hfw/pkg/obs"
@@ -28,7 +31,24 @@ type sqlxTokenAPIKey struct {
 	Created     time.Time
 	Deleted     *time.Time
 	LastUsed    *time.Time
+	Requests    int64
 	Description string
+	SecretHash  string
+	Scopes      pq.StringArray
+	Expires     *time.Time
+	RotatedFrom *string
+	RotatedTo   *string
+}
+
+func optionalID(strID *string) (*ids.ID, error) {
+	if strID == nil {
+		return nil, nil
+	}
+	var id ids.ID
+	if err := id.FromUUID(*strID); err != nil {
+		return nil, err
+	}
+	return &id, nil
 }
 
 func (st *sqlxTokenAPIKey) fromSQLX(t *APIKey) error {
@@ -41,7 +61,21 @@ func (st *sqlxTokenAPIKey) fromSQLX(t *APIKey) error {
 	t.Created = st.Created
 	t.Deleted = st.Deleted
 	t.LastUsed = st.LastUsed
+	t.Requests = st.Requests
 	t.Description = st.Description
+	t.SecretHash = st.SecretHash
+	t.Scopes = []string(st.Scopes)
+	if t.Scopes == nil {
+		t.Scopes = []string{}
+	}
+	t.Expires = st.Expires
+	var err error
+	if t.RotatedFrom, err = optionalID(st.RotatedFrom); err != nil {
+		return err
+	}
+	if t.RotatedTo, err = optionalID(st.RotatedTo); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -59,71 +93,74 @@ func (st *sqlxTokenAPIKey) toSQLX(t *APIKey) error {
 }
 */
 
-// CreateKey creates a new api key.
-func (r *RepoSQLX) CreateKey(key ids.ID, userID ids.ID, description string,
-	created time.Time) (*APIKey, error) {
-
-	sqlQ := `
+const createKeyQ = `
 INSERT INTO tokenapi_keys(
 	id
 	,user_id
 	,created
 	,deleted
-	,last_used
 	,description
+	,secret_hash
+	,scopes
+	,expires
+	,rotated_from
 )
 VALUES(
 	$1
 	,$2
 	,$3
 	,NULL
-	,NULL
 	,$4
+	,$5
+	,$6
+	,$7
+	,$8
 )
 `
-	strKey := key.ToUUID()
-	strUserID := userID.ToUUID()
 
-	master := r.sqlDB.Master()
-	// TODO: this does not need a transaction, this can be done
-	// in a single request to the DB
-	tx, err := master.Beginx()
-	if err != nil {
-		return nil, err
+func createKeyArgs(k *APIKey) []interface{} {
+	var rotatedFrom *string
+	if k.RotatedFrom != nil {
+		s := k.RotatedFrom.ToUUID()
+		rotatedFrom = &s
 	}
+	return []interface{}{k.Key.ToUUID(), k.UserID.ToUUID(), k.Created,
+		k.Description, k.SecretHash, pq.Array(k.Scopes), k.Expires, rotatedFrom}
+}
 
-	_, err = tx.Exec(sqlQ, strKey, strUserID, created, description)
-	if err != nil {
-		_ = tx.Rollback()
-		// TODO: if rollback failed .. what?
-		return nil, err
-	}
-	err = tx.Commit()
+// CreateKey stores a new api key.
+func (r *RepoSQLX) CreateKey(k *APIKey) error {
+	master := r.sqlDB.Master()
+	_, err := master.Exec(createKeyQ, createKeyArgs(k)...)
 	if err != nil {
-		return nil, err
+		r.ins.L.Err(err, "cannot create key", map[string]interface{}{
+			"query": createKeyQ,
+		})
+		return err
 	}
-
-	return &APIKey{
-		Key:         key,
-		UserID:      userID,
-		Created:     created,
-		Description: description,
-	}, nil
+	return nil
 }
 
 // GetKey retrieves an existing api key by id.
 func (r *RepoSQLX) GetKey(key ids.ID) (*APIKey, error) {
 	sqlQ := `
 SELECT
-	id AS ID
-	,user_id AS UserID
-	,created AS Created
-	,deleted AS Deleted
-	,last_used AS LastUsed
-	,description AS Description
-FROM tokenapi_keys
+	k.id AS ID
+	,k.user_id AS UserID
+	,k.created AS Created
+	,k.deleted AS Deleted
+	,u.last_used AS LastUsed
+	,COALESCE(u.requests, 0) AS Requests
+	,k.description AS Description
+	,k.secret_hash AS SecretHash
+	,k.scopes AS Scopes
+	,k.expires AS Expires
+	,k.rotated_from AS RotatedFrom
+	,k.rotated_to AS RotatedTo
+FROM tokenapi_keys k
+LEFT JOIN tokenapi_key_usage u ON u.key_id = k.id
 WHERE
-	id = $1
+	k.id = $1
 `
 	strKey := key.ToUUID()
 	master := r.sqlDB.Master()
@@ -133,6 +170,9 @@ WHERE
 	}
 	var sqlT sqlxTokenAPIKey
 	if err := row.StructScan(&sqlT); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	tk := APIKey{}
@@ -142,25 +182,88 @@ WHERE
 	return &tk, nil
 }
 
-// ListKeys returns a full list of api keys for a user.
-func (r *RepoSQLX) ListKeys(userID ids.ID) ([]APIKey, error) {
+// keyStateConds are the conditions to filter the keys by state,
+// using the current time as the $4 parameter.
+var keyStateConds = map[KeyState]string{
+	KeyStateAll: "",
+	KeyStateActive: `
+	AND (k.deleted IS NULL OR k.deleted > $4)
+	AND (k.expires IS NULL OR k.expires > $4)`,
+	KeyStateDeleted: `
+	AND k.deleted <= $4`,
+	KeyStateExpired: `
+	AND (k.deleted IS NULL OR k.deleted > $4)
+	AND k.expires <= $4`,
+}
+
+// ListKeys lists the api keys of a user in a given state
+// with pagination, sorted by key. A zero limit returns all
+// the keys, and a zero from starts from the first one.
+func (r *RepoSQLX) ListKeys(userID ids.ID, state KeyState, from ids.ID,
+	limit int, backwards bool) ([]APIKey, error) {
+
+	stateCond, ok := keyStateConds[state]
+	if !ok {
+		return nil, ErrInvalidState
+	}
+	var sqlLimit sql.NullInt64
+	if limit > 0 {
+		sqlLimit = sql.NullInt64{Int64: int64(limit), Valid: true}
+	}
+	var sqlFrom sql.NullString
+	if !from.IsZero() {
+		sqlFrom = sql.NullString{String: from.ToUUID(), Valid: true}
+	}
+
+	cursorCond := `
+	AND ($2::UUID IS NULL OR k.id > $2)`
+	order := `
+ORDER BY k.id`
+	if backwards {
+		cursorCond = `
+	AND ($2::UUID IS NULL OR k.id < $2)`
+		order = `
+ORDER BY k.id DESC`
+	}
 	sqlQ := `
 SELECT
-	id AS ID
-	,user_id AS UserID
-	,created AS Created
-	,deleted AS Deleted
-	,last_used AS LastUsed
-	,description AS Description
-FROM tokenapi_keys
+	k.id AS ID
+	,k.user_id AS UserID
+	,k.created AS Created
+	,k.deleted AS Deleted
+	,u.last_used AS LastUsed
+	,COALESCE(u.requests, 0) AS Requests
+	,k.description AS Description
+	,k.secret_hash AS SecretHash
+	,k.scopes AS Scopes
+	,k.expires AS Expires
+	,k.rotated_from AS RotatedFrom
+	,k.rotated_to AS RotatedTo
+FROM tokenapi_keys k
+LEFT JOIN tokenapi_key_usage u ON u.key_id = k.id
 WHERE
-	user_id = $1
+	k.user_id = $1` + stateCond + cursorCond + order + `
+LIMIT $3
 `
-	strUserID := userID.ToUUID()
+	if backwards {
+		// the previous page is selected in reverse order, and
+		// sorted back by key
+		sqlQ = `
+SELECT * FROM (` + sqlQ + `) AS backpage
+ORDER BY ID
+`
+	}
+	args := []interface{}{userID.ToUUID(), sqlFrom, sqlLimit}
+	if stateCond != "" {
+		args = append(args, time.Now())
+	}
 	master := r.sqlDB.Master()
-	rows, err := master.Queryx(sqlQ, strUserID)
+	rows, err := master.Queryx(sqlQ, args...)
 	if err != nil {
-		return nil, ErrNotFound
+		r.ins.L.Err(err, "cannot list keys", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -176,7 +279,7 @@ WHERE
 		}
 		tks = append(tks, tk)
 	}
-	return tks, nil
+	return tks, rows.Err()
 }
 
 // DeleteKey deletes an existing api key by id.
@@ -204,3 +307,59 @@ WHERE
 	}
 	return nil
 }
+
+// RotateKey stores the successor of a key of a user, and sets
+// the time when the rotated key is deleted. Returns ErrNotFound
+// if the key does not exist, is deleted or has already been
+// rotated.
+func (r *RepoSQLX) RotateKey(userID ids.ID, key ids.ID, successor *APIKey,
+	deleted time.Time) error {
+
+	rotateQ := `
+UPDATE tokenapi_keys
+SET
+	deleted = $3
+	,rotated_to = $4
+WHERE
+	id = $1
+	AND user_id = $2
+	AND rotated_to IS NULL
+	AND (deleted IS NULL OR deleted > $5)
+`
+	master := r.sqlDB.Master()
+	tx, err := master.Beginx()
+	if err != nil {
+		return err
+	}
+	rollback := func() {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+	}
+	if _, err := tx.Exec(createKeyQ, createKeyArgs(successor)...); err != nil {
+		rollback()
+		r.ins.L.Err(err, "cannot create successor key", map[string]interface{}{
+			"query": createKeyQ,
+		})
+		return err
+	}
+	res, err := tx.Exec(rotateQ, key.ToUUID(), userID.ToUUID(), deleted,
+		successor.Key.ToUUID(), time.Now())
+	if err != nil {
+		rollback()
+		r.ins.L.Err(err, "cannot rotate key", map[string]interface{}{
+			"query": rotateQ,
+		})
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		rollback()
+		return err
+	}
+	if n == 0 {
+		rollback()
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,145 @@
+package tokenapi
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+var _ QuotaRepo = (*RepoSQLX)(nil)
+
+// SetKeyQuota sets the quota of a key. A zero quota removes it.
+func (r *RepoSQLX) SetKeyQuota(key ids.ID, q Quota) error {
+	sqlQ := `
+INSERT INTO tokenapi_quotas(
+	key_id
+	,daily
+	,monthly
+)
+VALUES(
+	$1
+	,$2
+	,$3
+)
+ON CONFLICT (key_id) DO UPDATE SET
+	daily = EXCLUDED.daily
+	,monthly = EXCLUDED.monthly
+`
+	deleteQ := `
+DELETE FROM tokenapi_quotas
+WHERE
+	key_id = $1
+`
+	return r.setQuota(sqlQ, deleteQ, key.ToUUID(), q)
+}
+
+// SetUserQuota sets the quota for all the keys of a user. A zero
+// quota removes it.
+func (r *RepoSQLX) SetUserQuota(userID ids.ID, q Quota) error {
+	sqlQ := `
+INSERT INTO tokenapi_quotas(
+	user_id
+	,daily
+	,monthly
+)
+VALUES(
+	$1
+	,$2
+	,$3
+)
+ON CONFLICT (user_id) DO UPDATE SET
+	daily = EXCLUDED.daily
+	,monthly = EXCLUDED.monthly
+`
+	deleteQ := `
+DELETE FROM tokenapi_quotas
+WHERE
+	user_id = $1
+`
+	return r.setQuota(sqlQ, deleteQ, userID.ToUUID(), q)
+}
+
+func (r *RepoSQLX) setQuota(sqlQ string, deleteQ string, id string, q Quota) error {
+	master := r.sqlDB.Master()
+	args := []interface{}{id, q.Daily, q.Monthly}
+	if q.IsZero() {
+		sqlQ, args = deleteQ, args[:1]
+	}
+	if _, err := master.Exec(sqlQ, args...); err != nil {
+		r.ins.L.Err(err, "cannot set quota", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// GetQuotas returns the quota of a key and the quota of its
+// user (zero when they are not set).
+func (r *RepoSQLX) GetQuotas(userID ids.ID, key ids.ID) (Quota, Quota, error) {
+	sqlQ := `
+SELECT
+	key_id IS NOT NULL
+	,daily
+	,monthly
+FROM tokenapi_quotas
+WHERE
+	key_id = $1
+	OR user_id = $2
+`
+	var keyQ, userQ Quota
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, key.ToUUID(), userID.ToUUID())
+	if err != nil {
+		r.ins.L.Err(err, "cannot get quotas", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return keyQ, userQ, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var isKey bool
+		var q Quota
+		if err := rows.Scan(&isKey, &q.Daily, &q.Monthly); err != nil {
+			return keyQ, userQ, err
+		}
+		if isKey {
+			keyQ = q
+		} else {
+			userQ = q
+		}
+	}
+	return keyQ, userQ, rows.Err()
+}
+
+// GetKeyUsage returns the usage of a key in a day.
+func (r *RepoSQLX) GetKeyUsage(key ids.ID, day time.Time) (Usage, error) {
+	return r.getUsage(`key_id`, key.ToUUID(), day)
+}
+
+// GetUserUsage returns the usage of all the keys of a user in a day.
+func (r *RepoSQLX) GetUserUsage(userID ids.ID, day time.Time) (Usage, error) {
+	return r.getUsage(`user_id`, userID.ToUUID(), day)
+}
+
+func (r *RepoSQLX) getUsage(column string, id string, day time.Time) (Usage, error) {
+	sqlQ := `
+SELECT
+	COALESCE(SUM(requests) FILTER (WHERE day = $2), 0)
+	,COALESCE(SUM(requests), 0)
+FROM tokenapi_daily_usage
+WHERE
+	` + column + ` = $1
+	AND day >= DATE_TRUNC('month', $2::DATE)
+	AND day <= $2
+`
+	u := Usage{Day: usageDay(day)}
+	master := r.sqlDB.Master()
+	if err := master.QueryRowx(sqlQ, id, u.Day).Scan(&u.Daily, &u.Monthly); err != nil {
+		r.ins.L.Err(err, "cannot get usage", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return u, err
+	}
+	return u, nil
+}
@@ -39,17 +39,23 @@ func Test_RepoSQLX_HappyPath(t *testing.T) {
 	idGen := ids.NewIDGenerator()
 
 	key, _ := idGen.New()
-	tk, err := r.CreateKey(key, u.ID, "Test Key", time.Now())
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	tk := &APIKey{
+		Key:         key,
+		UserID:      u.ID,
+		Created:     time.Now(),
+		Description: "Test Key",
+		SecretHash:  hashSecret("secret"),
+		Scopes:      []string{"keys:read"},
+		Expires:     &expires,
+	}
+	err = r.CreateKey(tk)
 	if err != nil {
 		t.Errorf("error creating key: %s", err)
 		return
 	}
-	if key != tk.Key {
-		t.Errorf("key want %s, got %s", key, tk.Key)
-		return
-	}
 
-	apiKeys, err := r.ListKeys(u.ID)
+	apiKeys, err := r.ListKeys(u.ID, KeyStateAll, ids.ID{}, 0, false)
 	if err != nil {
 		t.Errorf("error listing keys: %s for %s", err, u.ID.ToUUID())
 		return
@@ -76,6 +82,102 @@ func Test_RepoSQLX_HappyPath(t *testing.T) {
 		t.Errorf("expected key, got nil")
 		return
 	}
+	if getK.SecretHash != tk.SecretHash || len(getK.Scopes) != 1 ||
+		getK.Scopes[0] != "keys:read" || getK.Expires == nil ||
+		!getK.Expires.Equal(expires) {
+		t.Errorf("get key mismatch, want %#v, got %#v", tk, getK)
+		return
+	}
+
+	used := time.Now().Truncate(time.Second)
+	day := usageDay(used)
+	err = r.AddUsage([]KeyUsage{{Key: tk.Key, UserID: u.ID, Day: day,
+		LastUsed: used, Requests: 2}})
+	if err != nil {
+		t.Errorf("cannot add usage: %s", err)
+		return
+	}
+	_ = r.AddUsage([]KeyUsage{{Key: tk.Key, UserID: u.ID, Day: day,
+		LastUsed: used.Add(-time.Minute), Requests: 1}})
+	apiKeys, _ = r.ListKeys(u.ID, KeyStateActive, ids.ID{}, 0, false)
+	if len(apiKeys) != 1 || apiKeys[0].Requests != 3 || apiKeys[0].LastUsed == nil ||
+		!apiKeys[0].LastUsed.Equal(used) {
+		t.Errorf("want 3 requests last used at %s, got %#v", used, apiKeys)
+		return
+	}
+	keyUsage, err := r.GetKeyUsage(tk.Key, day)
+	if err != nil || keyUsage.Daily != 3 || keyUsage.Monthly != 3 {
+		t.Errorf("want 3 requests in the day, got %#v (%v)", keyUsage, err)
+		return
+	}
+	if userUsage, _ := r.GetUserUsage(u.ID, day.AddDate(0, 0, 1)); userUsage.Daily != 0 ||
+		(day.AddDate(0, 0, 1).Month() == day.Month() && userUsage.Monthly != 3) {
+		t.Errorf("want 3 requests in the month, got %#v", userUsage)
+		return
+	}
+
+	if err := r.SetKeyQuota(tk.Key, Quota{Daily: 10}); err != nil {
+		t.Errorf("cannot set key quota: %s", err)
+		return
+	}
+	_ = r.SetKeyQuota(tk.Key, Quota{Daily: 20, Monthly: 100})
+	_ = r.SetUserQuota(u.ID, Quota{Monthly: 1000})
+	keyQ, userQ, err := r.GetQuotas(u.ID, tk.Key)
+	if err != nil || keyQ.Daily != 20 || keyQ.Monthly != 100 || userQ.Monthly != 1000 {
+		t.Errorf("unexpected quotas %#v %#v (%v)", keyQ, userQ, err)
+		return
+	}
+	_ = r.SetUserQuota(u.ID, Quota{})
+	if _, userQ, _ = r.GetQuotas(u.ID, tk.Key); !userQ.IsZero() {
+		t.Errorf("want user quota removed, got %#v", userQ)
+		return
+	}
+
+	// rotate the key, keeping it valid for a while
+	successorKey, _ := idGen.New()
+	successor := &APIKey{
+		Key:         successorKey,
+		UserID:      u.ID,
+		Created:     time.Now(),
+		Description: tk.Description,
+		SecretHash:  hashSecret("other secret"),
+		Scopes:      tk.Scopes,
+		RotatedFrom: &tk.Key,
+	}
+	graceEnd := time.Now().Add(time.Hour)
+	if err := r.RotateKey(u.ID, tk.Key, successor, graceEnd); err != nil {
+		t.Errorf("cannot rotate key: %s", err)
+		return
+	}
+	if err := r.RotateKey(u.ID, tk.Key, successor, graceEnd); err != ErrNotFound {
+		t.Errorf("want ErrNotFound rotating twice, got %v", err)
+		return
+	}
+	rotated, _ := r.GetKey(tk.Key)
+	if rotated.RotatedTo == nil || *rotated.RotatedTo != successorKey ||
+		rotated.Deleted == nil || rotated.IsDeleted(time.Now()) {
+		t.Errorf("unexpected rotated key %#v", rotated)
+		return
+	}
+	gotSuccessor, _ := r.GetKey(successorKey)
+	if gotSuccessor.RotatedFrom == nil || *gotSuccessor.RotatedFrom != tk.Key {
+		t.Errorf("unexpected successor key %#v", gotSuccessor)
+		return
+	}
+	// during the grace period both keys are active
+	first, _ := r.ListKeys(u.ID, KeyStateActive, ids.ID{}, 1, false)
+	second, _ := r.ListKeys(u.ID, KeyStateActive, first[0].Key, 1, false)
+	back, _ := r.ListKeys(u.ID, KeyStateActive, second[0].Key, 1, true)
+	if len(first) != 1 || len(second) != 1 || len(back) != 1 ||
+		first[0].Key == second[0].Key || back[0].Key != first[0].Key {
+		t.Errorf("unexpected pages %#v %#v %#v", first, second, back)
+		return
+	}
+	if deleted, _ := r.ListKeys(u.ID, KeyStateDeleted, ids.ID{}, 0, false); len(deleted) != 0 {
+		t.Errorf("want no deleted keys, got %#v", deleted)
+		return
+	}
+	_ = r.DeleteUserKey(u.ID, successorKey)
 
 	err = r.DeleteUserKey(u.ID, tk.Key)
 	if err != nil {
@@ -83,7 +185,7 @@ func Test_RepoSQLX_HappyPath(t *testing.T) {
 		return
 	}
 
-	lks, err := r.ListKeys(u.ID)
+	lks, err := r.ListKeys(u.ID, KeyStateAll, ids.ID{}, 0, false)
 	if err != nil {
 		t.Errorf("err listing keys after deletion: %s", err)
 		return
@@ -0,0 +1,72 @@
+package tokenapi
+
+var _ UsageRepo = (*RepoSQLX)(nil)
+
+// AddUsage adds the requests and updates the last used
+// time of a batch of keys, and adds the requests to the
+// daily usage (kept for billing, even for deleted keys).
+// The last used time of deleted keys is ignored.
+func (r *RepoSQLX) AddUsage(usage []KeyUsage) error {
+	sqlQ := `
+INSERT INTO tokenapi_key_usage(
+	key_id
+	,last_used
+	,requests
+)
+SELECT
+	$1
+	,$2
+	,$3
+WHERE EXISTS (
+	SELECT 1 FROM tokenapi_keys WHERE id = $1
+)
+ON CONFLICT (key_id) DO UPDATE SET
+	last_used = GREATEST(tokenapi_key_usage.last_used, EXCLUDED.last_used)
+	,requests = tokenapi_key_usage.requests + EXCLUDED.requests
+`
+	dailyQ := `
+INSERT INTO tokenapi_daily_usage(
+	key_id
+	,user_id
+	,day
+	,requests
+)
+SELECT
+	$1
+	,$2
+	,$3
+	,$4
+WHERE EXISTS (
+	SELECT 1 FROM users WHERE id = $2
+)
+ON CONFLICT (key_id, day) DO UPDATE SET
+	requests = tokenapi_daily_usage.requests + EXCLUDED.requests
+`
+	master := r.sqlDB.Master()
+	tx, err := master.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, u := range usage {
+		if _, err := tx.Exec(sqlQ, u.Key.ToUUID(), u.LastUsed, u.Requests); err != nil {
+			r.ins.L.Err(err, "cannot add key usage", map[string]interface{}{
+				"query": sqlQ,
+			})
+			if rbErr := tx.Rollback(); rbErr != nil {
+				r.ins.L.Err(rbErr, "rollback failed", nil)
+			}
+			return err
+		}
+		if _, err := tx.Exec(dailyQ, u.Key.ToUUID(), u.UserID.ToUUID(),
+			u.Day, u.Requests); err != nil {
+			r.ins.L.Err(err, "cannot add daily key usage", map[string]interface{}{
+				"query": dailyQ,
+			})
+			if rbErr := tx.Rollback(); rbErr != nil {
+				r.ins.L.Err(rbErr, "rollback failed", nil)
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
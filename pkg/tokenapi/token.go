@@ -0,0 +1,58 @@
+package tokenapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+const (
+	// TokenPrefix is the start of all the API tokens, so they
+	// can be easily recognized (for example by secret scanners).
+	TokenPrefix string = "hfw"
+
+	// AllScopes is a scope that grants any other one.
+	AllScopes string = "*"
+
+	tokenSeparator string = "_"
+	secretSize            = 32
+)
+
+// FormatToken builds the full API token from the public key
+// and the secret: hfw_<key>_<secret>
+func FormatToken(key ids.ID, secret string) string {
+	return strings.Join([]string{TokenPrefix, key.ToShuffled(), secret},
+		tokenSeparator)
+}
+
+// ParseToken splits an API token into its public key and
+// its secret.
+func ParseToken(token string) (ids.ID, string, error) {
+	var key ids.ID
+	parts := strings.SplitN(token, tokenSeparator, 3)
+	if len(parts) != 3 || parts[0] != TokenPrefix || parts[2] == "" {
+		return key, "", ErrInvalidKey
+	}
+	if err := key.FromShuffled(parts[1]); err != nil {
+		return key, "", ErrInvalidKey
+	}
+	return key, parts[2], nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashSecret returns the value stored for a secret. The secrets
+// are random, so a fast hash without salt is enough.
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
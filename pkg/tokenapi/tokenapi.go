@@ -1,6 +1,8 @@
 package tokenapi
 
 import (
+	"crypto/subtle"
+	"strings"
 	"time"
 
 	"github.com/dhontecillas/hfw/pkg/ids"
@@ -9,31 +11,80 @@ import (
 
 // Repo defines the storage contract for the TokenAPI functionality
 type Repo interface {
-	// CreateKey creates a new api key.
-	CreateKey(key ids.ID, userID ids.ID, description string,
-		created time.Time) (*APIKey, error)
+	QuotaRepo
+
+	// CreateKey stores a new api key.
+	CreateKey(k *APIKey) error
 	// GetKey retrieves an existing api key by id.
 	GetKey(key ids.ID) (*APIKey, error)
-	// ListKeys returns a full list of api keys for a user.
-	ListKeys(userID ids.ID) ([]APIKey, error)
+	// ListKeys lists the api keys of a user in a given state
+	// with pagination, sorted by key. A zero limit returns all
+	// the keys, and a zero from starts from the first one.
+	ListKeys(userID ids.ID, state KeyState, from ids.ID, limit int,
+		backwards bool) ([]APIKey, error)
 	// DeleteKey deletes an existing api key by id.
 	DeleteKey(key ids.ID) error
 	// DeleteUserKey deletes an existing api key by id checking
 	// that it belongs to the given user.
 	DeleteUserKey(userID ids.ID, key ids.ID) error
+	// RotateKey stores the successor of a key of a user, and sets
+	// the time when the rotated key is deleted. Returns ErrNotFound
+	// if the key does not exist, is deleted or has already been
+	// rotated.
+	RotateKey(userID ids.ID, key ids.ID, successor *APIKey, deleted time.Time) error
 }
 
+// MaxRotationGrace is the maximum time a rotated key can
+// still be used.
+const MaxRotationGrace = 30 * 24 * time.Hour
+
 // TokenAPI defines the interface to interact with api tokens.
 type TokenAPI interface {
-	CreateKey(userID ids.ID, description string) (*APIKey, error)
+	// CreateKey creates a new key with the given scopes. Expires
+	// is optional. The returned key contains the full Token, that
+	// cannot be retrieved later.
+	CreateKey(userID ids.ID, description string, scopes []string,
+		expires *time.Time) (*APIKey, error)
 	DeleteKey(userID ids.ID, key ids.ID) error
 	GetKey(key ids.ID) (*APIKey, error)
+	// ListKeys returns all the keys of a user, or only the
+	// ones that can be used when onlyActive is set.
 	ListKeys(userID ids.ID, onlyActive bool) ([]APIKey, error)
+	// ListKeysPage lists the keys of a user in a given state, with
+	// pagination using the key as cursor.
+	ListKeysPage(userID ids.ID, state KeyState, from ids.ID, limit int,
+		backwards bool) ([]APIKey, error)
+	// CheckKey returns the key for a full API token, or an error
+	// if the token is not valid, or the key has been deleted or
+	// has expired.
+	CheckKey(token string) (*APIKey, error)
+	// RotateKey creates a successor for a key, with the same
+	// description, scopes and lifetime. The old key can still be
+	// used during the grace period, and then it is deleted.
+	RotateKey(userID ids.ID, key ids.ID, grace time.Duration) (*APIKey, error)
+	// SetKeyQuota sets the daily and monthly quota of a key of a
+	// user. A zero quota removes it. The QuotaEnforcer of a
+	// NewTokenAPIWithQuotas applies it at once.
+	SetKeyQuota(userID ids.ID, key ids.ID, q Quota) error
+	// SetUserQuota sets the daily and monthly quota shared by all
+	// the keys of a user. A zero quota removes it. The QuotaEnforcer
+	// of a NewTokenAPIWithQuotas applies it at once.
+	SetUserQuota(userID ids.ID, q Quota) error
+	// GetQuotas returns the quota of a key of a user, and the
+	// quota of the user.
+	GetQuotas(userID ids.ID, key ids.ID) (Quota, Quota, error)
+	// GetKeyUsage returns the requests made with a key of a user
+	// in a day and in its month (up to that day).
+	GetKeyUsage(userID ids.ID, key ids.ID, day time.Time) (Usage, error)
+	// GetUserUsage returns the requests made with all the keys
+	// of a user in a day and in its month (up to that day).
+	GetUserUsage(userID ids.ID, day time.Time) (Usage, error)
 }
 
 type tokenAPI struct {
-	ins  *obs.Insighter
-	repo Repo
+	ins    *obs.Insighter
+	repo   Repo
+	quotas *QuotaEnforcer
 }
 
 // NewTokenAPI creates a new TokenAPI instance
@@ -44,26 +95,129 @@ func NewTokenAPI(ins *obs.Insighter, repo Repo) TokenAPI {
 	}
 }
 
-func (t *tokenAPI) CreateKey(userID ids.ID, description string) (*APIKey, error) {
+// NewTokenAPIWithQuotas creates a new TokenAPI instance that
+// discards the quotas cached by the enforcer when they are changed.
+// The enforcers of other instances apply the changes once their
+// TTL expires.
+func NewTokenAPIWithQuotas(ins *obs.Insighter, repo Repo, quotas *QuotaEnforcer) TokenAPI {
+	return &tokenAPI{
+		ins:    ins,
+		repo:   repo,
+		quotas: quotas,
+	}
+}
+
+func validScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// newKey generates a key with its secret, that is returned
+// in the Token field.
+func (t *tokenAPI) newKey(userID ids.ID, description string, scopes []string,
+	expires *time.Time) (*APIKey, error) {
+
 	idGen := ids.NewIDGenerator()
 	key, err := idGen.New()
 	if err != nil {
 		t.ins.L.Err(err, "cannot create unique id", nil)
 		return nil, err
 	}
-	t.ins.L.Warn("create key", map[string]interface{}{
-		"key":    key.ToUUID(),
+	secret, err := newSecret()
+	if err != nil {
+		t.ins.L.Err(err, "cannot create key secret", nil)
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return &APIKey{
+		Key:         key,
+		UserID:      userID,
+		Created:     time.Now(),
+		Description: description,
+		SecretHash:  hashSecret(secret),
+		Scopes:      scopes,
+		Expires:     expires,
+		Token:       FormatToken(key, secret),
+	}, nil
+}
+
+func (t *tokenAPI) CreateKey(userID ids.ID, description string, scopes []string,
+	expires *time.Time) (*APIKey, error) {
+
+	if !validScopes(scopes) {
+		return nil, ErrInvalidScope
+	}
+	k, err := t.newKey(userID, description, scopes, expires)
+	if err != nil {
+		return nil, err
+	}
+	t.ins.L.Info("create key", map[string]interface{}{
+		"key":    k.Key.ToUUID(),
 		"userID": userID.ToUUID(),
 	})
-	res, err := t.repo.CreateKey(key, userID, description, time.Now())
-	if err != nil {
+	if err := t.repo.CreateKey(k); err != nil {
 		t.ins.L.Err(err, "cannot create key", nil)
+		return nil, err
 	}
-	return res, err
+	return k, nil
+}
+
+func (t *tokenAPI) RotateKey(userID ids.ID, key ids.ID, grace time.Duration) (*APIKey, error) {
+	if grace < 0 || grace > MaxRotationGrace {
+		return nil, ErrInvalidGrace
+	}
+	old, err := t.repo.GetKey(key)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if old.UserID != userID || old.IsDeleted(now) || old.RotatedTo != nil {
+		return nil, ErrNotFound
+	}
+	// the successor keeps the lifetime of the rotated key
+	var expires *time.Time
+	if old.Expires != nil {
+		e := now.Add(old.Expires.Sub(old.Created))
+		expires = &e
+	}
+	successor, err := t.newKey(userID, old.Description, old.Scopes, expires)
+	if err != nil {
+		return nil, err
+	}
+	successor.RotatedFrom = &old.Key
+	if err := t.repo.RotateKey(userID, key, successor, now.Add(grace)); err != nil {
+		t.ins.L.Err(err, "cannot rotate key", nil)
+		return nil, err
+	}
+	t.ins.L.Info("rotate key", map[string]interface{}{
+		"key":       key.ToUUID(),
+		"successor": successor.Key.ToUUID(),
+		"userID":    userID.ToUUID(),
+	})
+	return successor, nil
 }
 
 func (t *tokenAPI) ListKeys(userID ids.ID, onlyActive bool) ([]APIKey, error) {
-	keys, err := t.repo.ListKeys(userID)
+	state := KeyStateAll
+	if onlyActive {
+		state = KeyStateActive
+	}
+	return t.ListKeysPage(userID, state, ids.ID{}, 0, false)
+}
+
+func (t *tokenAPI) ListKeysPage(userID ids.ID, state KeyState, from ids.ID,
+	limit int, backwards bool) ([]APIKey, error) {
+
+	if !state.Valid() {
+		return nil, ErrInvalidState
+	}
+	keys, err := t.repo.ListKeys(userID, state, from, limit, backwards)
 	if err != nil {
 		t.ins.L.Err(err, "cannot list user keys", nil)
 		return nil, err
@@ -88,3 +242,109 @@ func (t *tokenAPI) GetKey(key ids.ID) (*APIKey, error) {
 	}
 	return res, nil
 }
+
+func (t *tokenAPI) CheckKey(token string) (*APIKey, error) {
+	key, secret, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.repo.GetKey(key)
+	if err != nil {
+		if err != ErrNotFound {
+			t.ins.L.Err(err, "cannot get key", nil)
+		}
+		return nil, ErrInvalidKey
+	}
+	// keys created before the secrets were introduced have an
+	// empty hash, and cannot be used
+	hash := hashSecret(secret)
+	if res.SecretHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hash), []byte(res.SecretHash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now()
+	if res.IsDeleted(now) {
+		return nil, ErrInvalidKey
+	}
+	if res.IsExpired(now) {
+		return nil, ErrExpired
+	}
+	return res, nil
+}
+
+// userKey returns a key of a user, or ErrNotFound if it
+// belongs to another user.
+func (t *tokenAPI) userKey(userID ids.ID, key ids.ID) (*APIKey, error) {
+	k, err := t.repo.GetKey(key)
+	if err != nil {
+		if err != ErrNotFound {
+			t.ins.L.Err(err, "cannot get key", nil)
+		}
+		return nil, err
+	}
+	if k.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return k, nil
+}
+
+func (t *tokenAPI) SetKeyQuota(userID ids.ID, key ids.ID, q Quota) error {
+	if q.Daily < 0 || q.Monthly < 0 {
+		return ErrInvalidQuota
+	}
+	if _, err := t.userKey(userID, key); err != nil {
+		return err
+	}
+	if err := t.repo.SetKeyQuota(key, q); err != nil {
+		t.ins.L.Err(err, "cannot set key quota", nil)
+		return err
+	}
+	if t.quotas != nil {
+		t.quotas.Forget(key, userID)
+	}
+	return nil
+}
+
+func (t *tokenAPI) SetUserQuota(userID ids.ID, q Quota) error {
+	if q.Daily < 0 || q.Monthly < 0 {
+		return ErrInvalidQuota
+	}
+	if err := t.repo.SetUserQuota(userID, q); err != nil {
+		t.ins.L.Err(err, "cannot set user quota", nil)
+		return err
+	}
+	if t.quotas != nil {
+		t.quotas.ForgetUser(userID)
+	}
+	return nil
+}
+
+func (t *tokenAPI) GetQuotas(userID ids.ID, key ids.ID) (Quota, Quota, error) {
+	if _, err := t.userKey(userID, key); err != nil {
+		return Quota{}, Quota{}, err
+	}
+	keyQ, userQ, err := t.repo.GetQuotas(userID, key)
+	if err != nil {
+		t.ins.L.Err(err, "cannot get quotas", nil)
+	}
+	return keyQ, userQ, err
+}
+
+func (t *tokenAPI) GetKeyUsage(userID ids.ID, key ids.ID, day time.Time) (Usage, error) {
+	if _, err := t.userKey(userID, key); err != nil {
+		return Usage{}, err
+	}
+	u, err := t.repo.GetKeyUsage(key, usageDay(day))
+	if err != nil {
+		t.ins.L.Err(err, "cannot get key usage", nil)
+	}
+	return u, err
+}
+
+func (t *tokenAPI) GetUserUsage(userID ids.ID, day time.Time) (Usage, error) {
+	u, err := t.repo.GetUserUsage(userID, usageDay(day))
+	if err != nil {
+		t.ins.L.Err(err, "cannot get user usage", nil)
+	}
+	return u, err
+}
@@ -0,0 +1,293 @@
+package tokenapi
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// memRepo is a minimal in memory Repo for the tests
+type memRepo struct {
+	*memQuotaRepo
+	keys map[ids.ID]APIKey
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		memQuotaRepo: newMemQuotaRepo(),
+		keys:         map[ids.ID]APIKey{},
+	}
+}
+
+func (r *memRepo) CreateKey(k *APIKey) error {
+	stored := *k
+	stored.Token = ""
+	r.keys[k.Key] = stored
+	return nil
+}
+
+func (r *memRepo) GetKey(key ids.ID) (*APIKey, error) {
+	k, ok := r.keys[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &k, nil
+}
+
+func (r *memRepo) ListKeys(userID ids.ID, state KeyState, from ids.ID,
+	limit int, backwards bool) ([]APIKey, error) {
+	now := time.Now()
+	res := []APIKey{}
+	for _, k := range r.keys {
+		if k.UserID != userID {
+			continue
+		}
+		if !from.IsZero() && ((!backwards && k.Key.ToUUID() <= from.ToUUID()) ||
+			(backwards && k.Key.ToUUID() >= from.ToUUID())) {
+			continue
+		}
+		deleted, expired := k.IsDeleted(now), !k.IsDeleted(now) && k.IsExpired(now)
+		switch state {
+		case KeyStateActive:
+			if deleted || expired {
+				continue
+			}
+		case KeyStateDeleted:
+			if !deleted {
+				continue
+			}
+		case KeyStateExpired:
+			if !expired {
+				continue
+			}
+		}
+		res = append(res, k)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key.ToUUID() < res[j].Key.ToUUID()
+	})
+	if limit > 0 && len(res) > limit {
+		if backwards {
+			res = res[len(res)-limit:]
+		} else {
+			res = res[:limit]
+		}
+	}
+	return res, nil
+}
+
+func (r *memRepo) DeleteKey(key ids.ID) error {
+	delete(r.keys, key)
+	return nil
+}
+
+func (r *memRepo) DeleteUserKey(userID ids.ID, key ids.ID) error {
+	if k, ok := r.keys[key]; ok && k.UserID == userID {
+		delete(r.keys, key)
+	}
+	return nil
+}
+
+func (r *memRepo) RotateKey(userID ids.ID, key ids.ID, successor *APIKey,
+	deleted time.Time) error {
+	k, ok := r.keys[key]
+	if !ok || k.UserID != userID || k.RotatedTo != nil {
+		return ErrNotFound
+	}
+	_ = r.CreateKey(successor)
+	k.Deleted = &deleted
+	k.RotatedTo = &successor.Key
+	r.keys[key] = k
+	return nil
+}
+
+func Test_ParseToken(t *testing.T) {
+	key := ids.NewIDGenerator().MustNew()
+	token := FormatToken(key, "abc_def")
+	if !strings.HasPrefix(token, TokenPrefix+"_") {
+		t.Errorf("want %s prefix, got %s", TokenPrefix, token)
+		return
+	}
+	gotKey, secret, err := ParseToken(token)
+	if err != nil || gotKey != key || secret != "abc_def" {
+		t.Errorf("want %s abc_def, got %s %s (%v)", key.ToShuffled(),
+			gotKey.ToShuffled(), secret, err)
+		return
+	}
+	for _, bad := range []string{"", key.ToShuffled(), "foo_" + key.ToShuffled() + "_s",
+		TokenPrefix + "_notakey_s", TokenPrefix + "_" + key.ToShuffled() + "_"} {
+		if _, _, err := ParseToken(bad); err != ErrInvalidKey {
+			t.Errorf("want ErrInvalidKey for %q, got %v", bad, err)
+			return
+		}
+	}
+}
+
+func Test_TokenAPI_CheckKey(t *testing.T) {
+	repo := newMemRepo()
+	tapi := NewTokenAPI(obs.InsighterFromContext(context.Background()), repo)
+	userID := ids.NewIDGenerator().MustNew()
+
+	if _, err := tapi.CreateKey(userID, "bad", []string{"bad scope"}, nil); err != ErrInvalidScope {
+		t.Errorf("want ErrInvalidScope, got %v", err)
+		return
+	}
+
+	k, err := tapi.CreateKey(userID, "test", []string{"keys:read"}, nil)
+	if err != nil {
+		t.Errorf("cannot create key: %s", err.Error())
+		return
+	}
+	if k.Token == "" || repo.keys[k.Key].SecretHash == "" ||
+		strings.Contains(k.Token, repo.keys[k.Key].SecretHash) {
+		t.Errorf("the token must be returned, and only its hash stored")
+		return
+	}
+
+	got, err := tapi.CheckKey(k.Token)
+	if err != nil || got.UserID != userID || !got.HasScope("keys:read") ||
+		got.HasScope("keys:write") {
+		t.Errorf("unexpected checked key %#v (%v)", got, err)
+		return
+	}
+
+	// a token with the same public key but another secret is rejected
+	wrong := FormatToken(k.Key, strings.Repeat("0", 64))
+	if _, err := tapi.CheckKey(wrong); err != ErrInvalidKey {
+		t.Errorf("want ErrInvalidKey, got %v", err)
+		return
+	}
+
+	expires := time.Now().Add(-time.Minute)
+	expired, _ := tapi.CreateKey(userID, "expired", nil, &expires)
+	if _, err := tapi.CheckKey(expired.Token); err != ErrExpired {
+		t.Errorf("want ErrExpired, got %v", err)
+		return
+	}
+
+	deleted := repo.keys[k.Key]
+	now := time.Now()
+	deleted.Deleted = &now
+	repo.keys[k.Key] = deleted
+	if _, err := tapi.CheckKey(k.Token); err != ErrInvalidKey {
+		t.Errorf("want ErrInvalidKey for deleted key, got %v", err)
+		return
+	}
+}
+
+func Test_TokenAPI_RotateKey(t *testing.T) {
+	repo := newMemRepo()
+	tapi := NewTokenAPI(obs.InsighterFromContext(context.Background()), repo)
+	userID := ids.NewIDGenerator().MustNew()
+	expires := time.Now().Add(time.Hour)
+	old, _ := tapi.CreateKey(userID, "ci", []string{"keys:read"}, &expires)
+
+	if _, err := tapi.RotateKey(userID, old.Key, -time.Second); err != ErrInvalidGrace {
+		t.Errorf("want ErrInvalidGrace, got %v", err)
+		return
+	}
+	if _, err := tapi.RotateKey(ids.NewIDGenerator().MustNew(), old.Key, time.Hour); err != ErrNotFound {
+		t.Errorf("want ErrNotFound for other user, got %v", err)
+		return
+	}
+
+	successor, err := tapi.RotateKey(userID, old.Key, time.Hour)
+	if err != nil {
+		t.Errorf("cannot rotate key: %s", err.Error())
+		return
+	}
+	if successor.Description != "ci" || !successor.HasScope("keys:read") ||
+		successor.Expires == nil || successor.RotatedFrom == nil ||
+		*successor.RotatedFrom != old.Key {
+		t.Errorf("unexpected successor %#v", successor)
+		return
+	}
+
+	// both keys are valid during the grace period
+	if _, err := tapi.CheckKey(old.Token); err != nil {
+		t.Errorf("old key should be valid during grace: %v", err)
+		return
+	}
+	if _, err := tapi.CheckKey(successor.Token); err != nil {
+		t.Errorf("successor should be valid: %v", err)
+		return
+	}
+	if _, err := tapi.RotateKey(userID, old.Key, time.Hour); err != ErrNotFound {
+		t.Errorf("want ErrNotFound rotating twice, got %v", err)
+		return
+	}
+
+	// after the grace period only the successor is valid
+	rotated := repo.keys[old.Key]
+	past := time.Now().Add(-time.Second)
+	rotated.Deleted = &past
+	repo.keys[old.Key] = rotated
+	if _, err := tapi.CheckKey(old.Token); err != ErrInvalidKey {
+		t.Errorf("want ErrInvalidKey after grace, got %v", err)
+		return
+	}
+}
+
+func Test_TokenAPI_ListKeys(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	repo := newMemRepo()
+	tapi := NewTokenAPI(ins, repo)
+	userID := ids.NewIDGenerator().MustNew()
+
+	keys := make([]ids.ID, 0, 4)
+	for i := 0; i < 4; i++ {
+		k, err := tapi.CreateKey(userID, "key", nil, nil)
+		if err != nil {
+			t.Errorf("cannot create key: %s", err)
+			return
+		}
+		keys = append(keys, k.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ToUUID() < keys[j].ToUUID()
+	})
+	past := time.Now().Add(-time.Second)
+	deleted := repo.keys[keys[1]]
+	deleted.Deleted = &past
+	repo.keys[keys[1]] = deleted
+	expired := repo.keys[keys[2]]
+	expired.Expires = &past
+	repo.keys[keys[2]] = expired
+
+	all, _ := tapi.ListKeys(userID, false)
+	active, _ := tapi.ListKeys(userID, true)
+	if len(all) != 4 || len(active) != 2 {
+		t.Errorf("want 4 keys and 2 active, got %d and %d", len(all), len(active))
+		return
+	}
+	for state, want := range map[KeyState]ids.ID{
+		KeyStateDeleted: keys[1],
+		KeyStateExpired: keys[2],
+	} {
+		got, err := tapi.ListKeysPage(userID, state, ids.ID{}, 0, false)
+		if err != nil || len(got) != 1 || got[0].Key != want {
+			t.Errorf("unexpected %s keys %#v (%v)", state, got, err)
+			return
+		}
+	}
+
+	page, _ := tapi.ListKeysPage(userID, KeyStateAll, keys[0], 2, false)
+	if len(page) != 2 || page[0].Key != keys[1] || page[1].Key != keys[2] {
+		t.Errorf("unexpected forward page %#v", page)
+		return
+	}
+	page, _ = tapi.ListKeysPage(userID, KeyStateAll, keys[3], 2, true)
+	if len(page) != 2 || page[0].Key != keys[1] || page[1].Key != keys[2] {
+		t.Errorf("unexpected backwards page %#v", page)
+		return
+	}
+	if _, err := tapi.ListKeysPage(userID, KeyState("bad"), ids.ID{}, 0, false); err != ErrInvalidState {
+		t.Errorf("want ErrInvalidState, got %v", err)
+		return
+	}
+}
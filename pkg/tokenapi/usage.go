@@ -0,0 +1,159 @@
+package tokenapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// DefaultUsageFlushInterval is how often the UsageTracker
+// writes the aggregated usage when no interval is given.
+const DefaultUsageFlushInterval = time.Minute
+
+// KeyUsage has the aggregated use of a key in a day.
+type KeyUsage struct {
+	Key    ids.ID
+	UserID ids.ID
+	// Day is the start of the UTC day of the requests
+	Day      time.Time
+	LastUsed time.Time
+	Requests int64
+}
+
+// UsageRepo defines the storage contract to record
+// the use of the api keys.
+type UsageRepo interface {
+	// AddUsage adds the requests and updates the last used
+	// time of a batch of keys, and adds the requests to the
+	// daily usage (kept for billing, even for deleted keys).
+	// The last used time of deleted keys is ignored.
+	AddUsage(usage []KeyUsage) error
+}
+
+// usageDay returns the start of the UTC day of a time.
+func usageDay(when time.Time) time.Time {
+	y, m, d := when.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// usageKey identifies the aggregated usage of a key in a day.
+type usageKey struct {
+	key ids.ID
+	day time.Time
+}
+
+// UsageTracker aggregates the use of the api keys in memory, and
+// writes it to the UsageRepo on an interval, so we do not write
+// to the database on every request.
+type UsageTracker struct {
+	ins  *obs.Insighter
+	repo UsageRepo
+
+	mu      sync.Mutex
+	pending map[usageKey]*KeyUsage
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewUsageTracker creates a UsageTracker and starts flushing the
+// usage on the given interval. Close must be called on shutdown
+// to write the pending usage.
+func NewUsageTracker(ins *obs.Insighter, repo UsageRepo,
+	interval time.Duration) *UsageTracker {
+
+	if interval <= 0 {
+		interval = DefaultUsageFlushInterval
+	}
+	t := &UsageTracker{
+		ins:     ins,
+		repo:    repo,
+		pending: make(map[usageKey]*KeyUsage),
+		done:    make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run(interval)
+	return t
+}
+
+func (t *UsageTracker) run(interval time.Duration) {
+	defer t.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = t.Flush()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Track records a use of a key.
+func (t *UsageTracker) Track(key ids.ID, userID ids.ID, when time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(KeyUsage{
+		Key:      key,
+		UserID:   userID,
+		Day:      usageDay(when),
+		LastUsed: when,
+		Requests: 1,
+	})
+}
+
+func (t *UsageTracker) add(usage KeyUsage) {
+	uk := usageKey{key: usage.Key, day: usage.Day}
+	u, ok := t.pending[uk]
+	if !ok {
+		t.pending[uk] = &usage
+		return
+	}
+	when, requests := usage.LastUsed, usage.Requests
+	if when.After(u.LastUsed) {
+		u.LastUsed = when
+	}
+	u.Requests += requests
+}
+
+// Flush writes the aggregated usage. If it fails, the usage
+// is kept to be written in the next flush.
+func (t *UsageTracker) Flush() error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[usageKey]*KeyUsage)
+	t.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	batch := make([]KeyUsage, 0, len(pending))
+	for _, u := range pending {
+		batch = append(batch, *u)
+	}
+	if err := t.repo.AddUsage(batch); err != nil {
+		t.ins.L.Err(err, "cannot flush api keys usage", map[string]interface{}{
+			"keys": len(batch),
+		})
+		t.mu.Lock()
+		for _, u := range batch {
+			t.add(u)
+		}
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close stops the periodic flush and writes the pending usage.
+func (t *UsageTracker) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+	t.wg.Wait()
+	return t.Flush()
+}
@@ -0,0 +1,88 @@
+package tokenapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+type memUsageRepo struct {
+	mu      sync.Mutex
+	fail    bool
+	batches [][]KeyUsage
+}
+
+func (r *memUsageRepo) AddUsage(usage []KeyUsage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		return errors.New("db down")
+	}
+	r.batches = append(r.batches, usage)
+	return nil
+}
+
+func Test_UsageTracker(t *testing.T) {
+	repo := &memUsageRepo{}
+	ins := obs.InsighterFromContext(context.Background())
+	// a long interval, so only the explicit flushes write
+	tracker := NewUsageTracker(ins, repo, time.Hour)
+
+	first := ids.NewIDGenerator().MustNew()
+	second := ids.NewIDGenerator().MustNew()
+	userID := ids.NewIDGenerator().MustNew()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker.Track(first, userID, t0.Add(time.Second))
+	tracker.Track(first, userID, t0)
+	tracker.Track(second, userID, t0)
+
+	// a failed flush keeps the usage for the next one
+	repo.fail = true
+	if err := tracker.Flush(); err == nil {
+		t.Errorf("expected flush error")
+		return
+	}
+	repo.fail = false
+	tracker.Track(first, userID, t0.Add(2*time.Second))
+
+	if err := tracker.Flush(); err != nil {
+		t.Errorf("cannot flush: %s", err.Error())
+		return
+	}
+	if len(repo.batches) != 1 || len(repo.batches[0]) != 2 {
+		t.Errorf("want a batch with 2 keys, got %#v", repo.batches)
+		return
+	}
+	for _, u := range repo.batches[0] {
+		if u.UserID != userID || !u.Day.Equal(t0) {
+			t.Errorf("unexpected usage user or day: %#v", u)
+			return
+		}
+		if u.Key == first && (u.Requests != 3 || !u.LastUsed.Equal(t0.Add(2*time.Second))) {
+			t.Errorf("unexpected usage for first key: %#v", u)
+			return
+		}
+		if u.Key == second && u.Requests != 1 {
+			t.Errorf("unexpected usage for second key: %#v", u)
+			return
+		}
+	}
+
+	// closing writes the pending usage, by day
+	tracker.Track(second, userID, t0)
+	tracker.Track(second, userID, t0.Add(25*time.Hour))
+	if err := tracker.Close(); err != nil {
+		t.Errorf("cannot close: %s", err.Error())
+		return
+	}
+	if len(repo.batches) != 2 || len(repo.batches[1]) != 2 ||
+		repo.batches[1][0].Key != second || repo.batches[1][1].Key != second {
+		t.Errorf("want pending usage written on close, got %#v", repo.batches)
+		return
+	}
+}
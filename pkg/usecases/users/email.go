@@ -1,20 +1,24 @@
 package users
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dhontecillas/hfw/pkg/ids"
 	"github.com/dhontecillas/hfw/pkg/mailer"
 	"github.com/dhontecillas/hfw/pkg/notifications"
 	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/outbox"
 )
 
 // Notification template names
 const (
 	NotifRequestRegistration  string = "users_requestregistration"
 	NotifRequestPasswordReset string = "users_requestpasswordreset"
-
-	carrierEmail = "email"
+	NotifRequestLoginLink     string = "users_requestloginlink"
+	NotifRequestEmailChange   string = "users_requestemailchange"
+	NotifEmailChangeNotice    string = "users_emailchangenotice"
 )
 
 // RegistrationRepo defines the data access interface to
@@ -53,6 +57,51 @@ type RegistrationRepo interface {
 
 	// ListUsers lists users with pagination
 	ListUsers(from ids.ID, limit int, backwards bool) ([]User, error)
+
+	// GetTOTP returns the second factor configuration for a user,
+	// or nil if the user has not started an enrollment.
+	GetTOTP(userID ids.ID) (*TOTPConf, error)
+
+	// SetTOTPSecret stores a new, not yet enabled, secret for a
+	// user replacing any previous one.
+	SetTOTPSecret(userID ids.ID, secret string) error
+
+	// EnableTOTP enables the second factor for a user, storing
+	// the already used step and the hashes of the recovery codes.
+	EnableTOTP(userID ids.ID, step int64, recoveryCodeHashes []string) error
+
+	// DisableTOTP removes the second factor and the recovery codes.
+	DisableTOTP(userID ids.ID) error
+
+	// UseTOTPStep records the last used step, returning ErrConsumed
+	// if the same or a later step has already been used.
+	UseTOTPStep(userID ids.ID, step int64) error
+
+	// ConsumeRecoveryCode marks a recovery code as used, returning
+	// ErrNotFound if it does not exist or has already been used.
+	ConsumeRecoveryCode(userID ids.ID, codeHash string) error
+
+	// CreateLoginLink returns a single use token to log in a user
+	// without password, that expires after the given ttl.
+	CreateLoginLink(email string, ttl time.Duration) (*User, string, error)
+
+	// ConsumeLoginLink returns the user for a login link token,
+	// marking it as used.
+	ConsumeLoginLink(token string) (*User, error)
+
+	// CreateEmailChangeRequest stores a request to change the email
+	// of a user, returning the token to confirm it (to be sent to the
+	// new address) and the token to revert it (to be sent to the old
+	// one). Returns ErrUserExists if the new email is already in use.
+	CreateEmailChangeRequest(userID ids.ID, newEmail string,
+		ttl time.Duration, revertTTL time.Duration) (*EmailChange, error)
+
+	// ConfirmEmailChange applies the email change for a confirm token.
+	ConfirmEmailChange(confirmToken string) (*EmailChange, error)
+
+	// RevertEmailChange cancels a pending email change, or restores
+	// the old email if it was already confirmed.
+	RevertEmailChange(revertToken string) (*EmailChange, error)
 }
 
 // HostInfo contains the required info to construct
@@ -65,73 +114,59 @@ type HostInfo struct {
 	Host              string // includes port
 	ActivationPath    string // activation path where the handler has been installed
 	ResetPasswordPath string // reset pass path where the handler has been installed
+	LoginLinkPath     string // login link path where the handler has been installed
+	EmailChangePath   string // confirm email change path where the handler has been installed
+	EmailRevertPath   string // revert email change path where the handler has been installed
 }
 
 // EmailRegistration is the controller to handle
 // an email registration flow
 type EmailRegistration struct {
-	ins        *obs.Insighter
-	composer   notifications.Composer
-	mailSender mailer.Mailer
-	regRepo    RegistrationRepo
-	hostInfo   HostInfo
+	ins          *obs.Insighter
+	composer     notifications.Composer
+	regRepo      RegistrationRepo
+	emailCarrier *notifications.EmailCarrier
+	hostInfo     HostInfo
+	throttler    *LoginThrottler
+	sessions     *SessionRegistry
+	outbox       *outbox.Outbox
+	txRepo       TxRegistrationRepo
 }
 
 // NewEmailRegistration creates a new EmailRegistration
-// controller
+// controller. The throttler can be nil to disable the
+// account lockout on failed logins, and sessions can be nil
+// to not log out the user everywhere when the password is reset.
 func NewEmailRegistration(
 	ins *obs.Insighter,
 	composer notifications.Composer,
 	mailSender mailer.Mailer,
 	regRepo RegistrationRepo,
-	hostInfo HostInfo) *EmailRegistration {
+	hostInfo HostInfo,
+	throttler *LoginThrottler,
+	sessions *SessionRegistry) *EmailRegistration {
 	return &EmailRegistration{
-		ins:        ins,
-		composer:   composer,
-		mailSender: mailSender,
-		regRepo:    regRepo,
-		hostInfo:   hostInfo,
+		ins:          ins,
+		composer:     composer,
+		regRepo:      regRepo,
+		emailCarrier: notifications.NewEmailCarrier(mailSender),
+		hostInfo:     hostInfo,
+		throttler:    throttler,
+		sessions:     sessions,
 	}
 }
 
 func (r *EmailRegistration) sendMail(email string, notification string,
 	data map[string]interface{}) error {
 
-	content, err := r.composer.Render(notification, data, carrierEmail)
+	content, err := r.composer.Render(notification, data, notifications.CarrierEmail)
 	if err != nil {
 		// TODO: wrap the error here
 		return err
 	}
 
-	subject, ok := content.Texts["subject"]
-	if !ok {
-		return fmt.Errorf("missing 'subject' content")
-	}
-	textBody, ok := content.Texts["content"]
-	if !ok {
-		return fmt.Errorf("missing 'text body' content")
-	}
-	htmlBody, ok := content.HTMLs["content"]
-	if !ok {
-		return fmt.Errorf("missing 'html body' content")
-	}
-
-	fromName, fromAddress := r.mailSender.Sender()
-	emailMessage := mailer.Email{
-		To: mailer.User{
-			Name:    email,
-			Address: email,
-		},
-		From: mailer.User{
-			Name:    fromName,
-			Address: fromAddress,
-		},
-		Subject: subject,
-		HTML:    htmlBody,
-		Text:    textBody,
-	}
-
-	if sendEmailErr := r.mailSender.Send(emailMessage); err != nil {
+	to := &notifications.Recipient{Email: email}
+	if sendEmailErr := r.emailCarrier.Deliver(to, notification, content); sendEmailErr != nil {
 		r.ins.L.Err(sendEmailErr, "cannot send registration message", nil)
 		return fmt.Errorf("%w %s", ErrNotificationFailed, sendEmailErr)
 	}
@@ -141,22 +176,27 @@ func (r *EmailRegistration) sendMail(email string, notification string,
 // Register creates an inactive user, and send a notification (email),
 // with the activation link.
 func (r *EmailRegistration) Register(email string, password string) error {
-	token, e := r.regRepo.CreateInactiveUser(email, password)
-	if e != nil {
-		// TODO: if the user is already in the database, we might have
-		// the issue that is in activation pending, because it failed
-		// to send the notification, so, in that case, we could retry
-		// to send an activation link to the email.
-		r.ins.L.Err(e, "cannot create innactive user", nil)
-		return fmt.Errorf("cannot create innactive user: %w", e)
-	}
-
-	return r.sendMail(email, NotifRequestRegistration, map[string]interface{}{
-		"to_address":       email,
-		"activation_token": token,
-		"scheme":           r.hostInfo.Scheme,
-		"host":             r.hostInfo.Host,
-		"path":             r.hostInfo.ActivationPath,
+	return r.withMails(func(repo RegistrationRepo) ([]pendingMail, error) {
+		token, e := repo.CreateInactiveUser(email, password)
+		if e != nil {
+			// TODO: if the user is already in the database, we might have
+			// the issue that is in activation pending, because it failed
+			// to send the notification, so, in that case, we could retry
+			// to send an activation link to the email.
+			r.ins.L.Err(e, "cannot create innactive user", nil)
+			return nil, fmt.Errorf("cannot create innactive user: %w", e)
+		}
+		return []pendingMail{{
+			to:           email,
+			notification: NotifRequestRegistration,
+			data: map[string]interface{}{
+				"to_address":       email,
+				"activation_token": token,
+				"scheme":           r.hostInfo.Scheme,
+				"host":             r.hostInfo.Host,
+				"path":             r.hostInfo.ActivationPath,
+			},
+		}}, nil
 	})
 }
 
@@ -172,34 +212,83 @@ func (r *EmailRegistration) Activate(token string) error {
 // RequestResetPassword creates a temporal reset token and sends
 // it to the user email, so it can reset the password.
 func (r *EmailRegistration) RequestResetPassword(email string) error {
-	u, token, err := r.regRepo.CreatePasswordResetRequest(email)
-	if err != nil {
-		return err
-	}
-
-	return r.sendMail(u.Email, NotifRequestPasswordReset,
-		map[string]interface{}{
-			"to_address": u.Email,
-			"token":      token,
-			"scheme":     r.hostInfo.Scheme,
-			"host":       r.hostInfo.Host,
-			"path":       r.hostInfo.ResetPasswordPath,
-		})
+	return r.withMails(func(repo RegistrationRepo) ([]pendingMail, error) {
+		u, token, err := repo.CreatePasswordResetRequest(email)
+		if err != nil {
+			return nil, err
+		}
+		return []pendingMail{{
+			to:           u.Email,
+			notification: NotifRequestPasswordReset,
+			data: map[string]interface{}{
+				"to_address": u.Email,
+				"token":      token,
+				"scheme":     r.hostInfo.Scheme,
+				"host":       r.hostInfo.Host,
+				"path":       r.hostInfo.ResetPasswordPath,
+			},
+		}}, nil
+	})
 }
 
 // ResetPasswordWithToken sets a new password for a given user using a
-// reset password token
+// reset password token, and revokes all the sessions of the user.
 func (r *EmailRegistration) ResetPasswordWithToken(token string, newPassword string) error {
-	_, err := r.regRepo.ResetPassword(token, newPassword)
+	u, err := r.regRepo.ResetPassword(token, newPassword)
 	if err != nil {
 		return err
 	}
+	r.revokeAllSessions(u.ID)
 	return nil
 }
 
-// Login check if a user email and password are correct.
-func (r *EmailRegistration) Login(email string, password string) (ids.ID, error) {
-	return r.regRepo.CheckPassword(email, password)
+// revokeAllSessions logs out a user everywhere after a change
+// that might indicate the account was compromised
+func (r *EmailRegistration) revokeAllSessions(userID ids.ID) {
+	if r.sessions == nil {
+		return
+	}
+	if err := r.sessions.RevokeAll(userID); err != nil {
+		r.ins.L.Err(err, "cannot revoke sessions", map[string]interface{}{
+			"user_id": userID.ToUUID(),
+		})
+	}
+}
+
+// Login check if a user email and password are correct. Failed
+// attempts are tracked by email and client IP, and once the maximum
+// number of failures is reached, an ErrLocked error is returned.
+func (r *EmailRegistration) Login(email string, password string,
+	clientIP string) (ids.ID, error) {
+
+	if r.throttler == nil {
+		return r.regRepo.CheckPassword(email, password)
+	}
+
+	var userID ids.ID
+	if err := r.throttler.Check(email, clientIP); err != nil {
+		r.ins.L.Warn("login locked", map[string]interface{}{
+			"email":     email,
+			"client_ip": clientIP,
+			"error":     err.Error(),
+		})
+		return userID, err
+	}
+
+	userID, err := r.regRepo.CheckPassword(email, password)
+	if err != nil {
+		if errors.Is(err, ErrWrongPassword) || errors.Is(err, ErrNotFound) {
+			if tErr := r.throttler.Failed(email, clientIP); tErr != nil {
+				r.ins.L.Err(tErr, "cannot record failed login", nil)
+			}
+		}
+		return userID, err
+	}
+
+	if tErr := r.throttler.Succeeded(email); tErr != nil {
+		r.ins.L.Err(tErr, "cannot clear failed logins", nil)
+	}
+	return userID, nil
 }
 
 // GetUser returns a user given its ID
@@ -210,3 +299,19 @@ func (r *EmailRegistration) GetUser(userID ids.ID) (*User, error) {
 	}
 	return u, nil
 }
+
+var _ notifications.RecipientResolver = (*EmailRegistration)(nil)
+
+// Recipient returns the notifications.Recipient of a user, with
+// its email address, so the EmailRegistration can be used as the
+// notifications.RecipientResolver of a notifications.Dispatcher.
+func (r *EmailRegistration) Recipient(userID ids.ID) (*notifications.Recipient, error) {
+	u, err := r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &notifications.Recipient{
+		UserID: u.ID,
+		Email:  u.Email,
+	}, nil
+}
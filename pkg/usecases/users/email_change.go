@@ -0,0 +1,86 @@
+package users
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// Validity of the email change links
+const (
+	EmailChangeTTL       = 24 * time.Hour
+	EmailChangeRevertTTL = 7 * 24 * time.Hour
+)
+
+// RequestEmailChange sends a confirmation link to the new email
+// address, and a notice with a link to revert the change to the
+// current one.
+func (r *EmailRegistration) RequestEmailChange(userID ids.ID, newEmail string) error {
+	newEmail = strings.TrimSpace(newEmail)
+	if !strings.Contains(newEmail, "@") {
+		return ErrInvalidEmail
+	}
+	return r.withMails(func(repo RegistrationRepo) ([]pendingMail, error) {
+		ec, err := repo.CreateEmailChangeRequest(userID, newEmail,
+			EmailChangeTTL, EmailChangeRevertTTL)
+		if err != nil {
+			return nil, err
+		}
+		return []pendingMail{
+			{
+				to:           ec.NewEmail,
+				notification: NotifRequestEmailChange,
+				data: map[string]interface{}{
+					"to_address": ec.NewEmail,
+					"old_email":  ec.OldEmail,
+					"token":      ec.ConfirmToken,
+					"scheme":     r.hostInfo.Scheme,
+					"host":       r.hostInfo.Host,
+					"path":       r.hostInfo.EmailChangePath,
+				},
+			},
+			{
+				to:           ec.OldEmail,
+				notification: NotifEmailChangeNotice,
+				data: map[string]interface{}{
+					"to_address": ec.OldEmail,
+					"new_email":  ec.NewEmail,
+					"token":      ec.RevertToken,
+					"scheme":     r.hostInfo.Scheme,
+					"host":       r.hostInfo.Host,
+					"path":       r.hostInfo.EmailRevertPath,
+				},
+			},
+		}, nil
+	})
+}
+
+// ConfirmEmailChange applies an email change with the token
+// sent to the new address.
+func (r *EmailRegistration) ConfirmEmailChange(token string) error {
+	ec, err := r.regRepo.ConfirmEmailChange(token)
+	if err != nil {
+		return err
+	}
+	r.ins.L.Info("email changed", map[string]interface{}{
+		"user_id": ec.UserID.ToUUID(),
+	})
+	return nil
+}
+
+// RevertEmailChange cancels an email change (or restores the old
+// email if it has already been applied) with the token sent to the
+// old address, and revokes all the sessions of the user.
+func (r *EmailRegistration) RevertEmailChange(token string) error {
+	ec, err := r.regRepo.RevertEmailChange(token)
+	if err != nil {
+		return err
+	}
+	r.ins.L.Warn("email change reverted", map[string]interface{}{
+		"user_id": ec.UserID.ToUUID(),
+	})
+	// the change might have been requested from a stolen session
+	r.revokeAllSessions(ec.UserID)
+	return nil
+}
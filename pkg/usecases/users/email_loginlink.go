@@ -0,0 +1,50 @@
+package users
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// LoginLinkTTL is the time a login link can be used.
+const LoginLinkTTL = 15 * time.Minute
+
+// RequestLoginLink creates a single use login token and sends
+// it to the user email, so it can log in without password.
+func (r *EmailRegistration) RequestLoginLink(email string) error {
+	return r.withMails(func(repo RegistrationRepo) ([]pendingMail, error) {
+		u, token, err := repo.CreateLoginLink(email, LoginLinkTTL)
+		if err != nil {
+			return nil, err
+		}
+		return []pendingMail{{
+			to:           u.Email,
+			notification: NotifRequestLoginLink,
+			data: map[string]interface{}{
+				"to_address":  u.Email,
+				"token":       token,
+				"scheme":      r.hostInfo.Scheme,
+				"host":        r.hostInfo.Host,
+				"path":        r.hostInfo.LoginLinkPath,
+				"ttl_minutes": int(LoginLinkTTL.Minutes()),
+			},
+		}}, nil
+	})
+}
+
+// LoginWithLink returns the user ID for a login link token. The
+// token can only be used once.
+func (r *EmailRegistration) LoginWithLink(token string) (ids.ID, error) {
+	var userID ids.ID
+	u, err := r.regRepo.ConsumeLoginLink(token)
+	if err != nil {
+		return userID, err
+	}
+	if r.throttler != nil {
+		// a successful login also resets the failed password attempts
+		if tErr := r.throttler.Succeeded(u.Email); tErr != nil {
+			r.ins.L.Err(tErr, "cannot clear failed logins", nil)
+		}
+	}
+	return u.ID, nil
+}
@@ -0,0 +1,80 @@
+package users
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"github.com/dhontecillas/hfw/pkg/notifications"
+	"github.com/dhontecillas/hfw/pkg/outbox"
+)
+
+// TxRegistrationRepo is a RegistrationRepo whose operations can
+// run in a transaction shared with other repos.
+type TxRegistrationRepo interface {
+	RegistrationRepo
+
+	// WithTx returns a RegistrationRepo that runs its operations
+	// in the given transaction, without committing it.
+	WithTx(tx *sqlx.Tx) RegistrationRepo
+}
+
+// pendingMail is an email to send after a registration operation
+type pendingMail struct {
+	to           string
+	notification string
+	data         map[string]interface{}
+}
+
+// UseOutbox makes the controller write its emails to an outbox in
+// the same transaction of the change that requires them, instead
+// of sending them right after the change is committed. This way,
+// a user is not left without its activation link if the mailer
+// fails: the outbox.Dispatcher retries it. The regRepo must
+// implement TxRegistrationRepo, and use the same database as the
+// outbox.
+func (r *EmailRegistration) UseOutbox(ob *outbox.Outbox) error {
+	txRepo, ok := r.regRepo.(TxRegistrationRepo)
+	if !ok {
+		return ErrNoTxRepo
+	}
+	r.outbox = ob
+	r.txRepo = txRepo
+	return nil
+}
+
+// withMails runs a registration operation and sends the emails
+// it returns. With an outbox, the emails are stored in the same
+// transaction of the operation, that is rolled back if it fails.
+func (r *EmailRegistration) withMails(
+	op func(repo RegistrationRepo) ([]pendingMail, error)) error {
+
+	if r.outbox == nil {
+		mails, err := op(r.regRepo)
+		if err != nil {
+			return err
+		}
+		for _, m := range mails {
+			if err := r.sendMail(m.to, m.notification, m.data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return r.outbox.Transact(func(tx *sqlx.Tx) error {
+		mails, err := op(r.txRepo.WithTx(tx))
+		if err != nil {
+			return err
+		}
+		for _, m := range mails {
+			if err := r.outbox.Add(tx, &outbox.Message{
+				Carrier:      notifications.CarrierEmail,
+				To:           m.to,
+				Notification: m.notification,
+				Data:         m.data,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
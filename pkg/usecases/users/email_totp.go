@@ -0,0 +1,153 @@
+package users
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// HasTOTP tells if a user has the TOTP second factor enabled.
+func (r *EmailRegistration) HasTOTP(userID ids.ID) (bool, error) {
+	conf, err := r.regRepo.GetTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	return conf != nil && conf.Enabled, nil
+}
+
+// EnrollTOTP creates a new secret for the user, that will not be
+// used until it is confirmed with a valid code with ConfirmTOTP.
+func (r *EmailRegistration) EnrollTOTP(userID ids.ID, issuer string) (*TOTPEnrollment, error) {
+	u := r.regRepo.GetUserByID(userID)
+	if u == nil {
+		return nil, ErrNotFound
+	}
+	enabled, err := r.HasTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		r.ins.L.Err(err, "cannot create totp secret", nil)
+		return nil, err
+	}
+	if err := r.regRepo.SetTOTPSecret(userID, secret); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(issuer, u.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the second factor once the user proves that the
+// authenticator app has been set up, and returns the recovery codes
+// (that are only shown once).
+func (r *EmailRegistration) ConfirmTOTP(userID ids.ID, code string) ([]string, error) {
+	conf, err := r.regRepo.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		return nil, ErrNotFound
+	}
+	if conf.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	step, ok := verifyTOTP(conf.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		r.ins.L.Err(err, "cannot create recovery codes", nil)
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+	if err := r.regRepo.EnableTOTP(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code, or a recovery code, for a
+// user that has the second factor enabled. Each code can only be
+// used once. The failed codes are tracked per user, and once the
+// maximum number of failures is reached, an ErrLocked error is
+// returned (also for the following attempts, until the lock expires).
+func (r *EmailRegistration) VerifySecondFactor(userID ids.ID, code string) error {
+	if r.throttler != nil {
+		if err := r.throttler.CheckSecondFactor(userID); err != nil {
+			r.ins.L.Warn("second factor locked", map[string]interface{}{
+				"user_id": userID.ToUUID(),
+				"error":   err.Error(),
+			})
+			return err
+		}
+	}
+	err := r.verifySecondFactor(userID, code)
+	if r.throttler == nil {
+		return err
+	}
+	if errors.Is(err, ErrInvalidCode) {
+		if tErr := r.throttler.FailedSecondFactor(userID); tErr != nil {
+			if errors.Is(tErr, ErrLocked) {
+				return tErr
+			}
+			r.ins.L.Err(tErr, "cannot record failed second factor", nil)
+		}
+		return err
+	}
+	if err == nil {
+		if tErr := r.throttler.SucceededSecondFactor(userID); tErr != nil {
+			r.ins.L.Err(tErr, "cannot clear failed second factors", nil)
+		}
+	}
+	return err
+}
+
+func (r *EmailRegistration) verifySecondFactor(userID ids.ID, code string) error {
+	conf, err := r.regRepo.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if conf == nil || !conf.Enabled {
+		return ErrTOTPNotEnabled
+	}
+
+	if step, ok := verifyTOTP(conf.Secret, code, time.Now()); ok {
+		if step <= conf.LastStep {
+			return ErrInvalidCode
+		}
+		if err := r.regRepo.UseTOTPStep(userID, step); err != nil {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	if err := r.regRepo.ConsumeRecoveryCode(userID, hashRecoveryCode(code)); err != nil {
+		r.ins.L.Warn("invalid second factor code", map[string]interface{}{
+			"user_id": userID.ToUUID(),
+		})
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// DisableTOTP removes the second factor for a user, after checking
+// a valid TOTP or recovery code.
+func (r *EmailRegistration) DisableTOTP(userID ids.ID, code string) error {
+	if err := r.VerifySecondFactor(userID, code); err != nil {
+		return err
+	}
+	return r.regRepo.DisableTOTP(userID)
+}
@@ -17,3 +17,16 @@ type User struct {
 func (u *User) String() string {
 	return fmt.Sprintf("%s (%d) -> %s", u.ID.ToUUID(), u.Created.UnixNano(), u.Email)
 }
+
+// EmailChange is a request to change the email of a user. The
+// tokens are only set when the request is created.
+type EmailChange struct {
+	UserID       ids.ID
+	OldEmail     string
+	NewEmail     string
+	ConfirmToken string
+	RevertToken  string
+	Requested    time.Time
+	Confirmed    time.Time
+	Reverted     time.Time
+}
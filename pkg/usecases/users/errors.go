@@ -12,5 +12,21 @@ const (
 	ErrConsumed   = consterr.ConstErr("ErrConsumed")
 	ErrExpired    = consterr.ConstErr("ErrExpired")
 
+	ErrWrongPassword = consterr.ConstErr("ErrWrongPassword")
+	ErrLocked        = consterr.ConstErr("ErrLocked")
+
+	ErrInvalidCode        = consterr.ConstErr("ErrInvalidCode")
+	ErrTOTPNotEnabled     = consterr.ConstErr("ErrTOTPNotEnabled")
+	ErrTOTPAlreadyEnabled = consterr.ConstErr("ErrTOTPAlreadyEnabled")
+
+	ErrUnverifiedEmail = consterr.ConstErr("ErrUnverifiedEmail")
+	ErrInvalidEmail    = consterr.ConstErr("ErrInvalidEmail")
+
+	ErrSessionRevoked = consterr.ConstErr("ErrSessionRevoked")
+
+	ErrRoleExists  = consterr.ConstErr("ErrRoleExists")
+	ErrInvalidName = consterr.ConstErr("ErrInvalidName")
+
 	ErrNotificationFailed = consterr.ConstErr("ErrNotificationFailed")
+	ErrNoTxRepo           = consterr.ConstErr("ErrNoTxRepo")
 )
@@ -0,0 +1,109 @@
+package users
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// Identity links a user to an account in an external
+// identity provider (like an OpenID Connect one).
+type Identity struct {
+	UserID   ids.ID
+	Provider string
+	Subject  string
+	Email    string
+	Created  time.Time
+}
+
+// IdentitiesRepo defines the data access interface to
+// store the external identities of the users.
+type IdentitiesRepo interface {
+	// GetIdentity returns the identity for a provider subject,
+	// or nil if it has not been linked to any user.
+	GetIdentity(provider string, subject string) (*Identity, error)
+
+	// LinkIdentity links a provider subject to an existing user.
+	LinkIdentity(userID ids.ID, provider string, subject string, email string) error
+
+	// CreateUserWithIdentity creates an active user without password,
+	// and links it to the provider subject. Returns ErrUserExists if
+	// there is already a user with that email.
+	CreateUserWithIdentity(email string, provider string, subject string) (*User, error)
+
+	// ListIdentities returns the identities linked to a user.
+	ListIdentities(userID ids.ID) ([]Identity, error)
+}
+
+// ExternalLogin is the controller to log in users that have
+// been authenticated by an external identity provider.
+type ExternalLogin struct {
+	ins     *obs.Insighter
+	regRepo RegistrationRepo
+	idRepo  IdentitiesRepo
+}
+
+// NewExternalLogin creates a new ExternalLogin controller.
+func NewExternalLogin(ins *obs.Insighter, regRepo RegistrationRepo,
+	idRepo IdentitiesRepo) *ExternalLogin {
+	return &ExternalLogin{
+		ins:     ins,
+		regRepo: regRepo,
+		idRepo:  idRepo,
+	}
+}
+
+// Login returns the user linked to the provider subject. The first
+// time a subject is seen, it is linked to the user with the same email
+// (only if the provider has verified that email), or a new user is
+// created.
+func (e *ExternalLogin) Login(provider string, subject string,
+	email string, emailVerified bool) (*User, error) {
+
+	idt, err := e.idRepo.GetIdentity(provider, subject)
+	if err != nil {
+		return nil, err
+	}
+	if idt != nil {
+		u := e.regRepo.GetUserByID(idt.UserID)
+		if u == nil {
+			return nil, ErrNotFound
+		}
+		return u, nil
+	}
+
+	email = strings.TrimSpace(email)
+	if email == "" || !emailVerified {
+		// linking an unverified email would allow anyone to take
+		// over an account just by creating it in the provider
+		return nil, ErrUnverifiedEmail
+	}
+
+	u := e.regRepo.GetUserByEmail(email)
+	if u != nil {
+		if err := e.idRepo.LinkIdentity(u.ID, provider, subject, email); err != nil {
+			e.ins.L.Err(err, "cannot link identity", map[string]interface{}{
+				"provider": provider,
+				"user_id":  u.ID.ToUUID(),
+			})
+			return nil, err
+		}
+		return u, nil
+	}
+
+	u, err = e.idRepo.CreateUserWithIdentity(email, provider, subject)
+	if err != nil {
+		e.ins.L.Err(err, "cannot create user with identity", map[string]interface{}{
+			"provider": provider,
+		})
+		return nil, err
+	}
+	return u, nil
+}
+
+// Identities returns the external identities linked to a user.
+func (e *ExternalLogin) Identities(userID ids.ID) ([]Identity, error) {
+	return e.idRepo.ListIdentities(userID)
+}
@@ -0,0 +1,252 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// Default values for the login throttling
+const (
+	DefaultMaxEmailFailures = 5
+	DefaultMaxIPFailures    = 20
+	DefaultMaxTOTPFailures  = 5
+	DefaultBaseLockout      = time.Minute
+	DefaultMaxLockout       = 24 * time.Hour
+	DefaultFailuresWindow   = time.Hour
+
+	attemptKeyEmailPrefix = "email:"
+	attemptKeyIPPrefix    = "ip:"
+	attemptKeyTOTPPrefix  = "totp:"
+)
+
+// LockoutConf contains the parameters to throttle failed login
+// attempts. Zero values are replaced with the defaults.
+type LockoutConf struct {
+	// MaxEmailFailures is the number of failed attempts for
+	// an email before the account is locked.
+	MaxEmailFailures int
+	// MaxIPFailures is the number of failed attempts from
+	// a client IP before any login from that IP is locked.
+	MaxIPFailures int
+	// MaxTOTPFailures is the number of failed second factor codes
+	// for a user before the second factor is locked.
+	MaxTOTPFailures int
+	// BaseLockout is the duration of the first lock, each
+	// consecutive lock doubles the previous duration.
+	BaseLockout time.Duration
+	// MaxLockout is the upper limit for a lock duration.
+	MaxLockout time.Duration
+	// FailuresWindow is the time after the last failure, or
+	// after the end of the last lock, to forget about the
+	// previous failures and locks.
+	FailuresWindow time.Duration
+}
+
+// Validate sets the default values for the missing fields.
+func (c *LockoutConf) Validate() error {
+	if c.MaxEmailFailures <= 0 {
+		c.MaxEmailFailures = DefaultMaxEmailFailures
+	}
+	if c.MaxIPFailures <= 0 {
+		c.MaxIPFailures = DefaultMaxIPFailures
+	}
+	if c.MaxTOTPFailures <= 0 {
+		c.MaxTOTPFailures = DefaultMaxTOTPFailures
+	}
+	if c.BaseLockout <= 0 {
+		c.BaseLockout = DefaultBaseLockout
+	}
+	if c.MaxLockout <= 0 {
+		c.MaxLockout = DefaultMaxLockout
+	}
+	if c.MaxLockout < c.BaseLockout {
+		return fmt.Errorf("max lockout %s lower than base lockout %s",
+			c.MaxLockout, c.BaseLockout)
+	}
+	if c.FailuresWindow <= 0 {
+		c.FailuresWindow = DefaultFailuresWindow
+	}
+	return nil
+}
+
+// LoginAttempt contains the record of failed logins for
+// a given key (an email, a client IP, or the second factor
+// of a user).
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	Lockouts    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// LoginAttemptsRepo defines the data access interface to keep
+// track of failed login attempts.
+type LoginAttemptsRepo interface {
+	// GetLoginAttempt returns the stored attempts for a key, or
+	// nil if there are no failed attempts recorded.
+	GetLoginAttempt(key string) (*LoginAttempt, error)
+
+	// UpdateLoginAttempt applies update to the attempts for a key
+	// (an empty one when there are none) and stores the result,
+	// atomically, so concurrent failures are not lost.
+	UpdateLoginAttempt(key string, update func(a *LoginAttempt)) (*LoginAttempt, error)
+
+	// ClearLoginAttempt removes the recorded attempts for a key.
+	ClearLoginAttempt(key string) error
+}
+
+// LoginThrottler keeps track of failed logins per email and per
+// client IP, and of failed second factor codes per user, locking
+// them with an exponential backoff.
+type LoginThrottler struct {
+	repo LoginAttemptsRepo
+	conf LockoutConf
+	now  func() time.Time
+}
+
+// NewLoginThrottler creates a new LoginThrottler
+func NewLoginThrottler(repo LoginAttemptsRepo, conf LockoutConf) (*LoginThrottler, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return &LoginThrottler{
+		repo: repo,
+		conf: conf,
+		now:  time.Now,
+	}, nil
+}
+
+// emailAttemptKey normalizes the email, so its case variants
+// share the same failures.
+func emailAttemptKey(email string) string {
+	return attemptKeyEmailPrefix + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(clientIP string) string {
+	return attemptKeyIPPrefix + clientIP
+}
+
+func totpAttemptKey(userID ids.ID) string {
+	return attemptKeyTOTPPrefix + userID.ToUUID()
+}
+
+func (t *LoginThrottler) keys(email string, clientIP string) []string {
+	keys := []string{emailAttemptKey(email)}
+	if len(clientIP) > 0 {
+		keys = append(keys, ipAttemptKey(clientIP))
+	}
+	return keys
+}
+
+// Check returns an ErrLocked error if the email or the client IP
+// are currently locked.
+func (t *LoginThrottler) Check(email string, clientIP string) error {
+	now := t.now()
+	for _, k := range t.keys(email, clientIP) {
+		if err := t.check(k, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *LoginThrottler) check(key string, now time.Time) error {
+	a, err := t.repo.GetLoginAttempt(key)
+	if err != nil {
+		return err
+	}
+	if a != nil && now.Before(a.LockedUntil) {
+		return lockedError(a.LockedUntil)
+	}
+	return nil
+}
+
+func lockedError(until time.Time) error {
+	return fmt.Errorf("%w until %s", ErrLocked, until.Format(time.RFC3339))
+}
+
+// Failed records a failed login for the email and the client IP,
+// locking them if the maximum number of failures is reached.
+func (t *LoginThrottler) Failed(email string, clientIP string) error {
+	now := t.now()
+	if _, err := t.fail(emailAttemptKey(email), t.conf.MaxEmailFailures, now); err != nil {
+		return err
+	}
+	if len(clientIP) == 0 {
+		return nil
+	}
+	_, err := t.fail(ipAttemptKey(clientIP), t.conf.MaxIPFailures, now)
+	return err
+}
+
+// CheckSecondFactor returns an ErrLocked error if the second factor
+// of the user is currently locked.
+func (t *LoginThrottler) CheckSecondFactor(userID ids.ID) error {
+	return t.check(totpAttemptKey(userID), t.now())
+}
+
+// FailedSecondFactor records a failed second factor code for a user,
+// returning an ErrLocked error when the maximum number of failures
+// is reached.
+func (t *LoginThrottler) FailedSecondFactor(userID ids.ID) error {
+	a, err := t.fail(totpAttemptKey(userID), t.conf.MaxTOTPFailures, t.now())
+	if err != nil {
+		return err
+	}
+	if a.Failures == 0 {
+		return lockedError(a.LockedUntil)
+	}
+	return nil
+}
+
+// SucceededSecondFactor clears the second factor failures of a user.
+func (t *LoginThrottler) SucceededSecondFactor(userID ids.ID) error {
+	return t.repo.ClearLoginAttempt(totpAttemptKey(userID))
+}
+
+// fail records a failure for the key, returning the updated
+// attempt, that has no failures when it has just been locked.
+func (t *LoginThrottler) fail(key string, maxFailures int,
+	now time.Time) (*LoginAttempt, error) {
+	return t.repo.UpdateLoginAttempt(key, func(a *LoginAttempt) {
+		// the window starts when the last lock ends, so the locks
+		// longer than the window keep doubling up to MaxLockout
+		since := a.LastFailure
+		if a.LockedUntil.After(since) {
+			since = a.LockedUntil
+		}
+		if now.Sub(since) > t.conf.FailuresWindow {
+			*a = LoginAttempt{Key: key}
+		}
+		a.Failures++
+		a.LastFailure = now
+		if a.Failures >= maxFailures {
+			a.Lockouts++
+			a.Failures = 0
+			a.LockedUntil = now.Add(t.lockoutDuration(a.Lockouts))
+		}
+	})
+}
+
+// lockoutDuration doubles the base lockout for each consecutive lock.
+func (t *LoginThrottler) lockoutDuration(lockouts int) time.Duration {
+	d := t.conf.BaseLockout
+	for i := 1; i < lockouts; i++ {
+		d *= 2
+		if d >= t.conf.MaxLockout {
+			return t.conf.MaxLockout
+		}
+	}
+	return d
+}
+
+// Succeeded clears the failures recorded for an email. The failures
+// for the client IP are kept, so a valid account cannot be used to
+// reset the IP counter.
+func (t *LoginThrottler) Succeeded(email string) error {
+	return t.repo.ClearLoginAttempt(emailAttemptKey(email))
+}
@@ -0,0 +1,192 @@
+package users
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+func Test_LoginThrottler_LocksEmail(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th, err := NewLoginThrottler(NewMemLoginAttemptsRepo(), LockoutConf{
+		MaxEmailFailures: 3,
+		BaseLockout:      time.Minute,
+	})
+	if err != nil {
+		t.Errorf("cannot create throttler: %s", err.Error())
+		return
+	}
+	th.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if err := th.Check("foo@example.com", "10.0.0.1"); err != nil {
+			t.Errorf("unexpected lock at attempt %d: %s", i, err.Error())
+			return
+		}
+		_ = th.Failed("foo@example.com", "10.0.0.1")
+	}
+
+	err = th.Check("foo@example.com", "10.0.0.2")
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("want ErrLocked, got %v", err)
+		return
+	}
+
+	if err := th.Check("bar@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("other emails from the same ip should not be locked: %s", err.Error())
+		return
+	}
+
+	// after the first lock expires, the next lock doubles its duration
+	now = now.Add(time.Minute + time.Second)
+	if err := th.Check("foo@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("lock should have expired: %s", err.Error())
+		return
+	}
+	for i := 0; i < 3; i++ {
+		_ = th.Failed("foo@example.com", "10.0.0.1")
+	}
+	now = now.Add(time.Minute + time.Second)
+	if err := th.Check("foo@example.com", "10.0.0.1"); !errors.Is(err, ErrLocked) {
+		t.Errorf("second lock should last two minutes, got %v", err)
+		return
+	}
+	now = now.Add(time.Minute)
+	if err := th.Check("foo@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("second lock should have expired: %s", err.Error())
+		return
+	}
+}
+
+func Test_LoginThrottler_MaxLockout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th, _ := NewLoginThrottler(NewMemLoginAttemptsRepo(), LockoutConf{
+		MaxEmailFailures: 1,
+		BaseLockout:      time.Minute,
+		MaxLockout:       24 * time.Hour,
+		FailuresWindow:   time.Hour,
+	})
+	th.now = func() time.Time { return now }
+
+	// each lock starts once the previous one has ended
+	want := time.Minute
+	for i := 0; i < 13; i++ {
+		_ = th.Failed("Foo@Example.com ", "")
+		a, _ := th.repo.GetLoginAttempt(emailAttemptKey("foo@example.com"))
+		if a == nil || a.LockedUntil.Sub(now) != want {
+			t.Errorf("lock %d: want %s, got %#v", i+1, want, a)
+			return
+		}
+		now = a.LockedUntil.Add(time.Second)
+		if want *= 2; want > 24*time.Hour {
+			want = 24 * time.Hour
+		}
+	}
+
+	// the failures are forgotten after the window since the lock ended
+	now = now.Add(time.Hour)
+	_ = th.Failed("foo@example.com", "")
+	if err := th.Check("FOO@example.com", ""); !errors.Is(err, ErrLocked) {
+		t.Errorf("want ErrLocked, got %v", err)
+		return
+	}
+	a, _ := th.repo.GetLoginAttempt(emailAttemptKey("foo@example.com"))
+	if a.Lockouts != 1 || a.LockedUntil.Sub(now) != time.Minute {
+		t.Errorf("want the backoff restarted, got %#v", a)
+		return
+	}
+}
+
+func Test_LoginThrottler_LocksIP(t *testing.T) {
+	th, err := NewLoginThrottler(NewMemLoginAttemptsRepo(), LockoutConf{
+		MaxEmailFailures: 10,
+		MaxIPFailures:    2,
+	})
+	if err != nil {
+		t.Errorf("cannot create throttler: %s", err.Error())
+		return
+	}
+
+	_ = th.Failed("a@example.com", "10.0.0.1")
+	_ = th.Failed("b@example.com", "10.0.0.1")
+
+	if err := th.Check("c@example.com", "10.0.0.1"); !errors.Is(err, ErrLocked) {
+		t.Errorf("want ErrLocked for the ip, got %v", err)
+		return
+	}
+	if err := th.Check("c@example.com", "10.0.0.2"); err != nil {
+		t.Errorf("other ips should not be locked: %s", err.Error())
+		return
+	}
+}
+
+func Test_LoginThrottler_SuccessClearsEmail(t *testing.T) {
+	th, _ := NewLoginThrottler(NewMemLoginAttemptsRepo(), LockoutConf{
+		MaxEmailFailures: 2,
+	})
+
+	_ = th.Failed("foo@example.com", "")
+	_ = th.Succeeded("foo@example.com")
+	_ = th.Failed("foo@example.com", "")
+
+	if err := th.Check("foo@example.com", ""); err != nil {
+		t.Errorf("failures should be cleared after success: %s", err.Error())
+	}
+}
+
+func Test_LoginThrottler_LocksSecondFactor(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th, _ := NewLoginThrottler(NewMemLoginAttemptsRepo(), LockoutConf{
+		MaxTOTPFailures: 2,
+		BaseLockout:     time.Minute,
+	})
+	th.now = func() time.Time { return now }
+	gen := ids.NewIDGenerator()
+	userID := gen.MustNew()
+
+	if err := th.FailedSecondFactor(userID); err != nil {
+		t.Errorf("first failure should not lock: %s", err.Error())
+		return
+	}
+	if err := th.FailedSecondFactor(userID); !errors.Is(err, ErrLocked) {
+		t.Errorf("want ErrLocked on the last failure, got %v", err)
+		return
+	}
+	if err := th.CheckSecondFactor(userID); !errors.Is(err, ErrLocked) {
+		t.Errorf("want the second factor locked, got %v", err)
+		return
+	}
+	if err := th.CheckSecondFactor(gen.MustNew()); err != nil {
+		t.Errorf("other users should not be locked: %s", err.Error())
+		return
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if err := th.CheckSecondFactor(userID); err != nil {
+		t.Errorf("lock should have expired: %s", err.Error())
+		return
+	}
+}
+
+func Test_LoginThrottler_ConcurrentFailures(t *testing.T) {
+	repo := NewMemLoginAttemptsRepo()
+	th, _ := NewLoginThrottler(repo, LockoutConf{MaxEmailFailures: 100})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = th.Failed("foo@example.com", "")
+		}()
+	}
+	wg.Wait()
+
+	a, _ := repo.GetLoginAttempt(emailAttemptKey("foo@example.com"))
+	if a == nil || a.Failures != 20 {
+		t.Errorf("want 20 failures, got %#v", a)
+	}
+}
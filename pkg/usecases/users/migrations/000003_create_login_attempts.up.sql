@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE user_login_attempts(
+    attempt_key     VARCHAR(300) PRIMARY KEY
+    ,failures       INTEGER NOT NULL DEFAULT 0
+    ,lockouts       INTEGER NOT NULL DEFAULT 0
+    ,last_failure   TIMESTAMP NOT NULL
+    ,locked_until   TIMESTAMP
+);
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE user_registration_requests
+    ALTER COLUMN password TYPE VARCHAR(128);
+
+ALTER TABLE users
+    ALTER COLUMN password TYPE VARCHAR(128);
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE users
+    ALTER COLUMN password TYPE VARCHAR(512);
+
+ALTER TABLE user_registration_requests
+    ALTER COLUMN password TYPE VARCHAR(512);
+
+COMMIT;
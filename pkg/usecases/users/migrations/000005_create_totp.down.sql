@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE user_recovery_codes;
+DROP TABLE user_totp;
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE user_email_changes(
+    confirm_token_hash  VARCHAR(64) PRIMARY KEY
+    ,revert_token_hash  VARCHAR(64) NOT NULL UNIQUE
+    ,user_id            UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,old_email          VARCHAR(254) NOT NULL
+    ,new_email          VARCHAR(254) NOT NULL
+    ,requested          TIMESTAMP NOT NULL
+    ,expires            TIMESTAMP NOT NULL
+    ,revert_expires     TIMESTAMP NOT NULL
+    ,confirmed          TIMESTAMP
+    ,reverted           TIMESTAMP
+);
+CREATE INDEX idx_user_email_changes_user_id ON user_email_changes(user_id);
+
+COMMIT;
@@ -0,0 +1,23 @@
+BEGIN;
+
+CREATE TABLE roles(
+    name            VARCHAR(64) PRIMARY KEY
+    ,description    VARCHAR(255) NOT NULL
+    ,created        TIMESTAMP NOT NULL
+);
+
+CREATE TABLE grants(
+    role_name       VARCHAR(64) NOT NULL REFERENCES roles(name) ON DELETE CASCADE
+    ,permission     VARCHAR(128) NOT NULL
+    ,PRIMARY KEY (role_name, permission)
+);
+
+CREATE TABLE user_roles(
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,role_name      VARCHAR(64) NOT NULL REFERENCES roles(name) ON DELETE CASCADE
+    ,created        TIMESTAMP NOT NULL
+    ,PRIMARY KEY (user_id, role_name)
+);
+CREATE INDEX idx_user_roles_role_name ON user_roles(role_name);
+
+COMMIT;
@@ -0,0 +1,242 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default parameters for the password hashers
+const (
+	DefaultArgon2idTime    uint32 = 3
+	DefaultArgon2idMemory  uint32 = 64 * 1024 // in KiB
+	DefaultArgon2idThreads uint8  = 2
+	DefaultArgon2idKeyLen  uint32 = 32
+	DefaultArgon2idSaltLen uint32 = 16
+
+	argon2idPHCID string = "argon2id"
+)
+
+// PasswordHasher defines the interface to hash passwords and
+// to verify them against a stored hash in PHC string format.
+type PasswordHasher interface {
+	// Matches tells if the encoded hash was created with the
+	// algorithm of this hasher.
+	Matches(encoded string) bool
+
+	// Hash returns the encoded hash for a password.
+	Hash(password string) (string, error)
+
+	// Verify checks a password against an encoded hash.
+	Verify(password string, encoded string) (bool, error)
+
+	// NeedsRehash tells if the encoded hash was created with
+	// weaker parameters than the configured ones.
+	NeedsRehash(encoded string) bool
+}
+
+// BcryptHasher implements the PasswordHasher interface using
+// bcrypt (which is already stored in the modular crypt format
+// `$2a$cost$saltandhash`).
+type BcryptHasher struct {
+	Cost int
+}
+
+var _ PasswordHasher = (*BcryptHasher)(nil)
+
+// NewBcryptHasher creates a bcrypt hasher. If cost is zero
+// the bcrypt.DefaultCost is used.
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{
+		Cost: cost,
+	}
+}
+
+// Matches tells if the encoded hash is a bcrypt hash.
+func (h *BcryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// Hash returns the bcrypt hash for a password.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify checks a password against a bcrypt hash.
+func (h *BcryptHasher) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NeedsRehash tells if the hash was created with a lower cost.
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost < h.Cost
+}
+
+// Argon2idHasher implements the PasswordHasher interface using
+// argon2id, encoding the result in the PHC string format:
+// `$argon2id$v=19$m=65536,t=3,p=2$salt$hash`.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+var _ PasswordHasher = (*Argon2idHasher)(nil)
+
+// NewArgon2idHasher creates an argon2id hasher with the
+// default parameters.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    DefaultArgon2idTime,
+		Memory:  DefaultArgon2idMemory,
+		Threads: DefaultArgon2idThreads,
+		KeyLen:  DefaultArgon2idKeyLen,
+		SaltLen: DefaultArgon2idSaltLen,
+	}
+}
+
+type argon2idParams struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	// the leading '$' produces an empty first element
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != argon2idPHCID {
+		return nil, fmt.Errorf("not an argon2id hash")
+	}
+	var p argon2idParams
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil {
+		return nil, fmt.Errorf("bad argon2id version: %w", err)
+	}
+	if p.version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %d", p.version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d",
+		&p.memory, &p.time, &p.threads); err != nil {
+		return nil, fmt.Errorf("bad argon2id params: %w", err)
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("bad argon2id salt: %w", err)
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("bad argon2id key: %w", err)
+	}
+	return &p, nil
+}
+
+// Matches tells if the encoded hash is an argon2id hash.
+func (h *Argon2idHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$"+argon2idPHCID+"$")
+}
+
+// Hash returns the argon2id hash for a password.
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPHCID,
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks a password against an argon2id hash, using the
+// parameters stored in the hash.
+func (h *Argon2idHasher) Verify(password string, encoded string) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory,
+		p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+// NeedsRehash tells if the hash was created with weaker parameters.
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory < h.Memory || p.time < h.Time || p.threads < h.Threads ||
+		uint32(len(p.key)) < h.KeyLen || uint32(len(p.salt)) < h.SaltLen
+}
+
+// PasswordHashing selects the hasher to use for a stored hash, and
+// creates new hashes with the preferred one.
+type PasswordHashing struct {
+	preferred PasswordHasher
+	legacy    []PasswordHasher
+}
+
+// NewPasswordHashing creates a PasswordHashing that hashes new
+// passwords with the preferred hasher, and can still verify
+// the ones created with the legacy hashers.
+func NewPasswordHashing(preferred PasswordHasher,
+	legacy ...PasswordHasher) *PasswordHashing {
+	return &PasswordHashing{
+		preferred: preferred,
+		legacy:    legacy,
+	}
+}
+
+// NewDefaultPasswordHashing creates a PasswordHashing that uses
+// argon2id for new passwords and still accepts bcrypt ones.
+func NewDefaultPasswordHashing() *PasswordHashing {
+	return NewPasswordHashing(NewArgon2idHasher(), NewBcryptHasher(0))
+}
+
+// Hash returns the encoded hash with the preferred hasher.
+func (p *PasswordHashing) Hash(password string) (string, error) {
+	return p.preferred.Hash(password)
+}
+
+// Verify checks a password against an encoded hash, and tells if
+// the hash should be upgraded, because it was created with a
+// legacy hasher or with outdated parameters.
+func (p *PasswordHashing) Verify(password string, encoded string) (bool, bool, error) {
+	if p.preferred.Matches(encoded) {
+		ok, err := p.preferred.Verify(password, encoded)
+		return ok, ok && p.preferred.NeedsRehash(encoded), err
+	}
+	for _, h := range p.legacy {
+		if h.Matches(encoded) {
+			ok, err := h.Verify(password, encoded)
+			return ok, ok, err
+		}
+	}
+	return false, false, fmt.Errorf("unknown password hash format")
+}
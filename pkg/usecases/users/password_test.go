@@ -0,0 +1,91 @@
+package users
+
+import (
+	"strings"
+	"testing"
+)
+
+func testArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    1,
+		Memory:  1024,
+		Threads: 1,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func Test_Argon2idHasher(t *testing.T) {
+	h := testArgon2idHasher()
+	encoded, err := h.Hash("s3cr3t")
+	if err != nil {
+		t.Errorf("cannot hash: %s", err.Error())
+		return
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("unexpected PHC string: %s", encoded)
+		return
+	}
+	if !h.Matches(encoded) {
+		t.Errorf("hasher should match its own hashes")
+		return
+	}
+
+	ok, err := h.Verify("s3cr3t", encoded)
+	if err != nil || !ok {
+		t.Errorf("cannot verify password: %v", err)
+		return
+	}
+	ok, _ = h.Verify("wrong", encoded)
+	if ok {
+		t.Errorf("wrong password verified")
+		return
+	}
+
+	if h.NeedsRehash(encoded) {
+		t.Errorf("hash with same params should not need a rehash")
+		return
+	}
+	stronger := testArgon2idHasher()
+	stronger.Time = 2
+	if !stronger.NeedsRehash(encoded) {
+		t.Errorf("hash with weaker params should need a rehash")
+	}
+}
+
+func Test_PasswordHashing_UpgradesBcrypt(t *testing.T) {
+	bh := NewBcryptHasher(4)
+	legacy, err := bh.Hash("s3cr3t")
+	if err != nil {
+		t.Errorf("cannot hash: %s", err.Error())
+		return
+	}
+
+	ph := NewPasswordHashing(testArgon2idHasher(), bh)
+	ok, rehash, err := ph.Verify("s3cr3t", legacy)
+	if err != nil || !ok {
+		t.Errorf("cannot verify legacy password: %v", err)
+		return
+	}
+	if !rehash {
+		t.Errorf("legacy hash should be upgraded")
+		return
+	}
+
+	ok, rehash, _ = ph.Verify("wrong", legacy)
+	if ok || rehash {
+		t.Errorf("wrong password should not verify nor rehash")
+		return
+	}
+
+	upgraded, _ := ph.Hash("s3cr3t")
+	ok, rehash, err = ph.Verify("s3cr3t", upgraded)
+	if err != nil || !ok || rehash {
+		t.Errorf("preferred hash: ok %t, rehash %t, err %v", ok, rehash, err)
+		return
+	}
+
+	if _, _, err := ph.Verify("s3cr3t", "plaintext"); err == nil {
+		t.Errorf("expected error for unknown hash format")
+	}
+}
@@ -0,0 +1,153 @@
+package users
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+var _ LoginAttemptsRepo = (*MemLoginAttemptsRepo)(nil)
+
+// MemLoginAttemptsRepo is an in memory implementation of
+// a LoginAttemptsRepo, useful for tests or single instance
+// deployments.
+type MemLoginAttemptsRepo struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+}
+
+// NewMemLoginAttemptsRepo creates a new MemLoginAttemptsRepo
+func NewMemLoginAttemptsRepo() *MemLoginAttemptsRepo {
+	return &MemLoginAttemptsRepo{
+		attempts: make(map[string]LoginAttempt),
+	}
+}
+
+// GetLoginAttempt returns the stored attempts for a key, or
+// nil if there are no failed attempts recorded.
+func (r *MemLoginAttemptsRepo) GetLoginAttempt(key string) (*LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &a, nil
+}
+
+// UpdateLoginAttempt applies update to the attempts for a key
+// and stores the result.
+func (r *MemLoginAttemptsRepo) UpdateLoginAttempt(key string,
+	update func(a *LoginAttempt)) (*LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.attempts[key]
+	if !ok {
+		a = LoginAttempt{Key: key}
+	}
+	update(&a)
+	r.attempts[key] = a
+	return &a, nil
+}
+
+// ClearLoginAttempt removes the recorded attempts for a key.
+func (r *MemLoginAttemptsRepo) ClearLoginAttempt(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, key)
+	return nil
+}
+
+var _ SessionsRepo = (*MemSessionsRepo)(nil)
+
+// MemSessionsRepo is an in memory implementation of a
+// SessionsRepo, useful for tests or single instance deployments.
+type MemSessionsRepo struct {
+	mu       sync.Mutex
+	sessions map[ids.ID]Session
+}
+
+// NewMemSessionsRepo creates a new MemSessionsRepo
+func NewMemSessionsRepo() *MemSessionsRepo {
+	return &MemSessionsRepo{
+		sessions: make(map[ids.ID]Session),
+	}
+}
+
+// CreateSession stores a new session.
+func (r *MemSessionsRepo) CreateSession(s *Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.ID] = *s
+	return nil
+}
+
+// GetSession returns a session, or nil if it does not exist.
+func (r *MemSessionsRepo) GetSession(sessionID ids.ID) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// TouchSession updates the last time a session was used.
+func (r *MemSessionsRepo) TouchSession(sessionID ids.ID, lastSeen time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[sessionID]
+	if ok && s.LastSeen.Before(lastSeen) {
+		s.LastSeen = lastSeen
+		r.sessions[sessionID] = s
+	}
+	return nil
+}
+
+// ListSessions returns the not revoked sessions of a user,
+// the most recently used first.
+func (r *MemSessionsRepo) ListSessions(userID ids.ID) ([]Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []Session{}
+	for _, s := range r.sessions {
+		if s.UserID == userID && !s.IsRevoked() {
+			res = append(res, s)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastSeen.After(res[j].LastSeen)
+	})
+	return res, nil
+}
+
+// RevokeSession revokes a session of a user, returning
+// ErrNotFound if it does not exist or is already revoked.
+func (r *MemSessionsRepo) RevokeSession(userID ids.ID, sessionID ids.ID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[sessionID]
+	if !ok || s.UserID != userID || s.IsRevoked() {
+		return ErrNotFound
+	}
+	s.Revoked = time.Now()
+	r.sessions[sessionID] = s
+	return nil
+}
+
+// RevokeAllSessions revokes all the sessions of a user.
+func (r *MemSessionsRepo) RevokeAllSessions(userID ids.ID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for k, s := range r.sessions {
+		if s.UserID == userID && !s.IsRevoked() {
+			s.Revoked = now
+			r.sessions[k] = s
+		}
+	}
+	return nil
+}
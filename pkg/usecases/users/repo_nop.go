@@ -2,6 +2,7 @@ package users
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/dhontecillas/hfw/pkg/ids"
 )
@@ -64,3 +65,67 @@ func (r *NopRegistrationRepo) DeleteUser(email string) error {
 func (r *NopRegistrationRepo) ListUsers(from ids.ID, limit int, backwards bool) ([]User, error) {
 	return []User{}, fmt.Errorf("not implemented")
 }
+
+// GetTOTP returns the second factor configuration for a user,
+// or nil if the user has not started an enrollment.
+func (r *NopRegistrationRepo) GetTOTP(userID ids.ID) (*TOTPConf, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// SetTOTPSecret stores a new, not yet enabled, secret for a
+// user replacing any previous one.
+func (r *NopRegistrationRepo) SetTOTPSecret(userID ids.ID, secret string) error {
+	return fmt.Errorf("not implemented")
+}
+
+// EnableTOTP enables the second factor for a user.
+func (r *NopRegistrationRepo) EnableTOTP(userID ids.ID, step int64,
+	recoveryCodeHashes []string) error {
+	return fmt.Errorf("not implemented")
+}
+
+// DisableTOTP removes the second factor and the recovery codes.
+func (r *NopRegistrationRepo) DisableTOTP(userID ids.ID) error {
+	return fmt.Errorf("not implemented")
+}
+
+// UseTOTPStep records the last used step.
+func (r *NopRegistrationRepo) UseTOTPStep(userID ids.ID, step int64) error {
+	return fmt.Errorf("not implemented")
+}
+
+// ConsumeRecoveryCode marks a recovery code as used.
+func (r *NopRegistrationRepo) ConsumeRecoveryCode(userID ids.ID, codeHash string) error {
+	return fmt.Errorf("not implemented")
+}
+
+// CreateLoginLink returns a single use token to log in a user
+// without password, that expires after the given ttl.
+func (r *NopRegistrationRepo) CreateLoginLink(email string,
+	ttl time.Duration) (*User, string, error) {
+	return nil, "", fmt.Errorf("not implemented")
+}
+
+// ConsumeLoginLink returns the user for a login link token,
+// marking it as used.
+func (r *NopRegistrationRepo) ConsumeLoginLink(token string) (*User, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// CreateEmailChangeRequest stores a request to change the email
+// of a user.
+func (r *NopRegistrationRepo) CreateEmailChangeRequest(userID ids.ID,
+	newEmail string, ttl time.Duration, revertTTL time.Duration) (*EmailChange, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// ConfirmEmailChange applies the email change for a confirm token.
+func (r *NopRegistrationRepo) ConfirmEmailChange(confirmToken string) (*EmailChange, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// RevertEmailChange cancels a pending email change, or restores
+// the old email if it was already confirmed.
+func (r *NopRegistrationRepo) RevertEmailChange(revertToken string) (*EmailChange, error) {
+	return nil, fmt.Errorf("not implemented")
+}
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	"golang.org/x/crypto/bcrypt"
 
 	"github.com/dhontecillas/hfw/pkg/db"
 	"github.com/dhontecillas/hfw/pkg/ids"
@@ -17,6 +16,9 @@ import (
 )
 
 var _ RegistrationRepo = (*RepoSQLX)(nil)
+var _ LoginAttemptsRepo = (*RepoSQLX)(nil)
+var _ IdentitiesRepo = (*RepoSQLX)(nil)
+var _ TxRegistrationRepo = (*RepoSQLX)(nil)
 
 // RepoSQLX implemnte the RegistrationRepo interface
 // with a SQL db.
@@ -24,16 +26,64 @@ type RepoSQLX struct {
 	sqlDB     db.SQLDB
 	ins       *obs.Insighter
 	tokenSalt string
+	hashing   *PasswordHashing
+	// sharedTx is the transaction of a repo created with WithTx
+	sharedTx *sqlx.Tx
 }
 
-// NewRepoSQLX creates a new RepoSQLX
+// NewRepoSQLX creates a new RepoSQLX that hashes passwords with
+// argon2id (and still accepts existing bcrypt hashes).
 func NewRepoSQLX(ins *obs.Insighter, sqlDB db.SQLDB,
 	tokenSalt string) *RepoSQLX {
+	return NewRepoSQLXWithHashing(ins, sqlDB, tokenSalt,
+		NewDefaultPasswordHashing())
+}
+
+// NewRepoSQLXWithHashing creates a new RepoSQLX with a custom
+// password hashing configuration.
+func NewRepoSQLXWithHashing(ins *obs.Insighter, sqlDB db.SQLDB,
+	tokenSalt string, hashing *PasswordHashing) *RepoSQLX {
 	return &RepoSQLX{
 		sqlDB:     sqlDB,
 		ins:       ins,
 		tokenSalt: tokenSalt,
+		hashing:   hashing,
+	}
+}
+
+// WithTx returns a copy of the repo that runs its operations in a
+// transaction shared with other repos (like the outbox). The
+// transaction is not committed nor rolled back by the repo: the
+// caller must do it, rolling it back when an operation fails.
+func (r *RepoSQLX) WithTx(tx *sqlx.Tx) RegistrationRepo {
+	c := *r
+	c.sharedTx = tx
+	return &c
+}
+
+// begin starts a transaction, or returns the shared one.
+func (r *RepoSQLX) begin() (*sqlx.Tx, error) {
+	if r.sharedTx != nil {
+		return r.sharedTx, nil
 	}
+	return r.sqlDB.Master().Beginx()
+}
+
+// commit commits a transaction, unless it is the shared one.
+func (r *RepoSQLX) commit(tx *sqlx.Tx) error {
+	if tx == r.sharedTx {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// rollback rolls back a transaction, unless it is the shared one,
+// that is rolled back by its owner when the operation fails.
+func (r *RepoSQLX) rollback(tx *sqlx.Tx) error {
+	if tx == r.sharedTx {
+		return nil
+	}
+	return tx.Rollback()
 }
 
 func (r *RepoSQLX) scanUser(row *sqlx.Row, u *User) error {
@@ -107,10 +157,9 @@ WHERE
 func (r *RepoSQLX) GetUserByEmail(email string) *User {
 	// we do not need a transaction here, but since other getUserByID
 	// works with a slqx.Tx param, we reuse it here
-	master := r.sqlDB.Master()
-	tx, _ := master.Beginx()
+	tx, _ := r.begin()
 	u := r.getUserByEmail(tx, email)
-	_ = tx.Commit()
+	_ = r.commit(tx)
 	return u
 }
 
@@ -119,10 +168,9 @@ func (r *RepoSQLX) GetUserByEmail(email string) *User {
 func (r *RepoSQLX) GetUserByID(userID ids.ID) *User {
 	// we do not need a transaction here, but since other getUserByID
 	// works with a slqx.Tx param, we reuse it here
-	master := r.sqlDB.Master()
-	tx, _ := master.Beginx()
+	tx, _ := r.begin()
 	u := r.getUserByID(tx, userID)
-	_ = tx.Commit()
+	_ = r.commit(tx)
 	return u
 }
 
@@ -134,17 +182,35 @@ func (r *RepoSQLX) createToken(email string) string {
 	return hex.EncodeToString(token[:])
 }
 
-func (r *RepoSQLX) passwordHash(password string) string {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (r *RepoSQLX) passwordHash(password string) (string, error) {
+	hash, err := r.hashing.Hash(password)
 	if err != nil {
-		panic("cannot hash password")
+		r.ins.L.Err(err, "cannot hash password", nil)
+		return "", fmt.Errorf("cannot hash password: %w", err)
 	}
-	return string(hash)
+	return hash, nil
 }
 
-func (r *RepoSQLX) checkPassword(password string, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// rehashPassword upgrades the stored hash for a user, only if
+// the password has not been changed in the meantime.
+func (r *RepoSQLX) rehashPassword(strID string, password string, oldHash string) {
+	newHash, err := r.passwordHash(password)
+	if err != nil {
+		return
+	}
+	rehashQ := `
+UPDATE users
+SET
+	password = $1
+WHERE
+	id = $2
+	AND password = $3
+`
+	if _, err := r.sqlDB.Master().Exec(rehashQ, newHash, strID, oldHash); err != nil {
+		r.ins.L.Err(err, "cannot upgrade password hash", map[string]interface{}{
+			"id": strID,
+		})
+	}
 }
 
 type registrationRequest struct {
@@ -162,16 +228,15 @@ func (r *RepoSQLX) CreateInactiveUser(
 	email string, password string) (string, error) {
 
 	now := time.Now()
-	master := r.sqlDB.Master()
-	tx, err := master.Beginx()
+	tx, err := r.begin()
 	if err != nil {
 		return "", err
 	}
-	defer func() { _ = tx.Commit() }()
+	defer func() { _ = r.commit(tx) }()
 
 	u := r.getUserByEmail(tx, email)
 	if u != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		r.ins.L.Err(ErrUserExists, "email already exists", map[string]interface{}{
@@ -195,7 +260,13 @@ WHERE
 		r.ins.L.Err(err, " cannot update registration requests", nil)
 	}
 
-	hashedPass := r.passwordHash(password)
+	hashedPass, err := r.passwordHash(password)
+	if err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return "", err
+	}
 	token := r.createToken(email)
 	expirationHours := 24
 	expires := now.Add(time.Duration(expirationHours) * time.Hour)
@@ -221,7 +292,7 @@ VALUES(
 		r.ins.L.Err(err, "error executing query", map[string]interface{}{
 			"query": sqlQ,
 		})
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return "", err
@@ -231,8 +302,7 @@ VALUES(
 
 // ActivateUser confirms an user email with its activation token.
 func (r *RepoSQLX) ActivateUser(token string) (*User, error) {
-	master := r.sqlDB.Master()
-	tx, err := master.Beginx()
+	tx, err := r.begin()
 	if err != nil {
 		return nil, err
 	}
@@ -255,7 +325,7 @@ FOR UPDATE
 `
 	row := tx.QueryRowx(findQ, token)
 	if row == nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, ErrNotFound
@@ -278,14 +348,14 @@ FOR UPDATE
 	}
 
 	if !rq.consumed.IsZero() {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, ErrConsumed
 	}
 
 	if now.After(rq.expires) {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, ErrExpired
@@ -299,7 +369,7 @@ WHERE
 	token=$1
 `
 	if _, err := tx.Exec(consumeQ, token, now); err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, err
@@ -321,14 +391,14 @@ VALUES(
 )
 `
 	if _, err := tx.Exec(createUserQ, id.ToUUID(), rq.email, rq.password, now); err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+	if err := r.commit(tx); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		r.ins.L.Err(err, "cannot complete transaction", map[string]interface{}{
@@ -348,15 +418,14 @@ VALUES(
 func (r *RepoSQLX) CreatePasswordResetRequest(email string) (*User, string, error) {
 	token := r.createToken(email)
 
-	master := r.sqlDB.Master()
-	tx, err := master.Beginx()
+	tx, err := r.begin()
 	if err != nil {
 		return nil, "", err
 	}
 
 	u := r.getUserByEmail(tx, email)
 	if u == nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, "", ErrNotFound
@@ -395,7 +464,7 @@ VALUES (
 )
 `
 	if _, err := tx.Exec(insertTokenQ, u.ID.ToUUID(), token, now, expires); err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		r.ins.L.Err(err, "cannot create reset password token", nil)
@@ -403,7 +472,7 @@ VALUES (
 			u.ID.ToUUID(), err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if err := r.commit(tx); err != nil {
 		r.ins.L.Err(err, "cannot commit resest password request", nil)
 		return nil, "", err
 	}
@@ -421,8 +490,7 @@ type resetPasswordRequest struct {
 // ResetPassword changes the pasword for the user associated with
 // the given reset password token
 func (r *RepoSQLX) ResetPassword(token string, password string) (*User, error) {
-	master := r.sqlDB.Master()
-	tx, err := master.Beginx()
+	tx, err := r.begin()
 	if err != nil {
 		return nil, err
 	}
@@ -447,7 +515,7 @@ WHERE
 		&rpr.requested,
 		&rpr.expires,
 		&consumed); err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		r.ins.L.Err(err, "cannot scan result", map[string]interface{}{
@@ -460,7 +528,7 @@ WHERE
 	}
 
 	if !rpr.consumed.IsZero() {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, fmt.Errorf("token already consumed")
@@ -468,7 +536,7 @@ WHERE
 
 	now := time.Now()
 	if now.After(rpr.expires) {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		return nil, fmt.Errorf("token expired")
@@ -482,14 +550,20 @@ WHERE
 	token = $2
 `
 	if _, err := tx.Exec(consumeTokenQ, now, token); err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		werr := fmt.Errorf("cannot consume token %s: %w", token, err)
 		return nil, werr
 	}
 
-	passHash := r.passwordHash(password)
+	passHash, err := r.passwordHash(password)
+	if err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, err
+	}
 	updatePasswordQ := `
 UPDATE users
 SET
@@ -499,7 +573,7 @@ WHERE
 `
 
 	if _, err := tx.Exec(updatePasswordQ, passHash, rpr.userID); err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		werr := fmt.Errorf("cannot set password %s: %w", token, err)
@@ -513,20 +587,21 @@ WHERE
 	}
 	u := r.getUserByID(tx, id)
 	if u == nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
 			r.ins.L.Err(rbErr, "rollback failed", nil)
 		}
 		err := fmt.Errorf("cannot get user %s", rpr.userID)
 		return nil, err
 	}
-	if err := tx.Commit(); err != nil {
+	if err := r.commit(tx); err != nil {
 		return nil, err
 	}
 	return u, nil
 }
 
 // CheckPassword return the user ID for a user from its email
-// and password.
+// and password. If the stored hash was created with a legacy
+// algorithm or outdated parameters, it is upgraded.
 func (r *RepoSQLX) CheckPassword(email string, password string) (ids.ID, error) {
 	master := r.sqlDB.Master()
 	var userID ids.ID
@@ -542,12 +617,28 @@ WHERE
 	var strID string
 	var hashedPwd string
 	if err := row.Scan(&strID, &hashedPwd); err != nil {
+		if err == sql.ErrNoRows {
+			return userID, ErrNotFound
+		}
 		return userID, err
 	}
-	if !r.checkPassword(password, hashedPwd) {
-		return userID, fmt.Errorf("wrong password")
+	if hashedPwd == "" {
+		// users created from an external identity have no password
+		return userID, ErrWrongPassword
+	}
+	ok, needsRehash, err := r.hashing.Verify(password, hashedPwd)
+	if err != nil {
+		r.ins.L.Err(err, "cannot verify password", map[string]interface{}{
+			"id": strID,
+		})
+	}
+	if !ok {
+		return userID, ErrWrongPassword
+	}
+	if needsRehash {
+		r.rehashPassword(strID, password, hashedPwd)
 	}
-	err := userID.FromUUID(strID)
+	err = userID.FromUUID(strID)
 	return userID, err
 }
 
@@ -639,3 +730,332 @@ ORDER BY id
 	}
 	return results, nil
 }
+
+// GetLoginAttempt returns the stored attempts for a key, or
+// nil if there are no failed attempts recorded.
+func (r *RepoSQLX) GetLoginAttempt(key string) (*LoginAttempt, error) {
+	master := r.sqlDB.Master()
+	getAttemptQ := `
+SELECT
+	attempt_key
+	,failures
+	,lockouts
+	,last_failure
+	,locked_until
+FROM user_login_attempts
+WHERE
+	attempt_key = $1
+`
+	row := master.QueryRowx(getAttemptQ, key)
+	var a LoginAttempt
+	var lockedUntil *time.Time
+	if err := row.Scan(&a.Key, &a.Failures, &a.Lockouts,
+		&a.LastFailure, &lockedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		r.ins.L.Err(err, "cannot scan login attempt", map[string]interface{}{
+			"query": getAttemptQ,
+		})
+		return nil, err
+	}
+	if lockedUntil != nil {
+		a.LockedUntil = *lockedUntil
+	}
+	return &a, nil
+}
+
+// UpdateLoginAttempt applies update to the attempts for a key and
+// stores the result. The row is locked while it is updated, so the
+// concurrent failures for the same key are serialized.
+func (r *RepoSQLX) UpdateLoginAttempt(key string,
+	update func(a *LoginAttempt)) (*LoginAttempt, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	rollback := func(err error, msg string, query string) (*LoginAttempt, error) {
+		r.ins.L.Err(err, msg, map[string]interface{}{
+			"query": query,
+		})
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, err
+	}
+
+	// the row is created first, so there is always a row to lock
+	createAttemptQ := `
+INSERT INTO user_login_attempts(
+	attempt_key
+	,last_failure
+)
+VALUES(
+	$1
+	,$2
+)
+ON CONFLICT (attempt_key) DO NOTHING
+`
+	if _, err := tx.Exec(createAttemptQ, key, time.Time{}); err != nil {
+		return rollback(err, "cannot create login attempt", createAttemptQ)
+	}
+
+	lockAttemptQ := `
+SELECT
+	failures
+	,lockouts
+	,last_failure
+	,locked_until
+FROM user_login_attempts
+WHERE
+	attempt_key = $1
+FOR UPDATE
+`
+	a := LoginAttempt{Key: key}
+	var lockedUntil *time.Time
+	if err := tx.QueryRowx(lockAttemptQ, key).Scan(&a.Failures, &a.Lockouts,
+		&a.LastFailure, &lockedUntil); err != nil {
+		return rollback(err, "cannot lock login attempt", lockAttemptQ)
+	}
+	if lockedUntil != nil {
+		a.LockedUntil = *lockedUntil
+	}
+
+	update(&a)
+
+	updateAttemptQ := `
+UPDATE user_login_attempts
+SET
+	failures = $2
+	,lockouts = $3
+	,last_failure = $4
+	,locked_until = $5
+WHERE
+	attempt_key = $1
+`
+	lockedUntil = nil
+	if !a.LockedUntil.IsZero() {
+		lockedUntil = &a.LockedUntil
+	}
+	if _, err := tx.Exec(updateAttemptQ, key, a.Failures, a.Lockouts,
+		a.LastFailure, lockedUntil); err != nil {
+		return rollback(err, "cannot update login attempt", updateAttemptQ)
+	}
+	if err := r.commit(tx); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ClearLoginAttempt removes the recorded attempts for a key.
+func (r *RepoSQLX) ClearLoginAttempt(key string) error {
+	master := r.sqlDB.Master()
+	deleteAttemptQ := `
+DELETE FROM user_login_attempts
+WHERE
+	attempt_key = $1
+`
+	_, err := master.Exec(deleteAttemptQ, key)
+	return err
+}
+
+// GetTOTP returns the second factor configuration for a user,
+// or nil if the user has not started an enrollment.
+func (r *RepoSQLX) GetTOTP(userID ids.ID) (*TOTPConf, error) {
+	master := r.sqlDB.Master()
+	getTOTPQ := `
+SELECT
+	secret
+	,enabled
+	,last_step
+FROM user_totp
+WHERE
+	user_id = $1
+`
+	conf := TOTPConf{UserID: userID}
+	row := master.QueryRowx(getTOTPQ, userID.ToUUID())
+	if err := row.Scan(&conf.Secret, &conf.Enabled, &conf.LastStep); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		r.ins.L.Err(err, "cannot scan totp", map[string]interface{}{
+			"query": getTOTPQ,
+		})
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// SetTOTPSecret stores a new, not yet enabled, secret for a
+// user replacing any previous one.
+func (r *RepoSQLX) SetTOTPSecret(userID ids.ID, secret string) error {
+	master := r.sqlDB.Master()
+	setSecretQ := `
+INSERT INTO user_totp(
+	user_id
+	,secret
+	,enabled
+	,last_step
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,FALSE
+	,0
+	,$3
+)
+ON CONFLICT (user_id) DO UPDATE
+SET
+	secret = EXCLUDED.secret
+	,enabled = FALSE
+	,last_step = 0
+	,created = EXCLUDED.created
+`
+	if _, err := master.Exec(setSecretQ, userID.ToUUID(), secret, time.Now()); err != nil {
+		r.ins.L.Err(err, "cannot set totp secret", map[string]interface{}{
+			"query": setSecretQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// EnableTOTP enables the second factor for a user, storing
+// the already used step and the hashes of the recovery codes.
+func (r *RepoSQLX) EnableTOTP(userID ids.ID, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	strUID := userID.ToUUID()
+
+	enableQ := `
+UPDATE user_totp
+SET
+	enabled = TRUE
+	,last_step = $2
+WHERE
+	user_id = $1
+`
+	res, err := tx.Exec(enableQ, strUID, step)
+	if err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return ErrNotFound
+	}
+
+	clearCodesQ := `
+DELETE FROM user_recovery_codes
+WHERE
+	user_id = $1
+`
+	if _, err := tx.Exec(clearCodesQ, strUID); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return err
+	}
+
+	insertCodeQ := `
+INSERT INTO user_recovery_codes(
+	user_id
+	,code_hash
+)
+VALUES(
+	$1
+	,$2
+)
+`
+	for _, h := range recoveryCodeHashes {
+		if _, err := tx.Exec(insertCodeQ, strUID, h); err != nil {
+			if rbErr := r.rollback(tx); rbErr != nil {
+				r.ins.L.Err(rbErr, "rollback failed", nil)
+			}
+			return err
+		}
+	}
+	return r.commit(tx)
+}
+
+// DisableTOTP removes the second factor and the recovery codes.
+func (r *RepoSQLX) DisableTOTP(userID ids.ID) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	strUID := userID.ToUUID()
+	deleteQs := []string{
+		`DELETE FROM user_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_totp WHERE user_id = $1`,
+	}
+	for _, q := range deleteQs {
+		if _, err := tx.Exec(q, strUID); err != nil {
+			if rbErr := r.rollback(tx); rbErr != nil {
+				r.ins.L.Err(rbErr, "rollback failed", nil)
+			}
+			return err
+		}
+	}
+	return r.commit(tx)
+}
+
+// UseTOTPStep records the last used step, returning ErrConsumed
+// if the same or a later step has already been used.
+func (r *RepoSQLX) UseTOTPStep(userID ids.ID, step int64) error {
+	master := r.sqlDB.Master()
+	useStepQ := `
+UPDATE user_totp
+SET
+	last_step = $2
+WHERE
+	user_id = $1
+	AND last_step < $2
+`
+	res, err := master.Exec(useStepQ, userID.ToUUID(), step)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrConsumed
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode marks a recovery code as used, returning
+// ErrNotFound if it does not exist or has already been used.
+func (r *RepoSQLX) ConsumeRecoveryCode(userID ids.ID, codeHash string) error {
+	master := r.sqlDB.Master()
+	consumeQ := `
+UPDATE user_recovery_codes
+SET
+	consumed = $3
+WHERE
+	user_id = $1
+	AND code_hash = $2
+	AND consumed IS NULL
+`
+	res, err := master.Exec(consumeQ, userID.ToUUID(), codeHash, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
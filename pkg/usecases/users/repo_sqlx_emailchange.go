@@ -0,0 +1,333 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// postgres error codes for unique and foreign key
+// constraint violations
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgForeignKeyViolation
+}
+
+// CreateEmailChangeRequest stores a request to change the email
+// of a user, returning the token to confirm it (to be sent to the
+// new address) and the token to revert it (to be sent to the old
+// one). Any previous pending request for the user is discarded.
+func (r *RepoSQLX) CreateEmailChangeRequest(userID ids.ID, newEmail string,
+	ttl time.Duration, revertTTL time.Duration) (*EmailChange, error) {
+
+	confirmToken, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	revertToken, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+
+	u := r.getUserByID(tx, userID)
+	if u == nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, ErrNotFound
+	}
+	if r.getUserByEmail(tx, newEmail) != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, ErrUserExists
+	}
+
+	clearOldRequestsQ := `
+UPDATE
+	user_email_changes
+SET
+	expires=requested
+WHERE
+	user_id=$1
+	AND confirmed IS NULL
+	AND reverted IS NULL
+`
+	if _, err := tx.Exec(clearOldRequestsQ, u.ID.ToUUID()); err != nil {
+		// the transaction is aborted, so the request cannot be created
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		r.ins.L.Err(err, "cannot clean existing email change requests", nil)
+		return nil, err
+	}
+
+	now := time.Now()
+	insertRequestQ := `
+INSERT INTO user_email_changes(
+	confirm_token_hash
+	,revert_token_hash
+	,user_id
+	,old_email
+	,new_email
+	,requested
+	,expires
+	,revert_expires
+)
+VALUES (
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,$6
+	,$7
+	,$8
+)
+`
+	if _, err := tx.Exec(insertRequestQ, hashSecretToken(confirmToken),
+		hashSecretToken(revertToken), u.ID.ToUUID(), u.Email, newEmail,
+		now, now.Add(ttl), now.Add(revertTTL)); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		r.ins.L.Err(err, "cannot create email change request", nil)
+		return nil, err
+	}
+
+	if err := r.commit(tx); err != nil {
+		r.ins.L.Err(err, "cannot commit email change request", nil)
+		return nil, err
+	}
+	return &EmailChange{
+		UserID:       u.ID,
+		OldEmail:     u.Email,
+		NewEmail:     newEmail,
+		ConfirmToken: confirmToken,
+		RevertToken:  revertToken,
+		Requested:    now,
+	}, nil
+}
+
+type emailChangeRow struct {
+	tokenHash     string
+	userID        string
+	oldEmail      string
+	newEmail      string
+	requested     time.Time
+	expires       time.Time
+	revertExpires time.Time
+	confirmed     *time.Time
+	reverted      *time.Time
+}
+
+func (ecr *emailChangeRow) toEmailChange() (*EmailChange, error) {
+	ec := EmailChange{
+		OldEmail:  ecr.oldEmail,
+		NewEmail:  ecr.newEmail,
+		Requested: ecr.requested,
+	}
+	if err := ec.UserID.FromUUID(ecr.userID); err != nil {
+		return nil, err
+	}
+	if ecr.confirmed != nil {
+		ec.Confirmed = *ecr.confirmed
+	}
+	if ecr.reverted != nil {
+		ec.Reverted = *ecr.reverted
+	}
+	return &ec, nil
+}
+
+// getEmailChange locks and returns the request for a token hash
+// in the given column (confirm_token_hash or revert_token_hash)
+func (r *RepoSQLX) getEmailChange(tx *sqlx.Tx, column string,
+	tokenHash string) (*emailChangeRow, error) {
+	getRequestQ := `
+SELECT
+	confirm_token_hash
+	,user_id
+	,old_email
+	,new_email
+	,requested
+	,expires
+	,revert_expires
+	,confirmed
+	,reverted
+FROM user_email_changes
+WHERE
+	` + column + ` = $1
+FOR UPDATE
+`
+	var ecr emailChangeRow
+	row := tx.QueryRowx(getRequestQ, tokenHash)
+	if err := row.Scan(&ecr.tokenHash, &ecr.userID, &ecr.oldEmail,
+		&ecr.newEmail, &ecr.requested, &ecr.expires, &ecr.revertExpires,
+		&ecr.confirmed, &ecr.reverted); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		r.ins.L.Err(err, "cannot scan email change", map[string]interface{}{
+			"query": getRequestQ,
+		})
+		return nil, err
+	}
+	return &ecr, nil
+}
+
+// setUserEmail changes the email of a user, only if it still
+// has the expected one.
+func (r *RepoSQLX) setUserEmail(tx *sqlx.Tx, userID string,
+	fromEmail string, toEmail string) error {
+	updateEmailQ := `
+UPDATE users
+SET
+	email = $3
+WHERE
+	id = $1
+	AND email = $2
+`
+	res, err := tx.Exec(updateEmailQ, userID, fromEmail, toEmail)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrUserExists
+		}
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// the user has changed its email in the meantime
+		return ErrExpired
+	}
+	return nil
+}
+
+// ConfirmEmailChange applies the email change for a confirm token.
+// If the new email has been taken since the request was created,
+// ErrUserExists is returned and nothing is changed.
+func (r *RepoSQLX) ConfirmEmailChange(confirmToken string) (*EmailChange, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	rollback := func() {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+	}
+
+	ecr, err := r.getEmailChange(tx, "confirm_token_hash", hashSecretToken(confirmToken))
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	if ecr.confirmed != nil || ecr.reverted != nil {
+		rollback()
+		return nil, ErrConsumed
+	}
+	now := time.Now()
+	if now.After(ecr.expires) {
+		rollback()
+		return nil, ErrExpired
+	}
+
+	if err := r.setUserEmail(tx, ecr.userID, ecr.oldEmail, ecr.newEmail); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	confirmQ := `
+UPDATE user_email_changes
+SET
+	confirmed = $2
+WHERE
+	confirm_token_hash = $1
+`
+	if _, err := tx.Exec(confirmQ, ecr.tokenHash, now); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := r.commit(tx); err != nil {
+		r.ins.L.Err(err, "cannot commit email change", nil)
+		return nil, err
+	}
+	ecr.confirmed = &now
+	return ecr.toEmailChange()
+}
+
+// RevertEmailChange cancels a pending email change, or restores
+// the old email if it was already confirmed.
+func (r *RepoSQLX) RevertEmailChange(revertToken string) (*EmailChange, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	rollback := func() {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+	}
+
+	ecr, err := r.getEmailChange(tx, "revert_token_hash", hashSecretToken(revertToken))
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	if ecr.reverted != nil {
+		rollback()
+		return nil, ErrConsumed
+	}
+	now := time.Now()
+	if now.After(ecr.revertExpires) {
+		rollback()
+		return nil, ErrExpired
+	}
+
+	if ecr.confirmed != nil {
+		if err := r.setUserEmail(tx, ecr.userID, ecr.newEmail, ecr.oldEmail); err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+
+	revertQ := `
+UPDATE user_email_changes
+SET
+	reverted = $2
+WHERE
+	confirm_token_hash = $1
+`
+	if _, err := tx.Exec(revertQ, ecr.tokenHash, now); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := r.commit(tx); err != nil {
+		r.ins.L.Err(err, "cannot commit email change revert", nil)
+		return nil, err
+	}
+	ecr.reverted = &now
+	return ecr.toEmailChange()
+}
@@ -0,0 +1,181 @@
+package users
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// GetIdentity returns the identity for a provider subject,
+// or nil if it has not been linked to any user.
+func (r *RepoSQLX) GetIdentity(provider string, subject string) (*Identity, error) {
+	master := r.sqlDB.Master()
+	getIdentityQ := `
+SELECT
+	user_id
+	,email
+	,created
+FROM user_identities
+WHERE
+	provider = $1
+	AND subject = $2
+`
+	idt := Identity{Provider: provider, Subject: subject}
+	var strUserID string
+	row := master.QueryRowx(getIdentityQ, provider, subject)
+	if err := row.Scan(&strUserID, &idt.Email, &idt.Created); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		r.ins.L.Err(err, "cannot scan identity", map[string]interface{}{
+			"query": getIdentityQ,
+		})
+		return nil, err
+	}
+	if err := idt.UserID.FromUUID(strUserID); err != nil {
+		return nil, err
+	}
+	return &idt, nil
+}
+
+// LinkIdentity links a provider subject to an existing user.
+func (r *RepoSQLX) LinkIdentity(userID ids.ID, provider string,
+	subject string, email string) error {
+	master := r.sqlDB.Master()
+	linkQ := `
+INSERT INTO user_identities(
+	provider
+	,subject
+	,user_id
+	,email
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+)
+`
+	if _, err := master.Exec(linkQ, provider, subject, userID.ToUUID(),
+		email, time.Now()); err != nil {
+		r.ins.L.Err(err, "cannot link identity", map[string]interface{}{
+			"query": linkQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// CreateUserWithIdentity creates an active user without password,
+// and links it to the provider subject. Returns ErrUserExists if
+// there is already a user with that email.
+func (r *RepoSQLX) CreateUserWithIdentity(email string, provider string,
+	subject string) (*User, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if u := r.getUserByEmail(tx, email); u != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, ErrUserExists
+	}
+
+	now := time.Now()
+	id := ids.NewIDGenerator().MustNew()
+	createUserQ := `
+INSERT INTO users(
+	id
+	,email
+	,password
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,''
+	,$3
+)
+`
+	if _, err := tx.Exec(createUserQ, id.ToUUID(), email, now); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, err
+	}
+
+	linkQ := `
+INSERT INTO user_identities(
+	provider
+	,subject
+	,user_id
+	,email
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+)
+`
+	if _, err := tx.Exec(linkQ, provider, subject, id.ToUUID(), email, now); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, err
+	}
+
+	if err := r.commit(tx); err != nil {
+		r.ins.L.Err(err, "cannot complete transaction", map[string]interface{}{
+			"query": linkQ,
+		})
+		return nil, err
+	}
+	return &User{
+		ID:      id,
+		Email:   email,
+		Created: now,
+	}, nil
+}
+
+// ListIdentities returns the identities linked to a user.
+func (r *RepoSQLX) ListIdentities(userID ids.ID) ([]Identity, error) {
+	master := r.sqlDB.Master()
+	listQ := `
+SELECT
+	provider
+	,subject
+	,email
+	,created
+FROM user_identities
+WHERE
+	user_id = $1
+ORDER BY created
+`
+	rows, err := master.Queryx(listQ, userID.ToUUID())
+	if err != nil {
+		r.ins.L.Err(err, "cannot list identities", map[string]interface{}{
+			"query": listQ,
+		})
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Identity{}
+	for rows.Next() {
+		idt := Identity{UserID: userID}
+		if err := rows.Scan(&idt.Provider, &idt.Subject, &idt.Email,
+			&idt.Created); err != nil {
+			return nil, err
+		}
+		res = append(res, idt)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,185 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// secretTokenBytes is the random size of the tokens sent in
+// links that grant access to an account
+const secretTokenBytes = 32
+
+func newSecretToken() (string, error) {
+	b := make([]byte, secretTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashSecretToken returns the value stored in the database, so
+// the tokens cannot be used if the table is leaked.
+func hashSecretToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateLoginLink returns a single use token to log in a user
+// without password, that expires after the given ttl. Any previous
+// unused link for the user is discarded.
+func (r *RepoSQLX) CreateLoginLink(email string, ttl time.Duration) (*User, string, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	tx, err := r.begin()
+	if err != nil {
+		return nil, "", err
+	}
+
+	u := r.getUserByEmail(tx, email)
+	if u == nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, "", ErrNotFound
+	}
+
+	clearOldLinksQ := `
+UPDATE
+	user_login_links
+SET
+	expires=requested
+WHERE
+	user_id=$1
+	AND consumed IS NULL
+`
+	if _, err := tx.Exec(clearOldLinksQ, u.ID.ToUUID()); err != nil {
+		// the transaction is aborted, so the link cannot be created
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		r.ins.L.Err(err, "cannot clean existing login links", nil)
+		return nil, "", err
+	}
+
+	now := time.Now()
+	insertLinkQ := `
+INSERT INTO user_login_links(
+	token_hash
+	,user_id
+	,requested
+	,expires
+)
+VALUES (
+	$1
+	,$2
+	,$3
+	,$4
+)
+`
+	if _, err := tx.Exec(insertLinkQ, hashSecretToken(token), u.ID.ToUUID(),
+		now, now.Add(ttl)); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		r.ins.L.Err(err, "cannot create login link", nil)
+		return nil, "", fmt.Errorf("cannot create login link for %s: %w",
+			u.ID.ToUUID(), err)
+	}
+
+	if err := r.commit(tx); err != nil {
+		r.ins.L.Err(err, "cannot commit login link", nil)
+		return nil, "", err
+	}
+	return u, token, nil
+}
+
+// ConsumeLoginLink returns the user for a login link token,
+// marking it as used.
+func (r *RepoSQLX) ConsumeLoginLink(token string) (*User, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+
+	checkLinkQ := `
+SELECT
+	user_id
+	,expires
+	,consumed
+FROM user_login_links
+WHERE
+	token_hash = $1
+FOR UPDATE
+`
+	tokenHash := hashSecretToken(token)
+	var strUserID string
+	var expires time.Time
+	var consumed *time.Time
+	row := tx.QueryRowx(checkLinkQ, tokenHash)
+	if err := row.Scan(&strUserID, &expires, &consumed); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if consumed != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, ErrConsumed
+	}
+	now := time.Now()
+	if now.After(expires) {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, ErrExpired
+	}
+
+	consumeQ := `
+UPDATE
+	user_login_links
+SET
+	consumed=$2
+WHERE
+	token_hash=$1
+`
+	if _, err := tx.Exec(consumeQ, tokenHash, now); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, err
+	}
+
+	var userID ids.ID
+	if err := userID.FromUUID(strUserID); err != nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		r.ins.L.Err(err, "bad userID format", nil)
+		return nil, err
+	}
+	u := r.getUserByID(tx, userID)
+	if u == nil {
+		if rbErr := r.rollback(tx); rbErr != nil {
+			r.ins.L.Err(rbErr, "rollback failed", nil)
+		}
+		return nil, ErrNotFound
+	}
+	if err := r.commit(tx); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
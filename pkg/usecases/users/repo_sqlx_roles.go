@@ -0,0 +1,226 @@
+package users
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+var _ RolesRepo = (*RepoSQLX)(nil)
+
+// CreateRole creates a role without permissions, returning
+// ErrRoleExists if there is already one with that name.
+func (r *RepoSQLX) CreateRole(name string, description string) error {
+	master := r.sqlDB.Master()
+	createQ := `
+INSERT INTO roles(
+	name
+	,description
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+)
+`
+	if _, err := master.Exec(createQ, name, description, time.Now()); err != nil {
+		if isUniqueViolation(err) {
+			return ErrRoleExists
+		}
+		r.ins.L.Err(err, "cannot create role", map[string]interface{}{
+			"query": createQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// DeleteRole removes a role (and its grants and assignments),
+// returning ErrNotFound if it does not exist.
+func (r *RepoSQLX) DeleteRole(name string) error {
+	master := r.sqlDB.Master()
+	deleteQ := `
+DELETE FROM roles
+WHERE
+	name = $1
+`
+	res, err := master.Exec(deleteQ, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListRoles returns all the roles with their permissions,
+// sorted by name.
+func (r *RepoSQLX) ListRoles() ([]Role, error) {
+	master := r.sqlDB.Master()
+	listQ := `
+SELECT
+	r.name
+	,r.description
+	,r.created
+	,g.permission
+FROM roles r
+LEFT JOIN grants g ON g.role_name = r.name
+ORDER BY r.name, g.permission
+`
+	rows, err := master.Queryx(listQ)
+	if err != nil {
+		r.ins.L.Err(err, "cannot list roles", map[string]interface{}{
+			"query": listQ,
+		})
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Role{}
+	for rows.Next() {
+		var role Role
+		var permission sql.NullString
+		if err := rows.Scan(&role.Name, &role.Description, &role.Created,
+			&permission); err != nil {
+			return nil, err
+		}
+		if len(res) == 0 || res[len(res)-1].Name != role.Name {
+			role.Permissions = []string{}
+			res = append(res, role)
+		}
+		if permission.Valid {
+			last := &res[len(res)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+	return res, rows.Err()
+}
+
+// GrantPermission adds a permission to a role, returning
+// ErrNotFound if the role does not exist.
+func (r *RepoSQLX) GrantPermission(role string, permission string) error {
+	master := r.sqlDB.Master()
+	grantQ := `
+INSERT INTO grants(
+	role_name
+	,permission
+)
+VALUES(
+	$1
+	,$2
+)
+ON CONFLICT DO NOTHING
+`
+	if _, err := master.Exec(grantQ, role, permission); err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		r.ins.L.Err(err, "cannot grant permission", map[string]interface{}{
+			"query": grantQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// RevokePermission removes a permission from a role.
+func (r *RepoSQLX) RevokePermission(role string, permission string) error {
+	master := r.sqlDB.Master()
+	revokeQ := `
+DELETE FROM grants
+WHERE
+	role_name = $1
+	AND permission = $2
+`
+	_, err := master.Exec(revokeQ, role, permission)
+	return err
+}
+
+// AssignRole gives a role to a user, returning ErrNotFound
+// if the role or the user do not exist.
+func (r *RepoSQLX) AssignRole(userID ids.ID, role string) error {
+	master := r.sqlDB.Master()
+	assignQ := `
+INSERT INTO user_roles(
+	user_id
+	,role_name
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+)
+ON CONFLICT DO NOTHING
+`
+	if _, err := master.Exec(assignQ, userID.ToUUID(), role, time.Now()); err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		r.ins.L.Err(err, "cannot assign role", map[string]interface{}{
+			"query": assignQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// UnassignRole takes a role from a user.
+func (r *RepoSQLX) UnassignRole(userID ids.ID, role string) error {
+	master := r.sqlDB.Master()
+	unassignQ := `
+DELETE FROM user_roles
+WHERE
+	user_id = $1
+	AND role_name = $2
+`
+	_, err := master.Exec(unassignQ, userID.ToUUID(), role)
+	return err
+}
+
+// ListUserRoles returns the names of the roles of a user.
+func (r *RepoSQLX) ListUserRoles(userID ids.ID) ([]string, error) {
+	listQ := `
+SELECT
+	role_name
+FROM user_roles
+WHERE
+	user_id = $1
+ORDER BY role_name
+`
+	return r.listStrings(listQ, userID.ToUUID())
+}
+
+// ListUserPermissions returns the permissions granted to
+// a user through all its roles.
+func (r *RepoSQLX) ListUserPermissions(userID ids.ID) ([]string, error) {
+	listQ := `
+SELECT DISTINCT
+	g.permission
+FROM user_roles ur
+JOIN grants g ON g.role_name = ur.role_name
+WHERE
+	ur.user_id = $1
+ORDER BY g.permission
+`
+	return r.listStrings(listQ, userID.ToUUID())
+}
+
+func (r *RepoSQLX) listStrings(q string, args ...interface{}) ([]string, error) {
+	master := r.sqlDB.Master()
+	res := []string{}
+	if err := master.Select(&res, q, args...); err != nil {
+		r.ins.L.Err(err, "cannot list", map[string]interface{}{
+			"query": q,
+		})
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,178 @@
+package users
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+var _ SessionsRepo = (*RepoSQLX)(nil)
+
+// CreateSession stores a new session.
+func (r *RepoSQLX) CreateSession(s *Session) error {
+	master := r.sqlDB.Master()
+	createQ := `
+INSERT INTO user_sessions(
+	id
+	,user_id
+	,created
+	,last_seen
+	,ip
+	,user_agent
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,$6
+)
+`
+	if _, err := master.Exec(createQ, s.ID.ToUUID(), s.UserID.ToUUID(),
+		s.Created, s.LastSeen, s.IP, s.UserAgent); err != nil {
+		r.ins.L.Err(err, "cannot create session", map[string]interface{}{
+			"query": createQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// GetSession returns a session, or nil if it does not exist.
+func (r *RepoSQLX) GetSession(sessionID ids.ID) (*Session, error) {
+	master := r.sqlDB.Master()
+	getQ := `
+SELECT
+	user_id
+	,created
+	,last_seen
+	,ip
+	,user_agent
+	,revoked
+FROM user_sessions
+WHERE
+	id = $1
+`
+	s := Session{ID: sessionID}
+	var strUserID string
+	var revoked *time.Time
+	row := master.QueryRowx(getQ, sessionID.ToUUID())
+	if err := row.Scan(&strUserID, &s.Created, &s.LastSeen, &s.IP,
+		&s.UserAgent, &revoked); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		r.ins.L.Err(err, "cannot scan session", map[string]interface{}{
+			"query": getQ,
+		})
+		return nil, err
+	}
+	if err := s.UserID.FromUUID(strUserID); err != nil {
+		return nil, err
+	}
+	if revoked != nil {
+		s.Revoked = *revoked
+	}
+	return &s, nil
+}
+
+// TouchSession updates the last time a session was used.
+func (r *RepoSQLX) TouchSession(sessionID ids.ID, lastSeen time.Time) error {
+	master := r.sqlDB.Master()
+	touchQ := `
+UPDATE user_sessions
+SET
+	last_seen = $2
+WHERE
+	id = $1
+	AND last_seen < $2
+`
+	_, err := master.Exec(touchQ, sessionID.ToUUID(), lastSeen)
+	return err
+}
+
+// ListSessions returns the not revoked sessions of a user,
+// the most recently used first.
+func (r *RepoSQLX) ListSessions(userID ids.ID) ([]Session, error) {
+	master := r.sqlDB.Master()
+	listQ := `
+SELECT
+	id
+	,created
+	,last_seen
+	,ip
+	,user_agent
+FROM user_sessions
+WHERE
+	user_id = $1
+	AND revoked IS NULL
+ORDER BY last_seen DESC
+`
+	rows, err := master.Queryx(listQ, userID.ToUUID())
+	if err != nil {
+		r.ins.L.Err(err, "cannot list sessions", map[string]interface{}{
+			"query": listQ,
+		})
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Session{}
+	for rows.Next() {
+		s := Session{UserID: userID}
+		var strID string
+		if err := rows.Scan(&strID, &s.Created, &s.LastSeen, &s.IP,
+			&s.UserAgent); err != nil {
+			return nil, err
+		}
+		if err := s.ID.FromUUID(strID); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// RevokeSession revokes a session of a user, returning
+// ErrNotFound if it does not exist or is already revoked.
+func (r *RepoSQLX) RevokeSession(userID ids.ID, sessionID ids.ID) error {
+	master := r.sqlDB.Master()
+	revokeQ := `
+UPDATE user_sessions
+SET
+	revoked = $3
+WHERE
+	id = $1
+	AND user_id = $2
+	AND revoked IS NULL
+`
+	res, err := master.Exec(revokeQ, sessionID.ToUUID(), userID.ToUUID(), time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions revokes all the sessions of a user.
+func (r *RepoSQLX) RevokeAllSessions(userID ids.ID) error {
+	master := r.sqlDB.Master()
+	revokeQ := `
+UPDATE user_sessions
+SET
+	revoked = $2
+WHERE
+	user_id = $1
+	AND revoked IS NULL
+`
+	_, err := master.Exec(revokeQ, userID.ToUUID(), time.Now())
+	return err
+}
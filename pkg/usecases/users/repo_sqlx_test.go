@@ -212,3 +212,235 @@ func Test_RepoSQLX_ListUsers(t *testing.T) {
 	}
 
 }
+
+func Test_RepoSQLX_LoginAttempts(t *testing.T) {
+	deps := hfwtest.BuildExternalServices()
+	r := NewRepoSQLX(deps.Insighter(), deps.SQL, "tokenSalt")
+
+	email, _ := hfwtest.RandomEmailAndPassword()
+	key := emailAttemptKey(email)
+
+	a, err := r.GetLoginAttempt(key)
+	if err != nil {
+		t.Errorf("cannot get login attempt: %s", err.Error())
+		return
+	}
+	if a != nil {
+		t.Errorf("expected no login attempt, got %#v", a)
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 2; i++ {
+		_, err = r.UpdateLoginAttempt(key, func(a *LoginAttempt) {
+			a.Failures++
+			a.Lockouts = 1
+			a.LastFailure = now
+			a.LockedUntil = now.Add(time.Minute)
+		})
+		if err != nil {
+			t.Errorf("cannot update login attempt: %s", err.Error())
+			return
+		}
+	}
+
+	a, err = r.GetLoginAttempt(key)
+	if err != nil || a == nil {
+		t.Errorf("cannot get saved login attempt: %v", err)
+		return
+	}
+	if a.Failures != 2 || a.Lockouts != 1 || !a.LockedUntil.Equal(now.Add(time.Minute)) {
+		t.Errorf("login attempt mismatch: %#v", a)
+		return
+	}
+
+	if err := r.ClearLoginAttempt(key); err != nil {
+		t.Errorf("cannot clear login attempt: %s", err.Error())
+		return
+	}
+	a, _ = r.GetLoginAttempt(key)
+	if a != nil {
+		t.Errorf("expected cleared login attempt, got %#v", a)
+	}
+}
+
+func Test_RepoSQLX_LoginLinks(t *testing.T) {
+	email, pass := hfwtest.RandomEmailAndPassword()
+	deps := hfwtest.BuildExternalServices()
+	r := NewRepoSQLX(deps.Insighter(), deps.SQL, "tokenSalt")
+
+	if _, _, err := r.CreateLoginLink(email, time.Minute); err != ErrNotFound {
+		t.Errorf("want ErrNotFound for unknown email, got %v", err)
+		return
+	}
+
+	token, err := r.CreateInactiveUser(email, pass)
+	if err != nil {
+		t.Errorf("cannot create inactive user: %s", err.Error())
+		return
+	}
+	u, err := r.ActivateUser(token)
+	if err != nil {
+		t.Errorf("cannot activate user: %s", err.Error())
+		return
+	}
+	defer func() { _ = r.DeleteUser(email) }()
+
+	_, oldLink, err := r.CreateLoginLink(email, time.Minute)
+	if err != nil {
+		t.Errorf("cannot create login link: %s", err.Error())
+		return
+	}
+	_, link, err := r.CreateLoginLink(email, time.Minute)
+	if err != nil {
+		t.Errorf("cannot create login link: %s", err.Error())
+		return
+	}
+	if _, err := r.ConsumeLoginLink(oldLink); err != ErrExpired {
+		t.Errorf("a new link should discard the previous one, got %v", err)
+		return
+	}
+
+	lu, err := r.ConsumeLoginLink(link)
+	if err != nil {
+		t.Errorf("cannot consume login link: %s", err.Error())
+		return
+	}
+	if lu.ID != u.ID {
+		t.Errorf("want user %s, got %s", u.ID.ToUUID(), lu.ID.ToUUID())
+		return
+	}
+	if _, err := r.ConsumeLoginLink(link); err != ErrConsumed {
+		t.Errorf("want ErrConsumed, got %v", err)
+	}
+}
+
+func Test_RepoSQLX_EmailChange(t *testing.T) {
+	email, pass := hfwtest.RandomEmailAndPassword()
+	newEmail, _ := hfwtest.RandomEmailAndPassword()
+	deps := hfwtest.BuildExternalServices()
+	r := NewRepoSQLX(deps.Insighter(), deps.SQL, "tokenSalt")
+
+	token, err := r.CreateInactiveUser(email, pass)
+	if err != nil {
+		t.Errorf("cannot create inactive user: %s", err.Error())
+		return
+	}
+	u, err := r.ActivateUser(token)
+	if err != nil {
+		t.Errorf("cannot activate user: %s", err.Error())
+		return
+	}
+	defer func() {
+		_ = r.DeleteUser(email)
+		_ = r.DeleteUser(newEmail)
+	}()
+
+	if _, err := r.CreateEmailChangeRequest(u.ID, email, time.Hour, time.Hour); err != ErrUserExists {
+		t.Errorf("want ErrUserExists, got %v", err)
+		return
+	}
+
+	ec, err := r.CreateEmailChangeRequest(u.ID, newEmail, time.Hour, time.Hour)
+	if err != nil {
+		t.Errorf("cannot create email change: %s", err.Error())
+		return
+	}
+	if _, err := r.ConfirmEmailChange(ec.ConfirmToken); err != nil {
+		t.Errorf("cannot confirm email change: %s", err.Error())
+		return
+	}
+	if got := r.GetUserByID(u.ID); got == nil || got.Email != newEmail {
+		t.Errorf("want email %s, got %v", newEmail, got)
+		return
+	}
+	if _, err := r.ConfirmEmailChange(ec.ConfirmToken); err != ErrConsumed {
+		t.Errorf("want ErrConsumed, got %v", err)
+		return
+	}
+
+	if _, err := r.RevertEmailChange(ec.RevertToken); err != nil {
+		t.Errorf("cannot revert email change: %s", err.Error())
+		return
+	}
+	if got := r.GetUserByID(u.ID); got == nil || got.Email != email {
+		t.Errorf("want reverted email %s, got %v", email, got)
+	}
+}
+
+func Test_RepoSQLX_Roles(t *testing.T) {
+	email, pass := hfwtest.RandomEmailAndPassword()
+	deps := hfwtest.BuildExternalServices()
+	r := NewRepoSQLX(deps.Insighter(), deps.SQL, "tokenSalt")
+
+	token, err := r.CreateInactiveUser(email, pass)
+	if err != nil {
+		t.Errorf("cannot create inactive user: %s", err.Error())
+		return
+	}
+	u, err := r.ActivateUser(token)
+	if err != nil {
+		t.Errorf("cannot activate user: %s", err.Error())
+		return
+	}
+	defer func() { _ = r.DeleteUser(email) }()
+
+	roles := NewRoles(deps.Insighter(), r)
+	suffix := ids.NewIDGenerator().MustNew()
+	editor := fmt.Sprintf("editor_%s", suffix.ToUUID())
+	viewer := fmt.Sprintf("viewer_%s", suffix.ToUUID())
+	defer func() {
+		_ = roles.DeleteRole(editor)
+		_ = roles.DeleteRole(viewer)
+	}()
+
+	if err := roles.CreateRole(editor, "Editor"); err != nil {
+		t.Errorf("cannot create role: %s", err.Error())
+		return
+	}
+	if err := roles.CreateRole(editor, "Editor"); err != ErrRoleExists {
+		t.Errorf("want ErrRoleExists, got %v", err)
+		return
+	}
+	if err := roles.CreateRole("bad name", ""); err != ErrInvalidName {
+		t.Errorf("want ErrInvalidName, got %v", err)
+		return
+	}
+	_ = roles.CreateRole(viewer, "Viewer")
+	_ = roles.Grant(editor, "keys:write")
+	_ = roles.Grant(editor, "keys:read")
+	_ = roles.Grant(viewer, "keys:read")
+	if err := roles.Grant("missing_role", "keys:read"); err != ErrNotFound {
+		t.Errorf("want ErrNotFound, got %v", err)
+		return
+	}
+
+	if err := roles.Assign(u.ID, editor); err != nil {
+		t.Errorf("cannot assign role: %s", err.Error())
+		return
+	}
+	_ = roles.Assign(u.ID, viewer)
+	perms, err := roles.UserPermissions(u.ID)
+	if err != nil || len(perms) != 2 || perms[0] != "keys:read" || perms[1] != "keys:write" {
+		t.Errorf("want [keys:read keys:write], got %v (%v)", perms, err)
+		return
+	}
+
+	_ = roles.Unassign(u.ID, editor)
+	perms, _ = roles.UserPermissions(u.ID)
+	if len(perms) != 1 || perms[0] != "keys:read" {
+		t.Errorf("want [keys:read], got %v", perms)
+		return
+	}
+
+	// deleting a role takes it from the users
+	if err := roles.DeleteRole(viewer); err != nil {
+		t.Errorf("cannot delete role: %s", err.Error())
+		return
+	}
+	userRoles, _ := roles.UserRoles(u.ID)
+	if len(userRoles) != 0 {
+		t.Errorf("want no roles, got %v", userRoles)
+		return
+	}
+}
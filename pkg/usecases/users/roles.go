@@ -0,0 +1,136 @@
+package users
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// Role is a named set of permissions that can be
+// assigned to users.
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+	Created     time.Time
+}
+
+// RolesRepo defines the data access interface to store
+// the roles, their granted permissions and the roles
+// assigned to each user.
+type RolesRepo interface {
+	// CreateRole creates a role without permissions, returning
+	// ErrRoleExists if there is already one with that name.
+	CreateRole(name string, description string) error
+
+	// DeleteRole removes a role (and its grants and assignments),
+	// returning ErrNotFound if it does not exist.
+	DeleteRole(name string) error
+
+	// ListRoles returns all the roles with their permissions.
+	ListRoles() ([]Role, error)
+
+	// GrantPermission adds a permission to a role, returning
+	// ErrNotFound if the role does not exist.
+	GrantPermission(role string, permission string) error
+
+	// RevokePermission removes a permission from a role.
+	RevokePermission(role string, permission string) error
+
+	// AssignRole gives a role to a user, returning ErrNotFound
+	// if the role or the user do not exist.
+	AssignRole(userID ids.ID, role string) error
+
+	// UnassignRole takes a role from a user.
+	UnassignRole(userID ids.ID, role string) error
+
+	// ListUserRoles returns the names of the roles of a user.
+	ListUserRoles(userID ids.ID) ([]string, error)
+
+	// ListUserPermissions returns the permissions granted to
+	// a user through all its roles.
+	ListUserPermissions(userID ids.ID) ([]string, error)
+}
+
+// Roles is the controller to manage the roles and
+// permissions of the users.
+type Roles struct {
+	ins  *obs.Insighter
+	repo RolesRepo
+}
+
+// NewRoles creates a new Roles controller.
+func NewRoles(ins *obs.Insighter, repo RolesRepo) *Roles {
+	return &Roles{
+		ins:  ins,
+		repo: repo,
+	}
+}
+
+// validName checks that role names and permissions are not
+// empty and have no spaces (permissions are usually written as
+// "resource:action", like "keys:write").
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n")
+}
+
+// CreateRole creates a new role.
+func (r *Roles) CreateRole(name string, description string) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+	return r.repo.CreateRole(name, description)
+}
+
+// DeleteRole removes a role, taking it from all the users.
+func (r *Roles) DeleteRole(name string) error {
+	return r.repo.DeleteRole(name)
+}
+
+// Roles returns all the roles with their permissions.
+func (r *Roles) Roles() ([]Role, error) {
+	return r.repo.ListRoles()
+}
+
+// Grant adds a permission to a role.
+func (r *Roles) Grant(role string, permission string) error {
+	if !validName(permission) {
+		return ErrInvalidName
+	}
+	return r.repo.GrantPermission(role, permission)
+}
+
+// Revoke removes a permission from a role.
+func (r *Roles) Revoke(role string, permission string) error {
+	return r.repo.RevokePermission(role, permission)
+}
+
+// Assign gives a role to a user.
+func (r *Roles) Assign(userID ids.ID, role string) error {
+	err := r.repo.AssignRole(userID, role)
+	if err != nil {
+		r.ins.L.Warn("cannot assign role", map[string]interface{}{
+			"user_id": userID.ToUUID(),
+			"role":    role,
+			"error":   err.Error(),
+		})
+	}
+	return err
+}
+
+// Unassign takes a role from a user.
+func (r *Roles) Unassign(userID ids.ID, role string) error {
+	return r.repo.UnassignRole(userID, role)
+}
+
+// UserRoles returns the names of the roles of a user.
+func (r *Roles) UserRoles(userID ids.ID) ([]string, error) {
+	return r.repo.ListUserRoles(userID)
+}
+
+// UserPermissions returns the permissions granted to a user.
+func (r *Roles) UserPermissions(userID ids.ID) ([]string, error) {
+	return r.repo.ListUserPermissions(userID)
+}
@@ -0,0 +1,153 @@
+package users
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// DefaultSessionTouchInterval is the minimum time between updates
+// of the last seen time of a session, to not write on each request.
+const DefaultSessionTouchInterval = time.Minute
+
+// Max lengths of the session client values, as stored in the
+// user_sessions table: longer values are truncated.
+const (
+	MaxSessionIPLen        = 64
+	MaxSessionUserAgentLen = 512
+)
+
+// Session is a logged in session of a user.
+type Session struct {
+	ID        ids.ID
+	UserID    ids.ID
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+	Revoked   time.Time
+}
+
+// IsRevoked tells if the session can no longer be used.
+func (s *Session) IsRevoked() bool {
+	return !s.Revoked.IsZero()
+}
+
+// SessionsRepo defines the data access interface to
+// keep track of the logged in sessions.
+type SessionsRepo interface {
+	// CreateSession stores a new session.
+	CreateSession(s *Session) error
+
+	// GetSession returns a session, or nil if it does not exist.
+	GetSession(sessionID ids.ID) (*Session, error)
+
+	// TouchSession updates the last time a session was used.
+	TouchSession(sessionID ids.ID, lastSeen time.Time) error
+
+	// ListSessions returns the not revoked sessions of a user.
+	ListSessions(userID ids.ID) ([]Session, error)
+
+	// RevokeSession revokes a session of a user, returning
+	// ErrNotFound if it does not exist or is already revoked.
+	RevokeSession(userID ids.ID, sessionID ids.ID) error
+
+	// RevokeAllSessions revokes all the sessions of a user.
+	RevokeAllSessions(userID ids.ID) error
+}
+
+// SessionRegistry is the controller that records the logins
+// of the users, so their sessions can be listed and revoked.
+type SessionRegistry struct {
+	repo          SessionsRepo
+	touchInterval time.Duration
+	now           func() time.Time
+}
+
+// NewSessionRegistry creates a new SessionRegistry.
+func NewSessionRegistry(repo SessionsRepo) *SessionRegistry {
+	return &SessionRegistry{
+		repo:          repo,
+		touchInterval: DefaultSessionTouchInterval,
+		now:           time.Now,
+	}
+}
+
+// Start records a new session for a user, returning its ID.
+func (r *SessionRegistry) Start(userID ids.ID, ip string, userAgent string) (ids.ID, error) {
+	now := r.now()
+	s := Session{
+		ID:        ids.NewIDGenerator().MustNew(),
+		UserID:    userID,
+		Created:   now,
+		LastSeen:  now,
+		IP:        truncate(ip, MaxSessionIPLen),
+		UserAgent: truncate(userAgent, MaxSessionUserAgentLen),
+	}
+	if err := r.repo.CreateSession(&s); err != nil {
+		return s.ID, err
+	}
+	return s.ID, nil
+}
+
+// truncate cuts a client provided value to max characters, so
+// it always fits its column, replacing the invalid UTF-8.
+func truncate(val string, max int) string {
+	val = strings.ToValidUTF8(val, "\uFFFD")
+	if utf8.RuneCountInString(val) <= max {
+		return val
+	}
+	return string([]rune(val)[:max])
+}
+
+// Touch checks that a session is still valid, updating its last
+// seen time, and returns the user ID of the session. If the
+// session does not exist or has been revoked, it returns
+// ErrSessionRevoked.
+func (r *SessionRegistry) Touch(sessionID ids.ID) (ids.ID, error) {
+	var userID ids.ID
+	s, err := r.repo.GetSession(sessionID)
+	if err != nil {
+		return userID, err
+	}
+	if s == nil || s.IsRevoked() {
+		return userID, ErrSessionRevoked
+	}
+	now := r.now()
+	if now.Sub(s.LastSeen) >= r.touchInterval {
+		if err := r.repo.TouchSession(sessionID, now); err != nil {
+			return userID, err
+		}
+	}
+	return s.UserID, nil
+}
+
+// End revokes a session when the user logs out.
+func (r *SessionRegistry) End(sessionID ids.ID) error {
+	s, err := r.repo.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if s == nil || s.IsRevoked() {
+		return nil
+	}
+	return r.repo.RevokeSession(s.UserID, sessionID)
+}
+
+// List returns the active sessions of a user.
+func (r *SessionRegistry) List(userID ids.ID) ([]Session, error) {
+	return r.repo.ListSessions(userID)
+}
+
+// Revoke revokes one session of a user.
+func (r *SessionRegistry) Revoke(userID ids.ID, sessionID ids.ID) error {
+	return r.repo.RevokeSession(userID, sessionID)
+}
+
+// RevokeAll revokes all the sessions of a user (logging out the
+// user everywhere).
+func (r *SessionRegistry) RevokeAll(userID ids.ID) error {
+	return r.repo.RevokeAllSessions(userID)
+}
@@ -0,0 +1,95 @@
+package users
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+func Test_SessionRegistry_RevokeAll(t *testing.T) {
+	repo := NewMemSessionsRepo()
+	reg := NewSessionRegistry(repo)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reg.now = func() time.Time { return now }
+
+	userID := ids.NewIDGenerator().MustNew()
+	first, err := reg.Start(userID, "10.0.0.1", "browser")
+	if err != nil {
+		t.Errorf("cannot start session: %s", err.Error())
+		return
+	}
+	second, _ := reg.Start(userID, "10.0.0.2", "phone")
+
+	got, err := reg.Touch(first)
+	if err != nil || got != userID {
+		t.Errorf("want user %s, got %s (%v)", userID.ToUUID(), got.ToUUID(), err)
+		return
+	}
+
+	// last seen is only updated after the touch interval
+	now = now.Add(DefaultSessionTouchInterval / 2)
+	_, _ = reg.Touch(second)
+	s, _ := repo.GetSession(second)
+	if !s.LastSeen.Equal(s.Created) {
+		t.Errorf("last seen should not be updated yet")
+		return
+	}
+	now = now.Add(DefaultSessionTouchInterval)
+	_, _ = reg.Touch(second)
+	s, _ = repo.GetSession(second)
+	if !s.LastSeen.Equal(now) {
+		t.Errorf("want last seen %s, got %s", now, s.LastSeen)
+		return
+	}
+
+	list, _ := reg.List(userID)
+	if len(list) != 2 || list[0].ID != second {
+		t.Errorf("want 2 sessions, most recent first, got %#v", list)
+		return
+	}
+
+	if err := reg.Revoke(userID, first); err != nil {
+		t.Errorf("cannot revoke session: %s", err.Error())
+		return
+	}
+	if _, err := reg.Touch(first); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("want ErrSessionRevoked, got %v", err)
+		return
+	}
+
+	if err := reg.RevokeAll(userID); err != nil {
+		t.Errorf("cannot revoke all sessions: %s", err.Error())
+		return
+	}
+	if _, err := reg.Touch(second); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("want ErrSessionRevoked after revoke all, got %v", err)
+		return
+	}
+	if list, _ := reg.List(userID); len(list) != 0 {
+		t.Errorf("want no active sessions, got %d", len(list))
+	}
+}
+
+func Test_SessionRegistry_LongClientValues(t *testing.T) {
+	repo := NewMemSessionsRepo()
+	reg := NewSessionRegistry(repo)
+	userID := ids.NewIDGenerator().MustNew()
+
+	userAgent := strings.Repeat("ñ", MaxSessionUserAgentLen+10)
+	ip := strings.Repeat("1", MaxSessionIPLen+1)
+	sessionID, err := reg.Start(userID, ip, userAgent)
+	if err != nil {
+		t.Errorf("cannot start session: %s", err.Error())
+		return
+	}
+	s, _ := repo.GetSession(sessionID)
+	if s == nil || utf8.RuneCountInString(s.UserAgent) != MaxSessionUserAgentLen ||
+		!strings.HasPrefix(userAgent, s.UserAgent) || len(s.IP) != MaxSessionIPLen {
+		t.Errorf("want truncated client values, got %#v", s)
+		return
+	}
+}
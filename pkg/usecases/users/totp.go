@@ -0,0 +1,138 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // #nosec G505 -- RFC 6238 default algorithm, supported by authenticator apps
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// TOTP parameters, using the defaults supported by
+// most authenticator apps (RFC 6238).
+const (
+	TOTPPeriod            int64 = 30
+	TOTPDigits            int   = 6
+	TOTPSkew              int64 = 1 // number of periods accepted before and after
+	TOTPSecretSize        int   = 20
+	TOTPRecoveryCodes     int   = 10
+	TOTPRecoveryCodeBytes int   = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPConf contains the second factor configuration for a user.
+type TOTPConf struct {
+	UserID   ids.ID
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+// TOTPEnrollment contains the data to set up an authenticator
+// app. The ProvisioningURI is the payload to encode in a QR code.
+type TOTPEnrollment struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+// newTOTPSecret returns a random base32 encoded secret.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, TOTPSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpProvisioningURI builds the `otpauth://` URI used by
+// authenticator apps.
+func totpProvisioningURI(issuer string, account string, secret string) string {
+	label := url.PathEscape(account)
+	if len(issuer) > 0 {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	params := url.Values{}
+	params.Set("secret", secret)
+	if len(issuer) > 0 {
+		params.Set("issuer", issuer)
+	}
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	params.Set("period", fmt.Sprintf("%d", TOTPPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// totpCode computes the code for a given time step (RFC 4226).
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("bad totp secret: %w", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, bin%mod), nil
+}
+
+// verifyTOTP checks a code against the secret, allowing some clock
+// skew. It returns the matched step, so it cannot be reused.
+func verifyTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for s := current - TOTPSkew; s <= current+TOTPSkew; s++ {
+		expected, err := totpCode(secret, s)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns a list of random one time codes.
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, TOTPRecoveryCodes)
+	b := make([]byte, TOTPRecoveryCodeBytes)
+	for i := 0; i < TOTPRecoveryCodes; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		h := hex.EncodeToString(b)
+		codes = append(codes, h[:len(h)/2]+"-"+h[len(h)/2:])
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode returns the value to store for a recovery code.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
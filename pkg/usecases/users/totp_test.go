@@ -0,0 +1,70 @@
+package users
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret "12345678901234567890" from the RFC 6238 test vectors
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func Test_TOTPCode_RFCVectors(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := totpCode(rfcTOTPSecret, totpStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if got != want {
+			t.Errorf("at %d want %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func Test_VerifyTOTP_Skew(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	prev, _ := totpCode(rfcTOTPSecret, totpStep(now)-1)
+	step, ok := verifyTOTP(rfcTOTPSecret, prev, now)
+	if !ok || step != totpStep(now)-1 {
+		t.Errorf("previous period code should be accepted")
+		return
+	}
+
+	old, _ := totpCode(rfcTOTPSecret, totpStep(now)-3)
+	if _, ok := verifyTOTP(rfcTOTPSecret, old, now); ok {
+		t.Errorf("old codes should be rejected")
+	}
+}
+
+func Test_TOTPProvisioningURI(t *testing.T) {
+	uri := totpProvisioningURI("My App", "foo@example.com", rfcTOTPSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/My%20App:foo@example.com?") {
+		t.Errorf("unexpected uri: %s", uri)
+		return
+	}
+	if !strings.Contains(uri, "secret="+rfcTOTPSecret) {
+		t.Errorf("missing secret in uri: %s", uri)
+	}
+}
+
+func Test_RecoveryCodes(t *testing.T) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if len(codes) != TOTPRecoveryCodes {
+		t.Errorf("want %d codes, got %d", TOTPRecoveryCodes, len(codes))
+		return
+	}
+	if hashRecoveryCode(codes[0]) != hashRecoveryCode(" "+strings.ToUpper(codes[0])) {
+		t.Errorf("recovery code hash should ignore case and spaces")
+	}
+}
@@ -0,0 +1,56 @@
+package webhooks
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// NewClient creates the http client used by default to send the
+// deliveries. As the endpoint URLs are provided by the users, it
+// does not follow redirects, does not use proxies, and refuses to
+// connect to loopback, private and link-local addresses. The check
+// is done with the resolved address when dialing, so it also covers
+// the host names that resolve to those addresses.
+func NewClient(timeout time.Duration) *http.Client {
+	return newClient(timeout, checkPublicAddress)
+}
+
+func newClient(timeout time.Duration,
+	control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkPublicAddress is a net.Dialer Control function that only
+// allows to connect to public addresses.
+func checkPublicAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,85 @@
+package webhooks
+
+import (
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+)
+
+// AllEvents subscribes an endpoint to any event.
+const AllEvents string = "*"
+
+// Delivery status values
+const (
+	StatusPending   string = "pending"
+	StatusDelivered string = "delivered"
+	StatusFailed    string = "failed"
+)
+
+// Endpoint is a URL of a user subscribed to some events.
+type Endpoint struct {
+	ID     ids.ID
+	UserID ids.ID
+	URL    string
+	// Secret is the key used to sign the deliveries
+	Secret  string
+	Events  []string
+	Created time.Time
+	// Failures is the number of consecutive failed attempts
+	Failures int
+	// Disabled is set when the endpoint has failed too many
+	// times in a row, and no more deliveries are made.
+	Disabled *time.Time
+}
+
+// IsDisabled tells if the endpoint no longer receives deliveries.
+func (e *Endpoint) IsDisabled() bool {
+	return e.Disabled != nil
+}
+
+// Subscribed tells if the endpoint receives an event.
+func (e *Endpoint) Subscribed(event string) bool {
+	for _, ev := range e.Events {
+		if ev == event || ev == AllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an event to be sent to an endpoint. Its ID is
+// sent in the requests, so the receivers can discard the
+// repeated ones.
+type Delivery struct {
+	ID         ids.ID
+	EndpointID ids.ID
+	Event      string
+	Payload    []byte
+	Status     string
+	Attempts   int
+	// NextAttempt is when the delivery is tried again
+	NextAttempt time.Time
+	Created     time.Time
+	Delivered   *time.Time
+
+	// URL and Secret of the endpoint, set when the
+	// delivery is claimed to be sent.
+	URL    string
+	Secret string
+}
+
+// Attempt is an entry in the log of a delivery.
+type Attempt struct {
+	DeliveryID ids.ID
+	// Attempt is the number of the attempt, starting at 1
+	Attempt    int
+	Started    time.Time
+	Duration   time.Duration
+	StatusCode int
+	Error      string
+}
+
+// Succeeded tells if the endpoint accepted the delivery.
+func (a *Attempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
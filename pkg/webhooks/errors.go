@@ -0,0 +1,15 @@
+package webhooks
+
+import (
+	"github.com/dhontecillas/hfw/pkg/consterr"
+)
+
+// Domain errors for webhooks
+const (
+	ErrNotFound     = consterr.ConstErr("ErrNotFound")
+	ErrInvalidURL   = consterr.ConstErr("ErrInvalidURL")
+	ErrInvalidEvent = consterr.ConstErr("ErrInvalidEvent")
+	// ErrForbiddenAddress is returned when dialing an endpoint that
+	// resolves to a loopback, private or link-local address.
+	ErrForbiddenAddress = consterr.ConstErr("ErrForbiddenAddress")
+)
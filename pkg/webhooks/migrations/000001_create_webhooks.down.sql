@@ -0,0 +1,5 @@
+BEGIN;
+DROP TABLE webhook_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_endpoints;
+COMMIT;
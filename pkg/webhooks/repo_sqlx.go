@@ -0,0 +1,586 @@
+package webhooks
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/dhontecillas/hfw/pkg/db"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// RepoSQLX implements the webhooks repository with sqlx
+type RepoSQLX struct {
+	sqlDB db.SQLDB
+	ins   *obs.Insighter
+}
+
+var _ Repo = (*RepoSQLX)(nil)
+
+// NewRepoSQLX creates a new RepoSQLX
+func NewRepoSQLX(ins *obs.Insighter, sqlDB db.SQLDB) *RepoSQLX {
+	return &RepoSQLX{
+		sqlDB: sqlDB,
+		ins:   ins,
+	}
+}
+
+type sqlxEndpoint struct {
+	ID       string
+	UserID   string
+	URL      string
+	Secret   string
+	Events   pq.StringArray
+	Created  time.Time
+	Failures int
+	Disabled *time.Time
+}
+
+func (se *sqlxEndpoint) fromSQLX(e *Endpoint) error {
+	if err := e.ID.FromUUID(se.ID); err != nil {
+		return err
+	}
+	if err := e.UserID.FromUUID(se.UserID); err != nil {
+		return err
+	}
+	e.URL = se.URL
+	e.Secret = se.Secret
+	e.Events = []string(se.Events)
+	e.Created = se.Created
+	e.Failures = se.Failures
+	e.Disabled = se.Disabled
+	return nil
+}
+
+type sqlxDelivery struct {
+	ID          string
+	EndpointID  string
+	Event       string
+	Payload     []byte
+	Status      string
+	Attempts    int
+	NextAttempt time.Time
+	Created     time.Time
+	Delivered   *time.Time
+	URL         sql.NullString
+	Secret      sql.NullString
+}
+
+func (sd *sqlxDelivery) fromSQLX(d *Delivery) error {
+	if err := d.ID.FromUUID(sd.ID); err != nil {
+		return err
+	}
+	if err := d.EndpointID.FromUUID(sd.EndpointID); err != nil {
+		return err
+	}
+	d.Event = sd.Event
+	d.Payload = sd.Payload
+	d.Status = sd.Status
+	d.Attempts = sd.Attempts
+	d.NextAttempt = sd.NextAttempt
+	d.Created = sd.Created
+	d.Delivered = sd.Delivered
+	d.URL = sd.URL.String
+	d.Secret = sd.Secret.String
+	return nil
+}
+
+const selectEndpointQ = `
+SELECT
+	id AS ID
+	,user_id AS UserID
+	,url AS URL
+	,secret AS Secret
+	,events AS Events
+	,created AS Created
+	,failures AS Failures
+	,disabled AS Disabled
+FROM webhook_endpoints
+`
+
+const selectDeliveryQ = `
+SELECT
+	id AS ID
+	,endpoint_id AS EndpointID
+	,event AS Event
+	,payload AS Payload
+	,status AS Status
+	,attempts AS Attempts
+	,next_attempt AS NextAttempt
+	,created AS Created
+	,delivered AS Delivered
+FROM webhook_deliveries
+`
+
+func (r *RepoSQLX) rollback(tx *sqlx.Tx) {
+	if rbErr := tx.Rollback(); rbErr != nil {
+		r.ins.L.Err(rbErr, "rollback failed", nil)
+	}
+}
+
+// CreateEndpoint stores a new endpoint.
+func (r *RepoSQLX) CreateEndpoint(e *Endpoint) error {
+	sqlQ := `
+INSERT INTO webhook_endpoints(
+	id
+	,user_id
+	,url
+	,secret
+	,events
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,$6
+)
+`
+	master := r.sqlDB.Master()
+	if _, err := master.Exec(sqlQ, e.ID.ToUUID(), e.UserID.ToUUID(), e.URL,
+		e.Secret, pq.Array(e.Events), e.Created); err != nil {
+		r.ins.L.Err(err, "cannot create endpoint", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	return nil
+}
+
+// GetEndpoint returns an endpoint, or ErrNotFound.
+func (r *RepoSQLX) GetEndpoint(id ids.ID) (*Endpoint, error) {
+	sqlQ := selectEndpointQ + `
+WHERE
+	id = $1
+`
+	var se sqlxEndpoint
+	master := r.sqlDB.Master()
+	if err := master.QueryRowx(sqlQ, id.ToUUID()).StructScan(&se); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		r.ins.L.Err(err, "cannot get endpoint", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	var e Endpoint
+	if err := se.fromSQLX(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListEndpoints returns the endpoints of a user.
+func (r *RepoSQLX) ListEndpoints(userID ids.ID) ([]Endpoint, error) {
+	sqlQ := selectEndpointQ + `
+WHERE
+	user_id = $1
+ORDER BY id
+`
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, userID.ToUUID())
+	if err != nil {
+		r.ins.L.Err(err, "cannot list endpoints", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Endpoint{}
+	for rows.Next() {
+		var se sqlxEndpoint
+		if err := rows.StructScan(&se); err != nil {
+			return nil, err
+		}
+		var e Endpoint
+		if err := se.fromSQLX(&e); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// execUserEndpoint runs a query on an endpoint of a user, returning
+// ErrNotFound if it does not change any row.
+func (r *RepoSQLX) execUserEndpoint(sqlQ string, userID ids.ID, id ids.ID) error {
+	master := r.sqlDB.Master()
+	res, err := master.Exec(sqlQ, id.ToUUID(), userID.ToUUID())
+	if err != nil {
+		r.ins.L.Err(err, "cannot update endpoint", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteEndpoint removes an endpoint of a user, with its
+// deliveries, returning ErrNotFound if it does not exist.
+func (r *RepoSQLX) DeleteEndpoint(userID ids.ID, id ids.ID) error {
+	sqlQ := `
+DELETE FROM webhook_endpoints
+WHERE
+	id = $1
+	AND user_id = $2
+`
+	return r.execUserEndpoint(sqlQ, userID, id)
+}
+
+// EnableEndpoint enables again a disabled endpoint of a user,
+// resetting its failures.
+func (r *RepoSQLX) EnableEndpoint(userID ids.ID, id ids.ID) error {
+	sqlQ := `
+UPDATE webhook_endpoints
+SET
+	disabled = NULL
+	,failures = 0
+WHERE
+	id = $1
+	AND user_id = $2
+`
+	return r.execUserEndpoint(sqlQ, userID, id)
+}
+
+// Enqueue creates a pending delivery of an event for each
+// enabled endpoint of a user subscribed to it, and returns
+// the number of deliveries.
+func (r *RepoSQLX) Enqueue(userID ids.ID, event string, payload []byte,
+	now time.Time) (int, error) {
+	endpointsQ := `
+SELECT
+	id
+FROM webhook_endpoints
+WHERE
+	user_id = $1
+	AND disabled IS NULL
+	AND ($2 = ANY(events) OR $3 = ANY(events))
+`
+	insertQ := `
+INSERT INTO webhook_deliveries(
+	id
+	,endpoint_id
+	,event
+	,payload
+	,status
+	,attempts
+	,next_attempt
+	,created
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,0
+	,$6
+	,$6
+)
+`
+	master := r.sqlDB.Master()
+	tx, err := master.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	var endpointIDs []string
+	if err := tx.Select(&endpointIDs, endpointsQ, userID.ToUUID(), event,
+		AllEvents); err != nil {
+		r.rollback(tx)
+		r.ins.L.Err(err, "cannot select subscribed endpoints", map[string]interface{}{
+			"query": endpointsQ,
+		})
+		return 0, err
+	}
+	idGen := ids.NewIDGenerator()
+	for _, endpointID := range endpointIDs {
+		id := idGen.MustNew()
+		if _, err := tx.Exec(insertQ, id.ToUUID(), endpointID, event, payload,
+			StatusPending, now); err != nil {
+			r.rollback(tx)
+			r.ins.L.Err(err, "cannot enqueue delivery", map[string]interface{}{
+				"query": insertQ,
+			})
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(endpointIDs), nil
+}
+
+// ClaimDeliveries returns up to limit pending deliveries of
+// enabled endpoints that are due, and postpones them for the
+// lease time, so other workers do not send them meanwhile.
+func (r *RepoSQLX) ClaimDeliveries(now time.Time, limit int,
+	lease time.Duration) ([]Delivery, error) {
+	sqlQ := `
+WITH claimed AS (
+	UPDATE webhook_deliveries
+	SET
+		next_attempt = $3
+	WHERE id IN (
+		SELECT
+			d.id
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		WHERE
+			d.status = $4
+			AND d.next_attempt <= $1
+			AND e.disabled IS NULL
+		ORDER BY d.next_attempt
+		LIMIT $2
+		FOR UPDATE OF d SKIP LOCKED
+	)
+	RETURNING *
+)
+SELECT
+	c.id AS ID
+	,c.endpoint_id AS EndpointID
+	,c.event AS Event
+	,c.payload AS Payload
+	,c.status AS Status
+	,c.attempts AS Attempts
+	,c.next_attempt AS NextAttempt
+	,c.created AS Created
+	,c.delivered AS Delivered
+	,e.url AS URL
+	,e.secret AS Secret
+FROM claimed c
+JOIN webhook_endpoints e ON e.id = c.endpoint_id
+ORDER BY c.created
+`
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, now, limit, now.Add(lease), StatusPending)
+	if err != nil {
+		r.ins.L.Err(err, "cannot claim deliveries", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+func scanDeliveries(rows *sqlx.Rows) ([]Delivery, error) {
+	defer rows.Close()
+	res := []Delivery{}
+	for rows.Next() {
+		var sd sqlxDelivery
+		if err := rows.StructScan(&sd); err != nil {
+			return nil, err
+		}
+		var d Delivery
+		if err := sd.fromSQLX(&d); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// RecordAttempt adds an attempt to the log and updates its
+// delivery and endpoint. It returns if the endpoint has been
+// disabled.
+func (r *RepoSQLX) RecordAttempt(a *Attempt, endpointID ids.ID, next *time.Time,
+	maxFailures int) (bool, error) {
+	logQ := `
+INSERT INTO webhook_attempts(
+	delivery_id
+	,attempt
+	,started
+	,duration_ms
+	,status_code
+	,error
+)
+VALUES(
+	$1
+	,$2
+	,$3
+	,$4
+	,$5
+	,$6
+)
+`
+	deliveredQ := `
+UPDATE webhook_deliveries
+SET
+	status = $2
+	,attempts = $3
+	,delivered = $4
+WHERE
+	id = $1
+`
+	retryQ := `
+UPDATE webhook_deliveries
+SET
+	status = $2
+	,attempts = $3
+	,next_attempt = $4
+WHERE
+	id = $1
+`
+	succeededQ := `
+UPDATE webhook_endpoints
+SET
+	failures = 0
+WHERE
+	id = $1
+`
+	failedQ := `
+UPDATE webhook_endpoints
+SET
+	failures = failures + 1
+	,disabled = CASE
+		WHEN disabled IS NULL AND failures + 1 >= $2 THEN $3
+		ELSE disabled
+	END
+WHERE
+	id = $1
+RETURNING disabled = $3
+`
+	master := r.sqlDB.Master()
+	tx, err := master.Beginx()
+	if err != nil {
+		return false, err
+	}
+	exec := func(sqlQ string, args ...interface{}) error {
+		if _, err := tx.Exec(sqlQ, args...); err != nil {
+			r.rollback(tx)
+			r.ins.L.Err(err, "cannot record attempt", map[string]interface{}{
+				"query": sqlQ,
+			})
+			return err
+		}
+		return nil
+	}
+	deliveryID := a.DeliveryID.ToUUID()
+	if err := exec(logQ, deliveryID, a.Attempt, a.Started,
+		a.Duration.Milliseconds(), a.StatusCode, a.Error); err != nil {
+		return false, err
+	}
+
+	if a.Succeeded() {
+		if err := exec(deliveredQ, deliveryID, StatusDelivered, a.Attempt,
+			a.Started); err != nil {
+			return false, err
+		}
+		if err := exec(succeededQ, endpointID.ToUUID()); err != nil {
+			return false, err
+		}
+		return false, tx.Commit()
+	}
+
+	status, nextAttempt := StatusFailed, a.Started
+	if next != nil {
+		status, nextAttempt = StatusPending, *next
+	}
+	if err := exec(retryQ, deliveryID, status, a.Attempt, nextAttempt); err != nil {
+		return false, err
+	}
+	var disabled sql.NullBool
+	if err := tx.QueryRowx(failedQ, endpointID.ToUUID(), maxFailures,
+		a.Started).Scan(&disabled); err != nil && err != sql.ErrNoRows {
+		r.rollback(tx)
+		r.ins.L.Err(err, "cannot record endpoint failure", map[string]interface{}{
+			"query": failedQ,
+		})
+		return false, err
+	}
+	return disabled.Bool, tx.Commit()
+}
+
+// GetDelivery returns a delivery, or ErrNotFound.
+func (r *RepoSQLX) GetDelivery(id ids.ID) (*Delivery, error) {
+	sqlQ := selectDeliveryQ + `
+WHERE
+	id = $1
+`
+	var sd sqlxDelivery
+	master := r.sqlDB.Master()
+	if err := master.QueryRowx(sqlQ, id.ToUUID()).StructScan(&sd); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		r.ins.L.Err(err, "cannot get delivery", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	var d Delivery
+	if err := sd.fromSQLX(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ListDeliveries returns the last deliveries of an endpoint.
+func (r *RepoSQLX) ListDeliveries(endpointID ids.ID, limit int) ([]Delivery, error) {
+	sqlQ := selectDeliveryQ + `
+WHERE
+	endpoint_id = $1
+ORDER BY id DESC
+LIMIT $2
+`
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, endpointID.ToUUID(), limit)
+	if err != nil {
+		r.ins.L.Err(err, "cannot list deliveries", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// ListAttempts returns the log of attempts of a delivery.
+func (r *RepoSQLX) ListAttempts(deliveryID ids.ID) ([]Attempt, error) {
+	sqlQ := `
+SELECT
+	attempt
+	,started
+	,duration_ms
+	,status_code
+	,error
+FROM webhook_attempts
+WHERE
+	delivery_id = $1
+ORDER BY attempt
+`
+	master := r.sqlDB.Master()
+	rows, err := master.Queryx(sqlQ, deliveryID.ToUUID())
+	if err != nil {
+		r.ins.L.Err(err, "cannot list attempts", map[string]interface{}{
+			"query": sqlQ,
+		})
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Attempt{}
+	for rows.Next() {
+		a := Attempt{DeliveryID: deliveryID}
+		var durationMs int64
+		if err := rows.Scan(&a.Attempt, &a.Started, &durationMs, &a.StatusCode,
+			&a.Error); err != nil {
+			return nil, err
+		}
+		a.Duration = time.Duration(durationMs) * time.Millisecond
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
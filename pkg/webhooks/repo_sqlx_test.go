@@ -0,0 +1,135 @@
+package webhooks
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/extdeps"
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/usecases/users"
+	hfwtest "github.com/dhontecillas/hfw/testing"
+)
+
+func createTestUser(deps *extdeps.ExternalServicesBuilder) (*users.User, error) {
+	email := "webhooks@example.com"
+	pass := "bar"
+	r := users.NewRepoSQLX(deps.Insighter(), deps.SQL, "tokenSalt")
+	token, err := r.CreateInactiveUser(email, pass)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.ActivateUser(token)
+	if err != nil {
+		return nil, err
+	}
+	u := r.GetUserByEmail(email)
+	return u, nil
+}
+
+func Test_RepoSQLX_Webhooks(t *testing.T) {
+	deps := hfwtest.BuildExternalServices()
+	u, err := createTestUser(deps)
+	if err != nil {
+		t.Errorf("err creating user : %s", err)
+		return
+	}
+
+	r := NewRepoSQLX(deps.Insighter(), deps.SQL)
+	now := time.Now().Truncate(time.Second)
+
+	e := &Endpoint{
+		ID:      ids.NewIDGenerator().MustNew(),
+		UserID:  u.ID,
+		URL:     "https://example.com/hook",
+		Secret:  "whsec_test",
+		Events:  []string{"user.created"},
+		Created: now,
+	}
+	if err := r.CreateEndpoint(e); err != nil {
+		t.Errorf("cannot create endpoint: %s", err)
+		return
+	}
+	endpoints, err := r.ListEndpoints(u.ID)
+	if err != nil || len(endpoints) != 1 || endpoints[0].Events[0] != "user.created" {
+		t.Errorf("want 1 endpoint, got %#v (%v)", endpoints, err)
+		return
+	}
+
+	n, err := r.Enqueue(u.ID, "user.deleted", []byte(`{}`), now)
+	if err != nil || n != 0 {
+		t.Errorf("want no deliveries for unsubscribed event, got %d (%v)", n, err)
+		return
+	}
+	n, err = r.Enqueue(u.ID, "user.created", []byte(`{}`), now)
+	if err != nil || n != 1 {
+		t.Errorf("want 1 delivery, got %d (%v)", n, err)
+		return
+	}
+
+	claimed, err := r.ClaimDeliveries(now, 10, time.Minute)
+	if err != nil || len(claimed) != 1 {
+		t.Errorf("want 1 claimed delivery, got %d (%v)", len(claimed), err)
+		return
+	}
+	if claimed[0].URL != e.URL || claimed[0].Secret != e.Secret {
+		t.Errorf("want endpoint url and secret in the claimed delivery")
+		return
+	}
+	again, err := r.ClaimDeliveries(now, 10, time.Minute)
+	if err != nil || len(again) != 0 {
+		t.Errorf("want no deliveries claimed during the lease, got %d (%v)",
+			len(again), err)
+		return
+	}
+
+	a := &Attempt{
+		DeliveryID: claimed[0].ID,
+		Attempt:    1,
+		Started:    now,
+		Duration:   time.Second,
+		StatusCode: http.StatusInternalServerError,
+		Error:      "status 500",
+	}
+	next := now.Add(time.Minute)
+	disabled, err := r.RecordAttempt(a, e.ID, &next, 2)
+	if err != nil || disabled {
+		t.Errorf("want endpoint enabled after 1 failure, got %t (%v)", disabled, err)
+		return
+	}
+	a = &Attempt{
+		DeliveryID: claimed[0].ID,
+		Attempt:    2,
+		Started:    next,
+		StatusCode: http.StatusOK,
+	}
+	if disabled, err := r.RecordAttempt(a, e.ID, nil, 2); err != nil || disabled {
+		t.Errorf("cannot record successful attempt: %t (%v)", disabled, err)
+		return
+	}
+
+	d, err := r.GetDelivery(claimed[0].ID)
+	if err != nil || d.Status != StatusDelivered || d.Attempts != 2 {
+		t.Errorf("want delivered after 2 attempts, got %#v (%v)", d, err)
+		return
+	}
+	attempts, err := r.ListAttempts(d.ID)
+	if err != nil || len(attempts) != 2 || attempts[0].Duration != time.Second {
+		t.Errorf("want 2 attempts, got %#v (%v)", attempts, err)
+		return
+	}
+	ep, err := r.GetEndpoint(e.ID)
+	if err != nil || ep.Failures != 0 {
+		t.Errorf("want failures reset, got %#v (%v)", ep, err)
+		return
+	}
+
+	if err := r.DeleteEndpoint(u.ID, e.ID); err != nil {
+		t.Errorf("cannot delete endpoint: %s", err)
+		return
+	}
+	if _, err := r.GetDelivery(d.ID); err != ErrNotFound {
+		t.Errorf("want deliveries deleted with the endpoint, got %v", err)
+		return
+	}
+}
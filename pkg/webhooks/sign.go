@@ -0,0 +1,46 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Headers sent with each delivery
+const (
+	HeaderSignature string = "X-Webhook-Signature"
+	HeaderEvent     string = "X-Webhook-Event"
+	HeaderDelivery  string = "X-Webhook-Delivery"
+)
+
+// secretSize is the number of random bytes of the endpoint secrets
+const secretSize = 32
+
+func newSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp and
+// the body of a delivery, using the endpoint secret.
+func Sign(secret string, ts time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts.Unix(), 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureHeader returns the value of the HeaderSignature header,
+// with the form `t=<unix timestamp>,v1=<signature>`. Including the
+// timestamp in the signature allows the receivers to reject the
+// replayed deliveries.
+func SignatureHeader(secret string, ts time.Time, body []byte) string {
+	return "t=" + strconv.FormatInt(ts.Unix(), 10) + ",v1=" + Sign(secret, ts, body)
+}
@@ -0,0 +1,195 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// Repo defines the storage contract for the endpoints, and the
+// outbox of deliveries with their log of attempts.
+type Repo interface {
+	// CreateEndpoint stores a new endpoint.
+	CreateEndpoint(e *Endpoint) error
+	// GetEndpoint returns an endpoint, or ErrNotFound.
+	GetEndpoint(id ids.ID) (*Endpoint, error)
+	// ListEndpoints returns the endpoints of a user.
+	ListEndpoints(userID ids.ID) ([]Endpoint, error)
+	// DeleteEndpoint removes an endpoint of a user, with its
+	// deliveries, returning ErrNotFound if it does not exist.
+	DeleteEndpoint(userID ids.ID, id ids.ID) error
+	// EnableEndpoint enables again a disabled endpoint of a user,
+	// resetting its failures.
+	EnableEndpoint(userID ids.ID, id ids.ID) error
+
+	// Enqueue creates a pending delivery of an event for each
+	// enabled endpoint of a user subscribed to it, and returns
+	// the number of deliveries.
+	Enqueue(userID ids.ID, event string, payload []byte, now time.Time) (int, error)
+	// ClaimDeliveries returns up to limit pending deliveries of
+	// enabled endpoints that are due, and postpones them for the
+	// lease time, so other workers do not send them meanwhile.
+	ClaimDeliveries(now time.Time, limit int, lease time.Duration) ([]Delivery, error)
+	// RecordAttempt adds an attempt to the log and updates its
+	// delivery and endpoint. When it succeeded, the delivery is
+	// done and the endpoint failures are reset. Otherwise, the
+	// delivery is tried again at next (or fails, if next is nil),
+	// and the endpoint is disabled when it reaches maxFailures in
+	// a row. It returns if the endpoint has been disabled.
+	RecordAttempt(a *Attempt, endpointID ids.ID, next *time.Time,
+		maxFailures int) (bool, error)
+	// GetDelivery returns a delivery, or ErrNotFound.
+	GetDelivery(id ids.ID) (*Delivery, error)
+	// ListDeliveries returns the last deliveries of an endpoint.
+	ListDeliveries(endpointID ids.ID, limit int) ([]Delivery, error)
+	// ListAttempts returns the log of attempts of a delivery.
+	ListAttempts(deliveryID ids.ID) ([]Attempt, error)
+}
+
+// Message is the body sent to the endpoints.
+type Message struct {
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Data    interface{} `json:"data"`
+}
+
+// Webhooks is the controller to manage the endpoints of the
+// users, and to publish the events to them.
+type Webhooks struct {
+	ins  *obs.Insighter
+	repo Repo
+	now  func() time.Time
+}
+
+// NewWebhooks creates a new Webhooks controller.
+func NewWebhooks(ins *obs.Insighter, repo Repo) *Webhooks {
+	return &Webhooks{
+		ins:  ins,
+		repo: repo,
+		now:  time.Now,
+	}
+}
+
+func validURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func validEvent(event string) bool {
+	return event != "" && !strings.ContainsAny(event, " \t\r\n")
+}
+
+// Subscribe creates an endpoint for a user, that will receive
+// the given events. The returned endpoint has the Secret to
+// check the signatures.
+func (w *Webhooks) Subscribe(userID ids.ID, rawURL string,
+	events []string) (*Endpoint, error) {
+	if !validURL(rawURL) {
+		return nil, ErrInvalidURL
+	}
+	if len(events) == 0 {
+		return nil, ErrInvalidEvent
+	}
+	for _, ev := range events {
+		if !validEvent(ev) {
+			return nil, ErrInvalidEvent
+		}
+	}
+	secret, err := newSecret()
+	if err != nil {
+		w.ins.L.Err(err, "cannot create webhook secret", nil)
+		return nil, err
+	}
+	e := &Endpoint{
+		ID:      ids.NewIDGenerator().MustNew(),
+		UserID:  userID,
+		URL:     rawURL,
+		Secret:  secret,
+		Events:  events,
+		Created: w.now(),
+	}
+	if err := w.repo.CreateEndpoint(e); err != nil {
+		w.ins.L.Err(err, "cannot create webhook endpoint", nil)
+		return nil, err
+	}
+	return e, nil
+}
+
+// Endpoints returns the endpoints of a user.
+func (w *Webhooks) Endpoints(userID ids.ID) ([]Endpoint, error) {
+	return w.repo.ListEndpoints(userID)
+}
+
+// Unsubscribe removes an endpoint of a user.
+func (w *Webhooks) Unsubscribe(userID ids.ID, id ids.ID) error {
+	return w.repo.DeleteEndpoint(userID, id)
+}
+
+// Enable enables again an endpoint that has been disabled
+// after failing too many times.
+func (w *Webhooks) Enable(userID ids.ID, id ids.ID) error {
+	return w.repo.EnableEndpoint(userID, id)
+}
+
+// Publish stores a delivery of an event for each endpoint of the
+// user subscribed to it, to be sent by the Worker. The data is sent
+// in a Message, encoded as JSON. It returns the number of deliveries.
+func (w *Webhooks) Publish(userID ids.ID, event string, data interface{}) (int, error) {
+	if !validEvent(event) {
+		return 0, ErrInvalidEvent
+	}
+	now := w.now()
+	payload, err := json.Marshal(Message{Event: event, Created: now.UTC(), Data: data})
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.repo.Enqueue(userID, event, payload, now)
+	if err != nil {
+		w.ins.L.Err(err, "cannot enqueue webhook", map[string]interface{}{
+			"event": event,
+		})
+		return 0, err
+	}
+	return n, nil
+}
+
+// userEndpoint returns an endpoint of a user, or ErrNotFound.
+func (w *Webhooks) userEndpoint(userID ids.ID, id ids.ID) (*Endpoint, error) {
+	e, err := w.repo.GetEndpoint(id)
+	if err != nil {
+		return nil, err
+	}
+	if e.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// Deliveries returns the last deliveries of an endpoint of a user.
+func (w *Webhooks) Deliveries(userID ids.ID, endpointID ids.ID,
+	limit int) ([]Delivery, error) {
+	if _, err := w.userEndpoint(userID, endpointID); err != nil {
+		return nil, err
+	}
+	return w.repo.ListDeliveries(endpointID, limit)
+}
+
+// Attempts returns the log of attempts of a delivery to an
+// endpoint of a user.
+func (w *Webhooks) Attempts(userID ids.ID, deliveryID ids.ID) ([]Attempt, error) {
+	d, err := w.repo.GetDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.userEndpoint(userID, d.EndpointID); err != nil {
+		return nil, err
+	}
+	return w.repo.ListAttempts(deliveryID)
+}
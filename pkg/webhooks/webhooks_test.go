@@ -0,0 +1,313 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+)
+
+// memRepo is an in memory Repo for the tests
+type memRepo struct {
+	mu         sync.Mutex
+	endpoints  map[ids.ID]*Endpoint
+	deliveries map[ids.ID]*Delivery
+	attempts   map[ids.ID][]Attempt
+}
+
+var _ Repo = (*memRepo)(nil)
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		endpoints:  map[ids.ID]*Endpoint{},
+		deliveries: map[ids.ID]*Delivery{},
+		attempts:   map[ids.ID][]Attempt{},
+	}
+}
+
+func (m *memRepo) CreateEndpoint(e *Endpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ce := *e
+	m.endpoints[e.ID] = &ce
+	return nil
+}
+
+func (m *memRepo) GetEndpoint(id ids.ID) (*Endpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.endpoints[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	ce := *e
+	return &ce, nil
+}
+
+func (m *memRepo) ListEndpoints(userID ids.ID) ([]Endpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []Endpoint{}
+	for _, e := range m.endpoints {
+		if e.UserID == userID {
+			res = append(res, *e)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID.ToUUID() < res[j].ID.ToUUID()
+	})
+	return res, nil
+}
+
+func (m *memRepo) DeleteEndpoint(userID ids.ID, id ids.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.endpoints[id]
+	if !ok || e.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.endpoints, id)
+	for did, d := range m.deliveries {
+		if d.EndpointID == id {
+			delete(m.deliveries, did)
+			delete(m.attempts, did)
+		}
+	}
+	return nil
+}
+
+func (m *memRepo) EnableEndpoint(userID ids.ID, id ids.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.endpoints[id]
+	if !ok || e.UserID != userID {
+		return ErrNotFound
+	}
+	e.Disabled = nil
+	e.Failures = 0
+	return nil
+}
+
+func (m *memRepo) Enqueue(userID ids.ID, event string, payload []byte,
+	now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	idGen := ids.NewIDGenerator()
+	for _, e := range m.endpoints {
+		if e.UserID != userID || e.IsDisabled() || !e.Subscribed(event) {
+			continue
+		}
+		d := &Delivery{
+			ID:          idGen.MustNew(),
+			EndpointID:  e.ID,
+			Event:       event,
+			Payload:     payload,
+			Status:      StatusPending,
+			NextAttempt: now,
+			Created:     now,
+		}
+		m.deliveries[d.ID] = d
+		n++
+	}
+	return n, nil
+}
+
+func (m *memRepo) ClaimDeliveries(now time.Time, limit int,
+	lease time.Duration) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []Delivery{}
+	for _, d := range m.deliveries {
+		if len(res) == limit {
+			break
+		}
+		e := m.endpoints[d.EndpointID]
+		if d.Status != StatusPending || d.NextAttempt.After(now) || e.IsDisabled() {
+			continue
+		}
+		d.NextAttempt = now.Add(lease)
+		cd := *d
+		cd.URL = e.URL
+		cd.Secret = e.Secret
+		res = append(res, cd)
+	}
+	return res, nil
+}
+
+func (m *memRepo) RecordAttempt(a *Attempt, endpointID ids.ID, next *time.Time,
+	maxFailures int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[a.DeliveryID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	m.attempts[d.ID] = append(m.attempts[d.ID], *a)
+	d.Attempts = a.Attempt
+	e := m.endpoints[endpointID]
+	if a.Succeeded() {
+		d.Status = StatusDelivered
+		delivered := a.Started
+		d.Delivered = &delivered
+		e.Failures = 0
+		return false, nil
+	}
+	if next != nil {
+		d.NextAttempt = *next
+	} else {
+		d.Status = StatusFailed
+	}
+	e.Failures++
+	if !e.IsDisabled() && e.Failures >= maxFailures {
+		disabled := a.Started
+		e.Disabled = &disabled
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *memRepo) GetDelivery(id ids.ID) (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cd := *d
+	return &cd, nil
+}
+
+func (m *memRepo) ListDeliveries(endpointID ids.ID, limit int) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []Delivery{}
+	for _, d := range m.deliveries {
+		if d.EndpointID == endpointID {
+			res = append(res, *d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID.ToUUID() > res[j].ID.ToUUID()
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (m *memRepo) ListAttempts(deliveryID ids.ID) ([]Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Attempt{}, m.attempts[deliveryID]...), nil
+}
+
+func Test_Webhooks_Subscribe(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	w := NewWebhooks(ins, newMemRepo())
+	userID := ids.NewIDGenerator().MustNew()
+
+	if _, err := w.Subscribe(userID, "ftp://example.com/hook", []string{"a"}); err != ErrInvalidURL {
+		t.Errorf("want ErrInvalidURL, got %v", err)
+		return
+	}
+	if _, err := w.Subscribe(userID, "https:///hook", []string{"a"}); err != ErrInvalidURL {
+		t.Errorf("want ErrInvalidURL for missing host, got %v", err)
+		return
+	}
+	if _, err := w.Subscribe(userID, "https://example.com/hook", nil); err != ErrInvalidEvent {
+		t.Errorf("want ErrInvalidEvent, got %v", err)
+		return
+	}
+	if _, err := w.Subscribe(userID, "https://example.com/hook", []string{"a b"}); err != ErrInvalidEvent {
+		t.Errorf("want ErrInvalidEvent for blanks, got %v", err)
+		return
+	}
+
+	e, err := w.Subscribe(userID, "https://example.com/hook", []string{"user.created"})
+	if err != nil {
+		t.Errorf("cannot subscribe: %s", err)
+		return
+	}
+	if len(e.Secret) == 0 {
+		t.Errorf("want a secret")
+		return
+	}
+	endpoints, err := w.Endpoints(userID)
+	if err != nil || len(endpoints) != 1 {
+		t.Errorf("want 1 endpoint, got %d (%v)", len(endpoints), err)
+		return
+	}
+
+	other := ids.NewIDGenerator().MustNew()
+	if err := w.Unsubscribe(other, e.ID); err != ErrNotFound {
+		t.Errorf("want ErrNotFound unsubscribing other user endpoint, got %v", err)
+		return
+	}
+	if err := w.Unsubscribe(userID, e.ID); err != nil {
+		t.Errorf("cannot unsubscribe: %s", err)
+		return
+	}
+}
+
+func Test_Webhooks_Publish(t *testing.T) {
+	ins := obs.InsighterFromContext(context.Background())
+	repo := newMemRepo()
+	w := NewWebhooks(ins, repo)
+	userID := ids.NewIDGenerator().MustNew()
+
+	created, err := w.Subscribe(userID, "https://example.com/created",
+		[]string{"user.created"})
+	if err != nil {
+		t.Errorf("cannot subscribe: %s", err)
+		return
+	}
+	if _, err := w.Subscribe(userID, "https://example.com/all",
+		[]string{AllEvents}); err != nil {
+		t.Errorf("cannot subscribe: %s", err)
+		return
+	}
+
+	n, err := w.Publish(userID, "user.created", map[string]string{"name": "foo"})
+	if err != nil || n != 2 {
+		t.Errorf("want 2 deliveries, got %d (%v)", n, err)
+		return
+	}
+	n, err = w.Publish(userID, "user.deleted", nil)
+	if err != nil || n != 1 {
+		t.Errorf("want 1 delivery, got %d (%v)", n, err)
+		return
+	}
+	n, err = w.Publish(ids.NewIDGenerator().MustNew(), "user.created", nil)
+	if err != nil || n != 0 {
+		t.Errorf("want no deliveries for other user, got %d (%v)", n, err)
+		return
+	}
+
+	deliveries, err := w.Deliveries(userID, created.ID, 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Errorf("want 1 delivery, got %d (%v)", len(deliveries), err)
+		return
+	}
+	var msg struct {
+		Event string            `json:"event"`
+		Data  map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(deliveries[0].Payload, &msg); err != nil {
+		t.Errorf("cannot decode payload: %s", err)
+		return
+	}
+	if msg.Event != "user.created" || msg.Data["name"] != "foo" {
+		t.Errorf("unexpected payload: %s", deliveries[0].Payload)
+		return
+	}
+	if _, err := w.Deliveries(ids.NewIDGenerator().MustNew(), created.ID, 10); err != ErrNotFound {
+		t.Errorf("want ErrNotFound for other user deliveries, got %v", err)
+		return
+	}
+}
@@ -0,0 +1,249 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+	"github.com/dhontecillas/hfw/pkg/poller"
+)
+
+// Metrics reported by the Worker
+const (
+	MetWebhookDelivered        string = "webhooks.delivered"
+	MetWebhookAttemptFailed    string = "webhooks.attempt.failed"
+	MetWebhookFailed           string = "webhooks.failed"
+	MetWebhookEndpointDisabled string = "webhooks.endpoint.disabled"
+)
+
+// MetricDefinitions returns the definitions of the metrics
+// reported by the Worker, to be registered in the insighter.
+func MetricDefinitions() metrics.MetricDefinitionList {
+	defs := metrics.MetricDefinitionList{}
+	for _, name := range []string{MetWebhookDelivered, MetWebhookAttemptFailed,
+		MetWebhookFailed, MetWebhookEndpointDisabled} {
+		defs = append(defs, &metrics.MetricDefinition{
+			Name:       name,
+			MetricType: metrics.MetricTypeMonotonicCounter,
+		})
+	}
+	return defs
+}
+
+// Default values for the WorkerConf
+const (
+	DefaultPollInterval = 5 * time.Second
+	DefaultBatchSize    = 50
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxAttempts  = 10
+	DefaultBaseBackoff  = 30 * time.Second
+	DefaultMaxBackoff   = 6 * time.Hour
+	DefaultMaxFailures  = 20
+)
+
+// maxResponseBody is the part of the response body that is
+// read (and discarded), so the connection can be reused.
+const maxResponseBody = 512
+
+// WorkerConf has the configuration of a Worker. Zero values
+// are replaced with the defaults.
+type WorkerConf struct {
+	// PollInterval is the time between checks of pending deliveries
+	PollInterval time.Duration
+	// BatchSize is the max number of deliveries sent at once
+	BatchSize int
+	// Timeout of each request
+	Timeout time.Duration
+	// MaxAttempts is the number of attempts before a delivery fails
+	MaxAttempts int
+	// BaseBackoff and MaxBackoff are the poller.Backoff of
+	// the retries.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// MaxFailures is the number of failed attempts in a row that
+	// disable an endpoint.
+	MaxFailures int
+}
+
+func (c *WorkerConf) setDefaults() {
+	if c.PollInterval <= 0 {
+		c.PollInterval = DefaultPollInterval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = DefaultBaseBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = DefaultMaxFailures
+	}
+}
+
+// Backoff returns the time to wait before retrying a delivery
+// that has failed the given number of attempts.
+func (c *WorkerConf) Backoff(attempts int) time.Duration {
+	return poller.Backoff{Base: c.BaseBackoff, Max: c.MaxBackoff}.Delay(attempts)
+}
+
+// Worker sends the pending deliveries to the endpoints. Several
+// workers can run at the same time, because the deliveries are
+// claimed before sending them.
+type Worker struct {
+	ins    *obs.Insighter
+	repo   Repo
+	client *http.Client
+	conf   WorkerConf
+	now    func() time.Time
+
+	loop *poller.Loop
+}
+
+// NewWorker creates a Worker. If client is nil, a NewClient with
+// the configured timeout is used.
+func NewWorker(ins *obs.Insighter, repo Repo, client *http.Client,
+	conf WorkerConf) *Worker {
+	conf.setDefaults()
+	if client == nil {
+		client = NewClient(conf.Timeout)
+	}
+	w := &Worker{
+		ins:    ins,
+		repo:   repo,
+		client: client,
+		conf:   conf,
+		now:    time.Now,
+	}
+	// keep sending while there are full batches
+	w.loop = poller.NewLoop(conf.PollInterval, func() bool {
+		return w.RunOnce() == w.conf.BatchSize
+	})
+	return w
+}
+
+// Start sends the pending deliveries in the background, until
+// Close is called.
+func (w *Worker) Start() {
+	w.loop.Start()
+}
+
+// Close stops the worker, waiting for the deliveries in progress.
+func (w *Worker) Close() {
+	w.loop.Stop()
+}
+
+// RunOnce claims a batch of pending deliveries and sends them,
+// returning the number of deliveries claimed.
+func (w *Worker) RunOnce() int {
+	// the lease covers the requests of the batch, that are
+	// sent concurrently
+	lease := 2 * w.conf.Timeout
+	deliveries, err := w.repo.ClaimDeliveries(w.now(), w.conf.BatchSize, lease)
+	if err != nil {
+		w.ins.L.Err(err, "cannot claim webhook deliveries", nil)
+		return 0
+	}
+	var wg sync.WaitGroup
+	for idx := range deliveries {
+		wg.Add(1)
+		go func(d *Delivery) {
+			defer wg.Done()
+			w.deliver(d)
+		}(&deliveries[idx])
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+// deliver sends a delivery and records the attempt.
+func (w *Worker) deliver(d *Delivery) {
+	attrs := map[string]interface{}{
+		"webhook.delivery": d.ID.ToUUID(),
+		"webhook.endpoint": d.EndpointID.ToUUID(),
+		"webhook.event":    d.Event,
+		"webhook.attempt":  int64(d.Attempts + 1),
+	}
+	span := w.ins.T.Start(context.Background(), "webhook_delivery", attrs)
+	defer span.End()
+
+	a := w.send(d)
+	span.I64("http.status_code", int64(a.StatusCode))
+
+	var next *time.Time
+	if !a.Succeeded() {
+		span.Err(fmt.Errorf("webhook delivery failed: %s", a.Error))
+		w.ins.M.Inc(MetWebhookAttemptFailed)
+		if a.Attempt < w.conf.MaxAttempts {
+			retry := a.Started.Add(w.conf.Backoff(a.Attempt))
+			next = &retry
+		} else {
+			w.ins.M.Inc(MetWebhookFailed)
+		}
+	} else {
+		w.ins.M.Inc(MetWebhookDelivered)
+	}
+	disabled, err := w.repo.RecordAttempt(a, d.EndpointID, next, w.conf.MaxFailures)
+	if err != nil {
+		span.Err(err)
+		w.ins.L.Err(err, "cannot record webhook attempt", attrs)
+		return
+	}
+	if disabled {
+		w.ins.M.Inc(MetWebhookEndpointDisabled)
+		w.ins.L.Warn("webhook endpoint disabled", attrs)
+	}
+}
+
+// send makes the request of a delivery, returning the attempt.
+func (w *Worker) send(d *Delivery) *Attempt {
+	a := &Attempt{
+		DeliveryID: d.ID,
+		Attempt:    d.Attempts + 1,
+		Started:    w.now(),
+	}
+	start := time.Now()
+	defer func() {
+		a.Duration = time.Since(start)
+		a.Error = poller.SanitizeError(a.Error)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, d.ID.ToUUID())
+	req.Header.Set(HeaderSignature, SignatureHeader(d.Secret, a.Started, d.Payload))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer res.Body.Close()
+	// the response body is not stored, because the attempts are
+	// visible to the user that owns the endpoint
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, maxResponseBody))
+	a.StatusCode = res.StatusCode
+	if !a.Succeeded() {
+		a.Error = fmt.Sprintf("status %d", res.StatusCode)
+	}
+	return a
+}
@@ -0,0 +1,376 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhontecillas/hfw/pkg/ids"
+	"github.com/dhontecillas/hfw/pkg/obs"
+	"github.com/dhontecillas/hfw/pkg/obs/metrics"
+)
+
+// receiver is an endpoint for the tests, that checks the
+// signature of the received deliveries.
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []string
+	badSigs  int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if !validSignature(rc.secret, r.Header.Get(HeaderSignature), body) {
+		rc.badSigs++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	rc.received = append(rc.received, r.Header.Get(HeaderDelivery))
+	w.WriteHeader(rc.status)
+	_, _ = w.Write([]byte("nope"))
+}
+
+func (rc *receiver) setStatus(status int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.status = status
+}
+
+func validSignature(secret string, header string, body []byte) bool {
+	var ts int64
+	var sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return false
+		}
+		switch kv[0] {
+		case "t":
+			ts, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			sig = kv[1]
+		}
+	}
+	return sig != "" && sig == Sign(secret, time.Unix(ts, 0), body)
+}
+
+type workerTest struct {
+	repo   *memRepo
+	hooks  *Webhooks
+	worker *Worker
+	meter  *metrics.MockMeter
+	recv   *receiver
+	srv    *httptest.Server
+	now    time.Time
+	userID ids.ID
+	ep     *Endpoint
+}
+
+func newWorkerTest(t *testing.T, conf WorkerConf) *workerTest {
+	ins := obs.InsighterFromContext(context.Background())
+	meter := metrics.NewMockMeter()
+	ins.M = meter
+
+	wt := &workerTest{
+		repo:   newMemRepo(),
+		meter:  meter,
+		recv:   &receiver{status: http.StatusOK},
+		now:    time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		userID: ids.NewIDGenerator().MustNew(),
+	}
+	wt.srv = httptest.NewServer(wt.recv)
+	t.Cleanup(wt.srv.Close)
+
+	wt.hooks = NewWebhooks(ins, wt.repo)
+	wt.hooks.now = func() time.Time { return wt.now }
+	ep, err := wt.hooks.Subscribe(wt.userID, wt.srv.URL, []string{AllEvents})
+	if err != nil {
+		t.Fatalf("cannot subscribe: %s", err)
+	}
+	wt.ep = ep
+	wt.recv.secret = ep.Secret
+
+	// a single delivery per batch, so the meter is not
+	// used concurrently
+	conf.BatchSize = 1
+	wt.worker = NewWorker(ins, wt.repo, wt.srv.Client(), conf)
+	wt.worker.now = func() time.Time { return wt.now }
+	return wt
+}
+
+func Test_Worker_Delivered(t *testing.T) {
+	wt := newWorkerTest(t, WorkerConf{})
+	if _, err := wt.hooks.Publish(wt.userID, "user.created", "foo"); err != nil {
+		t.Errorf("cannot publish: %s", err)
+		return
+	}
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want 1 delivery sent, got %d", n)
+		return
+	}
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no more deliveries, got %d", n)
+		return
+	}
+	if wt.recv.badSigs != 0 || len(wt.recv.received) != 1 {
+		t.Errorf("want 1 valid delivery, got %d (bad signatures: %d)",
+			len(wt.recv.received), wt.recv.badSigs)
+		return
+	}
+
+	deliveries, _ := wt.hooks.Deliveries(wt.userID, wt.ep.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != StatusDelivered {
+		t.Errorf("want a delivered delivery, got %#v", deliveries)
+		return
+	}
+	if deliveries[0].ID.ToUUID() != wt.recv.received[0] {
+		t.Errorf("want delivery header %s, got %s", deliveries[0].ID.ToUUID(),
+			wt.recv.received[0])
+		return
+	}
+	attempts, _ := wt.hooks.Attempts(wt.userID, deliveries[0].ID)
+	if len(attempts) != 1 || attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("want 1 successful attempt, got %#v", attempts)
+		return
+	}
+	if wt.meter.Count(MetWebhookDelivered) != 1 {
+		t.Errorf("want delivered metric, got %v", wt.meter.Incs)
+		return
+	}
+}
+
+func Test_Worker_Retries(t *testing.T) {
+	wt := newWorkerTest(t, WorkerConf{
+		MaxAttempts: 3,
+		BaseBackoff: time.Minute,
+		MaxBackoff:  time.Hour,
+	})
+	wt.recv.setStatus(http.StatusInternalServerError)
+	if _, err := wt.hooks.Publish(wt.userID, "user.created", "foo"); err != nil {
+		t.Errorf("cannot publish: %s", err)
+		return
+	}
+
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want 1 delivery sent, got %d", n)
+		return
+	}
+	// not retried before the backoff
+	wt.now = wt.now.Add(time.Minute - time.Second)
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no retry before the backoff, got %d", n)
+		return
+	}
+	wt.now = wt.now.Add(time.Second)
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want a retry after the backoff, got %d", n)
+		return
+	}
+	// the second backoff is doubled
+	wt.now = wt.now.Add(time.Minute)
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no retry before the second backoff, got %d", n)
+		return
+	}
+	wt.now = wt.now.Add(time.Minute)
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want a retry after the second backoff, got %d", n)
+		return
+	}
+	// no more attempts
+	wt.now = wt.now.Add(24 * time.Hour)
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no more attempts, got %d", n)
+		return
+	}
+
+	deliveries, _ := wt.hooks.Deliveries(wt.userID, wt.ep.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != StatusFailed ||
+		deliveries[0].Attempts != 3 {
+		t.Errorf("want a failed delivery after 3 attempts, got %#v", deliveries)
+		return
+	}
+	attempts, _ := wt.hooks.Attempts(wt.userID, deliveries[0].ID)
+	if len(attempts) != 3 {
+		t.Errorf("want 3 attempts, got %d", len(attempts))
+		return
+	}
+	if attempts[0].Error != "status 500" || attempts[0].StatusCode != 500 {
+		t.Errorf("want only the status in the error, got %q", attempts[0].Error)
+		return
+	}
+	if wt.meter.Count(MetWebhookAttemptFailed) != 3 || wt.meter.Count(MetWebhookFailed) != 1 {
+		t.Errorf("unexpected metrics: %v", wt.meter.Incs)
+		return
+	}
+}
+
+func Test_Worker_DisablesEndpoint(t *testing.T) {
+	wt := newWorkerTest(t, WorkerConf{
+		MaxAttempts: 10,
+		MaxFailures: 2,
+		BaseBackoff: time.Minute,
+	})
+	wt.recv.setStatus(http.StatusBadGateway)
+	if _, err := wt.hooks.Publish(wt.userID, "user.created", "foo"); err != nil {
+		t.Errorf("cannot publish: %s", err)
+		return
+	}
+	for i := 0; i < 2; i++ {
+		if n := wt.worker.RunOnce(); n != 1 {
+			t.Errorf("attempt %d: want 1 delivery sent, got %d", i+1, n)
+			return
+		}
+		wt.now = wt.now.Add(time.Hour)
+	}
+	if n := wt.worker.RunOnce(); n != 0 {
+		t.Errorf("want no deliveries to a disabled endpoint, got %d", n)
+		return
+	}
+	ep, _ := wt.repo.GetEndpoint(wt.ep.ID)
+	if !ep.IsDisabled() {
+		t.Errorf("want endpoint disabled")
+		return
+	}
+	if wt.meter.Count(MetWebhookEndpointDisabled) != 1 {
+		t.Errorf("want disabled metric, got %v", wt.meter.Incs)
+		return
+	}
+	n, _ := wt.hooks.Publish(wt.userID, "user.updated", "foo")
+	if n != 0 {
+		t.Errorf("want no deliveries enqueued for a disabled endpoint, got %d", n)
+		return
+	}
+
+	// once enabled, the pending delivery is sent again
+	wt.recv.setStatus(http.StatusNoContent)
+	if err := wt.hooks.Enable(wt.userID, wt.ep.ID); err != nil {
+		t.Errorf("cannot enable endpoint: %s", err)
+		return
+	}
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want the pending delivery sent, got %d", n)
+		return
+	}
+	deliveries, _ := wt.hooks.Deliveries(wt.userID, wt.ep.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != StatusDelivered {
+		t.Errorf("want a delivered delivery, got %#v", deliveries)
+		return
+	}
+}
+
+func Test_Worker_StartClose(t *testing.T) {
+	wt := newWorkerTest(t, WorkerConf{PollInterval: 10 * time.Millisecond})
+	for i := 0; i < 3; i++ {
+		if _, err := wt.hooks.Publish(wt.userID, "user.created", i); err != nil {
+			t.Errorf("cannot publish: %s", err)
+			return
+		}
+	}
+	wt.worker.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		wt.recv.mu.Lock()
+		n := len(wt.recv.received)
+		wt.recv.mu.Unlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	wt.worker.Close()
+	wt.worker.Close()
+	if len(wt.recv.received) != 3 {
+		t.Errorf("want 3 deliveries, got %d", len(wt.recv.received))
+		return
+	}
+}
+
+func Test_Worker_BinaryResponse(t *testing.T) {
+	wt := newWorkerTest(t, WorkerConf{MaxAttempts: 2})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("\x00\xff\xfe binary \x00"))
+	}))
+	defer srv.Close()
+	ep, err := wt.hooks.Subscribe(wt.userID, srv.URL, []string{AllEvents})
+	if err != nil {
+		t.Errorf("cannot subscribe: %s", err)
+		return
+	}
+	if err := wt.hooks.Unsubscribe(wt.userID, wt.ep.ID); err != nil {
+		t.Errorf("cannot unsubscribe: %s", err)
+		return
+	}
+	if _, err := wt.hooks.Publish(wt.userID, "user.created", "foo"); err != nil {
+		t.Errorf("cannot publish: %s", err)
+		return
+	}
+	if n := wt.worker.RunOnce(); n != 1 {
+		t.Errorf("want 1 delivery sent, got %d", n)
+		return
+	}
+	deliveries, _ := wt.hooks.Deliveries(wt.userID, ep.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Attempts != 1 {
+		t.Errorf("want the attempt recorded, got %#v", deliveries)
+		return
+	}
+	attempts, _ := wt.hooks.Attempts(wt.userID, deliveries[0].ID)
+	if len(attempts) != 1 || attempts[0].Error != "status 500" {
+		t.Errorf("want only the status in the error, got %#v", attempts)
+		return
+	}
+}
+
+func Test_Client(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	// the default client refuses to connect to the loopback test server
+	_, err := NewClient(time.Second).Post(srv.URL, "application/json", nil)
+	if !errors.Is(err, ErrForbiddenAddress) || hits != 0 {
+		t.Errorf("want ErrForbiddenAddress, got %v (%d hits)", err, hits)
+		return
+	}
+
+	// without the address check, the redirects are not followed
+	res, err := newClient(time.Second, nil).Post(srv.URL, "application/json", nil)
+	if err != nil || res.StatusCode != http.StatusFound || hits != 1 {
+		t.Errorf("want the redirect response, got %v (%d hits)", err, hits)
+		return
+	}
+	res.Body.Close()
+
+	for addr, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+	} {
+		if got := publicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("%s: want public %t, got %t", addr, want, got)
+			return
+		}
+	}
+}
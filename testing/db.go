@@ -68,7 +68,11 @@ func BuildExternalServices() *extdeps.ExternalServicesBuilder {
 	sqlConf := testDBConfig()
 
 	mailer := mailer.NewNopMailer()
-	composer := notifications.NewFileSystemComposer("./pkg/notifications")
+	composer, err := notifications.NewFSComposer(notifications.Templates(),
+		notifications.FSComposerConf{})
+	if err != nil {
+		panic("cannot load notification templates: " + err.Error())
+	}
 	flushFn := func() {}
 	return extdeps.NewExternalServicesBuilder(insBuilderFn, flushFn, mailer,
 		db.NewSQLDB(insBuilderFn(), sqlConf), composer)
@@ -0,0 +1,18 @@
+BEGIN;
+
+CREATE TABLE user_totp(
+    user_id         UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE
+    ,secret         VARCHAR(64) NOT NULL
+    ,enabled        BOOLEAN NOT NULL DEFAULT FALSE
+    ,last_step      BIGINT NOT NULL DEFAULT 0
+    ,created        TIMESTAMP NOT NULL
+);
+
+CREATE TABLE user_recovery_codes(
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,code_hash      VARCHAR(64) NOT NULL
+    ,consumed       TIMESTAMP
+    ,PRIMARY KEY (user_id, code_hash)
+);
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+CREATE TABLE user_identities(
+    provider        VARCHAR(64) NOT NULL
+    ,subject        VARCHAR(255) NOT NULL
+    ,user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,email          VARCHAR(254)
+    ,created        TIMESTAMP NOT NULL
+    ,PRIMARY KEY (provider, subject)
+);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+CREATE TABLE user_login_links(
+    token_hash      VARCHAR(64) PRIMARY KEY
+    ,user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,requested      TIMESTAMP NOT NULL
+    ,expires        TIMESTAMP NOT NULL
+    ,consumed       TIMESTAMP
+);
+CREATE INDEX idx_user_login_links_user_id ON user_login_links(user_id);
+
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE user_sessions;
+COMMIT;
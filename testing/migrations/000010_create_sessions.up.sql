@@ -0,0 +1,14 @@
+BEGIN;
+
+CREATE TABLE user_sessions(
+    id              UUID PRIMARY KEY
+    ,user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,created        TIMESTAMP NOT NULL
+    ,last_seen      TIMESTAMP NOT NULL
+    ,ip             VARCHAR(64) NOT NULL
+    ,user_agent     VARCHAR(512) NOT NULL
+    ,revoked        TIMESTAMP
+);
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
+
+COMMIT;
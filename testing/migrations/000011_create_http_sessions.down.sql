@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE http_sessions;
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE http_sessions(
+    id              VARCHAR(64) PRIMARY KEY
+    ,data           TEXT NOT NULL
+    ,modified       TIMESTAMP NOT NULL
+    ,expires        TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_http_sessions_expires ON http_sessions(expires);
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+DROP TABLE user_roles;
+DROP TABLE grants;
+DROP TABLE roles;
+COMMIT;
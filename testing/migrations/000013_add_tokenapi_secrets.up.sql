@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE tokenapi_keys
+    ADD COLUMN secret_hash  VARCHAR(64) NOT NULL DEFAULT ''
+    ,ADD COLUMN scopes      TEXT[] NOT NULL DEFAULT '{}'
+    ,ADD COLUMN expires     TIMESTAMP;
+
+COMMIT;
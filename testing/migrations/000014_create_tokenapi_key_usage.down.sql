@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE tokenapi_keys ADD COLUMN last_used TIMESTAMP;
+DROP TABLE tokenapi_key_usage;
+COMMIT;
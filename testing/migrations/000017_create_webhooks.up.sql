@@ -0,0 +1,42 @@
+BEGIN;
+
+CREATE TABLE webhook_endpoints(
+    id              UUID PRIMARY KEY
+    ,user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+    ,url            TEXT NOT NULL
+    ,secret         TEXT NOT NULL
+    ,events         TEXT[] NOT NULL
+    ,created        TIMESTAMP NOT NULL
+    ,failures       INTEGER NOT NULL DEFAULT 0
+    ,disabled       TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_endpoints_user_id ON webhook_endpoints(user_id);
+
+CREATE TABLE webhook_deliveries(
+    id              UUID PRIMARY KEY
+    ,endpoint_id    UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE
+    ,event          TEXT NOT NULL
+    ,payload        BYTEA NOT NULL
+    ,status         TEXT NOT NULL
+    ,attempts       INTEGER NOT NULL DEFAULT 0
+    ,next_attempt   TIMESTAMP NOT NULL
+    ,created        TIMESTAMP NOT NULL
+    ,delivered      TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_deliveries_endpoint_id ON webhook_deliveries(endpoint_id);
+CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt)
+    WHERE status = 'pending';
+
+CREATE TABLE webhook_attempts(
+    delivery_id     UUID NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE
+    ,attempt        INTEGER NOT NULL
+    ,started        TIMESTAMP NOT NULL
+    ,duration_ms    BIGINT NOT NULL
+    ,status_code    INTEGER NOT NULL
+    ,error          TEXT NOT NULL
+    ,PRIMARY KEY (delivery_id, attempt)
+);
+
+COMMIT;
@@ -0,0 +1,24 @@
+BEGIN;
+
+CREATE TABLE jobs(
+    id              UUID PRIMARY KEY
+    ,type           TEXT NOT NULL
+    ,payload        JSONB NOT NULL
+    ,unique_key     TEXT
+    ,status         TEXT NOT NULL
+    ,attempts       INTEGER NOT NULL DEFAULT 0
+    ,max_attempts   INTEGER NOT NULL
+    ,run_at         TIMESTAMP NOT NULL
+    ,created        TIMESTAMP NOT NULL
+    ,lease_until    TIMESTAMP
+    ,finished       TIMESTAMP
+    ,last_error     TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_jobs_due ON jobs(run_at)
+    WHERE status IN ('pending', 'running');
+CREATE INDEX idx_jobs_status ON jobs(status, finished);
+CREATE UNIQUE INDEX idx_jobs_unique_key ON jobs(unique_key)
+    WHERE status IN ('pending', 'running');
+
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE outbox_messages;
+COMMIT;